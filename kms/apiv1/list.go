@@ -0,0 +1,83 @@
+package apiv1
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"path"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// MatchKey returns true if a key with the given short name and labels matches
+// the filters in the request.
+func (r *ListKeysRequest) MatchKey(name string, labels map[string]string) bool {
+	if r.NameFilter != "" {
+		if ok, err := path.Match(r.NameFilter, name); err != nil || !ok {
+			return false
+		}
+	}
+	for k, v := range r.Labels {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Paginate returns the page of keys defined by the PageSize and PageToken in
+// the request. It's used by KMSs that do not support pagination natively, the
+// page token is the offset of the first key in the page.
+func (r *ListKeysRequest) Paginate(keys []*KeyInfo) (*ListKeysResponse, error) {
+	if r.PageSize < 0 {
+		return nil, errors.New("listKeysRequest 'pageSize' cannot be negative")
+	}
+
+	var offset int
+	if r.PageToken != "" {
+		n, err := strconv.Atoi(r.PageToken)
+		if err != nil || n < 0 || n > len(keys) {
+			return nil, errors.Errorf("listKeysRequest 'pageToken' %s is not valid", r.PageToken)
+		}
+		offset = n
+	}
+
+	keys = keys[offset:]
+	if r.PageSize == 0 || len(keys) <= r.PageSize {
+		return &ListKeysResponse{
+			Keys: keys,
+		}, nil
+	}
+	return &ListKeysResponse{
+		Keys:          keys[:r.PageSize],
+		NextPageToken: strconv.Itoa(offset + r.PageSize),
+	}, nil
+}
+
+// SignatureAlgorithmForKey returns the default signature algorithm used with
+// the given public key. It returns UnspecifiedSignAlgorithm if the key is not
+// supported.
+func SignatureAlgorithmForKey(pub crypto.PublicKey) SignatureAlgorithm {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return ECDSAWithSHA256
+		case elliptic.P384():
+			return ECDSAWithSHA384
+		case elliptic.P521():
+			return ECDSAWithSHA512
+		default:
+			return UnspecifiedSignAlgorithm
+		}
+	case *rsa.PublicKey:
+		return SHA256WithRSA
+	case ed25519.PublicKey:
+		return PureEd25519
+	default:
+		return UnspecifiedSignAlgorithm
+	}
+}
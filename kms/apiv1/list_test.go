@@ -0,0 +1,131 @@
+package apiv1
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"testing"
+)
+
+func TestListKeysRequest_MatchKey(t *testing.T) {
+	type fields struct {
+		NameFilter string
+		Labels     map[string]string
+	}
+	type args struct {
+		name   string
+		labels map[string]string
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   bool
+	}{
+		{"ok empty", fields{}, args{"foo", nil}, true},
+		{"ok name", fields{"foo", nil}, args{"foo", nil}, true},
+		{"ok pattern", fields{"foo-*", nil}, args{"foo-bar", nil}, true},
+		{"ok labels", fields{"", map[string]string{"env": "prod"}}, args{"foo", map[string]string{"env": "prod", "team": "pki"}}, true},
+		{"ok name and labels", fields{"foo*", map[string]string{"env": "prod"}}, args{"foo", map[string]string{"env": "prod"}}, true},
+		{"fail name", fields{"bar", nil}, args{"foo", nil}, false},
+		{"fail pattern", fields{"[", nil}, args{"foo", nil}, false},
+		{"fail missing label", fields{"", map[string]string{"env": "prod"}}, args{"foo", nil}, false},
+		{"fail label value", fields{"", map[string]string{"env": "prod"}}, args{"foo", map[string]string{"env": "dev"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ListKeysRequest{
+				NameFilter: tt.fields.NameFilter,
+				Labels:     tt.fields.Labels,
+			}
+			if got := r.MatchKey(tt.args.name, tt.args.labels); got != tt.want {
+				t.Errorf("ListKeysRequest.MatchKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListKeysRequest_Paginate(t *testing.T) {
+	keys := []*KeyInfo{
+		{Name: "key1"}, {Name: "key2"}, {Name: "key3"},
+	}
+	type fields struct {
+		PageSize  int
+		PageToken string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		want    *ListKeysResponse
+		wantErr bool
+	}{
+		{"ok all", fields{0, ""}, &ListKeysResponse{Keys: keys}, false},
+		{"ok first page", fields{2, ""}, &ListKeysResponse{Keys: keys[:2], NextPageToken: "2"}, false},
+		{"ok last page", fields{2, "2"}, &ListKeysResponse{Keys: keys[2:]}, false},
+		{"ok exact page", fields{3, ""}, &ListKeysResponse{Keys: keys}, false},
+		{"ok end", fields{2, "3"}, &ListKeysResponse{Keys: []*KeyInfo{}}, false},
+		{"fail page size", fields{-1, ""}, nil, true},
+		{"fail page token", fields{1, "foo"}, nil, true},
+		{"fail page token negative", fields{1, "-1"}, nil, true},
+		{"fail page token out of range", fields{1, "4"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ListKeysRequest{
+				PageSize:  tt.fields.PageSize,
+				PageToken: tt.fields.PageToken,
+			}
+			got, err := r.Paginate(keys)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ListKeysRequest.Paginate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListKeysRequest.Paginate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignatureAlgorithmForKey(t *testing.T) {
+	mustECDSA := func(c elliptic.Curve) crypto.PublicKey {
+		k, err := ecdsa.GenerateKey(c, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k.Public()
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		pub  crypto.PublicKey
+		want SignatureAlgorithm
+	}{
+		{"P-256", mustECDSA(elliptic.P256()), ECDSAWithSHA256},
+		{"P-384", mustECDSA(elliptic.P384()), ECDSAWithSHA384},
+		{"P-521", mustECDSA(elliptic.P521()), ECDSAWithSHA512},
+		{"P-224", mustECDSA(elliptic.P224()), UnspecifiedSignAlgorithm},
+		{"RSA", rsaKey.Public(), SHA256WithRSA},
+		{"Ed25519", edKey, PureEd25519},
+		{"unknown", []byte("foo"), UnspecifiedSignAlgorithm},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SignatureAlgorithmForKey(tt.pub); got != tt.want {
+				t.Errorf("SignatureAlgorithmForKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
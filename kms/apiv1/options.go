@@ -30,6 +30,51 @@ type CertificateManager interface {
 	StoreCertificate(req *StoreCertificateRequest) error
 }
 
+// CertificateChainManager is the interface implemented by the KMS that can
+// load and store chains of x509.Certificates.
+type CertificateChainManager interface {
+	LoadCertificateChain(req *LoadCertificateChainRequest) ([]*x509.Certificate, error)
+	StoreCertificateChain(req *StoreCertificateChainRequest) error
+}
+
+// KeyLister is the interface implemented by the KMS that can list the keys they
+// hold.
+type KeyLister interface {
+	ListKeys(req *ListKeysRequest) (*ListKeysResponse, error)
+}
+
+// KeyDeleter is the interface implemented by the KMS that can delete or
+// schedule the destruction of keys.
+type KeyDeleter interface {
+	DeleteKey(req *DeleteKeyRequest) error
+}
+
+// KeyRotator is the interface implemented by the KMS that can create new
+// versions of an existing key.
+type KeyRotator interface {
+	RotateKey(req *RotateKeyRequest) (*CreateKeyResponse, error)
+}
+
+// KeyImporter is the interface implemented by the KMS that can import
+// existing private keys.
+type KeyImporter interface {
+	ImportKey(req *ImportKeyRequest) (*CreateKeyResponse, error)
+}
+
+// KeyWrapper is the interface implemented by the KMS that can export and
+// import keys encrypted with another key.
+type KeyWrapper interface {
+	WrapKey(req *WrapKeyRequest) ([]byte, error)
+	UnwrapKey(req *UnwrapKeyRequest) error
+}
+
+// SymmetricEncrypter is the interface implemented by the KMS that can encrypt
+// and decrypt data using symmetric keys.
+type SymmetricEncrypter interface {
+	Encrypt(req *EncryptRequest) ([]byte, error)
+	Decrypt(req *DecryptRequest) ([]byte, error)
+}
+
 // NameValidator is an interface that KeyManager can implement to validate a
 // given name or URI.
 type NameValidator interface {
@@ -47,6 +92,27 @@ type Attester interface {
 	CreateAttestation(req *CreateAttestationRequest) (*CreateAttestationResponse, error)
 }
 
+// HealthChecker is the interface implemented by the KMS that can check the
+// connection with the device or service that holds the keys.
+type HealthChecker interface {
+	HealthCheck() error
+}
+
+// The errors that can be used with errors.Is to check the type of error
+// returned by a KMS, for example:
+//
+//	if errors.Is(err, apiv1.ErrNotFound) {
+//		// create the key
+//	}
+var (
+	ErrNotImplemented   error = NotImplementedError{}
+	ErrAlreadyExists    error = AlreadyExistsError{}
+	ErrNotFound         error = NotFoundError{}
+	ErrPermissionDenied error = PermissionDeniedError{}
+	ErrPINLocked        error = PINLockedError{}
+	ErrRateLimited      error = RateLimitedError{}
+)
+
 // NotImplementedError is the type of error returned if an operation is not
 // implemented.
 type NotImplementedError struct {
@@ -60,6 +126,12 @@ func (e NotImplementedError) Error() string {
 	return "not implemented"
 }
 
+// Is returns true if target is a NotImplementedError.
+func (e NotImplementedError) Is(target error) bool {
+	_, ok := target.(NotImplementedError)
+	return ok
+}
+
 // AlreadyExistsError is the type of error returned if a key already exists. This
 // is currently only implmented on pkcs11.
 type AlreadyExistsError struct {
@@ -73,6 +145,118 @@ func (e AlreadyExistsError) Error() string {
 	return "key already exists"
 }
 
+// Is returns true if target is an AlreadyExistsError.
+func (e AlreadyExistsError) Is(target error) bool {
+	_, ok := target.(AlreadyExistsError)
+	return ok
+}
+
+// NotFoundError is the type of error returned if a key or object does not
+// exist. Err is the error returned by the device or service, if any.
+type NotFoundError struct {
+	Message string
+	Err     error
+}
+
+func (e NotFoundError) Error() string {
+	return formatError(e.Message, e.Err, "not found")
+}
+
+// Unwrap returns the underlying error.
+func (e NotFoundError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is a NotFoundError.
+func (e NotFoundError) Is(target error) bool {
+	_, ok := target.(NotFoundError)
+	return ok
+}
+
+// PermissionDeniedError is the type of error returned if the credentials used
+// are not valid or don't have permission to perform an operation. Err is the
+// error returned by the device or service, if any.
+type PermissionDeniedError struct {
+	Message string
+	Err     error
+}
+
+func (e PermissionDeniedError) Error() string {
+	return formatError(e.Message, e.Err, "permission denied")
+}
+
+// Unwrap returns the underlying error.
+func (e PermissionDeniedError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is a PermissionDeniedError.
+func (e PermissionDeniedError) Is(target error) bool {
+	_, ok := target.(PermissionDeniedError)
+	return ok
+}
+
+// PINLockedError is the type of error returned if the PIN of a device has been
+// blocked after too many failed attempts. Err is the error returned by the
+// device, if any.
+type PINLockedError struct {
+	Message string
+	Err     error
+}
+
+func (e PINLockedError) Error() string {
+	return formatError(e.Message, e.Err, "pin locked")
+}
+
+// Unwrap returns the underlying error.
+func (e PINLockedError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is a PINLockedError.
+func (e PINLockedError) Is(target error) bool {
+	_, ok := target.(PINLockedError)
+	return ok
+}
+
+// RateLimitedError is the type of error returned if a service rejects a
+// request because a quota or rate limit has been exceeded. Err is the error
+// returned by the service, if any.
+type RateLimitedError struct {
+	Message string
+	Err     error
+}
+
+func (e RateLimitedError) Error() string {
+	return formatError(e.Message, e.Err, "rate limited")
+}
+
+// Unwrap returns the underlying error.
+func (e RateLimitedError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns true if target is a RateLimitedError.
+func (e RateLimitedError) Is(target error) bool {
+	_, ok := target.(RateLimitedError)
+	return ok
+}
+
+// formatError returns the message of an error with the same format used by
+// github.com/pkg/errors.Wrap.
+func formatError(msg string, err error, defaultMsg string) string {
+	switch {
+	case msg != "" && err != nil:
+		return msg + ": " + err.Error()
+	case msg != "":
+		return msg
+	case err != nil:
+		return err.Error()
+	default:
+		return defaultMsg
+	}
+}
+
 // Type represents the KMS type used.
 type Type string
 
@@ -95,6 +279,14 @@ const (
 	AzureKMS Type = "azurekms"
 	// CAPIKMS
 	CAPIKMS Type = "capi"
+	// VaultKMS is a KMS implementation using the HashiCorp Vault transit
+	// secrets engine.
+	VaultKMS Type = "vaultkms"
+	// TPMKMS is a KMS implementation using a TPM 2.0.
+	TPMKMS Type = "tpm"
+	// MultiKMS is a KMS implementation that replicates the same keys in
+	// multiple KMSes.
+	MultiKMS Type = "multikms"
 )
 
 // Options are the KMS options. They represent the kms object in the ca.json.
@@ -130,6 +322,43 @@ type Options struct {
 
 	// Profile to use in AmazonKMS.
 	Profile string `json:"profile,omitempty"`
+
+	// URIs are the URIs used to configure each one of the replicas of a
+	// MultiKMS. The rest of the options are shared by all of them.
+	//
+	// Used by: multikms
+	URIs []string `json:"uris,omitempty"`
+
+	// Hooks are the callbacks used to report the events in the connection with
+	// a device, they can be used to collect metrics.
+	//
+	// Used by: pkcs11, yubikey
+	Hooks *ConnectionHooks `json:"-"`
+}
+
+// ConnectionHooks are the callbacks called by the KMS that can reconnect to a
+// device when the session with it is lost. All of them are optional.
+type ConnectionHooks struct {
+	// SessionLost is called when an operation fails because the session with
+	// the device has been lost.
+	SessionLost func(typ Type, err error)
+	// Reconnect is called after each attempt to reconnect to the device, err
+	// is nil if the attempt succeeded.
+	Reconnect func(typ Type, err error)
+}
+
+// NotifySessionLost calls the SessionLost hook if it is defined.
+func (h *ConnectionHooks) NotifySessionLost(typ Type, err error) {
+	if h != nil && h.SessionLost != nil {
+		h.SessionLost(typ, err)
+	}
+}
+
+// NotifyReconnect calls the Reconnect hook if it is defined.
+func (h *ConnectionHooks) NotifyReconnect(typ Type, err error) {
+	if h != nil && h.Reconnect != nil {
+		h.Reconnect(typ, err)
+	}
 }
 
 // Validate checks the fields in Options.
@@ -142,8 +371,8 @@ func (o *Options) Validate() error {
 	switch Type(typ) {
 	case DefaultKMS, SoftKMS: // Go crypto based kms.
 	case CloudKMS, AmazonKMS, AzureKMS: // Cloud based kms.
-	case YubiKey, PKCS11: // Hardware based kms.
-	case SSHAgentKMS, CAPIKMS: // Others
+	case YubiKey, PKCS11, TPMKMS: // Hardware based kms.
+	case SSHAgentKMS, CAPIKMS, VaultKMS, MultiKMS: // Others
 	default:
 		return fmt.Errorf("unsupported kms type %s", o.Type)
 	}
@@ -1,6 +1,9 @@
 package apiv1
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -16,6 +19,9 @@ func TestOptions_Validate(t *testing.T) {
 		{"awskms", &Options{Type: "awskms"}, false},
 		{"sshagentkms", &Options{Type: "sshagentkms"}, false},
 		{"pkcs11", &Options{Type: "pkcs11"}, false},
+		{"vaultkms", &Options{Type: "vaultkms"}, false},
+		{"tpm", &Options{Type: "tpm"}, false},
+		{"multikms", &Options{Type: "multikms"}, false},
 		{"unsupported", &Options{Type: "unsupported"}, true},
 	}
 	for _, tt := range tests {
@@ -48,6 +54,8 @@ func TestOptions_GetType(t *testing.T) {
 		{"ok by uri", fields{"", "yubikey:foo=bar"}, YubiKey, false},
 		{"ok by uri", fields{"", "sshagentkms:foo=bar"}, SSHAgentKMS, false},
 		{"ok by uri", fields{"", "azurekms:foo=bar"}, AzureKMS, false},
+		{"ok by uri", fields{"", "vaultkms:foo=bar"}, VaultKMS, false},
+		{"ok by uri", fields{"", "tpm:foo=bar"}, TPMKMS, false},
 		{"fail uri", fields{"", "foo=bar"}, DefaultKMS, true},
 	}
 	for _, tt := range tests {
@@ -115,3 +123,104 @@ func TestErrAlreadyExists_Error(t *testing.T) {
 		})
 	}
 }
+
+func TestErrors_Error(t *testing.T) {
+	cause := errors.New("the cause")
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"not found", NotFoundError{}, "not found"},
+		{"not found message", NotFoundError{Message: "key not found"}, "key not found"},
+		{"not found err", NotFoundError{Err: cause}, "the cause"},
+		{"not found message and err", NotFoundError{Message: "key not found", Err: cause}, "key not found: the cause"},
+		{"permission denied", PermissionDeniedError{}, "permission denied"},
+		{"permission denied message and err", PermissionDeniedError{Message: "sign failed", Err: cause}, "sign failed: the cause"},
+		{"pin locked", PINLockedError{}, "pin locked"},
+		{"pin locked message and err", PINLockedError{Message: "login failed", Err: cause}, "login failed: the cause"},
+		{"rate limited", RateLimitedError{}, "rate limited"},
+		{"rate limited message and err", RateLimitedError{Message: "sign failed", Err: cause}, "sign failed: the cause"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestErrors_Is(t *testing.T) {
+	cause := errors.New("the cause")
+	sentinels := []error{
+		ErrNotImplemented, ErrAlreadyExists, ErrNotFound,
+		ErrPermissionDenied, ErrPINLocked, ErrRateLimited,
+	}
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"not implemented", NotImplementedError{Message: "not implemented"}, ErrNotImplemented},
+		{"already exists", AlreadyExistsError{Message: "already exists"}, ErrAlreadyExists},
+		{"not found", NotFoundError{Message: "key not found", Err: cause}, ErrNotFound},
+		{"permission denied", PermissionDeniedError{Message: "sign failed", Err: cause}, ErrPermissionDenied},
+		{"pin locked", PINLockedError{Message: "login failed", Err: cause}, ErrPINLocked},
+		{"rate limited", RateLimitedError{Message: "sign failed", Err: cause}, ErrRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fmt.Errorf("wrapped: %w", tt.err)
+			for _, target := range sentinels {
+				if got := errors.Is(err, target); got != (target == tt.want) {
+					t.Errorf("errors.Is(%T, %T) = %v, want %v", tt.err, target, got, !got)
+				}
+			}
+			if u, ok := tt.err.(interface{ Unwrap() error }); ok && !errors.Is(err, cause) {
+				t.Errorf("errors.Is(%T, cause) = false, want true, Unwrap() = %v", tt.err, u.Unwrap())
+			}
+		})
+	}
+}
+
+func TestConnectionHooks(t *testing.T) {
+	var sessionLost, reconnect []error
+	hooks := &ConnectionHooks{
+		SessionLost: func(typ Type, err error) {
+			if typ != PKCS11 {
+				t.Errorf("ConnectionHooks.SessionLost() type = %v, want %v", typ, PKCS11)
+			}
+			sessionLost = append(sessionLost, err)
+		},
+		Reconnect: func(typ Type, err error) {
+			if typ != PKCS11 {
+				t.Errorf("ConnectionHooks.Reconnect() type = %v, want %v", typ, PKCS11)
+			}
+			reconnect = append(reconnect, err)
+		},
+	}
+	errLost := errors.New("session lost")
+
+	tests := []struct {
+		name  string
+		hooks *ConnectionHooks
+	}{
+		{"ok", hooks},
+		{"ok empty", &ConnectionHooks{}},
+		{"ok nil", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.hooks.NotifySessionLost(PKCS11, errLost)
+			tt.hooks.NotifyReconnect(PKCS11, nil)
+		})
+	}
+
+	if !reflect.DeepEqual(sessionLost, []error{errLost}) {
+		t.Errorf("ConnectionHooks.NotifySessionLost() calls = %v, want [%v]", sessionLost, errLost)
+	}
+	if !reflect.DeepEqual(reconnect, []error{nil}) {
+		t.Errorf("ConnectionHooks.NotifyReconnect() calls = %v, want [<nil>]", reconnect)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto"
 	"crypto/x509"
 	"fmt"
+	"time"
 )
 
 // ProtectionLevel specifies on some KMS how cryptographic operations are
@@ -17,6 +18,11 @@ const (
 	Software
 	// Crypto operations are performed in a Hardware Security Module.
 	HSM
+	// Crypto operations are performed by an external key manager.
+	External
+	// Crypto operations are performed by an external key manager reached
+	// through a VPC network.
+	ExternalVPC
 )
 
 // PINPolicy represents PIN requirements when signing or decrypting with an
@@ -57,6 +63,10 @@ func (p ProtectionLevel) String() string {
 		return "software"
 	case HSM:
 		return "hsm"
+	case External:
+		return "external"
+	case ExternalVPC:
+		return "external-vpc"
 	default:
 		return fmt.Sprintf("unknown(%d)", p)
 	}
@@ -120,6 +130,37 @@ func (s SignatureAlgorithm) String() string {
 	}
 }
 
+// WrapAlgorithm is the algorithm used to wrap and unwrap keys.
+type WrapAlgorithm int
+
+const (
+	// Not specified, AES key wrap with padding will be used.
+	UnspecifiedWrapAlgorithm WrapAlgorithm = iota
+	// AES key wrap as defined in RFC 3394. The length of the key to wrap must
+	// be a multiple of 8 bytes.
+	AESKeyWrap
+	// AES key wrap with padding as defined in RFC 5649.
+	AESKeyWrapPad
+	// RSA-OAEP with SHA-256. It can only wrap small keys, like AES keys.
+	RSAOAEPWithSHA256
+)
+
+// String returns a string representation of the wrap algorithm.
+func (w WrapAlgorithm) String() string {
+	switch w {
+	case UnspecifiedWrapAlgorithm:
+		return "unspecified"
+	case AESKeyWrap:
+		return "AES-KW"
+	case AESKeyWrapPad:
+		return "AES-KWP"
+	case RSAOAEPWithSHA256:
+		return "RSA-OAEP-SHA256"
+	default:
+		return fmt.Sprintf("unknown(%d)", w)
+	}
+}
+
 // GetPublicKeyRequest is the parameter used in the kms.GetPublicKey method.
 type GetPublicKeyRequest struct {
 	Name string
@@ -129,7 +170,8 @@ type GetPublicKeyRequest struct {
 type CreateKeyRequest struct {
 	// Name represents the key name or label used to identify a key.
 	//
-	// Used by: awskms, cloudkms, azurekms, pkcs11, yubikey.
+	// Used by: awskms, cloudkms, azurekms, pkcs11, yubikey, vaultkms, tpmkms,
+	// softkms, sshagentkms.
 	Name string
 
 	// SignatureAlgorithm represents the type of key to create.
@@ -138,10 +180,62 @@ type CreateKeyRequest struct {
 	// Bits is the number of bits on RSA keys.
 	Bits int
 
+	// PublicExponent is the public exponent of RSA keys. If not set, the
+	// default exponent 65537 is used.
+	//
+	// Used by: pkcs11
+	PublicExponent int
+
 	// ProtectionLevel specifies how cryptographic operations are performed.
 	// Used by: cloudkms, azurekms.
 	ProtectionLevel ProtectionLevel
 
+	// EKMConnection is the resource name of the EKM connection used with the
+	// ExternalVPC protection level, with the format
+	// projects/*/locations/*/ekmConnections/*.
+	//
+	// Used by: cloudkms
+	EKMConnection string
+
+	// ExternalKey identifies the key material in the external key manager. With
+	// the External protection level it is the key URI, and with ExternalVPC the
+	// key path in the EKM connection.
+	//
+	// Used by: cloudkms
+	ExternalKey string
+
+	// Decrypt creates an RSA key used to decrypt data instead of signing it.
+	//
+	// Used by: awskms, cloudkms, azurekms
+	Decrypt bool
+
+	// Labels is a set of key-value pairs added as labels or tags to the new
+	// key.
+	//
+	// Used by: awskms
+	Labels map[string]string
+
+	// Symmetric creates a symmetric key, used with the SymmetricEncrypter
+	// interface, instead of an asymmetric key. The SignatureAlgorithm, Bits
+	// and Decrypt fields must not be set, and the response does not include a
+	// public key.
+	//
+	// Used by: awskms
+	Symmetric bool
+
+	// CustomKeyStoreID is the id of the custom key store, backed by an AWS
+	// CloudHSM cluster, where the key will be created. AWS custom key stores
+	// only support symmetric keys, so Symmetric must be set.
+	//
+	// Used by: awskms
+	CustomKeyStoreID string
+
+	// Policy is the JSON policy document attached to the new key. If it's not
+	// set, the default policy of the KMS is used.
+	//
+	// Used by: awskms
+	Policy string
+
 	// Extractable defines if the new key may be exported from the HSM under a
 	// wrap key. On pkcs11 sets the CKA_EXTRACTABLE bit.
 	//
@@ -159,6 +253,23 @@ type CreateKeyRequest struct {
 	//
 	// Used by: yubikey
 	TouchPolicy TouchPolicy
+
+	// Password is the password used to encrypt the private key file.
+	//
+	// Used by: softkms
+	Password []byte
+
+	// Lifetime is the time the key will be kept in the agent. A zero lifetime
+	// keeps the key until it is removed.
+	//
+	// Used by: sshagentkms
+	Lifetime time.Duration
+
+	// ConfirmBeforeUse requires the agent to ask for confirmation every time
+	// the key is used.
+	//
+	// Used by: sshagentkms
+	ConfirmBeforeUse bool
 }
 
 // CreateKeyResponse is the response value of the kms.CreateKey method.
@@ -207,6 +318,20 @@ type StoreCertificateRequest struct {
 	Extractable bool
 }
 
+// LoadCertificateChainRequest is the parameter used in the LoadCertificateChain
+// method of a CertificateChainManager.
+type LoadCertificateChainRequest struct {
+	Name string
+}
+
+// StoreCertificateChainRequest is the parameter used in the
+// StoreCertificateChain method of a CertificateChainManager. The first
+// certificate in the chain is the leaf certificate.
+type StoreCertificateChainRequest struct {
+	Name             string
+	CertificateChain []*x509.Certificate
+}
+
 // CreateAttestationRequest is the parameter used in the kms.CreateAttestation
 // method.
 //
@@ -216,6 +341,12 @@ type StoreCertificateRequest struct {
 // release.
 type CreateAttestationRequest struct {
 	Name string
+
+	// Nonce is the qualifying data included in the attestation, it should be
+	// a fresh value provided by the verifier.
+	//
+	// Used by: tpmkms
+	Nonce []byte
 }
 
 // CreateAttestationResponse is the response value of the kms.CreateAttestation
@@ -229,4 +360,203 @@ type CreateAttestationResponse struct {
 	Certificate      *x509.Certificate
 	CertificateChain []*x509.Certificate
 	PublicKey        crypto.PublicKey
+
+	// AttestationData is the TPMS_ATTEST structure signed by the attestation
+	// key, AttestationSignature the TPMT_SIGNATURE over it, and
+	// AttestationPublicKey the public key of the attestation key.
+	//
+	// Used by: tpmkms
+	AttestationData      []byte
+	AttestationSignature []byte
+	AttestationPublicKey crypto.PublicKey
+
+	// EndorsementKeyCertificates are the certificates of the TPM endorsement
+	// keys. They do not certify the attestation key, a verifier must bind the
+	// attestation key to an endorsement key, for example using credential
+	// activation, before trusting the attestation.
+	//
+	// Used by: tpmkms
+	EndorsementKeyCertificates []*x509.Certificate
+}
+
+// ListKeysRequest is the parameter used in the kms.ListKeys method.
+type ListKeysRequest struct {
+	// Name is the parent of the keys to list. On softkms it's a directory, on
+	// cloudkms a key ring, and on azurekms a vault URI. Other KMSs list all the
+	// keys available with the configured credentials.
+	//
+	// Used by: softkms, cloudkms, azurekms.
+	Name string
+
+	// NameFilter is a pattern with the syntax used by path.Match. If set only
+	// keys with a short name, a label, file name or key id depending on the
+	// KMS, matching the pattern will be returned.
+	NameFilter string
+
+	// Labels is a set of key-value pairs that must be present in the labels or
+	// tags of a key for it to be returned.
+	//
+	// Used by: awskms, cloudkms, azurekms.
+	Labels map[string]string
+
+	// PageSize is the maximum number of keys to return. If it's 0 all the keys
+	// will be returned.
+	PageSize int
+
+	// PageToken is the value of NextPageToken in a previous response, and it's
+	// used to retrieve the next page of keys.
+	PageToken string
+}
+
+// ListKeysResponse is the response value of the kms.ListKeys method.
+type ListKeysResponse struct {
+	Keys          []*KeyInfo
+	NextPageToken string
+}
+
+// KeyInfo describes a key returned by the kms.ListKeys method.
+type KeyInfo struct {
+	// Name is the key name or URI, it can be used in GetPublicKeyRequest and
+	// CreateSignerRequest.
+	Name string
+
+	// SignatureAlgorithm is the signature algorithm of the key, it will be
+	// UnspecifiedSignAlgorithm if it cannot be determined.
+	SignatureAlgorithm SignatureAlgorithm
+
+	// ProtectionLevel specifies how cryptographic operations are performed.
+	ProtectionLevel ProtectionLevel
+
+	// Labels are the labels or tags associated with the key.
+	Labels map[string]string
+}
+
+// DeleteKeyRequest is the parameter used in the kms.DeleteKey method.
+type DeleteKeyRequest struct {
+	// Name is the key name or URI.
+	Name string
+
+	// PendingWindow is the waiting period before a key is destroyed, during
+	// this time the destruction can be canceled. If it's not set the KMS
+	// default will be used. Cloud KMS and Azure Key Vault use the destruction
+	// window configured in the key or vault.
+	//
+	// Used by: awskms.
+	PendingWindow time.Duration
+}
+
+// RotateKeyRequest is the parameter used in the kms.RotateKey method.
+type RotateKeyRequest struct {
+	// Name is the name or URI of an existing key. The version of the key in the
+	// name, if any, is ignored.
+	Name string
+}
+
+// ImportKeyRequest is the parameter used in the kms.ImportKey method.
+type ImportKeyRequest struct {
+	// Name represents the key name or label used to identify the key.
+	//
+	// Used by: yubikey, azurekms
+	Name string
+
+	// PrivateKey is the key to import.
+	PrivateKey crypto.PrivateKey
+
+	// PrivateKeyPEM is the PEM encoding of the key to import. It is used if
+	// PrivateKey is not set.
+	PrivateKeyPEM []byte
+
+	// Password is the password used to decrypt the PrivateKeyPEM.
+	Password []byte
+
+	// Certificate is the certificate for the key that will be stored with it.
+	// It is required by the yubikey, as the public key of an imported key can
+	// only be read from the certificate.
+	//
+	// Used by: yubikey
+	Certificate *x509.Certificate
+
+	// PINPolicy defines PIN requirements when signing or decrypting with the
+	// key.
+	//
+	// Used by: yubikey
+	PINPolicy PINPolicy
+
+	// TouchPolicy represents proof-of-presence requirements when signing or
+	// decrypting with the key.
+	//
+	// Used by: yubikey
+	TouchPolicy TouchPolicy
+}
+
+// WrapKeyRequest is the parameter used in the kms.WrapKey method.
+type WrapKeyRequest struct {
+	// Name is the name or URI of the key to wrap. The key must be extractable.
+	Name string
+
+	// WrappingKey is the name or URI of the key used to wrap the key.
+	WrappingKey string
+
+	// Algorithm is the algorithm used to wrap the key.
+	Algorithm WrapAlgorithm
+}
+
+// UnwrapKeyRequest is the parameter used in the kms.UnwrapKey method.
+type UnwrapKeyRequest struct {
+	// Name is the name or URI of the new key.
+	Name string
+
+	// WrappingKey is the name or URI of the key used to unwrap the key.
+	WrappingKey string
+
+	// WrappedKey is the wrapped key as returned by WrapKey.
+	WrappedKey []byte
+
+	// Algorithm is the algorithm used to wrap the key.
+	Algorithm WrapAlgorithm
+
+	// SignatureAlgorithm represents the type of the wrapped private key.
+	SignatureAlgorithm SignatureAlgorithm
+
+	// SecretKey indicates that the wrapped key is an AES key instead of a
+	// private key.
+	SecretKey bool
+
+	// Extractable defines if the new key may be wrapped again.
+	Extractable bool
+}
+
+// EncryptRequest is the parameter used in the kms.Encrypt method.
+type EncryptRequest struct {
+	// Name is the name or URI of the symmetric key.
+	Name string
+
+	// Plaintext is the data to encrypt.
+	Plaintext []byte
+
+	// AdditionalData is optional data that is authenticated but not
+	// encrypted. The same value must be used to decrypt the ciphertext.
+	AdditionalData []byte
+
+	// Password is the password used to decrypt the key file.
+	//
+	// Used by: softkms.
+	Password []byte
+}
+
+// DecryptRequest is the parameter used in the kms.Decrypt method.
+type DecryptRequest struct {
+	// Name is the name or URI of the symmetric key.
+	Name string
+
+	// Ciphertext is the data to decrypt, as returned by the kms.Encrypt method.
+	Ciphertext []byte
+
+	// AdditionalData is the optional data authenticated during the encryption.
+	AdditionalData []byte
+
+	// Password is the password used to decrypt the key file.
+	//
+	// Used by: softkms.
+	Password []byte
 }
@@ -11,6 +11,8 @@ func TestProtectionLevel_String(t *testing.T) {
 		{"unspecified", UnspecifiedProtectionLevel, "unspecified"},
 		{"software", Software, "software"},
 		{"hsm", HSM, "hsm"},
+		{"external", External, "external"},
+		{"external-vpc", ExternalVPC, "external-vpc"},
 		{"unknown", ProtectionLevel(100), "unknown(100)"},
 	}
 	for _, tt := range tests {
@@ -49,3 +51,24 @@ func TestSignatureAlgorithm_String(t *testing.T) {
 		})
 	}
 }
+
+func TestWrapAlgorithm_String(t *testing.T) {
+	tests := []struct {
+		name string
+		w    WrapAlgorithm
+		want string
+	}{
+		{"UnspecifiedWrapAlgorithm", UnspecifiedWrapAlgorithm, "unspecified"},
+		{"AESKeyWrap", AESKeyWrap, "AES-KW"},
+		{"AESKeyWrapPad", AESKeyWrapPad, "AES-KWP"},
+		{"RSAOAEPWithSHA256", RSAOAEPWithSHA256, "RSA-OAEP-SHA256"},
+		{"unknown", WrapAlgorithm(100), "unknown(100)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.w.String(); got != tt.want {
+				t.Errorf("WrapAlgorithm.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
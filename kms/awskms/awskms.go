@@ -3,11 +3,14 @@ package awskms
 import (
 	"context"
 	"crypto"
+	"encoding/base64"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
@@ -20,6 +23,13 @@ import (
 // Scheme is the scheme used in uris.
 const Scheme = "awskms"
 
+// keySchema defines the attributes allowed in the key URIs, like
+// awskms:key-id=9d8f4c6e-5b2a-4a1c-8e7f-3b6d2c1a0f9e.
+var keySchema = &uri.Schema{
+	Scheme: Scheme,
+	Path:   []string{"key-id"},
+}
+
 // KMS implements a KMS using AWS Key Management Service.
 type KMS struct {
 	session *session.Session
@@ -33,8 +43,18 @@ type KeyManagementClient interface {
 	CreateKeyWithContext(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error)
 	CreateAliasWithContext(ctx aws.Context, input *kms.CreateAliasInput, opts ...request.Option) (*kms.CreateAliasOutput, error)
 	SignWithContext(ctx aws.Context, input *kms.SignInput, opts ...request.Option) (*kms.SignOutput, error)
+	ListKeysWithContext(ctx aws.Context, input *kms.ListKeysInput, opts ...request.Option) (*kms.ListKeysOutput, error)
+	DescribeKeyWithContext(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error)
+	ListResourceTagsWithContext(ctx aws.Context, input *kms.ListResourceTagsInput, opts ...request.Option) (*kms.ListResourceTagsOutput, error)
+	ScheduleKeyDeletionWithContext(ctx aws.Context, input *kms.ScheduleKeyDeletionInput, opts ...request.Option) (*kms.ScheduleKeyDeletionOutput, error)
+	EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error)
+	DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error)
 }
 
+// additionalDataKey is the encryption context key used to store the additional
+// authenticated data in Encrypt and Decrypt.
+const additionalDataKey = "aad"
+
 // customerMasterKeySpecMapping is a mapping between the step signature algorithm,
 // and bits for RSA keys, with awskms CustomerMasterKeySpec.
 var customerMasterKeySpecMapping = map[apiv1.SignatureAlgorithm]interface{}{
@@ -64,6 +84,17 @@ var customerMasterKeySpecMapping = map[apiv1.SignatureAlgorithm]interface{}{
 	apiv1.ECDSAWithSHA512: kms.CustomerMasterKeySpecEccNistP521,
 }
 
+// keySpecMapping is a mapping between the awskms KeySpec and the default step
+// signature algorithm used with it.
+var keySpecMapping = map[string]apiv1.SignatureAlgorithm{
+	kms.KeySpecRsa2048:     apiv1.SHA256WithRSA,
+	kms.KeySpecRsa3072:     apiv1.SHA256WithRSA,
+	kms.KeySpecRsa4096:     apiv1.SHA256WithRSA,
+	kms.KeySpecEccNistP256: apiv1.ECDSAWithSHA256,
+	kms.KeySpecEccNistP384: apiv1.ECDSAWithSHA384,
+	kms.KeySpecEccNistP521: apiv1.ECDSAWithSHA512,
+}
+
 // New creates a new AWSKMS. By default, sessions will be created using the
 // credentials in `~/.aws/credentials`, but this can be overridden using the
 // CredentialsFile option, the Region and Profile can also be configured as
@@ -112,6 +143,7 @@ func New(ctx context.Context, opts apiv1.Options) (*KMS, error) {
 }
 
 func init() {
+	uri.RegisterSchema(keySchema)
 	apiv1.Register(apiv1.AmazonKMS, func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
 		return New(ctx, opts)
 	})
@@ -134,50 +166,105 @@ func (k *KMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, er
 		KeyId: &keyID,
 	})
 	if err != nil {
-		return nil, errors.Wrap(err, "awskms GetPublicKeyWithContext failed")
+		return nil, errors.Wrap(convertError(err), "awskms GetPublicKeyWithContext failed")
 	}
 
 	return pemutil.ParseDER(resp.PublicKey)
 }
 
 // CreateKey generates a new key in KMS and returns the public key version
-// of it.
+// of it. If the request sets Decrypt, an RSA key for RSA-OAEP decryption is
+// created instead of a signing key. The labels in the request are added as tags
+// next to the "name" tag.
+//
+// If the request sets Symmetric, a SYMMETRIC_DEFAULT key that can be used with
+// Encrypt and Decrypt is created, and the response does not include a public
+// key. Only symmetric keys can be created in a custom key store backed by AWS
+// CloudHSM, using the CustomKeyStoreID.
 func (k *KMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
 	if req.Name == "" {
 		return nil, errors.New("createKeyRequest 'name' cannot be empty")
 	}
+	if _, ok := req.Labels["name"]; ok {
+		return nil, errors.New("createKeyRequest 'labels' cannot contain the reserved tag 'name'")
+	}
 
-	keySpec, err := getCustomerMasterKeySpecMapping(req.SignatureAlgorithm, req.Bits)
-	if err != nil {
-		return nil, err
+	var keyUsage, keySpec string
+	switch {
+	case req.Symmetric:
+		if req.SignatureAlgorithm != apiv1.UnspecifiedSignAlgorithm || req.Bits != 0 || req.Decrypt {
+			return nil, errors.New("createKeyRequest 'signatureAlgorithm', 'bits' and 'decrypt' cannot be used with 'symmetric'")
+		}
+		keyUsage = kms.KeyUsageTypeEncryptDecrypt
+		keySpec = kms.CustomerMasterKeySpecSymmetricDefault
+	case req.CustomKeyStoreID != "":
+		return nil, errors.New("createKeyRequest 'customKeyStoreID' requires 'symmetric': awskms custom key stores only support symmetric keys")
+	default:
+		keyUsage = kms.KeyUsageTypeSignVerify
+		signatureAlgorithm := req.SignatureAlgorithm
+		if req.Decrypt {
+			keyUsage = kms.KeyUsageTypeEncryptDecrypt
+			if signatureAlgorithm == apiv1.UnspecifiedSignAlgorithm {
+				signatureAlgorithm = apiv1.SHA256WithRSA
+			}
+		}
+
+		var err error
+		if keySpec, err = getCustomerMasterKeySpecMapping(signatureAlgorithm, req.Bits); err != nil {
+			return nil, err
+		}
+		if req.Decrypt && !strings.HasPrefix(keySpec, "RSA_") {
+			return nil, errors.Errorf("awskms does not support decryption keys with signature algorithm '%s'", signatureAlgorithm)
+		}
 	}
 
 	tag := new(kms.Tag)
 	tag.SetTagKey("name")
 	tag.SetTagValue(req.Name)
+	tags := []*kms.Tag{tag}
+	for _, key := range sortedKeys(req.Labels) {
+		t := new(kms.Tag)
+		t.SetTagKey(key)
+		t.SetTagValue(req.Labels[key])
+		tags = append(tags, t)
+	}
 
 	input := &kms.CreateKeyInput{
 		Description:           &req.Name,
 		CustomerMasterKeySpec: &keySpec,
-		Tags:                  []*kms.Tag{tag},
+		Tags:                  tags,
+	}
+	input.SetKeyUsage(keyUsage)
+	if req.CustomKeyStoreID != "" {
+		input.SetCustomKeyStoreId(req.CustomKeyStoreID)
+		input.SetOrigin(kms.OriginTypeAwsCloudhsm)
+	}
+	if req.Policy != "" {
+		input.SetPolicy(req.Policy)
 	}
-	input.SetKeyUsage(kms.KeyUsageTypeSignVerify)
 
 	ctx, cancel := defaultContext()
 	defer cancel()
 
 	resp, err := k.service.CreateKeyWithContext(ctx, input)
 	if err != nil {
-		return nil, errors.Wrap(err, "awskms CreateKeyWithContext failed")
+		return nil, errors.Wrap(convertError(err), "awskms CreateKeyWithContext failed")
 	}
 	if err := k.createKeyAlias(*resp.KeyMetadata.KeyId, req.Name); err != nil {
 		return nil, err
 	}
 
 	// Create uri for key
-	name := uri.New("awskms", url.Values{
+	name := keySchema.String(url.Values{
 		"key-id": []string{*resp.KeyMetadata.KeyId},
-	}).String()
+	})
+
+	// Symmetric keys do not have a public key and cannot sign.
+	if req.Symmetric {
+		return &apiv1.CreateKeyResponse{
+			Name: name,
+		}, nil
+	}
 
 	publicKey, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{
 		Name: name,
@@ -208,7 +295,7 @@ func (k *KMS) createKeyAlias(keyID, alias string) error {
 		TargetKeyId: &keyID,
 	})
 	if err != nil {
-		return errors.Wrap(err, "awskms CreateAliasWithContext failed")
+		return errors.Wrap(convertError(err), "awskms CreateAliasWithContext failed")
 	}
 	return nil
 }
@@ -221,15 +308,260 @@ func (k *KMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error
 	return NewSigner(k.service, req.SigningKey)
 }
 
+// CreateDecrypter creates a new crypto.Decrypter with a previously configured
+// RSA key. Only RSAES_OAEP_SHA_256 is supported.
+func (k *KMS) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Decrypter, error) {
+	if req.DecryptionKey == "" {
+		return nil, errors.New("createDecrypterRequest 'decryptionKey' cannot be empty")
+	}
+	d, err := NewDecrypter(k.service, req.DecryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListKeys returns the keys in KMS. Pagination uses the native awskms markers,
+// the name filter is matched against the "name" tag added by CreateKey, or the
+// key id if the tag is not present, and labels are matched against the tags.
+// The labels of the returned keys are the tags other than "name", and keys
+// that cannot sign do not have a signature algorithm.
+// Because filters are applied after a page is retrieved, a page can have less
+// keys than the requested page size.
+func (k *KMS) ListKeys(req *apiv1.ListKeysRequest) (*apiv1.ListKeysResponse, error) {
+	if req.PageSize < 0 {
+		return nil, errors.New("listKeysRequest 'pageSize' cannot be negative")
+	}
+
+	input := &kms.ListKeysInput{}
+	if req.PageSize > 0 {
+		input.SetLimit(int64(req.PageSize))
+	}
+	if req.PageToken != "" {
+		input.SetMarker(req.PageToken)
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := k.service.ListKeysWithContext(ctx, input)
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "awskms ListKeysWithContext failed")
+	}
+
+	keys := []*apiv1.KeyInfo{}
+	for _, entry := range resp.Keys {
+		keyID := aws.StringValue(entry.KeyId)
+		tags, err := k.listTags(ctx, keyID)
+		if err != nil {
+			return nil, err
+		}
+		name := keyID
+		if v, ok := tags["name"]; ok {
+			name = v
+		}
+		if !req.MatchKey(name, tags) {
+			continue
+		}
+
+		md, err := k.service.DescribeKeyWithContext(ctx, &kms.DescribeKeyInput{
+			KeyId: &keyID,
+		})
+		if err != nil {
+			return nil, errors.Wrap(convertError(err), "awskms DescribeKeyWithContext failed")
+		}
+
+		// The "name" tag added by CreateKey is not a label.
+		var labels map[string]string
+		for key, value := range tags {
+			if key == "name" {
+				continue
+			}
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[key] = value
+		}
+
+		// Only signing keys have a signature algorithm.
+		var signatureAlgorithm apiv1.SignatureAlgorithm
+		if aws.StringValue(md.KeyMetadata.KeyUsage) == kms.KeyUsageTypeSignVerify {
+			signatureAlgorithm = keySpecMapping[aws.StringValue(md.KeyMetadata.KeySpec)]
+		}
+
+		keys = append(keys, &apiv1.KeyInfo{
+			Name: keySchema.String(url.Values{
+				"key-id": []string{keyID},
+			}),
+			SignatureAlgorithm: signatureAlgorithm,
+			ProtectionLevel:    getProtectionLevel(md.KeyMetadata),
+			Labels:             labels,
+		})
+	}
+
+	var nextPageToken string
+	if aws.BoolValue(resp.Truncated) {
+		nextPageToken = aws.StringValue(resp.NextMarker)
+	}
+
+	return &apiv1.ListKeysResponse{
+		Keys:          keys,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// originTypeExternalKeyStore is the origin of the keys in an external key
+// store, it's not defined in the version of the SDK used.
+const originTypeExternalKeyStore = "EXTERNAL_KEY_STORE"
+
+// getProtectionLevel returns the protection level of a key using its origin.
+// The key material of the keys created in AWS KMS or in a CloudHSM custom key
+// store, and the imported one, is protected by HSMs, the keys in an external
+// key store are kept outside AWS.
+func getProtectionLevel(md *kms.KeyMetadata) apiv1.ProtectionLevel {
+	switch aws.StringValue(md.Origin) {
+	case kms.OriginTypeAwsKms, kms.OriginTypeAwsCloudhsm, kms.OriginTypeExternal:
+		return apiv1.HSM
+	case originTypeExternalKeyStore:
+		return apiv1.External
+	default:
+		return apiv1.UnspecifiedProtectionLevel
+	}
+}
+
+func (k *KMS) listTags(ctx context.Context, keyID string) (map[string]string, error) {
+	resp, err := k.service.ListResourceTagsWithContext(ctx, &kms.ListResourceTagsInput{
+		KeyId: &keyID,
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "awskms ListResourceTagsWithContext failed")
+	}
+	tags := make(map[string]string, len(resp.Tags))
+	for _, t := range resp.Tags {
+		tags[aws.StringValue(t.TagKey)] = aws.StringValue(t.TagValue)
+	}
+	return tags, nil
+}
+
+// DeleteKey schedules the deletion of a key in KMS. The pending window in the
+// request is rounded up to days, if it's not set, the awskms default of 30 days
+// will be used.
+func (k *KMS) DeleteKey(req *apiv1.DeleteKeyRequest) error {
+	if req.Name == "" {
+		return errors.New("deleteKeyRequest 'name' cannot be empty")
+	}
+	if req.PendingWindow < 0 {
+		return errors.New("deleteKeyRequest 'pendingWindow' cannot be negative")
+	}
+	keyID, err := parseKeyID(req.Name)
+	if err != nil {
+		return err
+	}
+
+	input := &kms.ScheduleKeyDeletionInput{
+		KeyId: &keyID,
+	}
+	if req.PendingWindow > 0 {
+		days := (req.PendingWindow + 24*time.Hour - 1) / (24 * time.Hour)
+		input.SetPendingWindowInDays(int64(days))
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	if _, err := k.service.ScheduleKeyDeletionWithContext(ctx, input); err != nil {
+		return errors.Wrap(convertError(err), "awskms ScheduleKeyDeletionWithContext failed")
+	}
+	return nil
+}
+
+// Encrypt encrypts the given plaintext using a symmetric key in KMS. The
+// additional data, if any, is sent base64 encoded in the encryption context.
+func (k *KMS) Encrypt(req *apiv1.EncryptRequest) ([]byte, error) {
+	if req.Name == "" {
+		return nil, errors.New("encryptRequest 'name' cannot be empty")
+	}
+	keyID, err := parseKeyID(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := k.service.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:             &keyID,
+		Plaintext:         req.Plaintext,
+		EncryptionContext: encryptionContext(req.AdditionalData),
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "awskms EncryptWithContext failed")
+	}
+	return resp.CiphertextBlob, nil
+}
+
+// Decrypt decrypts the given ciphertext, as returned by Encrypt, using a
+// symmetric key in KMS.
+func (k *KMS) Decrypt(req *apiv1.DecryptRequest) ([]byte, error) {
+	if req.Name == "" {
+		return nil, errors.New("decryptRequest 'name' cannot be empty")
+	}
+	keyID, err := parseKeyID(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := k.service.DecryptWithContext(ctx, &kms.DecryptInput{
+		KeyId:             &keyID,
+		CiphertextBlob:    req.Ciphertext,
+		EncryptionContext: encryptionContext(req.AdditionalData),
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "awskms DecryptWithContext failed")
+	}
+	return resp.Plaintext, nil
+}
+
 // Close closes the connection of the KMS client.
 func (k *KMS) Close() error {
 	return nil
 }
 
+// ValidateName validates that the given string is a valid key id or URI.
+func (k *KMS) ValidateName(s string) error {
+	if uri.HasScheme(Scheme, s) {
+		if err := keySchema.Validate(s); err != nil {
+			return err
+		}
+	}
+	keyID, err := parseKeyID(s)
+	if err != nil {
+		return err
+	}
+	if keyID == "" {
+		return errors.New("key name cannot be empty")
+	}
+	return nil
+}
+
 func defaultContext() (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), 15*time.Second)
 }
 
+// encryptionContext returns the encryption context used to authenticate the
+// given additional data.
+func encryptionContext(additionalData []byte) map[string]*string {
+	if len(additionalData) == 0 {
+		return nil
+	}
+	return map[string]*string{
+		additionalDataKey: aws.String(base64.StdEncoding.EncodeToString(additionalData)),
+	}
+}
+
 // parseKeyID extracts the key-id from an uri.
 func parseKeyID(name string) (string, error) {
 	name = strings.ToLower(name)
@@ -246,6 +578,16 @@ func parseKeyID(name string) (string, error) {
 	return name, nil
 }
 
+// sortedKeys returns the keys of the given map in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func getCustomerMasterKeySpecMapping(alg apiv1.SignatureAlgorithm, bits int) (string, error) {
 	v, ok := customerMasterKeySpecMapping[alg]
 	if !ok {
@@ -265,3 +607,22 @@ func getCustomerMasterKeySpecMapping(alg apiv1.SignatureAlgorithm, bits int) (st
 		return "", errors.Errorf("unexpected error: this should not happen")
 	}
 }
+
+// convertError converts the errors returned by AWS KMS to the error types
+// defined in apiv1.
+func convertError(err error) error {
+	var e awserr.Error
+	if !errors.As(err, &e) {
+		return err
+	}
+	switch e.Code() {
+	case kms.ErrCodeNotFoundException:
+		return apiv1.NotFoundError{Err: err}
+	case "AccessDeniedException", "UnrecognizedClientException", "ExpiredTokenException":
+		return apiv1.PermissionDeniedError{Err: err}
+	case "ThrottlingException", kms.ErrCodeLimitExceededException:
+		return apiv1.RateLimitedError{Err: err}
+	default:
+		return err
+	}
+}
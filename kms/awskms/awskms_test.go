@@ -3,13 +3,16 @@ package awskms
 import (
 	"context"
 	"crypto"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
@@ -163,6 +166,10 @@ func TestKMS_CreateKey(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	rsaKey, err := pemutil.ParseKey([]byte(rsaPublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	type fields struct {
 		session *session.Session
@@ -199,7 +206,134 @@ func TestKMS_CreateKey(t *testing.T) {
 				SigningKey: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
 			},
 		}, false},
+		{"ok decrypt", fields{nil, &MockClient{
+			createKeyWithContext: func(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error) {
+				if aws.StringValue(input.KeyUsage) != kms.KeyUsageTypeEncryptDecrypt {
+					return nil, fmt.Errorf("unexpected key usage %s", aws.StringValue(input.KeyUsage))
+				}
+				if aws.StringValue(input.CustomerMasterKeySpec) != kms.CustomerMasterKeySpecRsa3072 {
+					return nil, fmt.Errorf("unexpected key spec %s", aws.StringValue(input.CustomerMasterKeySpec))
+				}
+				md := new(kms.KeyMetadata)
+				md.SetKeyId(rsaKeyID)
+				return &kms.CreateKeyOutput{
+					KeyMetadata: md,
+				}, nil
+			},
+			createAliasWithContext:  okClient.createAliasWithContext,
+			getPublicKeyWithContext: okClient.getPublicKeyWithContext,
+		}}, args{&apiv1.CreateKeyRequest{
+			Name:    "decrypter",
+			Decrypt: true,
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "awskms:key-id=4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b",
+			PublicKey: rsaKey,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "awskms:key-id=4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b",
+			},
+		}, false},
+		{"ok options", fields{nil, &MockClient{
+			createKeyWithContext: func(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error) {
+				wantTags := []*kms.Tag{
+					{TagKey: aws.String("name"), TagValue: aws.String("root")},
+					{TagKey: aws.String("env"), TagValue: aws.String("prod")},
+					{TagKey: aws.String("team"), TagValue: aws.String("pki")},
+				}
+				switch {
+				case input.CustomKeyStoreId != nil || input.Origin != nil:
+					return nil, fmt.Errorf("unexpected custom key store %s", aws.StringValue(input.CustomKeyStoreId))
+				case aws.StringValue(input.Policy) != `{"Version":"2012-10-17"}`:
+					return nil, fmt.Errorf("unexpected policy %s", aws.StringValue(input.Policy))
+				case !reflect.DeepEqual(input.Tags, wantTags):
+					return nil, fmt.Errorf("unexpected tags %v", input.Tags)
+				}
+				return okClient.createKeyWithContext(ctx, input, opts...)
+			},
+			createAliasWithContext:  okClient.createAliasWithContext,
+			getPublicKeyWithContext: okClient.getPublicKeyWithContext,
+		}}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			Labels:             map[string]string{"team": "pki", "env": "prod"},
+			Policy:             `{"Version":"2012-10-17"}`,
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			PublicKey: key,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			},
+		}, false},
+		{"ok symmetric", fields{nil, &MockClient{
+			createKeyWithContext: func(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error) {
+				switch {
+				case aws.StringValue(input.KeyUsage) != kms.KeyUsageTypeEncryptDecrypt:
+					return nil, fmt.Errorf("unexpected key usage %s", aws.StringValue(input.KeyUsage))
+				case aws.StringValue(input.CustomerMasterKeySpec) != kms.CustomerMasterKeySpecSymmetricDefault:
+					return nil, fmt.Errorf("unexpected key spec %s", aws.StringValue(input.CustomerMasterKeySpec))
+				case input.CustomKeyStoreId != nil || input.Origin != nil:
+					return nil, fmt.Errorf("unexpected custom key store %s", aws.StringValue(input.CustomKeyStoreId))
+				}
+				return okClient.createKeyWithContext(ctx, input, opts...)
+			},
+			createAliasWithContext: okClient.createAliasWithContext,
+			getPublicKeyWithContext: func(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error) {
+				return nil, fmt.Errorf("unexpected call to GetPublicKey")
+			},
+		}}, args{&apiv1.CreateKeyRequest{
+			Name:      "data",
+			Symmetric: true,
+		}}, &apiv1.CreateKeyResponse{
+			Name: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+		}, false},
+		{"ok custom key store", fields{nil, &MockClient{
+			createKeyWithContext: func(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error) {
+				switch {
+				case aws.StringValue(input.KeyUsage) != kms.KeyUsageTypeEncryptDecrypt:
+					return nil, fmt.Errorf("unexpected key usage %s", aws.StringValue(input.KeyUsage))
+				case aws.StringValue(input.CustomerMasterKeySpec) != kms.CustomerMasterKeySpecSymmetricDefault:
+					return nil, fmt.Errorf("unexpected key spec %s", aws.StringValue(input.CustomerMasterKeySpec))
+				case aws.StringValue(input.CustomKeyStoreId) != "cks-1234567890abcdef0":
+					return nil, fmt.Errorf("unexpected custom key store %s", aws.StringValue(input.CustomKeyStoreId))
+				case aws.StringValue(input.Origin) != kms.OriginTypeAwsCloudhsm:
+					return nil, fmt.Errorf("unexpected origin %s", aws.StringValue(input.Origin))
+				}
+				return okClient.createKeyWithContext(ctx, input, opts...)
+			},
+			createAliasWithContext:  okClient.createAliasWithContext,
+			getPublicKeyWithContext: okClient.getPublicKeyWithContext,
+		}}, args{&apiv1.CreateKeyRequest{
+			Name:             "data",
+			Symmetric:        true,
+			CustomKeyStoreID: "cks-1234567890abcdef0",
+		}}, &apiv1.CreateKeyResponse{
+			Name: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+		}, false},
 		{"fail empty", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{}}, nil, true},
+		{"fail custom key store", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			CustomKeyStoreID:   "cks-1234567890abcdef0",
+		}}, nil, true},
+		{"fail symmetric decrypt", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{
+			Name:      "data",
+			Symmetric: true,
+			Decrypt:   true,
+		}}, nil, true},
+		{"fail symmetric signature algorithm", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{
+			Name:               "data",
+			SignatureAlgorithm: apiv1.SHA256WithRSA,
+			Symmetric:          true,
+		}}, nil, true},
+		{"fail name label", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			Labels:             map[string]string{"name": "other"},
+		}}, nil, true},
+		{"fail decrypt ecdsa", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{
+			Name:               "root",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			Decrypt:            true,
+		}}, nil, true},
 		{"fail unsupported alg", fields{nil, okClient}, args{&apiv1.CreateKeyRequest{
 			Name:               "root",
 			SignatureAlgorithm: apiv1.PureEd25519,
@@ -307,6 +441,389 @@ func TestKMS_CreateSigner(t *testing.T) {
 	}
 }
 
+func TestKMS_CreateDecrypter(t *testing.T) {
+	client := getOKClient()
+	key, err := pemutil.ParseKey([]byte(rsaPublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type fields struct {
+		session *session.Session
+		service KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.CreateDecrypterRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    crypto.Decrypter
+		wantErr bool
+	}{
+		{"ok", fields{nil, client}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "awskms:key-id=4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b",
+		}}, &Decrypter{
+			service:   client,
+			keyID:     "4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b",
+			publicKey: key,
+		}, false},
+		{"fail empty", fields{nil, client}, args{&apiv1.CreateDecrypterRequest{}}, nil, true},
+		{"fail not rsa", fields{nil, client}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+		}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{
+				session: tt.fields.session,
+				service: tt.fields.service,
+			}
+			got, err := k.CreateDecrypter(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KMS.CreateDecrypter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KMS.CreateDecrypter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKMS_ListKeys(t *testing.T) {
+	okClient := getOKClient()
+	ecKey := &apiv1.KeyInfo{
+		Name:               "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+		ProtectionLevel:    apiv1.HSM,
+		Labels:             map[string]string{"env": "prod"},
+	}
+	rsaKey := &apiv1.KeyInfo{
+		Name:               "awskms:key-id=4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b",
+		SignatureAlgorithm: apiv1.SHA256WithRSA,
+		ProtectionLevel:    apiv1.HSM,
+	}
+
+	type fields struct {
+		session *session.Session
+		service KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.ListKeysRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *apiv1.ListKeysResponse
+		wantErr bool
+	}{
+		{"ok", fields{nil, okClient}, args{&apiv1.ListKeysRequest{}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{ecKey, rsaKey},
+		}, false},
+		{"ok name filter", fields{nil, okClient}, args{&apiv1.ListKeysRequest{NameFilter: "ro*"}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{ecKey},
+		}, false},
+		{"ok key id filter", fields{nil, okClient}, args{&apiv1.ListKeysRequest{NameFilter: "4f5e1b3a-*"}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{rsaKey},
+		}, false},
+		{"ok labels", fields{nil, okClient}, args{&apiv1.ListKeysRequest{Labels: map[string]string{"env": "prod"}}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{ecKey},
+		}, false},
+		{"ok page", fields{nil, &MockClient{
+			listKeysWithContext: func(ctx aws.Context, input *kms.ListKeysInput, opts ...request.Option) (*kms.ListKeysOutput, error) {
+				if aws.Int64Value(input.Limit) != 1 || aws.StringValue(input.Marker) != "marker-1" {
+					return nil, fmt.Errorf("unexpected input %v", input)
+				}
+				return &kms.ListKeysOutput{
+					Keys:       []*kms.KeyListEntry{{KeyId: aws.String(keyID)}},
+					NextMarker: aws.String("marker-2"),
+					Truncated:  aws.Bool(true),
+				}, nil
+			},
+			describeKeyWithContext:      okClient.describeKeyWithContext,
+			listResourceTagsWithContext: okClient.listResourceTagsWithContext,
+		}}, args{&apiv1.ListKeysRequest{PageSize: 1, PageToken: "marker-1"}}, &apiv1.ListKeysResponse{
+			Keys:          []*apiv1.KeyInfo{ecKey},
+			NextPageToken: "marker-2",
+		}, false},
+		{"ok key metadata", fields{nil, &MockClient{
+			listKeysWithContext: okClient.listKeysWithContext,
+			describeKeyWithContext: func(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error) {
+				md := new(kms.KeyMetadata)
+				md.SetKeyId(*input.KeyId)
+				if *input.KeyId == rsaKeyID {
+					md.SetKeySpec(kms.KeySpecRsa3072)
+					md.SetKeyUsage(kms.KeyUsageTypeEncryptDecrypt)
+					md.SetOrigin(originTypeExternalKeyStore)
+					md.SetCustomKeyStoreId("cks-1234567890abcdef0")
+				} else {
+					md.SetKeySpec(kms.KeySpecSymmetricDefault)
+					md.SetKeyUsage(kms.KeyUsageTypeEncryptDecrypt)
+					md.SetOrigin(kms.OriginTypeAwsCloudhsm)
+					md.SetCustomKeyStoreId("cks-1234567890abcdef1")
+				}
+				return &kms.DescribeKeyOutput{
+					KeyMetadata: md,
+				}, nil
+			},
+			listResourceTagsWithContext: okClient.listResourceTagsWithContext,
+		}}, args{&apiv1.ListKeysRequest{}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{
+				{Name: ecKey.Name, ProtectionLevel: apiv1.HSM, Labels: map[string]string{"env": "prod"}},
+				{Name: rsaKey.Name, ProtectionLevel: apiv1.External},
+			},
+		}, false},
+		{"fail page size", fields{nil, okClient}, args{&apiv1.ListKeysRequest{PageSize: -1}}, nil, true},
+		{"fail listKeys", fields{nil, &MockClient{
+			listKeysWithContext: func(ctx aws.Context, input *kms.ListKeysInput, opts ...request.Option) (*kms.ListKeysOutput, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.ListKeysRequest{}}, nil, true},
+		{"fail describeKey", fields{nil, &MockClient{
+			listKeysWithContext:         okClient.listKeysWithContext,
+			listResourceTagsWithContext: okClient.listResourceTagsWithContext,
+			describeKeyWithContext: func(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.ListKeysRequest{}}, nil, true},
+		{"fail listResourceTags", fields{nil, &MockClient{
+			listKeysWithContext:    okClient.listKeysWithContext,
+			describeKeyWithContext: okClient.describeKeyWithContext,
+			listResourceTagsWithContext: func(ctx aws.Context, input *kms.ListResourceTagsInput, opts ...request.Option) (*kms.ListResourceTagsOutput, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.ListKeysRequest{}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{
+				session: tt.fields.session,
+				service: tt.fields.service,
+			}
+			got, err := k.ListKeys(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KMS.ListKeys() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KMS.ListKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKMS_DeleteKey(t *testing.T) {
+	okClient := getOKClient()
+	checkWindow := func(days int64) *MockClient {
+		return &MockClient{
+			scheduleKeyDeletionWithContext: func(ctx aws.Context, input *kms.ScheduleKeyDeletionInput, opts ...request.Option) (*kms.ScheduleKeyDeletionOutput, error) {
+				if aws.StringValue(input.KeyId) != keyID {
+					return nil, fmt.Errorf("unexpected key id %s", aws.StringValue(input.KeyId))
+				}
+				if got := aws.Int64Value(input.PendingWindowInDays); got != days {
+					return nil, fmt.Errorf("unexpected pending window %d, want %d", got, days)
+				}
+				return okClient.scheduleKeyDeletionWithContext(ctx, input, opts...)
+			},
+		}
+	}
+
+	type fields struct {
+		session *session.Session
+		service KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.DeleteKeyRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{"ok", fields{nil, checkWindow(0)}, args{&apiv1.DeleteKeyRequest{
+			Name: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+		}}, false},
+		{"ok pending window", fields{nil, checkWindow(7)}, args{&apiv1.DeleteKeyRequest{
+			Name:          "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			PendingWindow: 7 * 24 * time.Hour,
+		}}, false},
+		{"ok pending window rounded", fields{nil, checkWindow(8)}, args{&apiv1.DeleteKeyRequest{
+			Name:          "be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			PendingWindow: 7*24*time.Hour + time.Minute,
+		}}, false},
+		{"fail empty", fields{nil, okClient}, args{&apiv1.DeleteKeyRequest{}}, true},
+		{"fail pending window", fields{nil, okClient}, args{&apiv1.DeleteKeyRequest{
+			Name:          "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			PendingWindow: -time.Hour,
+		}}, true},
+		{"fail parseKeyID", fields{nil, okClient}, args{&apiv1.DeleteKeyRequest{
+			Name: "awskms:key-id=",
+		}}, true},
+		{"fail scheduleKeyDeletion", fields{nil, &MockClient{
+			scheduleKeyDeletionWithContext: func(ctx aws.Context, input *kms.ScheduleKeyDeletionInput, opts ...request.Option) (*kms.ScheduleKeyDeletionOutput, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.DeleteKeyRequest{
+			Name: "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+		}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{
+				session: tt.fields.session,
+				service: tt.fields.service,
+			}
+			if err := k.DeleteKey(tt.args.req); (err != nil) != tt.wantErr {
+				t.Errorf("KMS.DeleteKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKMS_Encrypt(t *testing.T) {
+	okClient := getOKClient()
+	checkContext := func(want map[string]*string) *MockClient {
+		return &MockClient{
+			encryptWithContext: func(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+				if aws.StringValue(input.KeyId) != keyID {
+					return nil, fmt.Errorf("unexpected key id %s", aws.StringValue(input.KeyId))
+				}
+				if !reflect.DeepEqual(input.EncryptionContext, want) {
+					return nil, fmt.Errorf("unexpected encryption context %v, want %v", input.EncryptionContext, want)
+				}
+				return okClient.encryptWithContext(ctx, input, opts...)
+			},
+		}
+	}
+
+	type fields struct {
+		session *session.Session
+		service KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.EncryptRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", fields{nil, checkContext(nil)}, args{&apiv1.EncryptRequest{
+			Name:      "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			Plaintext: []byte("plaintext"),
+		}}, []byte("ciphertext"), false},
+		{"ok additional data", fields{nil, checkContext(map[string]*string{"aad": aws.String("YWFk")})}, args{&apiv1.EncryptRequest{
+			Name:           "be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			Plaintext:      []byte("plaintext"),
+			AdditionalData: []byte("aad"),
+		}}, []byte("ciphertext"), false},
+		{"fail empty", fields{nil, okClient}, args{&apiv1.EncryptRequest{}}, nil, true},
+		{"fail parseKeyID", fields{nil, okClient}, args{&apiv1.EncryptRequest{
+			Name: "awskms:key-id=",
+		}}, nil, true},
+		{"fail encrypt", fields{nil, &MockClient{
+			encryptWithContext: func(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.EncryptRequest{
+			Name:      "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			Plaintext: []byte("plaintext"),
+		}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{
+				session: tt.fields.session,
+				service: tt.fields.service,
+			}
+			got, err := k.Encrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KMS.Encrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KMS.Encrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKMS_Decrypt(t *testing.T) {
+	okClient := getOKClient()
+	checkContext := func(want map[string]*string) *MockClient {
+		return &MockClient{
+			decryptWithContext: func(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+				if aws.StringValue(input.KeyId) != keyID {
+					return nil, fmt.Errorf("unexpected key id %s", aws.StringValue(input.KeyId))
+				}
+				if !reflect.DeepEqual(input.EncryptionContext, want) {
+					return nil, fmt.Errorf("unexpected encryption context %v, want %v", input.EncryptionContext, want)
+				}
+				return okClient.decryptWithContext(ctx, input, opts...)
+			},
+		}
+	}
+
+	type fields struct {
+		session *session.Session
+		service KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.DecryptRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", fields{nil, checkContext(nil)}, args{&apiv1.DecryptRequest{
+			Name:       "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			Ciphertext: []byte("ciphertext"),
+		}}, []byte("plaintext"), false},
+		{"ok additional data", fields{nil, checkContext(map[string]*string{"aad": aws.String("YWFk")})}, args{&apiv1.DecryptRequest{
+			Name:           "be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			Ciphertext:     []byte("ciphertext"),
+			AdditionalData: []byte("aad"),
+		}}, []byte("plaintext"), false},
+		{"fail empty", fields{nil, okClient}, args{&apiv1.DecryptRequest{}}, nil, true},
+		{"fail parseKeyID", fields{nil, okClient}, args{&apiv1.DecryptRequest{
+			Name: "awskms:key-id=",
+		}}, nil, true},
+		{"fail decrypt", fields{nil, &MockClient{
+			decryptWithContext: func(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.DecryptRequest{
+			Name:       "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936",
+			Ciphertext: []byte("ciphertext"),
+		}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{
+				session: tt.fields.session,
+				service: tt.fields.service,
+			}
+			got, err := k.Decrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KMS.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KMS.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestKMS_Close(t *testing.T) {
 	type fields struct {
 		session *session.Session
@@ -332,6 +849,32 @@ func TestKMS_Close(t *testing.T) {
 	}
 }
 
+func TestKMS_ValidateName(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{"ok", "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936", false},
+		{"ok aws", "aws:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936", false},
+		{"ok key id", "be468355-ca7a-40d9-a28b-8ae1c4c7f936", false},
+		{"ok arn", "arn:aws:kms:us-east-1:123456789012:key/be468355-ca7a-40d9-a28b-8ae1c4c7f936", false},
+		{"fail unknown attribute", "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936;region=us-east-1", true},
+		{"fail repeated attribute", "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936?key-id=other", true},
+		{"fail missing key-id", "awskms:", true},
+		{"fail parse", "awskms:key-id=%ZZ", true},
+		{"fail empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KMS{}
+			if err := k.ValidateName(tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("KMS.ValidateName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_parseKeyID(t *testing.T) {
 	type args struct {
 		name string
@@ -362,3 +905,39 @@ func Test_parseKeyID(t *testing.T) {
 		})
 	}
 }
+
+func Test_convertError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"not found", awserr.New(kms.ErrCodeNotFoundException, "key not found", nil), apiv1.ErrNotFound},
+		{"permission denied", awserr.New("AccessDeniedException", "access denied", nil), apiv1.ErrPermissionDenied},
+		{"permission denied expired", awserr.New("ExpiredTokenException", "token expired", nil), apiv1.ErrPermissionDenied},
+		{"rate limited", awserr.New("ThrottlingException", "rate exceeded", nil), apiv1.ErrRateLimited},
+		{"rate limited limit exceeded", fmt.Errorf("an error: %w", awserr.New(kms.ErrCodeLimitExceededException, "limit exceeded", nil)), apiv1.ErrRateLimited},
+		{"other aws", awserr.New(kms.ErrCodeDisabledException, "key disabled", nil), nil},
+		{"other", errors.New("an error"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := convertError(tt.err)
+			if tt.want == nil {
+				if err != tt.err {
+					t.Errorf("convertError() = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("convertError() = %#v, want %T", err, tt.want)
+			}
+			if err.Error() != tt.err.Error() {
+				t.Errorf("convertError().Error() = %s, want %s", err.Error(), tt.err.Error())
+			}
+		})
+	}
+	if err := convertError(nil); err != nil {
+		t.Errorf("convertError() = %v, want nil", err)
+	}
+}
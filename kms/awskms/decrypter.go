@@ -0,0 +1,90 @@
+package awskms
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"io"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/pemutil"
+)
+
+// Decrypter implements a crypto.Decrypter using an RSA key in the AWS KMS.
+type Decrypter struct {
+	service   KeyManagementClient
+	keyID     string
+	publicKey crypto.PublicKey
+}
+
+// NewDecrypter creates a new decrypter using an RSA key in the AWS KMS.
+func NewDecrypter(svc KeyManagementClient, decryptionKey string) (*Decrypter, error) {
+	keyID, err := parseKeyID(decryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure that the key exists.
+	decrypter := &Decrypter{
+		service: svc,
+		keyID:   keyID,
+	}
+	if err := decrypter.preloadKey(keyID); err != nil {
+		return nil, err
+	}
+	if _, ok := decrypter.publicKey.(*rsa.PublicKey); !ok {
+		return nil, errors.Errorf("awskms key %s is not an RSA key", keyID)
+	}
+
+	return decrypter, nil
+}
+
+func (d *Decrypter) preloadKey(keyID string) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := d.service.GetPublicKeyWithContext(ctx, &kms.GetPublicKeyInput{
+		KeyId: &keyID,
+	})
+	if err != nil {
+		return errors.Wrap(convertError(err), "awskms GetPublicKeyWithContext failed")
+	}
+
+	d.publicKey, err = pemutil.ParseDER(resp.PublicKey)
+	return err
+}
+
+// Public returns the public key of this decrypter.
+func (d *Decrypter) Public() crypto.PublicKey {
+	return d.publicKey
+}
+
+// Decrypt decrypts a message encrypted with RSA-OAEP and SHA-256 using the
+// private key stored in the AWS KMS. Labels are not supported.
+func (d *Decrypter) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	oaep, ok := opts.(*rsa.OAEPOptions)
+	switch {
+	case !ok:
+		return nil, errors.Errorf("unsupported decrypter options %T", opts)
+	case oaep.Hash != crypto.SHA256:
+		return nil, errors.Errorf("unsupported hash function %v", oaep.Hash)
+	case len(oaep.Label) > 0:
+		return nil, errors.New("rsa-oaep labels are not supported")
+	}
+
+	req := &kms.DecryptInput{
+		KeyId:          &d.keyID,
+		CiphertextBlob: ciphertext,
+	}
+	req.SetEncryptionAlgorithm(kms.EncryptionAlgorithmSpecRsaesOaepSha256)
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := d.service.DecryptWithContext(ctx, req)
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "awskms DecryptWithContext failed")
+	}
+
+	return resp.Plaintext, nil
+}
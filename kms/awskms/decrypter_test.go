@@ -0,0 +1,148 @@
+package awskms
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"go.step.sm/crypto/pemutil"
+)
+
+func TestNewDecrypter(t *testing.T) {
+	okClient := getOKClient()
+	key, err := pemutil.ParseKey([]byte(rsaPublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		svc           KeyManagementClient
+		decryptionKey string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *Decrypter
+		wantErr bool
+	}{
+		{"ok", args{okClient, "awskms:key-id=4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b"}, &Decrypter{
+			service:   okClient,
+			keyID:     "4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b",
+			publicKey: key,
+		}, false},
+		{"fail parse", args{okClient, "awskms:key-id="}, nil, true},
+		{"fail not rsa", args{okClient, "awskms:key-id=be468355-ca7a-40d9-a28b-8ae1c4c7f936"}, nil, true},
+		{"fail preload", args{&MockClient{
+			getPublicKeyWithContext: func(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}, "awskms:key-id=4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b"}, nil, true},
+		{"fail preload not der", args{&MockClient{
+			getPublicKeyWithContext: func(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error) {
+				return &kms.GetPublicKeyOutput{
+					KeyId:     input.KeyId,
+					PublicKey: []byte(rsaPublicKey),
+				}, nil
+			},
+		}, "awskms:key-id=4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewDecrypter(tt.args.svc, tt.args.decryptionKey)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewDecrypter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewDecrypter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecrypter_Public(t *testing.T) {
+	okClient := getOKClient()
+	key, err := pemutil.ParseKey([]byte(rsaPublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecrypter(okClient, "awskms:key-id=4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Public(); !reflect.DeepEqual(got, key) {
+		t.Errorf("Decrypter.Public() = %v, want %v", got, key)
+	}
+}
+
+func TestDecrypter_Decrypt(t *testing.T) {
+	okClient := getOKClient()
+	key, err := pemutil.ParseKey([]byte(rsaPublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type fields struct {
+		service   KeyManagementClient
+		keyID     string
+		publicKey crypto.PublicKey
+	}
+	type args struct {
+		rand       io.Reader
+		ciphertext []byte
+		opts       crypto.DecrypterOpts
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", fields{&MockClient{
+			decryptWithContext: func(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+				if alg := aws.StringValue(input.EncryptionAlgorithm); alg != kms.EncryptionAlgorithmSpecRsaesOaepSha256 {
+					return nil, fmt.Errorf("unexpected encryption algorithm %s", alg)
+				}
+				if input.EncryptionContext != nil {
+					return nil, fmt.Errorf("unexpected encryption context %v", input.EncryptionContext)
+				}
+				return okClient.decryptWithContext(ctx, input, opts...)
+			},
+		}, "4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b", key}, args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA256}}, []byte("plaintext"), false},
+		{"fail nil opts", fields{okClient, "4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b", key}, args{rand.Reader, []byte("ciphertext"), nil}, nil, true},
+		{"fail pkcs1v15", fields{okClient, "4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b", key}, args{rand.Reader, []byte("ciphertext"), &rsa.PKCS1v15DecryptOptions{}}, nil, true},
+		{"fail hash", fields{okClient, "4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b", key}, args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA1}}, nil, true},
+		{"fail label", fields{okClient, "4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b", key}, args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label")}}, nil, true},
+		{"fail decrypt", fields{&MockClient{
+			decryptWithContext: func(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}, "4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b", key}, args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA256}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Decrypter{
+				service:   tt.fields.service,
+				keyID:     tt.fields.keyID,
+				publicKey: tt.fields.publicKey,
+			}
+			got, err := d.Decrypt(tt.args.rand, tt.args.ciphertext, tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decrypter.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decrypter.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,10 +9,16 @@ import (
 )
 
 type MockClient struct {
-	getPublicKeyWithContext func(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error)
-	createKeyWithContext    func(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error)
-	createAliasWithContext  func(ctx aws.Context, input *kms.CreateAliasInput, opts ...request.Option) (*kms.CreateAliasOutput, error)
-	signWithContext         func(ctx aws.Context, input *kms.SignInput, opts ...request.Option) (*kms.SignOutput, error)
+	getPublicKeyWithContext        func(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error)
+	createKeyWithContext           func(ctx aws.Context, input *kms.CreateKeyInput, opts ...request.Option) (*kms.CreateKeyOutput, error)
+	createAliasWithContext         func(ctx aws.Context, input *kms.CreateAliasInput, opts ...request.Option) (*kms.CreateAliasOutput, error)
+	signWithContext                func(ctx aws.Context, input *kms.SignInput, opts ...request.Option) (*kms.SignOutput, error)
+	listKeysWithContext            func(ctx aws.Context, input *kms.ListKeysInput, opts ...request.Option) (*kms.ListKeysOutput, error)
+	describeKeyWithContext         func(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error)
+	listResourceTagsWithContext    func(ctx aws.Context, input *kms.ListResourceTagsInput, opts ...request.Option) (*kms.ListResourceTagsOutput, error)
+	scheduleKeyDeletionWithContext func(ctx aws.Context, input *kms.ScheduleKeyDeletionInput, opts ...request.Option) (*kms.ScheduleKeyDeletionOutput, error)
+	encryptWithContext             func(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error)
+	decryptWithContext             func(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error)
 }
 
 func (m *MockClient) GetPublicKeyWithContext(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error) {
@@ -31,12 +37,46 @@ func (m *MockClient) SignWithContext(ctx aws.Context, input *kms.SignInput, opts
 	return m.signWithContext(ctx, input, opts...)
 }
 
+func (m *MockClient) ListKeysWithContext(ctx aws.Context, input *kms.ListKeysInput, opts ...request.Option) (*kms.ListKeysOutput, error) {
+	return m.listKeysWithContext(ctx, input, opts...)
+}
+
+func (m *MockClient) DescribeKeyWithContext(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error) {
+	return m.describeKeyWithContext(ctx, input, opts...)
+}
+
+func (m *MockClient) ListResourceTagsWithContext(ctx aws.Context, input *kms.ListResourceTagsInput, opts ...request.Option) (*kms.ListResourceTagsOutput, error) {
+	return m.listResourceTagsWithContext(ctx, input, opts...)
+}
+
+func (m *MockClient) ScheduleKeyDeletionWithContext(ctx aws.Context, input *kms.ScheduleKeyDeletionInput, opts ...request.Option) (*kms.ScheduleKeyDeletionOutput, error) {
+	return m.scheduleKeyDeletionWithContext(ctx, input, opts...)
+}
+
+func (m *MockClient) EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+	return m.encryptWithContext(ctx, input, opts...)
+}
+
+func (m *MockClient) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+	return m.decryptWithContext(ctx, input, opts...)
+}
+
 const (
 	publicKey = `-----BEGIN PUBLIC KEY-----
 MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE8XWlIWkOThxNjGbZLYUgRHmsvCrW
 KF+HLktPfPTIK3lGd1k4849WQs59XIN+LXZQ6b2eRBEBKAHEyQus8UU7gw==
 -----END PUBLIC KEY-----`
-	keyID = "be468355-ca7a-40d9-a28b-8ae1c4c7f936"
+	rsaPublicKey = `-----BEGIN PUBLIC KEY-----
+MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEApILLd91Bz4pE+H4ygBzD
+/BxYOasN4Ex0ebXfxhWtEFC2Md9aip+0kMLj33WiwRN25I+XBPw/7kUnM7n/R7Xg
+PoK9RrmpRuxL+9FCFmdpajbm1GUqvl7fLHtJ24nekndj6KuZ68Z8Yhbx/7zl1Y89
+Cm3RzabvulFnFB/WRCbjFenfHezMPqZbLedCYqDWcC05+VG2qpIXgZeC2Iw6Biim
+ALKPb5gQWcASZeBdo92qxcYiY5aOesPvmzNlEUr9taStdVPe5yyDOZWV1CQ0vJAA
+Y2jYpOPYAHtr1floWsLGZe/W1C+1Ir8Mb+CmlaIbTPWT6eYRCaaCh0ri/yRUNJlw
+LwIDAQAB
+-----END PUBLIC KEY-----`
+	keyID    = "be468355-ca7a-40d9-a28b-8ae1c4c7f936"
+	rsaKeyID = "4f5e1b3a-8c2d-4e7f-9a6b-1d2c3e4f5a6b"
 )
 
 var signature = []byte{
@@ -48,6 +88,9 @@ func getOKClient() *MockClient {
 	return &MockClient{
 		getPublicKeyWithContext: func(ctx aws.Context, input *kms.GetPublicKeyInput, opts ...request.Option) (*kms.GetPublicKeyOutput, error) {
 			block, _ := pem.Decode([]byte(publicKey))
+			if *input.KeyId == rsaKeyID {
+				block, _ = pem.Decode([]byte(rsaPublicKey))
+			}
 			return &kms.GetPublicKeyOutput{
 				KeyId:     input.KeyId,
 				PublicKey: block.Bytes,
@@ -68,5 +111,57 @@ func getOKClient() *MockClient {
 				Signature: signature,
 			}, nil
 		},
+		listKeysWithContext: func(ctx aws.Context, input *kms.ListKeysInput, opts ...request.Option) (*kms.ListKeysOutput, error) {
+			return &kms.ListKeysOutput{
+				Keys: []*kms.KeyListEntry{
+					{KeyId: aws.String(keyID)},
+					{KeyId: aws.String(rsaKeyID)},
+				},
+				Truncated: aws.Bool(false),
+			}, nil
+		},
+		describeKeyWithContext: func(ctx aws.Context, input *kms.DescribeKeyInput, opts ...request.Option) (*kms.DescribeKeyOutput, error) {
+			md := new(kms.KeyMetadata)
+			md.SetKeyId(*input.KeyId)
+			md.SetKeyUsage(kms.KeyUsageTypeSignVerify)
+			md.SetOrigin(kms.OriginTypeAwsKms)
+			if *input.KeyId == rsaKeyID {
+				md.SetKeySpec(kms.KeySpecRsa3072)
+			} else {
+				md.SetKeySpec(kms.KeySpecEccNistP256)
+			}
+			return &kms.DescribeKeyOutput{
+				KeyMetadata: md,
+			}, nil
+		},
+		listResourceTagsWithContext: func(ctx aws.Context, input *kms.ListResourceTagsInput, opts ...request.Option) (*kms.ListResourceTagsOutput, error) {
+			if *input.KeyId == rsaKeyID {
+				return &kms.ListResourceTagsOutput{}, nil
+			}
+			return &kms.ListResourceTagsOutput{
+				Tags: []*kms.Tag{
+					{TagKey: aws.String("name"), TagValue: aws.String("root")},
+					{TagKey: aws.String("env"), TagValue: aws.String("prod")},
+				},
+			}, nil
+		},
+		scheduleKeyDeletionWithContext: func(ctx aws.Context, input *kms.ScheduleKeyDeletionInput, opts ...request.Option) (*kms.ScheduleKeyDeletionOutput, error) {
+			return &kms.ScheduleKeyDeletionOutput{
+				KeyId:               input.KeyId,
+				PendingWindowInDays: input.PendingWindowInDays,
+			}, nil
+		},
+		encryptWithContext: func(ctx aws.Context, input *kms.EncryptInput, opts ...request.Option) (*kms.EncryptOutput, error) {
+			return &kms.EncryptOutput{
+				KeyId:          input.KeyId,
+				CiphertextBlob: []byte("ciphertext"),
+			}, nil
+		},
+		decryptWithContext: func(ctx aws.Context, input *kms.DecryptInput, opts ...request.Option) (*kms.DecryptOutput, error) {
+			return &kms.DecryptOutput{
+				KeyId:     input.KeyId,
+				Plaintext: []byte("plaintext"),
+			}, nil
+		},
 	}
 }
@@ -45,7 +45,7 @@ func (s *Signer) preloadKey(keyID string) error {
 		KeyId: &keyID,
 	})
 	if err != nil {
-		return errors.Wrap(err, "awskms GetPublicKeyWithContext failed")
+		return errors.Wrap(convertError(err), "awskms GetPublicKeyWithContext failed")
 	}
 
 	s.publicKey, err = pemutil.ParseDER(resp.PublicKey)
@@ -76,7 +76,7 @@ func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]
 
 	resp, err := s.service.SignWithContext(ctx, req)
 	if err != nil {
-		return nil, errors.Wrap(err, "awsKMS SignWithContext failed")
+		return nil, errors.Wrap(convertError(err), "awsKMS SignWithContext failed")
 	}
 
 	return resp.Signature, nil
@@ -0,0 +1,101 @@
+package azurekms
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"io"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.1/keyvault"
+	"github.com/pkg/errors"
+)
+
+// Decrypter implements a crypto.Decrypter using an RSA key in Azure Key Vault.
+type Decrypter struct {
+	client       KeyVaultClient
+	vaultBaseURL string
+	name         string
+	version      string
+	publicKey    crypto.PublicKey
+}
+
+// NewDecrypter creates a new decrypter using an RSA key in Azure Key Vault.
+func NewDecrypter(client KeyVaultClient, decryptionKey string, defaults DefaultOptions) (crypto.Decrypter, error) {
+	vault, name, version, _, err := parseKeyName(decryptionKey, defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure that the key exists.
+	decrypter := &Decrypter{
+		client:       client,
+		vaultBaseURL: vaultBaseURL(vault, defaults),
+		name:         name,
+		version:      version,
+	}
+	if err := decrypter.preloadKey(); err != nil {
+		return nil, err
+	}
+
+	return decrypter, nil
+}
+
+func (d *Decrypter) preloadKey() error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := d.client.GetKey(ctx, d.vaultBaseURL, d.name, d.version)
+	if err != nil {
+		return errors.Wrap(convertError(err), "keyVault GetKey failed")
+	}
+
+	d.publicKey, err = convertKey(resp.Key)
+	if err != nil {
+		return err
+	}
+	if _, ok := d.publicKey.(*rsa.PublicKey); !ok {
+		return errors.Errorf("keyVault key %s is not an RSA key", d.name)
+	}
+	return nil
+}
+
+// Public returns the public key of this decrypter.
+func (d *Decrypter) Public() crypto.PublicKey {
+	return d.publicKey
+}
+
+// Decrypt decrypts a message encrypted with RSA-OAEP using SHA-1 (RSA-OAEP) or
+// SHA-256 (RSA-OAEP-256) with the private key stored in Azure Key Vault. Labels
+// are not supported.
+func (d *Decrypter) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	oaep, ok := opts.(*rsa.OAEPOptions)
+	if !ok {
+		return nil, errors.Errorf("unsupported decrypter options %T", opts)
+	}
+	if len(oaep.Label) > 0 {
+		return nil, errors.New("rsa-oaep labels are not supported")
+	}
+
+	var alg keyvault.JSONWebKeyEncryptionAlgorithm
+	switch h := oaep.Hash; h {
+	case crypto.SHA1:
+		alg = keyvault.RSAOAEP
+	case crypto.SHA256:
+		alg = keyvault.RSAOAEP256
+	default:
+		return nil, errors.Errorf("unsupported hash function %v", h)
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	value := base64.RawURLEncoding.EncodeToString(ciphertext)
+	resp, err := d.client.Decrypt(ctx, d.vaultBaseURL, d.name, d.version, keyvault.KeyOperationsParameters{
+		Algorithm: alg,
+		Value:     &value,
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "keyVault Decrypt failed")
+	}
+	return decodeResult(resp, "Decrypt")
+}
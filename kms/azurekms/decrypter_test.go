@@ -0,0 +1,183 @@
+package azurekms
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.1/keyvault"
+	"github.com/golang/mock/gomock"
+	"go.step.sm/crypto/keyutil"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+func TestNewDecrypter(t *testing.T) {
+	ecKey, err := keyutil.GenerateDefaultSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := keyutil.GenerateSigner("RSA", "", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := rsaKey.Public()
+	jwk := createJWK(t, pub)
+
+	client := mockClient(t)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "my-key", "").Return(keyvault.KeyBundle{
+		Key: jwk,
+	}, nil)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "my-key", "my-version").Return(keyvault.KeyBundle{
+		Key: jwk,
+	}, nil)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-hsm.managedhsm.azure.net/", "my-key", "my-version").Return(keyvault.KeyBundle{
+		Key: jwk,
+	}, nil)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "ec-key", "").Return(keyvault.KeyBundle{
+		Key: createJWK(t, ecKey.Public()),
+	}, nil)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "not-found", "my-version").Return(keyvault.KeyBundle{}, errTest)
+
+	var noOptions DefaultOptions
+	type args struct {
+		client        KeyVaultClient
+		decryptionKey string
+		defaults      DefaultOptions
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    crypto.Decrypter
+		wantErr bool
+	}{
+		{"ok", args{client, "azurekms:vault=my-vault;name=my-key", noOptions}, &Decrypter{
+			client:       client,
+			vaultBaseURL: "https://my-vault.vault.azure.net/",
+			name:         "my-key",
+			version:      "",
+			publicKey:    pub,
+		}, false},
+		{"ok with version", args{client, "azurekms:name=my-key;vault=my-vault?version=my-version", noOptions}, &Decrypter{
+			client:       client,
+			vaultBaseURL: "https://my-vault.vault.azure.net/",
+			name:         "my-key",
+			version:      "my-version",
+			publicKey:    pub,
+		}, false},
+		{"ok with managed hsm", args{client, "azurekms:name=my-key?version=my-version", DefaultOptions{Vault: "my-hsm", ProtectionLevel: apiv1.HSM, ManagedHSM: true}}, &Decrypter{
+			client:       client,
+			vaultBaseURL: "https://my-hsm.managedhsm.azure.net/",
+			name:         "my-key",
+			version:      "my-version",
+			publicKey:    pub,
+		}, false},
+		{"fail not rsa", args{client, "azurekms:name=ec-key;vault=my-vault", noOptions}, nil, true},
+		{"fail GetKey", args{client, "azurekms:name=not-found;vault=my-vault?version=my-version", noOptions}, nil, true},
+		{"fail vault", args{client, "azurekms:name=not-found;vault=", noOptions}, nil, true},
+		{"fail id", args{client, "azurekms:name=;vault=my-vault?version=my-version", noOptions}, nil, true},
+		{"fail scheme", args{client, "kms:name=not-found;vault=my-vault?version=my-version", noOptions}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewDecrypter(tt.args.client, tt.args.decryptionKey, tt.args.defaults)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewDecrypter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewDecrypter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecrypter_Public(t *testing.T) {
+	key, err := keyutil.GenerateSigner("RSA", "", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := key.Public()
+
+	d := &Decrypter{publicKey: pub}
+	if got := d.Public(); !reflect.DeepEqual(got, pub) {
+		t.Errorf("Decrypter.Public() = %v, want %v", got, pub)
+	}
+}
+
+func TestDecrypter_Decrypt(t *testing.T) {
+	key, err := keyutil.GenerateSigner("RSA", "", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := key.Public()
+	ciphertext := []byte("ciphertext")
+	value := base64.RawURLEncoding.EncodeToString(ciphertext)
+	result := base64.RawURLEncoding.EncodeToString([]byte("plaintext"))
+	badResult := "bad-result!"
+
+	client := mockClient(t)
+	client.EXPECT().Decrypt(gomock.Any(), "https://my-vault.vault.azure.net/", "my-key", "", keyvault.KeyOperationsParameters{
+		Algorithm: keyvault.RSAOAEP,
+		Value:     &value,
+	}).Return(keyvault.KeyOperationResult{Result: &result}, nil)
+	client.EXPECT().Decrypt(gomock.Any(), "https://my-vault.vault.azure.net/", "my-key", "", keyvault.KeyOperationsParameters{
+		Algorithm: keyvault.RSAOAEP256,
+		Value:     &value,
+	}).Return(keyvault.KeyOperationResult{Result: &result}, nil)
+	client.EXPECT().Decrypt(gomock.Any(), "https://my-vault.vault.azure.net/", "not-found", "", gomock.Any()).Return(keyvault.KeyOperationResult{}, errTest)
+	client.EXPECT().Decrypt(gomock.Any(), "https://my-vault.vault.azure.net/", "empty", "", gomock.Any()).Return(keyvault.KeyOperationResult{}, nil)
+	client.EXPECT().Decrypt(gomock.Any(), "https://my-vault.vault.azure.net/", "bad-result", "", gomock.Any()).Return(keyvault.KeyOperationResult{Result: &badResult}, nil)
+
+	type fields struct {
+		client       KeyVaultClient
+		vaultBaseURL string
+		name         string
+		version      string
+		publicKey    crypto.PublicKey
+	}
+	type args struct {
+		rand       io.Reader
+		ciphertext []byte
+		opts       crypto.DecrypterOpts
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok RSA-OAEP", fields{client, "https://my-vault.vault.azure.net/", "my-key", "", pub}, args{rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA1}}, []byte("plaintext"), false},
+		{"ok RSA-OAEP-256", fields{client, "https://my-vault.vault.azure.net/", "my-key", "", pub}, args{rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256}}, []byte("plaintext"), false},
+		{"fail nil opts", fields{client, "https://my-vault.vault.azure.net/", "my-key", "", pub}, args{rand.Reader, ciphertext, nil}, nil, true},
+		{"fail pkcs1v15", fields{client, "https://my-vault.vault.azure.net/", "my-key", "", pub}, args{rand.Reader, ciphertext, &rsa.PKCS1v15DecryptOptions{}}, nil, true},
+		{"fail hash", fields{client, "https://my-vault.vault.azure.net/", "my-key", "", pub}, args{rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA512}}, nil, true},
+		{"fail label", fields{client, "https://my-vault.vault.azure.net/", "my-key", "", pub}, args{rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label")}}, nil, true},
+		{"fail Decrypt", fields{client, "https://my-vault.vault.azure.net/", "not-found", "", pub}, args{rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256}}, nil, true},
+		{"fail empty result", fields{client, "https://my-vault.vault.azure.net/", "empty", "", pub}, args{rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256}}, nil, true},
+		{"fail decode result", fields{client, "https://my-vault.vault.azure.net/", "bad-result", "", pub}, args{rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Decrypter{
+				client:       tt.fields.client,
+				vaultBaseURL: tt.fields.vaultBaseURL,
+				name:         tt.fields.name,
+				version:      tt.fields.version,
+				publicKey:    tt.fields.publicKey,
+			}
+			got, err := d.Decrypt(tt.args.rand, tt.args.ciphertext, tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decrypter.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decrypter.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -50,6 +50,36 @@ func (mr *KeyVaultClientMockRecorder) CreateKey(arg0, arg1, arg2, arg3 interface
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateKey", reflect.TypeOf((*KeyVaultClient)(nil).CreateKey), arg0, arg1, arg2, arg3)
 }
 
+// Decrypt mocks base method
+func (m *KeyVaultClient) Decrypt(arg0 context.Context, arg1, arg2, arg3 string, arg4 keyvault.KeyOperationsParameters) (keyvault.KeyOperationResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Decrypt", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(keyvault.KeyOperationResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Decrypt indicates an expected call of Decrypt
+func (mr *KeyVaultClientMockRecorder) Decrypt(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Decrypt", reflect.TypeOf((*KeyVaultClient)(nil).Decrypt), arg0, arg1, arg2, arg3, arg4)
+}
+
+// DeleteKey mocks base method
+func (m *KeyVaultClient) DeleteKey(arg0 context.Context, arg1, arg2 string) (keyvault.DeletedKeyBundle, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteKey", arg0, arg1, arg2)
+	ret0, _ := ret[0].(keyvault.DeletedKeyBundle)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteKey indicates an expected call of DeleteKey
+func (mr *KeyVaultClientMockRecorder) DeleteKey(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteKey", reflect.TypeOf((*KeyVaultClient)(nil).DeleteKey), arg0, arg1, arg2)
+}
+
 // GetKey mocks base method
 func (m *KeyVaultClient) GetKey(arg0 context.Context, arg1, arg2, arg3 string) (keyvault.KeyBundle, error) {
 	m.ctrl.T.Helper()
@@ -65,6 +95,36 @@ func (mr *KeyVaultClientMockRecorder) GetKey(arg0, arg1, arg2, arg3 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetKey", reflect.TypeOf((*KeyVaultClient)(nil).GetKey), arg0, arg1, arg2, arg3)
 }
 
+// GetKeys mocks base method
+func (m *KeyVaultClient) GetKeys(arg0 context.Context, arg1 string, arg2 *int32) (keyvault.KeyListResultPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetKeys", arg0, arg1, arg2)
+	ret0, _ := ret[0].(keyvault.KeyListResultPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetKeys indicates an expected call of GetKeys
+func (mr *KeyVaultClientMockRecorder) GetKeys(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetKeys", reflect.TypeOf((*KeyVaultClient)(nil).GetKeys), arg0, arg1, arg2)
+}
+
+// ImportKey mocks base method
+func (m *KeyVaultClient) ImportKey(arg0 context.Context, arg1, arg2 string, arg3 keyvault.KeyImportParameters) (keyvault.KeyBundle, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportKey", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(keyvault.KeyBundle)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImportKey indicates an expected call of ImportKey
+func (mr *KeyVaultClientMockRecorder) ImportKey(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportKey", reflect.TypeOf((*KeyVaultClient)(nil).ImportKey), arg0, arg1, arg2, arg3)
+}
+
 // Sign mocks base method
 func (m *KeyVaultClient) Sign(arg0 context.Context, arg1, arg2, arg3 string, arg4 keyvault.KeySignParameters) (keyvault.KeyOperationResult, error) {
 	m.ctrl.T.Helper()
@@ -79,3 +139,33 @@ func (mr *KeyVaultClientMockRecorder) Sign(arg0, arg1, arg2, arg3, arg4 interfac
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Sign", reflect.TypeOf((*KeyVaultClient)(nil).Sign), arg0, arg1, arg2, arg3, arg4)
 }
+
+// UnwrapKey mocks base method
+func (m *KeyVaultClient) UnwrapKey(arg0 context.Context, arg1, arg2, arg3 string, arg4 keyvault.KeyOperationsParameters) (keyvault.KeyOperationResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnwrapKey", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(keyvault.KeyOperationResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UnwrapKey indicates an expected call of UnwrapKey
+func (mr *KeyVaultClientMockRecorder) UnwrapKey(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnwrapKey", reflect.TypeOf((*KeyVaultClient)(nil).UnwrapKey), arg0, arg1, arg2, arg3, arg4)
+}
+
+// WrapKey mocks base method
+func (m *KeyVaultClient) WrapKey(arg0 context.Context, arg1, arg2, arg3 string, arg4 keyvault.KeyOperationsParameters) (keyvault.KeyOperationResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WrapKey", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(keyvault.KeyOperationResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WrapKey indicates an expected call of WrapKey
+func (mr *KeyVaultClientMockRecorder) WrapKey(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WrapKey", reflect.TypeOf((*KeyVaultClient)(nil).WrapKey), arg0, arg1, arg2, arg3, arg4)
+}
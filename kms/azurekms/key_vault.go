@@ -3,7 +3,10 @@ package azurekms
 import (
 	"context"
 	"crypto"
+	"encoding/base64"
+	"net/url"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.1/keyvault"
@@ -13,9 +16,11 @@ import (
 	"github.com/pkg/errors"
 	"go.step.sm/crypto/kms/apiv1"
 	"go.step.sm/crypto/kms/uri"
+	"go.step.sm/crypto/pemutil"
 )
 
 func init() {
+	uri.RegisterSchema(keySchema)
 	apiv1.Register(apiv1.AzureKMS, func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
 		return New(ctx, opts)
 	})
@@ -24,9 +29,30 @@ func init() {
 // Scheme is the scheme used for the Azure Key Vault uris.
 const Scheme = "azurekms"
 
-// keyIDRegexp is the regular expression that Key Vault uses on the kid. We can
-// extract the vault, name and version of the key.
-var keyIDRegexp = regexp.MustCompile(`^https://([0-9a-zA-Z-]+)\.vault\.azure\.net/keys/([0-9a-zA-Z-]+)/([0-9a-zA-Z-]+)$`)
+// keySchema defines the attributes allowed in the key URIs, like
+// azurekms:name=my-key;vault=my-vault?version=my-version&hsm=true.
+var keySchema = &uri.Schema{
+	Scheme: Scheme,
+	Path:   []string{"name", "vault"},
+	Query:  []string{"version", "hsm"},
+}
+
+const (
+	// LatestVersion is the version used to address the latest version of a
+	// key. It's the same as not using a version.
+	LatestVersion = "latest"
+	// PrimaryVersion is an alias of LatestVersion, Key Vault always uses the
+	// latest version of a key.
+	PrimaryVersion = "primary"
+)
+
+// keyIDRegexp is the regular expression that Key Vault and Managed HSM use on
+// the kid. We can extract the vault, name and version of the key.
+var keyIDRegexp = regexp.MustCompile(`^https://([0-9a-zA-Z-]+)\.(?:vault|managedhsm)\.azure\.net/keys/([0-9a-zA-Z-]+)/([0-9a-zA-Z-]+)$`)
+
+// keyItemIDRegexp is the regular expression that Key Vault and Managed HSM use
+// on the kid of the listed keys, these do not include the version.
+var keyItemIDRegexp = regexp.MustCompile(`^https://([0-9a-zA-Z-]+)\.(?:vault|managedhsm)\.azure\.net/keys/([0-9a-zA-Z-]+)$`)
 
 var (
 	valueTrue       = true
@@ -101,12 +127,22 @@ var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]keyType{
 // vaultResource is the value the client will use as audience.
 const vaultResource = "https://vault.azure.net"
 
+// managedHSMResource is the value the client will use as audience with a
+// Managed HSM.
+const managedHSMResource = "https://managedhsm.azure.net"
+
 // KeyVaultClient is the interface implemented by keyvault.BaseClient. It will
 // be used for testing purposes.
 type KeyVaultClient interface {
 	GetKey(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string) (keyvault.KeyBundle, error)
 	CreateKey(ctx context.Context, vaultBaseURL string, keyName string, parameters keyvault.KeyCreateParameters) (keyvault.KeyBundle, error)
 	Sign(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string, parameters keyvault.KeySignParameters) (keyvault.KeyOperationResult, error)
+	GetKeys(ctx context.Context, vaultBaseURL string, maxresults *int32) (keyvault.KeyListResultPage, error)
+	DeleteKey(ctx context.Context, vaultBaseURL string, keyName string) (keyvault.DeletedKeyBundle, error)
+	WrapKey(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string, parameters keyvault.KeyOperationsParameters) (keyvault.KeyOperationResult, error)
+	UnwrapKey(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string, parameters keyvault.KeyOperationsParameters) (keyvault.KeyOperationResult, error)
+	Decrypt(ctx context.Context, vaultBaseURL string, keyName string, keyVersion string, parameters keyvault.KeyOperationsParameters) (keyvault.KeyOperationResult, error)
+	ImportKey(ctx context.Context, vaultBaseURL string, keyName string, parameters keyvault.KeyImportParameters) (keyvault.KeyBundle, error)
 }
 
 // KeyVault implements a KMS using Azure Key Vault.
@@ -123,6 +159,11 @@ type KeyVaultClient interface {
 // be used; "hsm" defines if an HSM want to be used for this key, this is
 // specially useful when this is used from `step`.
 //
+// A Managed HSM can be used instead of a key vault setting "managed-hsm=true"
+// in the URI passed in apiv1.Options, e.g. azurekms:vault=hsm-name;managed-hsm=true.
+// In that case "vault" is the name of the Managed HSM, and all the keys are
+// HSM keys.
+//
 // TODO(mariano): The implementation is using /services/keyvault/v7.1/keyvault
 // package, at some point Azure might create a keyvault client with all the
 // functionality in /sdk/keyvault, we should migrate to that once available.
@@ -136,10 +177,12 @@ type KeyVault struct {
 type DefaultOptions struct {
 	Vault           string
 	ProtectionLevel apiv1.ProtectionLevel
+	ManagedHSM      bool
 }
 
 var createClient = func(ctx context.Context, opts apiv1.Options) (KeyVaultClient, error) {
 	baseClient := keyvault.New()
+	resource := vaultResource
 
 	// With an URI, try to log in only using client credentials in the URI.
 	// Client credentials requires:
@@ -160,6 +203,9 @@ var createClient = func(ctx context.Context, opts apiv1.Options) (KeyVaultClient
 		tenantID := u.Get("tenant-id")
 		// optional
 		aadEndpoint := u.Get("aad-endpoint")
+		if u.GetBool("managed-hsm") {
+			resource = managedHSMResource
+		}
 
 		if clientID != "" && clientSecret != "" && tenantID != "" {
 			s := auth.EnvironmentSettings{
@@ -167,7 +213,7 @@ var createClient = func(ctx context.Context, opts apiv1.Options) (KeyVaultClient
 					auth.ClientID:     clientID,
 					auth.ClientSecret: clientSecret,
 					auth.TenantID:     tenantID,
-					auth.Resource:     vaultResource,
+					auth.Resource:     resource,
 				},
 				Environment: azure.PublicCloud,
 			}
@@ -189,9 +235,9 @@ var createClient = func(ctx context.Context, opts apiv1.Options) (KeyVaultClient
 	//    - Username and password
 	//    - MSI
 	// 2. Using Azure CLI 2.0 on local development.
-	authorizer, err := auth.NewAuthorizerFromEnvironmentWithResource(vaultResource)
+	authorizer, err := auth.NewAuthorizerFromEnvironmentWithResource(resource)
 	if err != nil {
-		authorizer, err = auth.NewAuthorizerFromCLIWithResource(vaultResource)
+		authorizer, err = auth.NewAuthorizerFromCLIWithResource(resource)
 		if err != nil {
 			return nil, errors.Wrap(err, "error getting authorizer for key vault")
 		}
@@ -216,7 +262,8 @@ func New(ctx context.Context, opts apiv1.Options) (*KeyVault, error) {
 			return nil, err
 		}
 		defaults.Vault = u.Get("vault")
-		if u.GetBool("hsm") {
+		defaults.ManagedHSM = u.GetBool("managed-hsm")
+		if u.GetBool("hsm") || defaults.ManagedHSM {
 			defaults.ProtectionLevel = apiv1.HSM
 		}
 	}
@@ -241,15 +288,17 @@ func (k *KeyVault) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKe
 	ctx, cancel := defaultContext()
 	defer cancel()
 
-	resp, err := k.baseClient.GetKey(ctx, vaultBaseURL(vault), name, version)
+	resp, err := k.baseClient.GetKey(ctx, vaultBaseURL(vault, k.defaults), name, version)
 	if err != nil {
-		return nil, errors.Wrap(err, "keyVault GetKey failed")
+		return nil, errors.Wrap(convertError(err), "keyVault GetKey failed")
 	}
 
 	return convertKey(resp.Key)
 }
 
-// CreateKey creates a asymmetric key in Azure Key Vault.
+// CreateKey creates a asymmetric key in Azure Key Vault. If the request sets
+// Decrypt, an RSA key for RSA-OAEP decryption is created instead of a signing
+// key.
 func (k *KeyVault) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
 	if req.Name == "" {
 		return nil, errors.New("createKeyRequest 'name' cannot be empty")
@@ -266,10 +315,25 @@ func (k *KeyVault) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 	if protectionLevel == apiv1.UnspecifiedProtectionLevel && hsm {
 		protectionLevel = apiv1.HSM
 	}
+	if k.defaults.ManagedHSM && protectionLevel != apiv1.HSM {
+		return nil, errors.Errorf("keyVault managed HSM does not support protection level '%s'", protectionLevel)
+	}
+
+	signatureAlgorithm := req.SignatureAlgorithm
+	keyOps := []keyvault.JSONWebKeyOperation{keyvault.Sign, keyvault.Verify}
+	if req.Decrypt {
+		if signatureAlgorithm == apiv1.UnspecifiedSignAlgorithm {
+			signatureAlgorithm = apiv1.SHA256WithRSA
+		}
+		keyOps = []keyvault.JSONWebKeyOperation{keyvault.Encrypt, keyvault.Decrypt}
+	}
 
-	kt, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
+	kt, ok := signatureAlgorithmMapping[signatureAlgorithm]
 	if !ok {
-		return nil, errors.Errorf("keyVault does not support signature algorithm '%s'", req.SignatureAlgorithm)
+		return nil, errors.Errorf("keyVault does not support signature algorithm '%s'", signatureAlgorithm)
+	}
+	if req.Decrypt && kt.Kty != keyvault.RSA {
+		return nil, errors.Errorf("keyVault does not support decryption keys with signature algorithm '%s'", signatureAlgorithm)
 	}
 	var keySize *int32
 	if kt.Kty == keyvault.RSA || kt.Kty == keyvault.RSAHSM {
@@ -290,13 +354,11 @@ func (k *KeyVault) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 	ctx, cancel := defaultContext()
 	defer cancel()
 
-	resp, err := k.baseClient.CreateKey(ctx, vaultBaseURL(vault), name, keyvault.KeyCreateParameters{
+	resp, err := k.baseClient.CreateKey(ctx, vaultBaseURL(vault, k.defaults), name, keyvault.KeyCreateParameters{
 		Kty:     kt.KeyType(protectionLevel),
 		KeySize: keySize,
 		Curve:   kt.Curve,
-		KeyOps: &[]keyvault.JSONWebKeyOperation{
-			keyvault.Sign, keyvault.Verify,
-		},
+		KeyOps:  &keyOps,
 		KeyAttributes: &keyvault.KeyAttributes{
 			Enabled:   &valueTrue,
 			Created:   &created,
@@ -304,7 +366,7 @@ func (k *KeyVault) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 		},
 	})
 	if err != nil {
-		return nil, errors.Wrap(err, "keyVault CreateKey failed")
+		return nil, errors.Wrap(convertError(err), "keyVault CreateKey failed")
 	}
 
 	publicKey, err := convertKey(resp.Key)
@@ -330,6 +392,331 @@ func (k *KeyVault) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer,
 	return NewSigner(k.baseClient, req.SigningKey, k.defaults)
 }
 
+// ImportKey imports an existing RSA or EC private key into Azure Key Vault. The
+// key is imported as an HSM key if the protection level is HSM in the uri or
+// the default options. Like in CreateKey, the key can only be used to sign.
+func (k *KeyVault) ImportKey(req *apiv1.ImportKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("importKeyRequest 'name' cannot be empty")
+	}
+
+	vault, name, _, hsm, err := parseKeyName(req.Name, k.defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	priv := req.PrivateKey
+	if priv == nil {
+		if len(req.PrivateKeyPEM) == 0 {
+			return nil, errors.New("importKeyRequest 'privateKey' or 'privateKeyPEM' are required")
+		}
+		var opts []pemutil.Options
+		if req.Password != nil {
+			opts = append(opts, pemutil.WithPassword(req.Password))
+		}
+		if priv, err = pemutil.ParseKey(req.PrivateKeyPEM, opts...); err != nil {
+			return nil, errors.Wrap(err, "error parsing private key")
+		}
+	}
+
+	key, err := convertPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	key.KeyOps = &[]string{string(keyvault.Sign), string(keyvault.Verify)}
+
+	created := date.UnixTime(now())
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := k.baseClient.ImportKey(ctx, vaultBaseURL(vault, k.defaults), name, keyvault.KeyImportParameters{
+		Hsm: &hsm,
+		Key: key,
+		KeyAttributes: &keyvault.KeyAttributes{
+			Enabled:   &valueTrue,
+			Created:   &created,
+			NotBefore: &created,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "keyVault ImportKey failed")
+	}
+
+	publicKey, err := convertKey(resp.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	keyURI := getKeyName(vault, name, resp)
+	return &apiv1.CreateKeyResponse{
+		Name:      keyURI,
+		PublicKey: publicKey,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: keyURI,
+		},
+	}, nil
+}
+
+// CreateDecrypter returns a crypto.Decrypter from a previously created RSA key.
+// Only RSA-OAEP and RSA-OAEP-256 are supported.
+func (k *KeyVault) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Decrypter, error) {
+	if req.DecryptionKey == "" {
+		return nil, errors.New("createDecrypterRequest 'decryptionKey' cannot be empty")
+	}
+	return NewDecrypter(k.baseClient, req.DecryptionKey, k.defaults)
+}
+
+// ListKeys returns the keys in the vault passed in the request name using an
+// URI like azurekms:vault=vault-name, if the name is empty the default vault
+// will be used. The name filter is matched against the key name, and labels
+// against the key tags.
+func (k *KeyVault) ListKeys(req *apiv1.ListKeysRequest) (*apiv1.ListKeysResponse, error) {
+	vault := k.defaults.Vault
+	if req.Name != "" {
+		u, err := uri.ParseWithScheme(Scheme, req.Name)
+		if err != nil {
+			return nil, err
+		}
+		if v := u.Get("vault"); v != "" {
+			vault = v
+		}
+	}
+	if vault == "" {
+		return nil, errors.New("listKeysRequest 'name' is not valid: vault is missing")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	var keys []*apiv1.KeyInfo
+	page, err := k.baseClient.GetKeys(ctx, vaultBaseURL(vault, k.defaults), nil)
+	for ; err == nil && page.NotDone(); err = page.NextWithContext(ctx) {
+		for _, item := range page.Values() {
+			if item.Kid == nil {
+				continue
+			}
+			sm := keyItemIDRegexp.FindStringSubmatch(*item.Kid)
+			if len(sm) != 3 {
+				continue
+			}
+			tags := make(map[string]string, len(item.Tags))
+			for key, value := range item.Tags {
+				if value != nil {
+					tags[key] = *value
+				}
+			}
+			if !req.MatchKey(sm[2], tags) {
+				continue
+			}
+			keys = append(keys, &apiv1.KeyInfo{
+				Name: keySchema.String(url.Values{
+					"vault": []string{sm[1]},
+					"name":  []string{sm[2]},
+				}),
+				Labels: tags,
+			})
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "keyVault GetKeys failed")
+	}
+
+	resp, err := req.Paginate(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only get the key type of the keys in the page.
+	for _, info := range resp.Keys {
+		_, name, _, _, err := parseKeyName(info.Name, k.defaults)
+		if err != nil {
+			return nil, err
+		}
+		bundle, err := k.baseClient.GetKey(ctx, vaultBaseURL(vault, k.defaults), name, "")
+		if err != nil {
+			return nil, errors.Wrap(convertError(err), "keyVault GetKey failed")
+		}
+		if bundle.Key == nil {
+			continue
+		}
+		// The HSM key types are not valid JWK types.
+		jwk := *bundle.Key
+		switch jwk.Kty {
+		case keyvault.ECHSM:
+			jwk.Kty = keyvault.EC
+			info.ProtectionLevel = apiv1.HSM
+		case keyvault.RSAHSM:
+			jwk.Kty = keyvault.RSA
+			info.ProtectionLevel = apiv1.HSM
+		case keyvault.EC, keyvault.RSA:
+			info.ProtectionLevel = apiv1.Software
+		}
+		if pub, err := convertKey(&jwk); err == nil {
+			info.SignatureAlgorithm = apiv1.SignatureAlgorithmForKey(pub)
+		}
+	}
+
+	return resp, nil
+}
+
+// RotateKey creates a new version of the key in the request name. The new
+// version will have the same type, size, curve and operations as the latest
+// one.
+func (k *KeyVault) RotateKey(req *apiv1.RotateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("rotateKeyRequest 'name' cannot be empty")
+	}
+
+	vault, name, _, _, err := parseKeyName(req.Name, k.defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	current, err := k.baseClient.GetKey(ctx, vaultBaseURL(vault, k.defaults), name, "")
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "keyVault GetKey failed")
+	}
+	if current.Key == nil {
+		return nil, errors.Errorf("keyVault key %s does not have a public key", name)
+	}
+
+	var keySize *int32
+	if current.Key.N != nil {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(*current.Key.N, "="))
+		if err != nil {
+			return nil, errors.Wrap(err, "error decoding key modulus")
+		}
+		size := int32(len(b) * 8)
+		keySize = &size
+	}
+
+	var keyOps *[]keyvault.JSONWebKeyOperation
+	if current.Key.KeyOps != nil {
+		ops := make([]keyvault.JSONWebKeyOperation, len(*current.Key.KeyOps))
+		for i, op := range *current.Key.KeyOps {
+			ops[i] = keyvault.JSONWebKeyOperation(op)
+		}
+		keyOps = &ops
+	}
+
+	created := date.UnixTime(now())
+	resp, err := k.baseClient.CreateKey(ctx, vaultBaseURL(vault, k.defaults), name, keyvault.KeyCreateParameters{
+		Kty:     current.Key.Kty,
+		KeySize: keySize,
+		Curve:   current.Key.Crv,
+		KeyOps:  keyOps,
+		KeyAttributes: &keyvault.KeyAttributes{
+			Enabled:   &valueTrue,
+			Created:   &created,
+			NotBefore: &created,
+		},
+		Tags: current.Tags,
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "keyVault CreateKey failed")
+	}
+
+	publicKey, err := convertKey(resp.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	keyURI := getKeyName(vault, name, resp)
+	return &apiv1.CreateKeyResponse{
+		Name:      keyURI,
+		PublicKey: publicKey,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: keyURI,
+		},
+	}, nil
+}
+
+// DeleteKey deletes all the versions of a key in Azure Key Vault. If soft
+// delete is enabled in the vault, the key can be recovered during the
+// retention period configured in the vault.
+func (k *KeyVault) DeleteKey(req *apiv1.DeleteKeyRequest) error {
+	if req.Name == "" {
+		return errors.New("deleteKeyRequest 'name' cannot be empty")
+	}
+
+	vault, name, _, _, err := parseKeyName(req.Name, k.defaults)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	if _, err := k.baseClient.DeleteKey(ctx, vaultBaseURL(vault, k.defaults), name); err != nil {
+		return errors.Wrap(convertError(err), "keyVault DeleteKey failed")
+	}
+	return nil
+}
+
+// Encrypt wraps the given plaintext using the wrapKey operation with the
+// RSA-OAEP-256 algorithm. Azure Key Vault does not support additional
+// authenticated data in this operation. As the ciphertext is bound to a key
+// version, the name should include the version to decrypt it after a rotation.
+func (k *KeyVault) Encrypt(req *apiv1.EncryptRequest) ([]byte, error) {
+	if req.Name == "" {
+		return nil, errors.New("encryptRequest 'name' cannot be empty")
+	}
+	if len(req.AdditionalData) > 0 {
+		return nil, errors.New("encryptRequest 'additionalData' is not supported by keyVault")
+	}
+
+	vault, name, version, _, err := parseKeyName(req.Name, k.defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	value := base64.RawURLEncoding.EncodeToString(req.Plaintext)
+	resp, err := k.baseClient.WrapKey(ctx, vaultBaseURL(vault, k.defaults), name, version, keyvault.KeyOperationsParameters{
+		Algorithm: keyvault.RSAOAEP256,
+		Value:     &value,
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "keyVault WrapKey failed")
+	}
+	return decodeResult(resp, "WrapKey")
+}
+
+// Decrypt unwraps the given ciphertext, as returned by Encrypt, using the
+// unwrapKey operation with the RSA-OAEP-256 algorithm.
+func (k *KeyVault) Decrypt(req *apiv1.DecryptRequest) ([]byte, error) {
+	if req.Name == "" {
+		return nil, errors.New("decryptRequest 'name' cannot be empty")
+	}
+	if len(req.AdditionalData) > 0 {
+		return nil, errors.New("decryptRequest 'additionalData' is not supported by keyVault")
+	}
+
+	vault, name, version, _, err := parseKeyName(req.Name, k.defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	value := base64.RawURLEncoding.EncodeToString(req.Ciphertext)
+	resp, err := k.baseClient.UnwrapKey(ctx, vaultBaseURL(vault, k.defaults), name, version, keyvault.KeyOperationsParameters{
+		Algorithm: keyvault.RSAOAEP256,
+		Value:     &value,
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "keyVault UnwrapKey failed")
+	}
+	return decodeResult(resp, "UnwrapKey")
+}
+
 // Close closes the client connection to the Azure Key Vault. This is a noop.
 func (k *KeyVault) Close() error {
 	return nil
@@ -337,6 +724,9 @@ func (k *KeyVault) Close() error {
 
 // ValidateName validates that the given string is a valid URI.
 func (k *KeyVault) ValidateName(s string) error {
+	if err := keySchema.Validate(s); err != nil {
+		return err
+	}
 	_, _, _, _, err := parseKeyName(s, k.defaults)
 	return err
 }
@@ -4,7 +4,11 @@ package azurekms
 import (
 	"context"
 	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"reflect"
 	"testing"
@@ -16,6 +20,7 @@ import (
 	"go.step.sm/crypto/keyutil"
 	"go.step.sm/crypto/kms/apiv1"
 	"go.step.sm/crypto/kms/azurekms/internal/mock"
+	"go.step.sm/crypto/pemutil"
 	"gopkg.in/square/go-jose.v2"
 )
 
@@ -115,6 +120,20 @@ func TestNew(t *testing.T) {
 				ProtectionLevel: apiv1.HSM,
 			},
 		}, false},
+		{"ok with managed hsm", func() {
+			createClient = func(ctx context.Context, opts apiv1.Options) (KeyVaultClient, error) {
+				return client, nil
+			}
+		}, args{context.Background(), apiv1.Options{
+			URI: "azurekms:vault=my-hsm;managed-hsm=true",
+		}}, &KeyVault{
+			baseClient: client,
+			defaults: DefaultOptions{
+				Vault:           "my-hsm",
+				ProtectionLevel: apiv1.HSM,
+				ManagedHSM:      true,
+			},
+		}, false},
 		{"fail", func() {
 			createClient = func(ctx context.Context, opts apiv1.Options) (KeyVaultClient, error) {
 				return nil, errTest
@@ -161,6 +180,9 @@ func TestKeyVault_createClient(t *testing.T) {
 		{"ok with uri+aad", args{context.Background(), apiv1.Options{
 			URI: "azurekms:client-id=id;client-secret=secret;tenant-id=id;aad-enpoint=https%3A%2F%2Flogin.microsoftonline.us%2F",
 		}}, false, false},
+		{"ok with uri managed hsm", args{context.Background(), apiv1.Options{
+			URI: "azurekms:client-id=id;client-secret=secret;tenant-id=id;managed-hsm=true",
+		}}, false, false},
 		{"ok with uri no config", args{context.Background(), apiv1.Options{
 			URI: "azurekms:",
 		}}, true, false},
@@ -303,6 +325,25 @@ func TestKeyVault_CreateKey(t *testing.T) {
 			Key: e.Key,
 		}, nil)
 	}
+	for _, e := range []struct {
+		Kty     keyvault.JSONWebKeyType
+		KeySize *int32
+	}{{keyvault.RSA, &value3072}, {keyvault.RSAHSM, &value2048}} {
+		client.EXPECT().CreateKey(gomock.Any(), "https://my-vault.vault.azure.net/", "my-decrypt-key", keyvault.KeyCreateParameters{
+			Kty:     e.Kty,
+			KeySize: e.KeySize,
+			KeyOps: &[]keyvault.JSONWebKeyOperation{
+				keyvault.Encrypt, keyvault.Decrypt,
+			},
+			KeyAttributes: &keyvault.KeyAttributes{
+				Enabled:   &valueTrue,
+				Created:   &t0,
+				NotBefore: &t0,
+			},
+		}).Return(keyvault.KeyBundle{
+			Key: rsaJWK,
+		}, nil)
+	}
 	client.EXPECT().CreateKey(gomock.Any(), "https://my-vault.vault.azure.net/", "not-found", gomock.Any()).Return(keyvault.KeyBundle{}, errTest)
 	client.EXPECT().CreateKey(gomock.Any(), "https://my-vault.vault.azure.net/", "not-found", gomock.Any()).Return(keyvault.KeyBundle{
 		Key: nil,
@@ -450,6 +491,34 @@ func TestKeyVault_CreateKey(t *testing.T) {
 				SigningKey: "azurekms:name=my-key;vault=my-vault",
 			},
 		}, false},
+		{"ok decrypt", fields{client}, args{&apiv1.CreateKeyRequest{
+			Name:    "azurekms:vault=my-vault;name=my-decrypt-key",
+			Decrypt: true,
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "azurekms:name=my-decrypt-key;vault=my-vault",
+			PublicKey: rsaPub,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "azurekms:name=my-decrypt-key;vault=my-vault",
+			},
+		}, false},
+		{"ok decrypt HSM", fields{client}, args{&apiv1.CreateKeyRequest{
+			Name:               "azurekms:vault=my-vault;name=my-decrypt-key",
+			SignatureAlgorithm: apiv1.SHA256WithRSA,
+			Bits:               2048,
+			ProtectionLevel:    apiv1.HSM,
+			Decrypt:            true,
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "azurekms:name=my-decrypt-key;vault=my-vault",
+			PublicKey: rsaPub,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "azurekms:name=my-decrypt-key;vault=my-vault",
+			},
+		}, false},
+		{"fail decrypt ec", fields{client}, args{&apiv1.CreateKeyRequest{
+			Name:               "azurekms:vault=my-vault;name=my-decrypt-key",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+			Decrypt:            true,
+		}}, nil, true},
 		{"fail createKey", fields{client}, args{&apiv1.CreateKeyRequest{
 			Name:               "azurekms:vault=my-vault;name=not-found",
 			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
@@ -494,6 +563,296 @@ func TestKeyVault_CreateKey(t *testing.T) {
 	}
 }
 
+func TestKeyVault_CreateKey_managedHSM(t *testing.T) {
+	ecKey, err := keyutil.GenerateDefaultSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPub := ecKey.Public()
+	ecJWK := createJWK(t, ecPub)
+	kid := "https://my-hsm.managedhsm.azure.net/keys/my-key/my-version"
+	ecJWK.Kid = &kid
+
+	t0 := date.UnixTime(mockNow(t))
+	client := mockClient(t)
+	client.EXPECT().CreateKey(gomock.Any(), "https://my-hsm.managedhsm.azure.net/", "my-key", keyvault.KeyCreateParameters{
+		Kty:   keyvault.ECHSM,
+		Curve: keyvault.P256,
+		KeyOps: &[]keyvault.JSONWebKeyOperation{
+			keyvault.Sign, keyvault.Verify,
+		},
+		KeyAttributes: &keyvault.KeyAttributes{
+			Enabled:   &valueTrue,
+			Created:   &t0,
+			NotBefore: &t0,
+		},
+	}).Return(keyvault.KeyBundle{
+		Key: ecJWK,
+	}, nil)
+
+	defaults := DefaultOptions{Vault: "my-hsm", ProtectionLevel: apiv1.HSM, ManagedHSM: true}
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateKeyRequest
+		want    *apiv1.CreateKeyResponse
+		wantErr bool
+	}{
+		{"ok", &apiv1.CreateKeyRequest{
+			Name: "azurekms:name=my-key",
+		}, &apiv1.CreateKeyResponse{
+			Name:      "azurekms:name=my-key;vault=my-hsm?version=my-version",
+			PublicKey: ecPub,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "azurekms:name=my-key;vault=my-hsm?version=my-version",
+			},
+		}, false},
+		{"fail software", &apiv1.CreateKeyRequest{
+			Name:            "azurekms:name=my-key",
+			ProtectionLevel: apiv1.Software,
+		}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KeyVault{
+				baseClient: client,
+				defaults:   defaults,
+			}
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KeyVault.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KeyVault.CreateKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyVault_ImportKey(t *testing.T) {
+	ecKey, err := keyutil.GenerateDefaultSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := keyutil.GenerateSigner("RSA", "", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPub := ecKey.Public()
+	rsaPub := rsaKey.Public()
+	ecPEM, err := pemutil.Serialize(ecKey, pemutil.WithPassword([]byte("password")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importParameters := func(priv crypto.PrivateKey, hsm bool) keyvault.KeyImportParameters {
+		key, err := convertPrivateKey(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key.KeyOps = &[]string{"sign", "verify"}
+		t0 := date.UnixTime(mockNow(t))
+		return keyvault.KeyImportParameters{
+			Hsm: &hsm,
+			Key: key,
+			KeyAttributes: &keyvault.KeyAttributes{
+				Enabled:   &valueTrue,
+				Created:   &t0,
+				NotBefore: &t0,
+			},
+		}
+	}
+
+	client := mockClient(t)
+	client.EXPECT().ImportKey(gomock.Any(), "https://my-vault.vault.azure.net/", "my-key", importParameters(ecKey, false)).Return(keyvault.KeyBundle{
+		Key: createJWK(t, ecPub),
+	}, nil).Times(2)
+	client.EXPECT().ImportKey(gomock.Any(), "https://my-vault.vault.azure.net/", "my-key", importParameters(rsaKey, true)).Return(keyvault.KeyBundle{
+		Key: createJWK(t, rsaPub),
+	}, nil)
+	client.EXPECT().ImportKey(gomock.Any(), "https://my-vault.vault.azure.net/", "not-found", gomock.Any()).Return(keyvault.KeyBundle{}, errTest)
+	client.EXPECT().ImportKey(gomock.Any(), "https://my-vault.vault.azure.net/", "not-found", gomock.Any()).Return(keyvault.KeyBundle{
+		Key: nil,
+	}, nil)
+
+	type fields struct {
+		baseClient KeyVaultClient
+	}
+	type args struct {
+		req *apiv1.ImportKeyRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *apiv1.CreateKeyResponse
+		wantErr bool
+	}{
+		{"ok", fields{client}, args{&apiv1.ImportKeyRequest{
+			Name:       "azurekms:vault=my-vault;name=my-key",
+			PrivateKey: ecKey,
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "azurekms:name=my-key;vault=my-vault",
+			PublicKey: ecPub,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "azurekms:name=my-key;vault=my-vault",
+			},
+		}, false},
+		{"ok pem", fields{client}, args{&apiv1.ImportKeyRequest{
+			Name:          "azurekms:vault=my-vault;name=my-key",
+			PrivateKeyPEM: pem.EncodeToMemory(ecPEM),
+			Password:      []byte("password"),
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "azurekms:name=my-key;vault=my-vault",
+			PublicKey: ecPub,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "azurekms:name=my-key;vault=my-vault",
+			},
+		}, false},
+		{"ok rsa hsm", fields{client}, args{&apiv1.ImportKeyRequest{
+			Name:       "azurekms:vault=my-vault;name=my-key;hsm=true",
+			PrivateKey: rsaKey,
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "azurekms:name=my-key;vault=my-vault",
+			PublicKey: rsaPub,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "azurekms:name=my-key;vault=my-vault",
+			},
+		}, false},
+		{"fail importKey", fields{client}, args{&apiv1.ImportKeyRequest{
+			Name:       "azurekms:vault=my-vault;name=not-found",
+			PrivateKey: ecKey,
+		}}, nil, true},
+		{"fail convertKey", fields{client}, args{&apiv1.ImportKeyRequest{
+			Name:       "azurekms:vault=my-vault;name=not-found",
+			PrivateKey: ecKey,
+		}}, nil, true},
+		{"fail name", fields{client}, args{&apiv1.ImportKeyRequest{
+			Name:       "",
+			PrivateKey: ecKey,
+		}}, nil, true},
+		{"fail vault", fields{client}, args{&apiv1.ImportKeyRequest{
+			Name:       "azurekms:vault=;name=my-key",
+			PrivateKey: ecKey,
+		}}, nil, true},
+		{"fail no key", fields{client}, args{&apiv1.ImportKeyRequest{
+			Name: "azurekms:vault=my-vault;name=my-key",
+		}}, nil, true},
+		{"fail pem password", fields{client}, args{&apiv1.ImportKeyRequest{
+			Name:          "azurekms:vault=my-vault;name=my-key",
+			PrivateKeyPEM: pem.EncodeToMemory(ecPEM),
+			Password:      []byte("bad-password"),
+		}}, nil, true},
+		{"fail ed25519", fields{client}, args{&apiv1.ImportKeyRequest{
+			Name:       "azurekms:vault=my-vault;name=my-key",
+			PrivateKey: edKey,
+		}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KeyVault{
+				baseClient: tt.fields.baseClient,
+			}
+			got, err := k.ImportKey(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KeyVault.ImportKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KeyVault.ImportKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyVault_CreateDecrypter(t *testing.T) {
+	ecKey, err := keyutil.GenerateDefaultSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := keyutil.GenerateSigner("RSA", "", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub := rsaKey.Public()
+
+	client := mockClient(t)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "my-key", "").Return(keyvault.KeyBundle{
+		Key: createJWK(t, rsaPub),
+	}, nil)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "my-key", "my-version").Return(keyvault.KeyBundle{
+		Key: createJWK(t, rsaPub),
+	}, nil)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "ec-key", "").Return(keyvault.KeyBundle{
+		Key: createJWK(t, ecKey.Public()),
+	}, nil)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "not-found", "my-version").Return(keyvault.KeyBundle{}, errTest)
+
+	type fields struct {
+		baseClient KeyVaultClient
+	}
+	type args struct {
+		req *apiv1.CreateDecrypterRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    crypto.Decrypter
+		wantErr bool
+	}{
+		{"ok", fields{client}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "azurekms:vault=my-vault;name=my-key",
+		}}, &Decrypter{
+			client:       client,
+			vaultBaseURL: "https://my-vault.vault.azure.net/",
+			name:         "my-key",
+			version:      "",
+			publicKey:    rsaPub,
+		}, false},
+		{"ok with version", fields{client}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "azurekms:vault=my-vault;name=my-key?version=my-version",
+		}}, &Decrypter{
+			client:       client,
+			vaultBaseURL: "https://my-vault.vault.azure.net/",
+			name:         "my-key",
+			version:      "my-version",
+			publicKey:    rsaPub,
+		}, false},
+		{"fail not rsa", fields{client}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "azurekms:vault=my-vault;name=ec-key",
+		}}, nil, true},
+		{"fail GetKey", fields{client}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "azurekms:vault=my-vault;name=not-found?version=my-version",
+		}}, nil, true},
+		{"fail DecryptionKey", fields{client}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "",
+		}}, nil, true},
+		{"fail vault", fields{client}, args{&apiv1.CreateDecrypterRequest{
+			DecryptionKey: "azurekms:vault=;name=my-key",
+		}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KeyVault{
+				baseClient: tt.fields.baseClient,
+			}
+			got, err := k.CreateDecrypter(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KeyVault.CreateDecrypter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KeyVault.CreateDecrypter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestKeyVault_CreateSigner(t *testing.T) {
 	key, err := keyutil.GenerateDefaultSigner()
 	if err != nil {
@@ -566,6 +925,425 @@ func TestKeyVault_CreateSigner(t *testing.T) {
 	}
 }
 
+func TestKeyVault_ListKeys(t *testing.T) {
+	ecKey, err := keyutil.GenerateDefaultSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := keyutil.GenerateSigner("RSA", "", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecJWK := createJWK(t, ecKey.Public())
+	ecJWK.Kty = keyvault.ECHSM
+	rsaJWK := createJWK(t, rsaKey.Public())
+
+	kid := func(s string) *string {
+		return &s
+	}
+	prod := "prod"
+	firstPage := keyvault.KeyListResult{
+		Value: &[]keyvault.KeyItem{
+			{Kid: kid("https://my-vault.vault.azure.net/keys/key-1"), Tags: map[string]*string{"env": &prod}},
+			{Kid: kid("https://my-vault.vault.azure.net/certificates/cert-1")},
+			{Kid: nil},
+		},
+	}
+	secondPage := keyvault.KeyListResult{
+		Value: &[]keyvault.KeyItem{
+			{Kid: kid("https://my-vault.vault.azure.net/keys/key-2")},
+		},
+	}
+	page := keyvault.NewKeyListResultPage(firstPage, func(_ context.Context, cur keyvault.KeyListResult) (keyvault.KeyListResult, error) {
+		if cur.Value == firstPage.Value {
+			return secondPage, nil
+		}
+		return keyvault.KeyListResult{}, nil
+	})
+
+	client := mockClient(t)
+	client.EXPECT().GetKeys(gomock.Any(), "https://my-vault.vault.azure.net/", nil).DoAndReturn(func(_ context.Context, _ string, _ *int32) (keyvault.KeyListResultPage, error) {
+		return page, nil
+	}).AnyTimes()
+	client.EXPECT().GetKeys(gomock.Any(), "https://fail-vault.vault.azure.net/", nil).Return(keyvault.KeyListResultPage{}, errTest)
+	client.EXPECT().GetKeys(gomock.Any(), "https://fail-key.vault.azure.net/", nil).Return(keyvault.NewKeyListResultPage(secondPage, func(context.Context, keyvault.KeyListResult) (keyvault.KeyListResult, error) {
+		return keyvault.KeyListResult{}, nil
+	}), nil)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "key-1", "").Return(keyvault.KeyBundle{
+		Key: ecJWK,
+	}, nil).AnyTimes()
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "key-2", "").Return(keyvault.KeyBundle{
+		Key: rsaJWK,
+	}, nil).AnyTimes()
+	client.EXPECT().GetKey(gomock.Any(), "https://fail-key.vault.azure.net/", "key-2", "").Return(keyvault.KeyBundle{}, errTest)
+
+	key1 := &apiv1.KeyInfo{
+		Name:               "azurekms:name=key-1;vault=my-vault",
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+		ProtectionLevel:    apiv1.HSM,
+		Labels:             map[string]string{"env": "prod"},
+	}
+	key2 := &apiv1.KeyInfo{
+		Name:               "azurekms:name=key-2;vault=my-vault",
+		SignatureAlgorithm: apiv1.SHA256WithRSA,
+		ProtectionLevel:    apiv1.Software,
+		Labels:             map[string]string{},
+	}
+
+	type fields struct {
+		baseClient KeyVaultClient
+		defaults   DefaultOptions
+	}
+	type args struct {
+		req *apiv1.ListKeysRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *apiv1.ListKeysResponse
+		wantErr bool
+	}{
+		{"ok", fields{client, DefaultOptions{}}, args{&apiv1.ListKeysRequest{
+			Name: "azurekms:vault=my-vault",
+		}}, &apiv1.ListKeysResponse{Keys: []*apiv1.KeyInfo{key1, key2}}, false},
+		{"ok default vault", fields{client, DefaultOptions{Vault: "my-vault"}}, args{&apiv1.ListKeysRequest{}},
+			&apiv1.ListKeysResponse{Keys: []*apiv1.KeyInfo{key1, key2}}, false},
+		{"ok filter", fields{client, DefaultOptions{}}, args{&apiv1.ListKeysRequest{
+			Name: "azurekms:vault=my-vault", NameFilter: "*-2",
+		}}, &apiv1.ListKeysResponse{Keys: []*apiv1.KeyInfo{key2}}, false},
+		{"ok labels", fields{client, DefaultOptions{}}, args{&apiv1.ListKeysRequest{
+			Name: "azurekms:vault=my-vault", Labels: map[string]string{"env": "prod"},
+		}}, &apiv1.ListKeysResponse{Keys: []*apiv1.KeyInfo{key1}}, false},
+		{"ok page", fields{client, DefaultOptions{}}, args{&apiv1.ListKeysRequest{
+			Name: "azurekms:vault=my-vault", PageSize: 1,
+		}}, &apiv1.ListKeysResponse{Keys: []*apiv1.KeyInfo{key1}, NextPageToken: "1"}, false},
+		{"fail vault", fields{client, DefaultOptions{}}, args{&apiv1.ListKeysRequest{}}, nil, true},
+		{"fail uri", fields{client, DefaultOptions{}}, args{&apiv1.ListKeysRequest{
+			Name: "foo:vault=my-vault",
+		}}, nil, true},
+		{"fail GetKeys", fields{client, DefaultOptions{}}, args{&apiv1.ListKeysRequest{
+			Name: "azurekms:vault=fail-vault",
+		}}, nil, true},
+		{"fail GetKey", fields{client, DefaultOptions{}}, args{&apiv1.ListKeysRequest{
+			Name: "azurekms:vault=fail-key",
+		}}, nil, true},
+		{"fail page token", fields{client, DefaultOptions{}}, args{&apiv1.ListKeysRequest{
+			Name: "azurekms:vault=my-vault", PageToken: "foo",
+		}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KeyVault{
+				baseClient: tt.fields.baseClient,
+				defaults:   tt.fields.defaults,
+			}
+			got, err := k.ListKeys(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KeyVault.ListKeys() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KeyVault.ListKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyVault_RotateKey(t *testing.T) {
+	ecKey, err := keyutil.GenerateDefaultSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := keyutil.GenerateSigner("RSA", "", 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPub := ecKey.Public()
+	rsaPub := rsaKey.Public()
+	ecJWK := createJWK(t, ecPub)
+	ecJWK.Kty = keyvault.ECHSM
+	ecJWK.KeyOps = &[]string{"sign", "verify"}
+	rsaJWK := createJWK(t, rsaPub)
+	newECJWK := createJWK(t, ecPub)
+	newKid := "https://my-vault.vault.azure.net/keys/ec-key/new-version"
+	newECJWK.Kid = &newKid
+
+	t0 := date.UnixTime(mockNow(t))
+	prod := "prod"
+	client := mockClient(t)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "ec-key", "").Return(keyvault.KeyBundle{
+		Key:  ecJWK,
+		Tags: map[string]*string{"env": &prod},
+	}, nil)
+	client.EXPECT().CreateKey(gomock.Any(), "https://my-vault.vault.azure.net/", "ec-key", keyvault.KeyCreateParameters{
+		Kty:   keyvault.ECHSM,
+		Curve: keyvault.P256,
+		KeyOps: &[]keyvault.JSONWebKeyOperation{
+			keyvault.Sign, keyvault.Verify,
+		},
+		KeyAttributes: &keyvault.KeyAttributes{
+			Enabled:   &valueTrue,
+			Created:   &t0,
+			NotBefore: &t0,
+		},
+		Tags: map[string]*string{"env": &prod},
+	}).Return(keyvault.KeyBundle{
+		Key: newECJWK,
+	}, nil)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "rsa-key", "").Return(keyvault.KeyBundle{
+		Key: rsaJWK,
+	}, nil)
+	client.EXPECT().CreateKey(gomock.Any(), "https://my-vault.vault.azure.net/", "rsa-key", keyvault.KeyCreateParameters{
+		Kty:     keyvault.RSA,
+		KeySize: &value2048,
+		KeyAttributes: &keyvault.KeyAttributes{
+			Enabled:   &valueTrue,
+			Created:   &t0,
+			NotBefore: &t0,
+		},
+	}).Return(keyvault.KeyBundle{
+		Key: rsaJWK,
+	}, nil)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "not-found", "").Return(keyvault.KeyBundle{}, errTest)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "no-key", "").Return(keyvault.KeyBundle{}, nil)
+	client.EXPECT().GetKey(gomock.Any(), "https://my-vault.vault.azure.net/", "fail-create", "").Return(keyvault.KeyBundle{
+		Key: rsaJWK,
+	}, nil)
+	client.EXPECT().CreateKey(gomock.Any(), "https://my-vault.vault.azure.net/", "fail-create", gomock.Any()).Return(keyvault.KeyBundle{}, errTest)
+
+	type fields struct {
+		baseClient KeyVaultClient
+	}
+	type args struct {
+		req *apiv1.RotateKeyRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *apiv1.CreateKeyResponse
+		wantErr bool
+	}{
+		{"ok ec", fields{client}, args{&apiv1.RotateKeyRequest{
+			Name: "azurekms:vault=my-vault;name=ec-key?version=old-version",
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "azurekms:name=ec-key;vault=my-vault?version=new-version",
+			PublicKey: ecPub,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "azurekms:name=ec-key;vault=my-vault?version=new-version",
+			},
+		}, false},
+		{"ok rsa", fields{client}, args{&apiv1.RotateKeyRequest{
+			Name: "azurekms:vault=my-vault;name=rsa-key",
+		}}, &apiv1.CreateKeyResponse{
+			Name:      "azurekms:name=rsa-key;vault=my-vault",
+			PublicKey: rsaPub,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "azurekms:name=rsa-key;vault=my-vault",
+			},
+		}, false},
+		{"fail empty", fields{client}, args{&apiv1.RotateKeyRequest{}}, nil, true},
+		{"fail vault", fields{client}, args{&apiv1.RotateKeyRequest{
+			Name: "azurekms:vault=;name=ec-key",
+		}}, nil, true},
+		{"fail GetKey", fields{client}, args{&apiv1.RotateKeyRequest{
+			Name: "azurekms:vault=my-vault;name=not-found",
+		}}, nil, true},
+		{"fail no key", fields{client}, args{&apiv1.RotateKeyRequest{
+			Name: "azurekms:vault=my-vault;name=no-key",
+		}}, nil, true},
+		{"fail CreateKey", fields{client}, args{&apiv1.RotateKeyRequest{
+			Name: "azurekms:vault=my-vault;name=fail-create",
+		}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KeyVault{
+				baseClient: tt.fields.baseClient,
+			}
+			got, err := k.RotateKey(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KeyVault.RotateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KeyVault.RotateKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyVault_DeleteKey(t *testing.T) {
+	client := mockClient(t)
+	client.EXPECT().DeleteKey(gomock.Any(), "https://my-vault.vault.azure.net/", "my-key").Return(keyvault.DeletedKeyBundle{}, nil).Times(2)
+	client.EXPECT().DeleteKey(gomock.Any(), "https://my-vault.vault.azure.net/", "not-found").Return(keyvault.DeletedKeyBundle{}, errTest)
+
+	type fields struct {
+		baseClient KeyVaultClient
+	}
+	type args struct {
+		req *apiv1.DeleteKeyRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{"ok", fields{client}, args{&apiv1.DeleteKeyRequest{
+			Name: "azurekms:vault=my-vault;name=my-key",
+		}}, false},
+		{"ok with version", fields{client}, args{&apiv1.DeleteKeyRequest{
+			Name: "azurekms:vault=my-vault;name=my-key?version=my-version",
+		}}, false},
+		{"fail DeleteKey", fields{client}, args{&apiv1.DeleteKeyRequest{
+			Name: "azurekms:vault=my-vault;name=not-found",
+		}}, true},
+		{"fail empty", fields{client}, args{&apiv1.DeleteKeyRequest{}}, true},
+		{"fail vault", fields{client}, args{&apiv1.DeleteKeyRequest{
+			Name: "azurekms:vault=;name=my-key",
+		}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KeyVault{
+				baseClient: tt.fields.baseClient,
+			}
+			if err := k.DeleteKey(tt.args.req); (err != nil) != tt.wantErr {
+				t.Errorf("KeyVault.DeleteKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyVault_Encrypt(t *testing.T) {
+	value := base64.RawURLEncoding.EncodeToString([]byte("plaintext"))
+	result := base64.RawURLEncoding.EncodeToString([]byte("ciphertext"))
+	params := keyvault.KeyOperationsParameters{Algorithm: keyvault.RSAOAEP256, Value: &value}
+
+	client := mockClient(t)
+	client.EXPECT().WrapKey(gomock.Any(), "https://my-vault.vault.azure.net/", "my-key", "", params).Return(keyvault.KeyOperationResult{
+		Result: &result,
+	}, nil)
+	client.EXPECT().WrapKey(gomock.Any(), "https://my-vault.vault.azure.net/", "my-key", "my-version", params).Return(keyvault.KeyOperationResult{
+		Result: &result,
+	}, nil)
+	client.EXPECT().WrapKey(gomock.Any(), "https://my-vault.vault.azure.net/", "not-found", "", params).Return(keyvault.KeyOperationResult{}, errTest)
+	client.EXPECT().WrapKey(gomock.Any(), "https://my-vault.vault.azure.net/", "empty", "", params).Return(keyvault.KeyOperationResult{}, nil)
+
+	type fields struct {
+		baseClient KeyVaultClient
+	}
+	type args struct {
+		req *apiv1.EncryptRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", fields{client}, args{&apiv1.EncryptRequest{
+			Name: "azurekms:vault=my-vault;name=my-key", Plaintext: []byte("plaintext"),
+		}}, []byte("ciphertext"), false},
+		{"ok with version", fields{client}, args{&apiv1.EncryptRequest{
+			Name: "azurekms:vault=my-vault;name=my-key?version=my-version", Plaintext: []byte("plaintext"),
+		}}, []byte("ciphertext"), false},
+		{"fail WrapKey", fields{client}, args{&apiv1.EncryptRequest{
+			Name: "azurekms:vault=my-vault;name=not-found", Plaintext: []byte("plaintext"),
+		}}, nil, true},
+		{"fail empty result", fields{client}, args{&apiv1.EncryptRequest{
+			Name: "azurekms:vault=my-vault;name=empty", Plaintext: []byte("plaintext"),
+		}}, nil, true},
+		{"fail empty", fields{client}, args{&apiv1.EncryptRequest{}}, nil, true},
+		{"fail additional data", fields{client}, args{&apiv1.EncryptRequest{
+			Name: "azurekms:vault=my-vault;name=my-key", Plaintext: []byte("plaintext"), AdditionalData: []byte("aad"),
+		}}, nil, true},
+		{"fail vault", fields{client}, args{&apiv1.EncryptRequest{
+			Name: "azurekms:vault=;name=my-key", Plaintext: []byte("plaintext"),
+		}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KeyVault{
+				baseClient: tt.fields.baseClient,
+			}
+			got, err := k.Encrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KeyVault.Encrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KeyVault.Encrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyVault_Decrypt(t *testing.T) {
+	value := base64.RawURLEncoding.EncodeToString([]byte("ciphertext"))
+	result := base64.RawURLEncoding.EncodeToString([]byte("plaintext"))
+	invalid := "%%%"
+	params := keyvault.KeyOperationsParameters{Algorithm: keyvault.RSAOAEP256, Value: &value}
+
+	client := mockClient(t)
+	client.EXPECT().UnwrapKey(gomock.Any(), "https://my-vault.vault.azure.net/", "my-key", "my-version", params).Return(keyvault.KeyOperationResult{
+		Result: &result,
+	}, nil)
+	client.EXPECT().UnwrapKey(gomock.Any(), "https://my-vault.vault.azure.net/", "not-found", "", params).Return(keyvault.KeyOperationResult{}, errTest)
+	client.EXPECT().UnwrapKey(gomock.Any(), "https://my-vault.vault.azure.net/", "invalid", "", params).Return(keyvault.KeyOperationResult{
+		Result: &invalid,
+	}, nil)
+
+	type fields struct {
+		baseClient KeyVaultClient
+	}
+	type args struct {
+		req *apiv1.DecryptRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", fields{client}, args{&apiv1.DecryptRequest{
+			Name: "azurekms:vault=my-vault;name=my-key?version=my-version", Ciphertext: []byte("ciphertext"),
+		}}, []byte("plaintext"), false},
+		{"fail UnwrapKey", fields{client}, args{&apiv1.DecryptRequest{
+			Name: "azurekms:vault=my-vault;name=not-found", Ciphertext: []byte("ciphertext"),
+		}}, nil, true},
+		{"fail invalid result", fields{client}, args{&apiv1.DecryptRequest{
+			Name: "azurekms:vault=my-vault;name=invalid", Ciphertext: []byte("ciphertext"),
+		}}, nil, true},
+		{"fail empty", fields{client}, args{&apiv1.DecryptRequest{}}, nil, true},
+		{"fail additional data", fields{client}, args{&apiv1.DecryptRequest{
+			Name: "azurekms:vault=my-vault;name=my-key", Ciphertext: []byte("ciphertext"), AdditionalData: []byte("aad"),
+		}}, nil, true},
+		{"fail vault", fields{client}, args{&apiv1.DecryptRequest{
+			Name: "azurekms:vault=;name=my-key", Ciphertext: []byte("ciphertext"),
+		}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &KeyVault{
+				baseClient: tt.fields.baseClient,
+			}
+			got, err := k.Decrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("KeyVault.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KeyVault.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestKeyVault_Close(t *testing.T) {
 	client := mockClient(t)
 	type fields struct {
@@ -636,6 +1414,9 @@ func TestKeyVault_ValidateName(t *testing.T) {
 	}{
 		{"ok", args{"azurekms:name=my-key;vault=my-vault"}, false},
 		{"ok hsm", args{"azurekms:name=my-key;vault=my-vault?hsm=true"}, false},
+		{"ok version", args{"azurekms:vault=my-vault;name=my-key;version=my-version"}, false},
+		{"fail unknown attribute", args{"azurekms:name=my-key;vault=my-vault?region=westus"}, true},
+		{"fail repeated attribute", args{"azurekms:name=my-key;vault=my-vault?name=other-key"}, true},
 		{"fail scheme", args{"azure:name=my-key;vault=my-vault"}, true},
 		{"fail parse uri", args{"azurekms:name=%ZZ;vault=my-vault"}, true},
 		{"fail no name", args{"azurekms:vault=my-vault"}, true},
@@ -35,7 +35,7 @@ func NewSigner(client KeyVaultClient, signingKey string, defaults DefaultOptions
 	// Make sure that the key exists.
 	signer := &Signer{
 		client:       client,
-		vaultBaseURL: vaultBaseURL(vault),
+		vaultBaseURL: vaultBaseURL(vault, defaults),
 		name:         name,
 		version:      version,
 	}
@@ -52,7 +52,7 @@ func (s *Signer) preloadKey() error {
 
 	resp, err := s.client.GetKey(ctx, s.vaultBaseURL, s.name, s.version)
 	if err != nil {
-		return errors.Wrap(err, "keyVault GetKey failed")
+		return errors.Wrap(convertError(err), "keyVault GetKey failed")
 	}
 
 	s.publicKey, err = convertKey(resp.Key)
@@ -76,7 +76,7 @@ func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]
 	// Sign with retry if the key is not ready
 	resp, err := s.signWithRetry(alg, b64, 3)
 	if err != nil {
-		return nil, errors.Wrap(err, "keyVault Sign failed")
+		return nil, errors.Wrap(convertError(err), "keyVault Sign failed")
 	}
 
 	sig, err := base64.RawURLEncoding.DecodeString(*resp.Result)
@@ -3,11 +3,16 @@ package azurekms
 import (
 	"context"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/json"
+	"net/http"
 	"net/url"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.1/keyvault"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/pkg/errors"
 	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/kms/apiv1"
@@ -25,19 +30,30 @@ func getKeyName(vault, name string, bundle keyvault.KeyBundle) string {
 		sm := keyIDRegexp.FindAllStringSubmatch(*bundle.Key.Kid, 1)
 		if len(sm) == 1 && len(sm[0]) == 4 {
 			m := sm[0]
-			u := uri.New(Scheme, url.Values{
-				"vault": []string{m[1]},
-				"name":  []string{m[2]},
+			return keySchema.String(url.Values{
+				"vault":   []string{m[1]},
+				"name":    []string{m[2]},
+				"version": []string{m[3]},
 			})
-			u.RawQuery = url.Values{"version": []string{m[3]}}.Encode()
-			return u.String()
 		}
 	}
 	// Fallback to URI without id.
-	return uri.New(Scheme, url.Values{
+	return keySchema.String(url.Values{
 		"vault": []string{vault},
 		"name":  []string{name},
-	}).String()
+	})
+}
+
+// decodeResult returns the base64url decoded result of a key operation.
+func decodeResult(resp keyvault.KeyOperationResult, op string) ([]byte, error) {
+	if resp.Result == nil {
+		return nil, errors.Errorf("keyVault %s result is empty", op)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(*resp.Result)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error decoding keyVault %s result", op)
+	}
+	return b, nil
 }
 
 // parseKeyName returns the key vault, name and version from URIs like:
@@ -46,11 +62,11 @@ func getKeyName(vault, name string, bundle keyvault.KeyBundle) string {
 //   - azurekms:vault=key-vault;name=key-name?version=key-id
 //   - azurekms:vault=key-vault;name=key-name?version=key-id&hsm=true
 //
-// The key-id defines the version of the key, if it is not passed the latest
-// version will be used.
+// The key-id defines the version of the key, if it is not passed, or it is
+// "latest" or "primary", the latest version will be used.
 //
 // HSM can also be passed to define the protection level if this is not given in
-// CreateQuery.
+// CreateQuery. Keys in a Managed HSM are always HSM keys.
 func parseKeyName(rawURI string, defaults DefaultOptions) (vault, name, version string, hsm bool, err error) {
 	var u *uri.URI
 
@@ -70,18 +86,29 @@ func parseKeyName(rawURI string, defaults DefaultOptions) (vault, name, version
 		}
 		vault = defaults.Vault
 	}
-	if u.Get("hsm") == "" {
+	switch {
+	case defaults.ManagedHSM:
+		hsm = true
+	case u.Get("hsm") == "":
 		hsm = (defaults.ProtectionLevel == apiv1.HSM)
-	} else {
+	default:
 		hsm = u.GetBool("hsm")
 	}
 
 	version = u.Get("version")
+	if version == LatestVersion || version == PrimaryVersion {
+		version = ""
+	}
 
 	return
 }
 
-func vaultBaseURL(vault string) string {
+// vaultBaseURL returns the base URL of the given key vault, or the Managed HSM
+// if it's enabled in the default options.
+func vaultBaseURL(vault string, defaults DefaultOptions) string {
+	if defaults.ManagedHSM {
+		return "https://" + vault + ".managedhsm.azure.net/"
+	}
 	return "https://" + vault + ".vault.azure.net/"
 }
 
@@ -96,3 +123,42 @@ func convertKey(key *keyvault.JSONWebKey) (crypto.PublicKey, error) {
 	}
 	return jwk.Key, nil
 }
+
+// convertPrivateKey returns the JSON web key of an RSA or EC private key, as
+// expected by the Key Vault import operation.
+func convertPrivateKey(priv crypto.PrivateKey) (*keyvault.JSONWebKey, error) {
+	switch priv.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return nil, errors.Errorf("unsupported private key type %T", priv)
+	}
+	b, err := json.Marshal(&jose.JSONWebKey{Key: priv})
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling key")
+	}
+	key := new(keyvault.JSONWebKey)
+	if err := json.Unmarshal(b, key); err != nil {
+		return nil, errors.Wrap(err, "error unmarshaling key")
+	}
+	return key, nil
+}
+
+// convertError converts the errors returned by Azure Key Vault to the error
+// types defined in apiv1 using the status code of the response.
+func convertError(err error) error {
+	var e autorest.DetailedError
+	if !errors.As(err, &e) {
+		return err
+	}
+	code, _ := e.StatusCode.(int)
+	switch code {
+	case http.StatusNotFound:
+		return apiv1.NotFoundError{Err: err}
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return apiv1.PermissionDeniedError{Err: err}
+	case http.StatusTooManyRequests:
+		return apiv1.RateLimitedError{Err: err}
+	default:
+		return err
+	}
+}
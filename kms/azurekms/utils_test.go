@@ -1,9 +1,14 @@
 package azurekms
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
 	"testing"
 
 	"github.com/Azure/azure-sdk-for-go/services/keyvault/v7.1/keyvault"
+	"github.com/Azure/go-autorest/autorest"
 	"go.step.sm/crypto/kms/apiv1"
 )
 
@@ -27,6 +32,7 @@ func Test_getKeyName(t *testing.T) {
 		want string
 	}{
 		{"ok", args{"my-vault", "my-key", getBundle("https://my-vault.vault.azure.net/keys/my-key/my-version")}, "azurekms:name=my-key;vault=my-vault?version=my-version"},
+		{"ok managed hsm", args{"my-hsm", "my-key", getBundle("https://my-hsm.managedhsm.azure.net/keys/my-key/my-version")}, "azurekms:name=my-key;vault=my-hsm?version=my-version"},
 		{"ok default", args{"my-vault", "my-key", getBundle("https://my-vault.foo.net/keys/my-key/my-version")}, "azurekms:name=my-key;vault=my-vault"},
 		{"ok too short", args{"my-vault", "my-key", getBundle("https://my-vault.vault.azure.net/keys/my-version")}, "azurekms:name=my-key;vault=my-vault"},
 		{"ok too long", args{"my-vault", "my-key", getBundle("https://my-vault.vault.azure.net/keys/my-key/my-version/sign")}, "azurekms:name=my-key;vault=my-vault"},
@@ -60,10 +66,13 @@ func Test_parseKeyName(t *testing.T) {
 		{"ok", args{"azurekms:name=my-key;vault=my-vault?version=my-version", noOptions}, "my-vault", "my-key", "my-version", false, false},
 		{"ok opaque version", args{"azurekms:name=my-key;vault=my-vault;version=my-version", noOptions}, "my-vault", "my-key", "my-version", false, false},
 		{"ok no version", args{"azurekms:name=my-key;vault=my-vault", noOptions}, "my-vault", "my-key", "", false, false},
+		{"ok latest version", args{"azurekms:name=my-key;vault=my-vault?version=latest", noOptions}, "my-vault", "my-key", "", false, false},
+		{"ok primary version", args{"azurekms:name=my-key;vault=my-vault;version=primary", noOptions}, "my-vault", "my-key", "", false, false},
 		{"ok hsm", args{"azurekms:name=my-key;vault=my-vault?hsm=true", noOptions}, "my-vault", "my-key", "", true, false},
 		{"ok hsm false", args{"azurekms:name=my-key;vault=my-vault?hsm=false", noOptions}, "my-vault", "my-key", "", false, false},
 		{"ok default vault", args{"azurekms:name=my-key?version=my-version", DefaultOptions{Vault: "my-vault"}}, "my-vault", "my-key", "my-version", false, false},
 		{"ok default hsm", args{"azurekms:name=my-key;vault=my-vault?version=my-version", DefaultOptions{Vault: "other-vault", ProtectionLevel: apiv1.HSM}}, "my-vault", "my-key", "my-version", true, false},
+		{"ok managed hsm", args{"azurekms:name=my-key?hsm=false", DefaultOptions{Vault: "my-hsm", ManagedHSM: true}}, "my-hsm", "my-key", "", true, false},
 		{"fail scheme", args{"azure:name=my-key;vault=my-vault", noOptions}, "", "", "", false, true},
 		{"fail parse uri", args{"azurekms:name=%ZZ;vault=my-vault", noOptions}, "", "", "", false, true},
 		{"fail no name", args{"azurekms:vault=my-vault", noOptions}, "", "", "", false, true},
@@ -94,3 +103,68 @@ func Test_parseKeyName(t *testing.T) {
 		})
 	}
 }
+
+func Test_vaultBaseURL(t *testing.T) {
+	type args struct {
+		vault    string
+		defaults DefaultOptions
+	}
+	tests := []struct {
+		name string
+		args args
+		want string
+	}{
+		{"ok", args{"my-vault", DefaultOptions{}}, "https://my-vault.vault.azure.net/"},
+		{"ok hsm", args{"my-vault", DefaultOptions{ProtectionLevel: apiv1.HSM}}, "https://my-vault.vault.azure.net/"},
+		{"ok managed hsm", args{"my-hsm", DefaultOptions{ManagedHSM: true}}, "https://my-hsm.managedhsm.azure.net/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := vaultBaseURL(tt.args.vault, tt.args.defaults); got != tt.want {
+				t.Errorf("vaultBaseURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_convertError(t *testing.T) {
+	detailedError := func(code interface{}) error {
+		return autorest.DetailedError{
+			Original:   errors.New("the original error"),
+			StatusCode: code,
+			Message:    "Failure responding to request",
+		}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"not found", detailedError(http.StatusNotFound), apiv1.ErrNotFound},
+		{"permission denied", detailedError(http.StatusForbidden), apiv1.ErrPermissionDenied},
+		{"permission denied unauthorized", fmt.Errorf("an error: %w", detailedError(http.StatusUnauthorized)), apiv1.ErrPermissionDenied},
+		{"rate limited", detailedError(http.StatusTooManyRequests), apiv1.ErrRateLimited},
+		{"other status", detailedError(http.StatusInternalServerError), nil},
+		{"other", errors.New("an error"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := convertError(tt.err)
+			if tt.want == nil {
+				if !reflect.DeepEqual(err, tt.err) {
+					t.Errorf("convertError() = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("convertError() = %#v, want %T", err, tt.want)
+			}
+			if err.Error() != tt.err.Error() {
+				t.Errorf("convertError().Error() = %s, want %s", err.Error(), tt.err.Error())
+			}
+		})
+	}
+	if err := convertError(nil); err != nil {
+		t.Errorf("convertError() = %v, want nil", err)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"golang.org/x/sys/windows"
 	"io"
 	"math/big"
+	"net/url"
 	"reflect"
 	"strings"
 	"unsafe"
@@ -42,6 +43,16 @@ const (
 	IssuerNameArg    = "issuer"
 )
 
+// keySchema defines the attributes allowed in the key and certificate URIs.
+var keySchema = &uri.Schema{
+	Scheme: Scheme,
+	Path: []string{
+		ProviderNameArg, ContainerNameArg, StoreLocationArg, StoreNameArg,
+		HashArg, KeyIDArg, IssuerNameArg, SerialNumberArg,
+	},
+	Query: []string{"pin-value", "pin-source"},
+}
+
 var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]string{
 	apiv1.UnspecifiedSignAlgorithm: ALG_ECDSA_P256,
 	apiv1.SHA256WithRSA:            ALG_RSA,
@@ -272,6 +283,7 @@ func New(ctx context.Context, opts apiv1.Options) (*CAPIKMS, error) {
 }
 
 func init() {
+	uri.RegisterSchema(keySchema)
 	apiv1.Register(apiv1.CAPIKMS, func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
 		return New(ctx, opts)
 	})
@@ -285,6 +297,12 @@ func (k *CAPIKMS) Close() error {
 	return nil
 }
 
+// ValidateName validates that the given string is a valid key or certificate
+// URI.
+func (k *CAPIKMS) ValidateName(s string) error {
+	return keySchema.Validate(s)
+}
+
 // CreateSigner returns a nce crypto.Signer that will sign using the key passed in via the URI.
 func (k *CAPIKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
 	u, err := uri.ParseWithScheme(Scheme, req.SigningKey)
@@ -435,7 +453,10 @@ func (k *CAPIKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespon
 		return nil, fmt.Errorf("unable to retrieve public key: %w", err)
 	}
 
-	createdKeyURI := fmt.Sprintf("%s:%s=%s;%s=%s", Scheme, ProviderNameArg, k.providerName, ContainerNameArg, uc)
+	createdKeyURI := keySchema.String(url.Values{
+		ProviderNameArg:  []string{k.providerName},
+		ContainerNameArg: []string{uc},
+	})
 
 	return &apiv1.CreateKeyResponse{
 		Name:      createdKeyURI,
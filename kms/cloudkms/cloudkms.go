@@ -5,6 +5,7 @@ import (
 	"crypto"
 	"crypto/x509"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,6 +18,7 @@ import (
 	"go.step.sm/crypto/kms/apiv1"
 	"go.step.sm/crypto/kms/uri"
 	"go.step.sm/crypto/pemutil"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
 )
@@ -24,13 +26,32 @@ import (
 // Scheme is the scheme used in uris.
 const Scheme = "cloudkms"
 
+// keySchema defines the attributes allowed in the key URIs, like
+// cloudkms:name=projects/p/locations/l/keyRings/k/cryptoKeys/c?version=1.
+var keySchema = &uri.Schema{
+	Scheme: Scheme,
+	Path:   []string{"name"},
+	Query:  []string{"version"},
+}
+
 const pendingGenerationRetries = 10
 
+const (
+	// LatestVersion is the version used to address the latest enabled version
+	// of a crypto key.
+	LatestVersion = "latest"
+	// PrimaryVersion is the version used to address the primary version of a
+	// crypto key. Only symmetric crypto keys have a primary version.
+	PrimaryVersion = "primary"
+)
+
 // protectionLevelMapping maps step protection levels with cloud kms ones.
 var protectionLevelMapping = map[apiv1.ProtectionLevel]kmspb.ProtectionLevel{
 	apiv1.UnspecifiedProtectionLevel: kmspb.ProtectionLevel_PROTECTION_LEVEL_UNSPECIFIED,
 	apiv1.Software:                   kmspb.ProtectionLevel_SOFTWARE,
 	apiv1.HSM:                        kmspb.ProtectionLevel_HSM,
+	apiv1.External:                   kmspb.ProtectionLevel_EXTERNAL,
+	apiv1.ExternalVPC:                kmspb.ProtectionLevel_EXTERNAL_VPC,
 }
 
 // signatureAlgorithmMapping is a mapping between the step signature algorithm,
@@ -64,6 +85,40 @@ var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]interface{}{
 	apiv1.ECDSAWithSHA384: kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384,
 }
 
+// decryptionAlgorithmMapping is a mapping between the step signature algorithm,
+// and bits for RSA keys, with the cloud kms algorithm used to create decryption
+// keys. The signature algorithm only defines the hash used in RSA-OAEP.
+var decryptionAlgorithmMapping = map[apiv1.SignatureAlgorithm]map[int]kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm{
+	apiv1.UnspecifiedSignAlgorithm: {
+		0:    kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256,
+		2048: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256,
+		3072: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256,
+		4096: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA256,
+	},
+	apiv1.SHA256WithRSA: {
+		0:    kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256,
+		2048: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256,
+		3072: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256,
+		4096: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA256,
+	},
+	apiv1.SHA512WithRSA: {
+		0:    kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA512,
+		4096: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA512,
+	},
+}
+
+// decryptionHashMapping maps cloud kms decryption algorithms with the hash used
+// in RSA-OAEP.
+var decryptionHashMapping = map[kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm]crypto.Hash{
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA256: crypto.SHA256,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256: crypto.SHA256,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA256: crypto.SHA256,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA512: crypto.SHA512,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_2048_SHA1:   crypto.SHA1,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA1:   crypto.SHA1,
+	kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA1:   crypto.SHA1,
+}
+
 var cryptoKeyVersionMapping = map[kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm]x509.SignatureAlgorithm{
 	kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:        x509.ECDSAWithSHA256,
 	kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:        x509.ECDSAWithSHA384,
@@ -77,16 +132,38 @@ var cryptoKeyVersionMapping = map[kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorit
 	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA512:   x509.SHA512WithRSAPSS,
 }
 
+// keyAlgorithmMapping maps cloud kms algorithms with the step signature
+// algorithms.
+var keyAlgorithmMapping = map[kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm]apiv1.SignatureAlgorithm{
+	kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256:        apiv1.ECDSAWithSHA256,
+	kmspb.CryptoKeyVersion_EC_SIGN_P384_SHA384:        apiv1.ECDSAWithSHA384,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_2048_SHA256: apiv1.SHA256WithRSA,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256: apiv1.SHA256WithRSA,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA256: apiv1.SHA256WithRSA,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_4096_SHA512: apiv1.SHA512WithRSA,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_2048_SHA256:   apiv1.SHA256WithRSAPSS,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256:   apiv1.SHA256WithRSAPSS,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA256:   apiv1.SHA256WithRSAPSS,
+	kmspb.CryptoKeyVersion_RSA_SIGN_PSS_4096_SHA512:   apiv1.SHA512WithRSAPSS,
+}
+
 // KeyManagementClient defines the methods on KeyManagementClient that this
 // package will use. This interface will be used for unit testing.
 type KeyManagementClient interface {
 	Close() error
 	GetPublicKey(context.Context, *kmspb.GetPublicKeyRequest, ...gax.CallOption) (*kmspb.PublicKey, error)
 	AsymmetricSign(context.Context, *kmspb.AsymmetricSignRequest, ...gax.CallOption) (*kmspb.AsymmetricSignResponse, error)
+	AsymmetricDecrypt(context.Context, *kmspb.AsymmetricDecryptRequest, ...gax.CallOption) (*kmspb.AsymmetricDecryptResponse, error)
 	CreateCryptoKey(context.Context, *kmspb.CreateCryptoKeyRequest, ...gax.CallOption) (*kmspb.CryptoKey, error)
 	GetKeyRing(context.Context, *kmspb.GetKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
 	CreateKeyRing(context.Context, *kmspb.CreateKeyRingRequest, ...gax.CallOption) (*kmspb.KeyRing, error)
 	CreateCryptoKeyVersion(ctx context.Context, req *kmspb.CreateCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	ListCryptoKeys(ctx context.Context, req *kmspb.ListCryptoKeysRequest, opts ...gax.CallOption) *cloudkms.CryptoKeyIterator
+	DestroyCryptoKeyVersion(ctx context.Context, req *kmspb.DestroyCryptoKeyVersionRequest, opts ...gax.CallOption) (*kmspb.CryptoKeyVersion, error)
+	GetCryptoKey(ctx context.Context, req *kmspb.GetCryptoKeyRequest, opts ...gax.CallOption) (*kmspb.CryptoKey, error)
+	ListCryptoKeyVersions(ctx context.Context, req *kmspb.ListCryptoKeyVersionsRequest, opts ...gax.CallOption) *cloudkms.CryptoKeyVersionIterator
+	Encrypt(ctx context.Context, req *kmspb.EncryptRequest, opts ...gax.CallOption) (*kmspb.EncryptResponse, error)
+	Decrypt(ctx context.Context, req *kmspb.DecryptRequest, opts ...gax.CallOption) (*kmspb.DecryptResponse, error)
 }
 
 var newKeyManagementClient = func(ctx context.Context, opts ...option.ClientOption) (KeyManagementClient, error) {
@@ -128,6 +205,7 @@ func New(ctx context.Context, opts apiv1.Options) (*CloudKMS, error) {
 }
 
 func init() {
+	uri.RegisterSchema(keySchema)
 	apiv1.Register(apiv1.CloudKMS, func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
 		return New(ctx, opts)
 	})
@@ -148,16 +226,43 @@ func (k *CloudKMS) Close() error {
 	return nil
 }
 
+// ValidateName validates that the given string is a valid key name or URI.
+func (k *CloudKMS) ValidateName(s string) error {
+	if uri.HasScheme(Scheme, s) {
+		if err := keySchema.Validate(s); err != nil {
+			return err
+		}
+	}
+	key, _, err := parseKeyName(s)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return errors.New("key name cannot be empty")
+	}
+	return nil
+}
+
 // CreateSigner returns a new cloudkms signer configured with the given signing
 // key name.
 func (k *CloudKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
 	if req.SigningKey == "" {
 		return nil, errors.New("signing key cannot be empty")
 	}
-	return NewSigner(k.client, req.SigningKey)
+	name, err := k.getKeyVersionName(req.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(k.client, name)
 }
 
-// CreateKey creates in Google's Cloud KMS a new asymmetric key for signing.
+// CreateKey creates in Google's Cloud KMS a new asymmetric key for signing, or
+// for decryption if the request sets Decrypt.
+//
+// With the External and ExternalVPC protection levels the key material lives in
+// an external key manager, and the request must set the ExternalKey, and the
+// EKMConnection with ExternalVPC. The crypto key is created without versions
+// and a version pointing to the external key is added to it.
 func (k *CloudKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
 	if req.Name == "" {
 		return nil, errors.New("createKeyRequest 'name' cannot be empty")
@@ -168,20 +273,40 @@ func (k *CloudKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 		return nil, errors.Errorf("cloudKMS does not support protection level '%s'", req.ProtectionLevel)
 	}
 
-	var signatureAlgorithm kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm
-	v, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
-	if !ok {
-		return nil, errors.Errorf("cloudKMS does not support signature algorithm '%s'", req.SignatureAlgorithm)
+	isExternal := protectionLevel == kmspb.ProtectionLevel_EXTERNAL || protectionLevel == kmspb.ProtectionLevel_EXTERNAL_VPC
+	switch {
+	case isExternal && req.ExternalKey == "":
+		return nil, errors.Errorf("createKeyRequest 'externalKey' cannot be empty with protection level '%s'", req.ProtectionLevel)
+	case !isExternal && req.ExternalKey != "":
+		return nil, errors.Errorf("createKeyRequest 'externalKey' cannot be used with protection level '%s'", req.ProtectionLevel)
+	case protectionLevel == kmspb.ProtectionLevel_EXTERNAL_VPC && req.EKMConnection == "":
+		return nil, errors.Errorf("createKeyRequest 'ekmConnection' cannot be empty with protection level '%s'", req.ProtectionLevel)
+	case protectionLevel != kmspb.ProtectionLevel_EXTERNAL_VPC && req.EKMConnection != "":
+		return nil, errors.Errorf("createKeyRequest 'ekmConnection' cannot be used with protection level '%s'", req.ProtectionLevel)
 	}
-	switch v := v.(type) {
-	case kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm:
-		signatureAlgorithm = v
-	case map[int]kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm:
-		if signatureAlgorithm, ok = v[req.Bits]; !ok {
-			return nil, errors.Errorf("cloudKMS does not support signature algorithm '%s' with '%d' bits", req.SignatureAlgorithm, req.Bits)
+
+	purpose := kmspb.CryptoKey_ASYMMETRIC_SIGN
+	signatureAlgorithm, err := getSignatureAlgorithm(req.SignatureAlgorithm, req.Bits)
+	if req.Decrypt {
+		purpose = kmspb.CryptoKey_ASYMMETRIC_DECRYPT
+		signatureAlgorithm, err = getDecryptionAlgorithm(req.SignatureAlgorithm, req.Bits)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The version created with a new crypto key, or added to an existing one.
+	version := &kmspb.CryptoKeyVersion{
+		State: kmspb.CryptoKeyVersion_ENABLED,
+	}
+	if isExternal {
+		opts := &kmspb.ExternalProtectionLevelOptions{}
+		if protectionLevel == kmspb.ProtectionLevel_EXTERNAL_VPC {
+			opts.EkmConnectionKeyPath = req.ExternalKey
+		} else {
+			opts.ExternalKeyUri = req.ExternalKey
 		}
-	default:
-		return nil, errors.Errorf("unexpected error: this should not happen")
+		version.ExternalProtectionLevelOptions = opts
 	}
 
 	var crytoKeyName string
@@ -201,33 +326,34 @@ func (k *CloudKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 		Parent:      keyRing,
 		CryptoKeyId: keyID,
 		CryptoKey: &kmspb.CryptoKey{
-			Purpose: kmspb.CryptoKey_ASYMMETRIC_SIGN,
+			Purpose: purpose,
 			VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
 				ProtectionLevel: protectionLevel,
 				Algorithm:       signatureAlgorithm,
 			},
+			CryptoKeyBackend: req.EKMConnection,
 		},
+		SkipInitialVersionCreation: isExternal,
 	})
-	if err != nil {
-		if status.Code(err) != codes.AlreadyExists {
-			return nil, errors.Wrap(err, "cloudKMS CreateCryptoKey failed")
-		}
-		// Create a new version if the key already exists.
+	switch {
+	case err != nil && status.Code(err) != codes.AlreadyExists:
+		return nil, errors.Wrap(convertError(err), "cloudKMS CreateCryptoKey failed")
+	case err != nil || isExternal:
+		// Create a new version if the key already exists, or the first one for
+		// external keys.
 		//
 		// Note that it will have the same purpose, protection level and
 		// algorithm than as previous one.
 		req := &kmspb.CreateCryptoKeyVersionRequest{
-			Parent: req.Name,
-			CryptoKeyVersion: &kmspb.CryptoKeyVersion{
-				State: kmspb.CryptoKeyVersion_ENABLED,
-			},
+			Parent:           req.Name,
+			CryptoKeyVersion: version,
 		}
 		response, err := k.client.CreateCryptoKeyVersion(ctx, req)
 		if err != nil {
-			return nil, errors.Wrap(err, "cloudKMS CreateCryptoKeyVersion failed")
+			return nil, errors.Wrap(convertError(err), "cloudKMS CreateCryptoKeyVersion failed")
 		}
 		crytoKeyName = response.Name
-	} else {
+	default:
 		crytoKeyName = response.Name + "/cryptoKeyVersions/1"
 	}
 
@@ -242,7 +368,7 @@ func (k *CloudKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 		Name: crytoKeyName,
 	})
 	if err != nil {
-		return nil, errors.Wrap(err, "cloudKMS GetPublicKey failed")
+		return nil, errors.Wrap(convertError(err), "cloudKMS GetPublicKey failed")
 	}
 
 	return &apiv1.CreateKeyResponse{
@@ -254,6 +380,236 @@ func (k *CloudKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespo
 	}, nil
 }
 
+// CreateDecrypter returns a new cloudkms decrypter configured with the given
+// decryption key name. The key must be an ASYMMETRIC_DECRYPT key.
+func (k *CloudKMS) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Decrypter, error) {
+	if req.DecryptionKey == "" {
+		return nil, errors.New("decryption key cannot be empty")
+	}
+	name, err := k.getKeyVersionName(req.DecryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewDecrypter(k.client, name)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListKeys returns the crypto keys in the key ring passed in the request name,
+// the key ring must follow the pattern:
+//
+//	projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})/keyRings/([a-zA-Z0-9_-]{1,63})
+//
+// Pagination uses the native cloud kms page tokens, the name filter is matched
+// against the crypto key id, and the labels against the crypto key labels.
+// Because filters are applied after a page is retrieved, a page can have less
+// keys than the requested page size.
+func (k *CloudKMS) ListKeys(req *apiv1.ListKeysRequest) (*apiv1.ListKeysResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("listKeysRequest 'name' cannot be empty")
+	}
+	if req.PageSize < 0 {
+		return nil, errors.New("listKeysRequest 'pageSize' cannot be negative")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	it := k.client.ListCryptoKeys(ctx, &kmspb.ListCryptoKeysRequest{
+		Parent: req.Name,
+	})
+
+	var (
+		cryptoKeys    []*kmspb.CryptoKey
+		nextPageToken string
+		err           error
+	)
+	if req.PageSize > 0 {
+		nextPageToken, err = iterator.NewPager(it, req.PageSize, req.PageToken).NextPage(&cryptoKeys)
+	} else {
+		// Without a page size, all the keys after the page token are returned.
+		it.PageInfo().Token = req.PageToken
+		for {
+			var ck *kmspb.CryptoKey
+			if ck, err = it.Next(); err != nil {
+				break
+			}
+			cryptoKeys = append(cryptoKeys, ck)
+		}
+		if errors.Is(err, iterator.Done) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "cloudKMS ListCryptoKeys failed")
+	}
+
+	keys := []*apiv1.KeyInfo{}
+	for _, ck := range cryptoKeys {
+		_, keyID := parent(ck.Name)
+		if !req.MatchKey(keyID, ck.Labels) {
+			continue
+		}
+		info := &apiv1.KeyInfo{
+			Name:   ck.Name,
+			Labels: ck.Labels,
+		}
+		if tpl := ck.VersionTemplate; tpl != nil {
+			info.SignatureAlgorithm = keyAlgorithmMapping[tpl.Algorithm]
+			switch tpl.ProtectionLevel {
+			case kmspb.ProtectionLevel_SOFTWARE:
+				info.ProtectionLevel = apiv1.Software
+			case kmspb.ProtectionLevel_HSM:
+				info.ProtectionLevel = apiv1.HSM
+			case kmspb.ProtectionLevel_EXTERNAL:
+				info.ProtectionLevel = apiv1.External
+			case kmspb.ProtectionLevel_EXTERNAL_VPC:
+				info.ProtectionLevel = apiv1.ExternalVPC
+			}
+		}
+		keys = append(keys, info)
+	}
+
+	return &apiv1.ListKeysResponse{
+		Keys:          keys,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// RotateKey creates a new version of the crypto key in the request name. The
+// new version will have the same purpose, protection level and algorithm as the
+// previous ones.
+func (k *CloudKMS) RotateKey(req *apiv1.RotateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("rotateKeyRequest 'name' cannot be empty")
+	}
+
+	key, _, err := parseKeyName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	response, err := k.client.CreateCryptoKeyVersion(ctx, &kmspb.CreateCryptoKeyVersionRequest{
+		Parent: key,
+		CryptoKeyVersion: &kmspb.CryptoKeyVersion{
+			State: kmspb.CryptoKeyVersion_ENABLED,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "cloudKMS CreateCryptoKeyVersion failed")
+	}
+
+	// Retrieve public key to add it to the response.
+	pk, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{
+		Name: response.Name,
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "cloudKMS GetPublicKey failed")
+	}
+
+	return &apiv1.CreateKeyResponse{
+		Name:      response.Name,
+		PublicKey: pk,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: response.Name,
+		},
+	}, nil
+}
+
+// DeleteKey schedules the destruction of a crypto key version. The key version
+// will be destroyed after the destroy scheduled duration configured in the
+// crypto key, 24 hours by default. Key names must follow the pattern:
+//
+//	projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})/keyRings/([a-zA-Z0-9_-]{1,63})/cryptoKeys/([a-zA-Z0-9_-]{1,63})/cryptoKeyVersions/([a-zA-Z0-9_-]{1,63})
+func (k *CloudKMS) DeleteKey(req *apiv1.DeleteKeyRequest) error {
+	if req.Name == "" {
+		return errors.New("deleteKeyRequest 'name' cannot be empty")
+	}
+	_, version, err := parseKeyName(req.Name)
+	if err != nil {
+		return err
+	}
+	if version == "" {
+		return errors.Errorf("deleteKeyRequest 'name' %s is not a crypto key version", req.Name)
+	}
+	name, err := k.getKeyVersionName(req.Name)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	if _, err := k.client.DestroyCryptoKeyVersion(ctx, &kmspb.DestroyCryptoKeyVersionRequest{
+		Name: name,
+	}); err != nil {
+		return errors.Wrap(convertError(err), "cloudKMS DestroyCryptoKeyVersion failed")
+	}
+	return nil
+}
+
+// Encrypt encrypts the given plaintext using a symmetric crypto key. If the name
+// does not include a version, or the version is "primary", the primary version
+// of the crypto key will be used.
+func (k *CloudKMS) Encrypt(req *apiv1.EncryptRequest) ([]byte, error) {
+	if req.Name == "" {
+		return nil, errors.New("encryptRequest 'name' cannot be empty")
+	}
+	name, version, err := parseKeyName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	if version != "" && version != PrimaryVersion {
+		if name, err = k.getKeyVersionName(req.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := k.client.Encrypt(ctx, &kmspb.EncryptRequest{
+		Name:                        name,
+		Plaintext:                   req.Plaintext,
+		AdditionalAuthenticatedData: req.AdditionalData,
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "cloudKMS Encrypt failed")
+	}
+	return resp.Ciphertext, nil
+}
+
+// Decrypt decrypts the given ciphertext, as returned by Encrypt, using a
+// symmetric crypto key. The version in the name is ignored, Cloud KMS uses the
+// version that encrypted the data.
+func (k *CloudKMS) Decrypt(req *apiv1.DecryptRequest) ([]byte, error) {
+	if req.Name == "" {
+		return nil, errors.New("decryptRequest 'name' cannot be empty")
+	}
+	name, _, err := parseKeyName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	resp, err := k.client.Decrypt(ctx, &kmspb.DecryptRequest{
+		Name:                        name,
+		Ciphertext:                  req.Ciphertext,
+		AdditionalAuthenticatedData: req.AdditionalData,
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "cloudKMS Decrypt failed")
+	}
+	return resp.Plaintext, nil
+}
+
 func (k *CloudKMS) createKeyRingIfNeeded(name string) error {
 	ctx, cancel := defaultContext()
 	defer cancel()
@@ -271,7 +627,7 @@ func (k *CloudKMS) createKeyRingIfNeeded(name string) error {
 		KeyRingId: child,
 	})
 	if err != nil && status.Code(err) != codes.AlreadyExists {
-		return errors.Wrap(err, "cloudKMS CreateKeyRing failed")
+		return errors.Wrap(convertError(err), "cloudKMS CreateKeyRing failed")
 	}
 
 	return nil
@@ -281,14 +637,22 @@ func (k *CloudKMS) createKeyRingIfNeeded(name string) error {
 // follow the pattern:
 //
 //	projects/([^/]+)/locations/([a-zA-Z0-9_-]{1,63})/keyRings/([a-zA-Z0-9_-]{1,63})/cryptoKeys/([a-zA-Z0-9_-]{1,63})/cryptoKeyVersions/([a-zA-Z0-9_-]{1,63})
+//
+// If the version is not present, the latest enabled version will be used. See
+// parseKeyName for the other supported formats.
 func (k *CloudKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
 	if req.Name == "" {
 		return nil, errors.New("createKeyRequest 'name' cannot be empty")
 	}
 
-	response, err := k.getPublicKeyWithRetries(req.Name, pendingGenerationRetries)
+	name, err := k.getKeyVersionName(req.Name)
 	if err != nil {
-		return nil, errors.Wrap(err, "cloudKMS GetPublicKey failed")
+		return nil, err
+	}
+
+	response, err := k.getPublicKeyWithRetries(name, pendingGenerationRetries)
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "cloudKMS GetPublicKey failed")
 	}
 
 	pk, err := pemutil.ParseKey([]byte(response.Pem))
@@ -330,6 +694,136 @@ func (k *CloudKMS) getPublicKeyWithRetries(name string, retries int) (*kmspb.Pub
 	return nil, ErrTooManyRetries
 }
 
+// getKeyVersionName returns the name of the crypto key version addressed by
+// the given name, resolving the latest and primary versions if necessary.
+func (k *CloudKMS) getKeyVersionName(name string) (string, error) {
+	key, version, err := parseKeyName(name)
+	if err != nil {
+		return "", err
+	}
+
+	switch version {
+	case "", LatestVersion:
+		return k.getLatestVersionName(key)
+	case PrimaryVersion:
+		ctx, cancel := defaultContext()
+		defer cancel()
+
+		ck, err := k.client.GetCryptoKey(ctx, &kmspb.GetCryptoKeyRequest{
+			Name: key,
+		})
+		if err != nil {
+			return "", errors.Wrap(convertError(err), "cloudKMS GetCryptoKey failed")
+		}
+		if ck.Primary == nil {
+			return "", errors.Errorf("cloudKMS crypto key %s does not have a primary version", key)
+		}
+		return ck.Primary.Name, nil
+	default:
+		return key + "/cryptoKeyVersions/" + version, nil
+	}
+}
+
+// getLatestVersionName returns the name of the enabled crypto key version with
+// the highest version number.
+func (k *CloudKMS) getLatestVersionName(key string) (string, error) {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	it := k.client.ListCryptoKeyVersions(ctx, &kmspb.ListCryptoKeyVersionsRequest{
+		Parent: key,
+		Filter: "state=ENABLED",
+	})
+
+	var (
+		latest   string
+		latestID int
+	)
+	for {
+		v, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return "", errors.Wrap(convertError(err), "cloudKMS ListCryptoKeyVersions failed")
+		}
+		if v.State != kmspb.CryptoKeyVersion_ENABLED {
+			continue
+		}
+		_, id := parent(v.Name)
+		if n, err := strconv.Atoi(id); err == nil && n > latestID {
+			latest, latestID = v.Name, n
+		}
+	}
+
+	if latest == "" {
+		return "", errors.Errorf("cloudKMS crypto key %s does not have enabled versions", key)
+	}
+	return latest, nil
+}
+
+// getSignatureAlgorithm returns the cloud kms algorithm used to create signing
+// keys.
+func getSignatureAlgorithm(alg apiv1.SignatureAlgorithm, bits int) (kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm, error) {
+	v, ok := signatureAlgorithmMapping[alg]
+	if !ok {
+		return 0, errors.Errorf("cloudKMS does not support signature algorithm '%s'", alg)
+	}
+	switch v := v.(type) {
+	case kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm:
+		return v, nil
+	case map[int]kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm:
+		if a, ok := v[bits]; ok {
+			return a, nil
+		}
+		return 0, errors.Errorf("cloudKMS does not support signature algorithm '%s' with '%d' bits", alg, bits)
+	default:
+		return 0, errors.Errorf("unexpected error: this should not happen")
+	}
+}
+
+// getDecryptionAlgorithm returns the cloud kms algorithm used to create
+// decryption keys.
+func getDecryptionAlgorithm(alg apiv1.SignatureAlgorithm, bits int) (kmspb.CryptoKeyVersion_CryptoKeyVersionAlgorithm, error) {
+	v, ok := decryptionAlgorithmMapping[alg]
+	if !ok {
+		return 0, errors.Errorf("cloudKMS does not support decryption keys with signature algorithm '%s'", alg)
+	}
+	if a, ok := v[bits]; ok {
+		return a, nil
+	}
+	return 0, errors.Errorf("cloudKMS does not support decryption keys with signature algorithm '%s' and '%d' bits", alg, bits)
+}
+
+// parseKeyName returns the crypto key name and the version from a resource name
+// or URI like:
+//
+//   - projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1
+//   - projects/p/locations/l/keyRings/k/cryptoKeys/c
+//   - cloudkms:name=projects/p/locations/l/keyRings/k/cryptoKeys/c;version=1
+//   - cloudkms:name=projects/p/locations/l/keyRings/k/cryptoKeys/c?version=latest
+//
+// The version can be a version number, "latest" or "primary". An empty version
+// is returned if the name does not include it.
+func parseKeyName(name string) (key, version string, err error) {
+	if strings.HasPrefix(strings.ToLower(name), Scheme+":") {
+		u, err := uri.ParseWithScheme(Scheme, name)
+		if err != nil {
+			return "", "", err
+		}
+		if name = u.Get("name"); name == "" {
+			return "", "", errors.Errorf("key uri %s is not valid: name is missing", u.String())
+		}
+		if version = u.Get("version"); version != "" {
+			return strings.TrimSuffix(name, "/"), version, nil
+		}
+	}
+	if i := strings.Index(name, "/cryptoKeyVersions/"); i > 0 {
+		return name[:i], name[i+len("/cryptoKeyVersions/"):], nil
+	}
+	return name, "", nil
+}
+
 func defaultContext() (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), 15*time.Second)
 }
@@ -353,3 +847,18 @@ func parent(name string) (string, string) {
 		return name[:i], name[i+1:]
 	}
 }
+
+// convertError converts the gRPC errors returned by Google's Cloud KMS to the
+// error types defined in apiv1.
+func convertError(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return apiv1.NotFoundError{Err: err}
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return apiv1.PermissionDeniedError{Err: err}
+	case codes.ResourceExhausted:
+		return apiv1.RateLimitedError{Err: err}
+	default:
+		return err
+	}
+}
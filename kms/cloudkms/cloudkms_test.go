@@ -3,11 +3,13 @@ package cloudkms
 import (
 	"context"
 	"crypto"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
 	"testing"
 
+	cloudkms "cloud.google.com/go/kms/apiv1"
 	gax "github.com/googleapis/gax-go/v2"
 	"go.step.sm/crypto/kms/apiv1"
 	"go.step.sm/crypto/pemutil"
@@ -163,6 +165,30 @@ func TestCloudKMS_Close(t *testing.T) {
 	}
 }
 
+func TestCloudKMS_ValidateName(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{"ok", "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1", false},
+		{"ok uri", "cloudkms:name=projects/p/locations/l/keyRings/k/cryptoKeys/c;version=1", false},
+		{"ok uri query", "cloudkms:name=projects/p/locations/l/keyRings/k/cryptoKeys/c?version=latest", false},
+		{"fail unknown attribute", "cloudkms:name=projects/p/locations/l/keyRings/k/cryptoKeys/c;credentials-file=/tmp/file.json", true},
+		{"fail repeated attribute", "cloudkms:name=projects/p/locations/l/keyRings/k/cryptoKeys/c?name=other", true},
+		{"fail missing name", "cloudkms:version=1", true},
+		{"fail empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{}
+			if err := k.ValidateName(tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.ValidateName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestCloudKMS_CreateSigner(t *testing.T) {
 	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1"
 	pemBytes, err := os.ReadFile("testdata/pub.pem")
@@ -218,6 +244,62 @@ func TestCloudKMS_CreateSigner(t *testing.T) {
 	}
 }
 
+func TestCloudKMS_CreateDecrypter(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1"
+	pemBytes, err := os.ReadFile("testdata/rsapub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := pemutil.ParseKey(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.CreateDecrypterRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    crypto.Decrypter
+		wantErr bool
+	}{
+		{"ok", fields{&MockClient{
+			getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+				return &kmspb.PublicKey{Pem: string(pemBytes), Algorithm: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256}, nil
+			},
+		}}, args{&apiv1.CreateDecrypterRequest{DecryptionKey: keyName}}, &Decrypter{client: &MockClient{}, decryptionKey: keyName, hash: crypto.SHA256, publicKey: pk}, false},
+		{"fail empty", fields{&MockClient{}}, args{&apiv1.CreateDecrypterRequest{DecryptionKey: ""}}, nil, true},
+		{"fail signing key", fields{&MockClient{
+			getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+				return &kmspb.PublicKey{Pem: string(pemBytes), Algorithm: kmspb.CryptoKeyVersion_RSA_SIGN_PKCS1_3072_SHA256}, nil
+			},
+		}}, args{&apiv1.CreateDecrypterRequest{DecryptionKey: keyName}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			got, err := k.CreateDecrypter(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.CreateDecrypter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if decrypter, ok := got.(*Decrypter); ok {
+				decrypter.client = &MockClient{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CloudKMS.CreateDecrypter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestCloudKMS_CreateKey(t *testing.T) {
 	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c"
 	testError := fmt.Errorf("an error")
@@ -232,6 +314,15 @@ func TestCloudKMS_CreateKey(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	rsaPemBytes, err := os.ReadFile("testdata/rsapub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPK, err := pemutil.ParseKey(rsaPemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	var retries int
 	type fields struct {
 		client KeyManagementClient
@@ -312,7 +403,99 @@ func TestCloudKMS_CreateKey(t *testing.T) {
 			}},
 			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.HSM, SignatureAlgorithm: apiv1.ECDSAWithSHA256}},
 			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/1", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/1"}}, false},
+		{"ok decrypt", fields{
+			&MockClient{
+				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return &kmspb.KeyRing{}, nil
+				},
+				createCryptoKey: func(_ context.Context, req *kmspb.CreateCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+					if req.CryptoKey.Purpose != kmspb.CryptoKey_ASYMMETRIC_DECRYPT {
+						return nil, fmt.Errorf("unexpected purpose %s", req.CryptoKey.Purpose)
+					}
+					if alg := req.CryptoKey.VersionTemplate.Algorithm; alg != kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256 {
+						return nil, fmt.Errorf("unexpected algorithm %s", alg)
+					}
+					return &kmspb.CryptoKey{Name: keyName}, nil
+				},
+				getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+					return &kmspb.PublicKey{Pem: string(rsaPemBytes)}, nil
+				},
+			}},
+			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.Software, Decrypt: true}},
+			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/1", PublicKey: rsaPK, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/1"}}, false},
+		{"ok external", fields{
+			&MockClient{
+				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return &kmspb.KeyRing{}, nil
+				},
+				createCryptoKey: func(_ context.Context, req *kmspb.CreateCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+					if !req.SkipInitialVersionCreation {
+						return nil, fmt.Errorf("unexpected initial version creation")
+					}
+					if pl := req.CryptoKey.VersionTemplate.ProtectionLevel; pl != kmspb.ProtectionLevel_EXTERNAL {
+						return nil, fmt.Errorf("unexpected protection level %s", pl)
+					}
+					return &kmspb.CryptoKey{Name: keyName}, nil
+				},
+				createCryptoKeyVersion: func(_ context.Context, req *kmspb.CreateCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+					if uri := req.CryptoKeyVersion.GetExternalProtectionLevelOptions().GetExternalKeyUri(); uri != "https://ekm.example.com/v0/keys/root" {
+						return nil, fmt.Errorf("unexpected external key uri %s", uri)
+					}
+					return &kmspb.CryptoKeyVersion{Name: keyName + "/cryptoKeyVersions/1"}, nil
+				},
+				getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+					return &kmspb.PublicKey{Pem: string(pemBytes)}, nil
+				},
+			}},
+			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.External, SignatureAlgorithm: apiv1.ECDSAWithSHA256, ExternalKey: "https://ekm.example.com/v0/keys/root"}},
+			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/1", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/1"}}, false},
+		{"ok external vpc", fields{
+			&MockClient{
+				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return &kmspb.KeyRing{}, nil
+				},
+				createCryptoKey: func(_ context.Context, req *kmspb.CreateCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+					if pl := req.CryptoKey.VersionTemplate.ProtectionLevel; pl != kmspb.ProtectionLevel_EXTERNAL_VPC {
+						return nil, fmt.Errorf("unexpected protection level %s", pl)
+					}
+					if backend := req.CryptoKey.CryptoKeyBackend; backend != "projects/p/locations/l/ekmConnections/e" {
+						return nil, fmt.Errorf("unexpected crypto key backend %s", backend)
+					}
+					return &kmspb.CryptoKey{Name: keyName}, nil
+				},
+				createCryptoKeyVersion: func(_ context.Context, req *kmspb.CreateCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+					if path := req.CryptoKeyVersion.GetExternalProtectionLevelOptions().GetEkmConnectionKeyPath(); path != "v0/keys/root" {
+						return nil, fmt.Errorf("unexpected ekm connection key path %s", path)
+					}
+					return &kmspb.CryptoKeyVersion{Name: keyName + "/cryptoKeyVersions/1"}, nil
+				},
+				getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+					return &kmspb.PublicKey{Pem: string(pemBytes)}, nil
+				},
+			}},
+			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.ExternalVPC, SignatureAlgorithm: apiv1.ECDSAWithSHA256, EKMConnection: "projects/p/locations/l/ekmConnections/e", ExternalKey: "v0/keys/root"}},
+			&apiv1.CreateKeyResponse{Name: keyName + "/cryptoKeyVersions/1", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/1"}}, false},
 		{"fail name", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{}}, nil, true},
+		{"fail external key", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.External, SignatureAlgorithm: apiv1.ECDSAWithSHA256}}, nil, true},
+		{"fail external key not external", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.HSM, SignatureAlgorithm: apiv1.ECDSAWithSHA256, ExternalKey: "v0/keys/root"}}, nil, true},
+		{"fail ekm connection", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.ExternalVPC, SignatureAlgorithm: apiv1.ECDSAWithSHA256, ExternalKey: "v0/keys/root"}}, nil, true},
+		{"fail ekm connection not external vpc", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.External, SignatureAlgorithm: apiv1.ECDSAWithSHA256, EKMConnection: "projects/p/locations/l/ekmConnections/e", ExternalKey: "https://ekm.example.com/v0/keys/root"}}, nil, true},
+		{"fail decrypt signature algorithm", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.Software, SignatureAlgorithm: apiv1.ECDSAWithSHA256, Decrypt: true}}, nil, true},
+		{"fail decrypt number of bits", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.Software, SignatureAlgorithm: apiv1.SHA512WithRSA, Bits: 2048, Decrypt: true}}, nil, true},
+		{"fail create external key version", fields{
+			&MockClient{
+				getKeyRing: func(_ context.Context, _ *kmspb.GetKeyRingRequest, _ ...gax.CallOption) (*kmspb.KeyRing, error) {
+					return &kmspb.KeyRing{}, nil
+				},
+				createCryptoKey: func(_ context.Context, _ *kmspb.CreateCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+					return &kmspb.CryptoKey{Name: keyName}, nil
+				},
+				createCryptoKeyVersion: func(_ context.Context, _ *kmspb.CreateCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+					return nil, testError
+				},
+			}},
+			args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.External, SignatureAlgorithm: apiv1.ECDSAWithSHA256, ExternalKey: "https://ekm.example.com/v0/keys/root"}},
+			nil, true},
 		{"fail protection level", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.ProtectionLevel(100)}}, nil, true},
 		{"fail signature algorithm", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.Software, SignatureAlgorithm: apiv1.SignatureAlgorithm(100)}}, nil, true},
 		{"fail number of bits", fields{&MockClient{}}, args{&apiv1.CreateKeyRequest{Name: keyName, ProtectionLevel: apiv1.Software, SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 1024}},
@@ -462,3 +645,479 @@ func TestCloudKMS_GetPublicKey(t *testing.T) {
 		})
 	}
 }
+
+func TestCloudKMS_ListKeys(t *testing.T) {
+	keyRing := "projects/p/locations/l/keyRings/k"
+	cryptoKeys := []*kmspb.CryptoKey{
+		{Name: keyRing + "/cryptoKeys/root", Labels: map[string]string{"env": "prod"}, VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+			ProtectionLevel: kmspb.ProtectionLevel_HSM,
+			Algorithm:       kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+		}},
+		{Name: keyRing + "/cryptoKeys/intermediate", VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+			ProtectionLevel: kmspb.ProtectionLevel_SOFTWARE,
+			Algorithm:       kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256,
+		}},
+		{Name: keyRing + "/cryptoKeys/symmetric"},
+		{Name: keyRing + "/cryptoKeys/external", VersionTemplate: &kmspb.CryptoKeyVersionTemplate{
+			ProtectionLevel: kmspb.ProtectionLevel_EXTERNAL_VPC,
+			Algorithm:       kmspb.CryptoKeyVersion_EC_SIGN_P256_SHA256,
+		}},
+	}
+	rootKey := &apiv1.KeyInfo{
+		Name:               keyRing + "/cryptoKeys/root",
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+		ProtectionLevel:    apiv1.HSM,
+		Labels:             map[string]string{"env": "prod"},
+	}
+	intermediateKey := &apiv1.KeyInfo{
+		Name:               keyRing + "/cryptoKeys/intermediate",
+		SignatureAlgorithm: apiv1.SHA256WithRSAPSS,
+		ProtectionLevel:    apiv1.Software,
+	}
+	symmetricKey := &apiv1.KeyInfo{
+		Name: keyRing + "/cryptoKeys/symmetric",
+	}
+	externalKey := &apiv1.KeyInfo{
+		Name:               keyRing + "/cryptoKeys/external",
+		SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+		ProtectionLevel:    apiv1.ExternalVPC,
+	}
+
+	okClient := &MockClient{
+		listCryptoKeys: newListClient(t, &listServer{
+			listCryptoKeys: func(req *kmspb.ListCryptoKeysRequest) (*kmspb.ListCryptoKeysResponse, error) {
+				if req.Parent != keyRing {
+					return nil, fmt.Errorf("unexpected parent %s", req.Parent)
+				}
+				switch {
+				case req.PageSize == 1 && req.PageToken == "":
+					return &kmspb.ListCryptoKeysResponse{CryptoKeys: cryptoKeys[:1], NextPageToken: "token-1"}, nil
+				case req.PageSize == 0 && req.PageToken == "":
+					return &kmspb.ListCryptoKeysResponse{CryptoKeys: cryptoKeys[:2], NextPageToken: "token-2"}, nil
+				case req.PageToken == "token-1":
+					return &kmspb.ListCryptoKeysResponse{CryptoKeys: cryptoKeys[1:]}, nil
+				case req.PageToken == "token-2":
+					return &kmspb.ListCryptoKeysResponse{CryptoKeys: cryptoKeys[2:]}, nil
+				default:
+					return nil, fmt.Errorf("unexpected page %d %s", req.PageSize, req.PageToken)
+				}
+			},
+		}).ListCryptoKeys,
+	}
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.ListKeysRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *apiv1.ListKeysResponse
+		wantErr bool
+	}{
+		{"ok", fields{okClient}, args{&apiv1.ListKeysRequest{Name: keyRing}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{rootKey, intermediateKey, symmetricKey, externalKey},
+		}, false},
+		{"ok filter", fields{okClient}, args{&apiv1.ListKeysRequest{Name: keyRing, NameFilter: "inter*"}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{intermediateKey},
+		}, false},
+		{"ok labels", fields{okClient}, args{&apiv1.ListKeysRequest{Name: keyRing, Labels: map[string]string{"env": "prod"}}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{rootKey},
+		}, false},
+		{"ok page", fields{okClient}, args{&apiv1.ListKeysRequest{Name: keyRing, PageSize: 1}}, &apiv1.ListKeysResponse{
+			Keys:          []*apiv1.KeyInfo{rootKey},
+			NextPageToken: "token-1",
+		}, false},
+		{"ok page token", fields{okClient}, args{&apiv1.ListKeysRequest{Name: keyRing, PageToken: "token-1"}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{intermediateKey, symmetricKey, externalKey},
+		}, false},
+		{"fail name", fields{okClient}, args{&apiv1.ListKeysRequest{}}, nil, true},
+		{"fail page size", fields{okClient}, args{&apiv1.ListKeysRequest{Name: keyRing, PageSize: -1}}, nil, true},
+		{"fail list crypto keys", fields{okClient}, args{&apiv1.ListKeysRequest{Name: keyRing, PageToken: "foo"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			got, err := k.ListKeys(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.ListKeys() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CloudKMS.ListKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudKMS_DeleteKey(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1"
+	type fields struct {
+		client KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.DeleteKeyRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{"ok", fields{&MockClient{
+			destroyCryptoKeyVersion: func(_ context.Context, req *kmspb.DestroyCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+				if req.Name != keyName {
+					return nil, fmt.Errorf("unexpected name %s", req.Name)
+				}
+				return &kmspb.CryptoKeyVersion{Name: req.Name, State: kmspb.CryptoKeyVersion_DESTROY_SCHEDULED}, nil
+			},
+		}}, args{&apiv1.DeleteKeyRequest{Name: keyName}}, false},
+		{"fail name", fields{&MockClient{}}, args{&apiv1.DeleteKeyRequest{}}, true},
+		{"fail version", fields{&MockClient{}}, args{&apiv1.DeleteKeyRequest{
+			Name: "projects/p/locations/l/keyRings/k/cryptoKeys/c",
+		}}, true},
+		{"fail destroy", fields{&MockClient{
+			destroyCryptoKeyVersion: func(_ context.Context, _ *kmspb.DestroyCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.DeleteKeyRequest{Name: keyName}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			if err := k.DeleteKey(tt.args.req); (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.DeleteKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCloudKMS_RotateKey(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c"
+	pemBytes, err := os.ReadFile("testdata/pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := pemutil.ParseKey(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	okClient := &MockClient{
+		createCryptoKeyVersion: func(_ context.Context, req *kmspb.CreateCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+			if req.Parent != keyName {
+				return nil, fmt.Errorf("unexpected parent %s", req.Parent)
+			}
+			return &kmspb.CryptoKeyVersion{Name: keyName + "/cryptoKeyVersions/3"}, nil
+		},
+		getPublicKey: func(_ context.Context, req *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+			if req.Name != keyName+"/cryptoKeyVersions/3" {
+				return nil, fmt.Errorf("unexpected name %s", req.Name)
+			}
+			return &kmspb.PublicKey{Pem: string(pemBytes)}, nil
+		},
+	}
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.RotateKeyRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *apiv1.CreateKeyResponse
+		wantErr bool
+	}{
+		{"ok", fields{okClient}, args{&apiv1.RotateKeyRequest{Name: keyName}}, &apiv1.CreateKeyResponse{
+			Name: keyName + "/cryptoKeyVersions/3", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/3"},
+		}, false},
+		{"ok with version", fields{okClient}, args{&apiv1.RotateKeyRequest{Name: keyName + "/cryptoKeyVersions/2"}}, &apiv1.CreateKeyResponse{
+			Name: keyName + "/cryptoKeyVersions/3", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/3"},
+		}, false},
+		{"ok uri", fields{okClient}, args{&apiv1.RotateKeyRequest{Name: "cloudkms:name=" + keyName + "?version=latest"}}, &apiv1.CreateKeyResponse{
+			Name: keyName + "/cryptoKeyVersions/3", PublicKey: pk, CreateSignerRequest: apiv1.CreateSignerRequest{SigningKey: keyName + "/cryptoKeyVersions/3"},
+		}, false},
+		{"fail name", fields{okClient}, args{&apiv1.RotateKeyRequest{}}, nil, true},
+		{"fail uri", fields{okClient}, args{&apiv1.RotateKeyRequest{Name: "cloudkms:version=1"}}, nil, true},
+		{"fail createCryptoKeyVersion", fields{&MockClient{
+			createCryptoKeyVersion: func(_ context.Context, _ *kmspb.CreateCryptoKeyVersionRequest, _ ...gax.CallOption) (*kmspb.CryptoKeyVersion, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.RotateKeyRequest{Name: keyName}}, nil, true},
+		{"fail getPublicKey", fields{&MockClient{
+			createCryptoKeyVersion: okClient.createCryptoKeyVersion,
+			getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.RotateKeyRequest{Name: keyName}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			got, err := k.RotateKey(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.RotateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CloudKMS.RotateKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudKMS_Encrypt(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c"
+	encrypt := func(name string) func(context.Context, *kmspb.EncryptRequest, ...gax.CallOption) (*kmspb.EncryptResponse, error) {
+		return func(_ context.Context, req *kmspb.EncryptRequest, _ ...gax.CallOption) (*kmspb.EncryptResponse, error) {
+			if req.Name != name {
+				return nil, fmt.Errorf("unexpected name %s", req.Name)
+			}
+			if string(req.AdditionalAuthenticatedData) != "aad" {
+				return nil, fmt.Errorf("unexpected additional authenticated data %s", req.AdditionalAuthenticatedData)
+			}
+			return &kmspb.EncryptResponse{Name: name, Ciphertext: []byte("ciphertext")}, nil
+		}
+	}
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.EncryptRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", fields{&MockClient{
+			encrypt: encrypt(keyName),
+		}}, args{&apiv1.EncryptRequest{Name: keyName, Plaintext: []byte("plaintext"), AdditionalData: []byte("aad")}}, []byte("ciphertext"), false},
+		{"ok primary", fields{&MockClient{
+			encrypt: encrypt(keyName),
+		}}, args{&apiv1.EncryptRequest{Name: "cloudkms:name=" + keyName + ";version=primary", Plaintext: []byte("plaintext"), AdditionalData: []byte("aad")}}, []byte("ciphertext"), false},
+		{"ok version", fields{&MockClient{
+			encrypt: encrypt(keyName + "/cryptoKeyVersions/2"),
+		}}, args{&apiv1.EncryptRequest{Name: keyName + "/cryptoKeyVersions/2", Plaintext: []byte("plaintext"), AdditionalData: []byte("aad")}}, []byte("ciphertext"), false},
+		{"fail name", fields{&MockClient{}}, args{&apiv1.EncryptRequest{Plaintext: []byte("plaintext")}}, nil, true},
+		{"fail uri", fields{&MockClient{}}, args{&apiv1.EncryptRequest{Name: "cloudkms:version=1", Plaintext: []byte("plaintext")}}, nil, true},
+		{"fail latest", fields{&MockClient{
+			listCryptoKeyVersions: newListClient(t, &listServer{
+				listCryptoKeyVersions: func(_ *kmspb.ListCryptoKeyVersionsRequest) (*kmspb.ListCryptoKeyVersionsResponse, error) {
+					return nil, fmt.Errorf("an error")
+				},
+			}).ListCryptoKeyVersions,
+		}}, args{&apiv1.EncryptRequest{Name: keyName + "/cryptoKeyVersions/latest", Plaintext: []byte("plaintext")}}, nil, true},
+		{"fail encrypt", fields{&MockClient{
+			encrypt: func(_ context.Context, _ *kmspb.EncryptRequest, _ ...gax.CallOption) (*kmspb.EncryptResponse, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{&apiv1.EncryptRequest{Name: keyName, Plaintext: []byte("plaintext")}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			got, err := k.Encrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.Encrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CloudKMS.Encrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudKMS_Decrypt(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c"
+	okClient := &MockClient{
+		decrypt: func(_ context.Context, req *kmspb.DecryptRequest, _ ...gax.CallOption) (*kmspb.DecryptResponse, error) {
+			if req.Name != keyName {
+				return nil, fmt.Errorf("unexpected name %s", req.Name)
+			}
+			if string(req.AdditionalAuthenticatedData) != "aad" {
+				return nil, fmt.Errorf("unexpected additional authenticated data %s", req.AdditionalAuthenticatedData)
+			}
+			return &kmspb.DecryptResponse{Plaintext: []byte("plaintext")}, nil
+		},
+	}
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	type args struct {
+		req *apiv1.DecryptRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", fields{okClient}, args{&apiv1.DecryptRequest{Name: keyName, Ciphertext: []byte("ciphertext"), AdditionalData: []byte("aad")}}, []byte("plaintext"), false},
+		{"ok version", fields{okClient}, args{&apiv1.DecryptRequest{Name: keyName + "/cryptoKeyVersions/2", Ciphertext: []byte("ciphertext"), AdditionalData: []byte("aad")}}, []byte("plaintext"), false},
+		{"ok uri", fields{okClient}, args{&apiv1.DecryptRequest{Name: "cloudkms:name=" + keyName, Ciphertext: []byte("ciphertext"), AdditionalData: []byte("aad")}}, []byte("plaintext"), false},
+		{"fail name", fields{okClient}, args{&apiv1.DecryptRequest{Ciphertext: []byte("ciphertext")}}, nil, true},
+		{"fail uri", fields{okClient}, args{&apiv1.DecryptRequest{Name: "cloudkms:version=1", Ciphertext: []byte("ciphertext")}}, nil, true},
+		{"fail decrypt", fields{okClient}, args{&apiv1.DecryptRequest{Name: keyName, Ciphertext: []byte("ciphertext")}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			got, err := k.Decrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CloudKMS.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloudKMS_getKeyVersionName(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c"
+	versions := []*kmspb.CryptoKeyVersion{
+		{Name: keyName + "/cryptoKeyVersions/2", State: kmspb.CryptoKeyVersion_ENABLED},
+		{Name: keyName + "/cryptoKeyVersions/10", State: kmspb.CryptoKeyVersion_ENABLED},
+		{Name: keyName + "/cryptoKeyVersions/11", State: kmspb.CryptoKeyVersion_DISABLED},
+		{Name: keyName + "/cryptoKeyVersions/9", State: kmspb.CryptoKeyVersion_ENABLED},
+	}
+	listVersions := func(pages map[string][]*kmspb.CryptoKeyVersion, err error) func(context.Context, *kmspb.ListCryptoKeyVersionsRequest, ...gax.CallOption) *cloudkms.CryptoKeyVersionIterator {
+		return newListClient(t, &listServer{
+			listCryptoKeyVersions: func(req *kmspb.ListCryptoKeyVersionsRequest) (*kmspb.ListCryptoKeyVersionsResponse, error) {
+				if err != nil {
+					return nil, err
+				}
+				if req.Parent != keyName {
+					return nil, fmt.Errorf("unexpected parent %s", req.Parent)
+				}
+				next := ""
+				if req.PageToken == "" && len(pages) > 1 {
+					next = "next"
+				}
+				return &kmspb.ListCryptoKeyVersionsResponse{CryptoKeyVersions: pages[req.PageToken], NextPageToken: next}, nil
+			},
+		}).ListCryptoKeyVersions
+	}
+	okClient := &MockClient{
+		listCryptoKeyVersions: listVersions(map[string][]*kmspb.CryptoKeyVersion{
+			"": versions[:2], "next": versions[2:],
+		}, nil),
+		getCryptoKey: func(_ context.Context, req *kmspb.GetCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+			return &kmspb.CryptoKey{Name: req.Name, Primary: versions[0]}, nil
+		},
+	}
+
+	type fields struct {
+		client KeyManagementClient
+	}
+	type args struct {
+		name string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{"ok version", fields{&MockClient{}}, args{keyName + "/cryptoKeyVersions/1"}, keyName + "/cryptoKeyVersions/1", false},
+		{"ok uri version", fields{&MockClient{}}, args{"cloudkms:name=" + keyName + ";version=1"}, keyName + "/cryptoKeyVersions/1", false},
+		{"ok uri query version", fields{&MockClient{}}, args{"cloudkms:name=" + keyName + "?version=1"}, keyName + "/cryptoKeyVersions/1", false},
+		{"ok uri full name", fields{&MockClient{}}, args{"cloudkms:name=" + keyName + "/cryptoKeyVersions/1"}, keyName + "/cryptoKeyVersions/1", false},
+		{"ok latest", fields{okClient}, args{keyName}, keyName + "/cryptoKeyVersions/10", false},
+		{"ok latest version", fields{okClient}, args{keyName + "/cryptoKeyVersions/latest"}, keyName + "/cryptoKeyVersions/10", false},
+		{"ok uri latest", fields{okClient}, args{"cloudkms:name=" + keyName + "?version=latest"}, keyName + "/cryptoKeyVersions/10", false},
+		{"ok primary", fields{okClient}, args{keyName + "/cryptoKeyVersions/primary"}, keyName + "/cryptoKeyVersions/2", false},
+		{"fail uri", fields{okClient}, args{"cloudkms:version=1"}, "", true},
+		{"fail list versions", fields{&MockClient{
+			listCryptoKeyVersions: listVersions(nil, fmt.Errorf("an error")),
+		}}, args{keyName}, "", true},
+		{"fail no versions", fields{&MockClient{
+			listCryptoKeyVersions: listVersions(map[string][]*kmspb.CryptoKeyVersion{"": versions[2:3]}, nil),
+		}}, args{keyName}, "", true},
+		{"fail get crypto key", fields{&MockClient{
+			getCryptoKey: func(_ context.Context, _ *kmspb.GetCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}}, args{keyName + "/cryptoKeyVersions/primary"}, "", true},
+		{"fail no primary", fields{&MockClient{
+			getCryptoKey: func(_ context.Context, req *kmspb.GetCryptoKeyRequest, _ ...gax.CallOption) (*kmspb.CryptoKey, error) {
+				return &kmspb.CryptoKey{Name: req.Name}, nil
+			},
+		}}, args{keyName + "/cryptoKeyVersions/primary"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &CloudKMS{
+				client: tt.fields.client,
+			}
+			got, err := k.getKeyVersionName(tt.args.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CloudKMS.getKeyVersionName() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("CloudKMS.getKeyVersionName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_convertError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"not found", status.Error(codes.NotFound, "not found"), apiv1.ErrNotFound},
+		{"permission denied", status.Error(codes.PermissionDenied, "permission denied"), apiv1.ErrPermissionDenied},
+		{"permission denied unauthenticated", status.Error(codes.Unauthenticated, "unauthenticated"), apiv1.ErrPermissionDenied},
+		{"rate limited", status.Error(codes.ResourceExhausted, "quota exceeded"), apiv1.ErrRateLimited},
+		{"other grpc", status.Error(codes.Internal, "internal error"), nil},
+		{"other", errors.New("an error"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := convertError(tt.err)
+			if tt.want == nil {
+				if err != tt.err {
+					t.Errorf("convertError() = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("convertError() = %#v, want %T", err, tt.want)
+			}
+			if err.Error() != tt.err.Error() {
+				t.Errorf("convertError().Error() = %s, want %s", err.Error(), tt.err.Error())
+			}
+		})
+	}
+	if err := convertError(nil); err != nil {
+		t.Errorf("convertError() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,86 @@
+package cloudkms
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"io"
+
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/pemutil"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+// Decrypter implements a crypto.Decrypter using Google's Cloud KMS.
+type Decrypter struct {
+	client        KeyManagementClient
+	decryptionKey string
+	hash          crypto.Hash
+	publicKey     crypto.PublicKey
+}
+
+// NewDecrypter creates a new crypto.Decrypter the given CloudKMS decryption
+// key. The key must be an ASYMMETRIC_DECRYPT key using RSA-OAEP.
+func NewDecrypter(c KeyManagementClient, decryptionKey string) (*Decrypter, error) {
+	// Make sure that the key exists.
+	decrypter := &Decrypter{
+		client:        c,
+		decryptionKey: decryptionKey,
+	}
+	if err := decrypter.preloadKey(decryptionKey); err != nil {
+		return nil, err
+	}
+
+	return decrypter, nil
+}
+
+func (d *Decrypter) preloadKey(decryptionKey string) error {
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	response, err := d.client.GetPublicKey(ctx, &kmspb.GetPublicKeyRequest{
+		Name: decryptionKey,
+	})
+	if err != nil {
+		return errors.Wrap(convertError(err), "cloudKMS GetPublicKey failed")
+	}
+
+	var ok bool
+	if d.hash, ok = decryptionHashMapping[response.Algorithm]; !ok {
+		return errors.Errorf("cloudKMS key %s is not a decryption key", decryptionKey)
+	}
+	d.publicKey, err = pemutil.ParseKey([]byte(response.Pem))
+	return err
+}
+
+// Public returns the public key of this decrypter.
+func (d *Decrypter) Public() crypto.PublicKey {
+	return d.publicKey
+}
+
+// Decrypt decrypts a message encrypted with RSA-OAEP using the private key
+// stored in Google's Cloud KMS. The hash in the options must match the one in
+// the key algorithm, and labels are not supported.
+func (d *Decrypter) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	oaep, ok := opts.(*rsa.OAEPOptions)
+	switch {
+	case !ok:
+		return nil, errors.Errorf("unsupported decrypter options %T", opts)
+	case oaep.Hash != d.hash:
+		return nil, errors.Errorf("unsupported hash function %v", oaep.Hash)
+	case len(oaep.Label) > 0:
+		return nil, errors.New("rsa-oaep labels are not supported")
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	response, err := d.client.AsymmetricDecrypt(ctx, &kmspb.AsymmetricDecryptRequest{
+		Name:       d.decryptionKey,
+		Ciphertext: ciphertext,
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "cloudKMS AsymmetricDecrypt failed")
+	}
+
+	return response.Plaintext, nil
+}
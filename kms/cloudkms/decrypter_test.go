@@ -0,0 +1,155 @@
+package cloudkms
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+
+	gax "github.com/googleapis/gax-go/v2"
+	"go.step.sm/crypto/pemutil"
+	kmspb "google.golang.org/genproto/googleapis/cloud/kms/v1"
+)
+
+func TestNewDecrypter(t *testing.T) {
+	pemBytes, err := os.ReadFile("testdata/rsapub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := pemutil.ParseKey(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		c             KeyManagementClient
+		decryptionKey string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *Decrypter
+		wantErr bool
+	}{
+		{"ok", args{&MockClient{
+			getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+				return &kmspb.PublicKey{Pem: string(pemBytes), Algorithm: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256}, nil
+			},
+		}, "decryptionKey"}, &Decrypter{client: &MockClient{}, decryptionKey: "decryptionKey", hash: crypto.SHA256, publicKey: pk}, false},
+		{"ok sha512", args{&MockClient{
+			getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+				return &kmspb.PublicKey{Pem: string(pemBytes), Algorithm: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_4096_SHA512}, nil
+			},
+		}, "decryptionKey"}, &Decrypter{client: &MockClient{}, decryptionKey: "decryptionKey", hash: crypto.SHA512, publicKey: pk}, false},
+		{"fail get public key", args{&MockClient{
+			getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+				return nil, fmt.Errorf("an error")
+			},
+		}, "decryptionKey"}, nil, true},
+		{"fail signing key", args{&MockClient{
+			getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+				return &kmspb.PublicKey{Pem: string(pemBytes), Algorithm: kmspb.CryptoKeyVersion_RSA_SIGN_PSS_3072_SHA256}, nil
+			},
+		}, "decryptionKey"}, nil, true},
+		{"fail parse pem", args{&MockClient{
+			getPublicKey: func(_ context.Context, _ *kmspb.GetPublicKeyRequest, _ ...gax.CallOption) (*kmspb.PublicKey, error) {
+				return &kmspb.PublicKey{Pem: string("bad pem"), Algorithm: kmspb.CryptoKeyVersion_RSA_DECRYPT_OAEP_3072_SHA256}, nil
+			},
+		}, "decryptionKey"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewDecrypter(tt.args.c, tt.args.decryptionKey)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewDecrypter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil {
+				got.client = &MockClient{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewDecrypter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecrypter_Public(t *testing.T) {
+	pemBytes, err := os.ReadFile("testdata/rsapub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := pemutil.ParseKey(pemBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Decrypter{client: &MockClient{}, decryptionKey: "decryptionKey", hash: crypto.SHA256, publicKey: pk}
+	if got := d.Public(); !reflect.DeepEqual(got, pk) {
+		t.Errorf("Decrypter.Public() = %v, want %v", got, pk)
+	}
+}
+
+func TestDecrypter_Decrypt(t *testing.T) {
+	keyName := "projects/p/locations/l/keyRings/k/cryptoKeys/c/cryptoKeyVersions/1"
+	okClient := &MockClient{
+		asymmetricDecrypt: func(_ context.Context, req *kmspb.AsymmetricDecryptRequest, _ ...gax.CallOption) (*kmspb.AsymmetricDecryptResponse, error) {
+			if req.Name != keyName {
+				return nil, fmt.Errorf("unexpected name %s", req.Name)
+			}
+			return &kmspb.AsymmetricDecryptResponse{Plaintext: []byte("plaintext")}, nil
+		},
+	}
+	failClient := &MockClient{
+		asymmetricDecrypt: func(_ context.Context, _ *kmspb.AsymmetricDecryptRequest, _ ...gax.CallOption) (*kmspb.AsymmetricDecryptResponse, error) {
+			return nil, fmt.Errorf("an error")
+		},
+	}
+
+	type fields struct {
+		client        KeyManagementClient
+		decryptionKey string
+		hash          crypto.Hash
+	}
+	type args struct {
+		rand       io.Reader
+		ciphertext []byte
+		opts       crypto.DecrypterOpts
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", fields{okClient, keyName, crypto.SHA256}, args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA256}}, []byte("plaintext"), false},
+		{"ok sha1", fields{okClient, keyName, crypto.SHA1}, args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA1}}, []byte("plaintext"), false},
+		{"fail opts", fields{okClient, keyName, crypto.SHA256}, args{rand.Reader, []byte("ciphertext"), &rsa.PKCS1v15DecryptOptions{}}, nil, true},
+		{"fail hash", fields{okClient, keyName, crypto.SHA256}, args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA512}}, nil, true},
+		{"fail label", fields{okClient, keyName, crypto.SHA256}, args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label")}}, nil, true},
+		{"fail decrypt", fields{failClient, keyName, crypto.SHA256}, args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA256}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Decrypter{
+				client:        tt.fields.client,
+				decryptionKey: tt.fields.decryptionKey,
+				hash:          tt.fields.hash,
+			}
+			got, err := d.Decrypt(tt.args.rand, tt.args.ciphertext, tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decrypter.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decrypter.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -40,7 +40,7 @@ func (s *Signer) preloadKey(signingKey string) error {
 		Name: signingKey,
 	})
 	if err != nil {
-		return errors.Wrap(err, "cloudKMS GetPublicKey failed")
+		return errors.Wrap(convertError(err), "cloudKMS GetPublicKey failed")
 	}
 	s.algorithm = cryptoKeyVersionMapping[response.Algorithm]
 	s.publicKey, err = pemutil.ParseKey([]byte(response.Pem))
@@ -81,7 +81,7 @@ func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]
 
 	response, err := s.client.AsymmetricSign(ctx, req)
 	if err != nil {
-		return nil, errors.Wrap(err, "cloudKMS AsymmetricSign failed")
+		return nil, errors.Wrap(convertError(err), "cloudKMS AsymmetricSign failed")
 	}
 
 	return response.Signature, nil
@@ -17,6 +17,34 @@ type KeyManager = apiv1.KeyManager
 // store x509.Certificates.
 type CertificateManager = apiv1.CertificateManager
 
+// CertificateChainManager is the interface implemented by the KMS that can
+// load and store chains of x509.Certificates.
+type CertificateChainManager = apiv1.CertificateChainManager
+
+// KeyLister is the interface implemented by the KMS that can list the keys they
+// hold.
+type KeyLister = apiv1.KeyLister
+
+// KeyDeleter is the interface implemented by the KMS that can delete or
+// schedule the destruction of keys.
+type KeyDeleter = apiv1.KeyDeleter
+
+// KeyRotator is the interface implemented by the KMS that can create new
+// versions of an existing key.
+type KeyRotator = apiv1.KeyRotator
+
+// KeyImporter is the interface implemented by the KMS that can import
+// existing private keys.
+type KeyImporter = apiv1.KeyImporter
+
+// KeyWrapper is the interface implemented by the KMS that can export and
+// import keys encrypted with another key.
+type KeyWrapper = apiv1.KeyWrapper
+
+// SymmetricEncrypter is the interface implemented by the KMS that can encrypt
+// and decrypt data using symmetric keys.
+type SymmetricEncrypter = apiv1.SymmetricEncrypter
+
 // Attester is the interface implemented by the KMS that can respond with an
 // attestation certificate or key.
 //
@@ -26,6 +54,10 @@ type CertificateManager = apiv1.CertificateManager
 // release.
 type Attester = apiv1.Attester
 
+// HealthChecker is the interface implemented by the KMS that can check the
+// connection with the device or service that holds the keys.
+type HealthChecker = apiv1.HealthChecker
+
 // Options are the KMS options. They represent the kms object in the ca.json.
 type Options = apiv1.Options
 
@@ -2,10 +2,13 @@ package kms
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/fs"
+	"sort"
 
 	"go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/pemutil"
 )
 
 // FS adds a close method to the fs.FS interface. This new method allows to
@@ -51,11 +54,7 @@ func loadKMS(ctx context.Context, kmsuri string) (apiv1.KeyManager, error) {
 }
 
 func openError(name string, err error) *fs.PathError {
-	return &fs.PathError{
-		Path: name,
-		Op:   "open",
-		Err:  err,
-	}
+	return pathError("open", name, err)
 }
 
 // certFS implements an io/fs to load certificates from a KMS.
@@ -108,8 +107,12 @@ func KeyFS(ctx context.Context, kmsuri string) (FS, error) {
 	return &keyFS{kmsfs: km}, nil
 }
 
-// Open returns a file representing a public key in a KMS.
+// Open returns a file representing a public key in a KMS. The name "." opens
+// a directory with the keys in the default location.
 func (f *keyFS) Open(name string) (fs.File, error) {
+	if name == "." {
+		return openDir(f.kmsfs, f.Open), nil
+	}
 	km, err := f.getKMS(name)
 	if err != nil {
 		return nil, openError(name, err)
@@ -126,3 +129,203 @@ func (f *keyFS) Open(name string) (fs.File, error) {
 		Object: pub,
 	}, nil
 }
+
+// ReadDir returns the keys in the default location of the KMS, like the keys in
+// a PKCS #11 token or in a YubiKey. Only the root directory "." can be listed,
+// and the KMS must implement the apiv1.KeyLister interface and list keys
+// without a parent.
+//
+// The name of each entry is the opaque name of the key in the KMS, and it can
+// be used with Open. The names are only valid paths for fs.WalkDir, fs.Glob or
+// fstest.TestFS if they do not contain slashes, like the URIs returned by
+// pkcs11 or yubikey.
+func (f *keyFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return readDir(f.kmsfs, name, f.Open)
+}
+
+// WritableFS is an FS that can also list and create keys and store
+// certificates in a KMS.
+type WritableFS interface {
+	FS
+	fs.ReadDirFS
+	// WriteFile stores the PEM-encoded certificate in data with the given
+	// name, replacing the existing one if the KMS supports it. If data
+	// contains more than one certificate the KMS must implement the
+	// apiv1.CertificateChainManager interface. The permissions are ignored,
+	// softkms URIs can define them using the mode attribute.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// GenerateKey creates a new key in the KMS and returns a file
+	// representing its public key.
+	GenerateKey(req *apiv1.CreateKeyRequest) (fs.File, error)
+}
+
+// objectFS implements an io/fs to load, list and create keys and certificates
+// in a KMS.
+type objectFS struct {
+	*kmsfs
+}
+
+// ObjectFS creates a new WritableFS with the given KMS URI. Open returns the
+// certificate with the given name if the KMS implements the
+// apiv1.CertificateManager interface and the certificate exists, and the
+// public key otherwise.
+func ObjectFS(ctx context.Context, kmsuri string) (WritableFS, error) {
+	km, err := newFS(ctx, kmsuri)
+	if err != nil {
+		return nil, err
+	}
+	return &objectFS{kmsfs: km}, nil
+}
+
+// Open returns a file representing a certificate or a public key in a KMS.
+// The name "." opens a directory with the keys in the default location.
+func (f *objectFS) Open(name string) (fs.File, error) {
+	if name == "." {
+		return openDir(f.kmsfs, f.Open), nil
+	}
+	km, err := f.getKMS(name)
+	if err != nil {
+		return nil, openError(name, err)
+	}
+	if cm, ok := km.(apiv1.CertificateManager); ok {
+		cert, err := cm.LoadCertificate(&apiv1.LoadCertificateRequest{
+			Name: name,
+		})
+		if err == nil {
+			return &object{
+				Path:   name,
+				Object: cert,
+			}, nil
+		}
+	}
+	pub, err := km.GetPublicKey(&apiv1.GetPublicKeyRequest{
+		Name: name,
+	})
+	if err != nil {
+		return nil, openError(name, err)
+	}
+	return &object{
+		Path:   name,
+		Object: pub,
+	}, nil
+}
+
+// ReadDir returns the keys in the default location of the KMS, see
+// keyFS.ReadDir.
+func (f *objectFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return readDir(f.kmsfs, name, f.Open)
+}
+
+// WriteFile stores the certificates in data in the KMS.
+func (f *objectFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	km, err := f.getKMS(name)
+	if err != nil {
+		return pathError("write", name, err)
+	}
+	certs, err := pemutil.ParseCertificateBundle(data)
+	if err != nil {
+		return pathError("write", name, err)
+	}
+
+	if len(certs) == 1 {
+		cm, ok := km.(apiv1.CertificateManager)
+		if !ok {
+			return pathError("write", name, apiv1.NotImplementedError{
+				Message: "kms does not implement a CertificateManager",
+			})
+		}
+		err = cm.StoreCertificate(&apiv1.StoreCertificateRequest{
+			Name:        name,
+			Certificate: certs[0],
+		})
+	} else {
+		cm, ok := km.(apiv1.CertificateChainManager)
+		if !ok {
+			return pathError("write", name, apiv1.NotImplementedError{
+				Message: "kms does not implement a CertificateChainManager",
+			})
+		}
+		err = cm.StoreCertificateChain(&apiv1.StoreCertificateChainRequest{
+			Name:             name,
+			CertificateChain: certs,
+		})
+	}
+	if err != nil {
+		return pathError("write", name, err)
+	}
+	return nil
+}
+
+// GenerateKey creates a new key using the KMS CreateKey method.
+func (f *objectFS) GenerateKey(req *apiv1.CreateKeyRequest) (fs.File, error) {
+	km, err := f.getKMS(req.Name)
+	if err != nil {
+		return nil, pathError("create", req.Name, err)
+	}
+	resp, err := km.CreateKey(req)
+	if err != nil {
+		return nil, pathError("create", req.Name, err)
+	}
+	return &object{
+		Path:   resp.Name,
+		Object: resp.PublicKey,
+	}, nil
+}
+
+func pathError(op, name string, err error) *fs.PathError {
+	return &fs.PathError{
+		Path: name,
+		Op:   op,
+		Err:  err,
+	}
+}
+
+// openDir returns the root directory, it lists the keys in the default
+// location. The given open function is used to open the keys.
+func openDir(f *kmsfs, open func(string) (fs.File, error)) fs.File {
+	return &dir{
+		Path: ".",
+		list: func() ([]fs.DirEntry, error) {
+			return readDir(f, ".", open)
+		},
+	}
+}
+
+// readDir lists all the keys in the default location, the entries are sorted
+// by name. The given open function is used to get the info of an entry.
+func readDir(f *kmsfs, name string, open func(string) (fs.File, error)) ([]fs.DirEntry, error) {
+	if name != "." {
+		return nil, pathError("readdir", name, errors.New("not a directory"))
+	}
+	km, err := f.getKMS("")
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	kl, ok := km.(apiv1.KeyLister)
+	if !ok {
+		return nil, pathError("readdir", name, apiv1.NotImplementedError{
+			Message: "kms does not implement a KeyLister",
+		})
+	}
+
+	var entries []fs.DirEntry
+	req := &apiv1.ListKeysRequest{}
+	for {
+		resp, err := kl.ListKeys(req)
+		if err != nil {
+			return nil, pathError("readdir", name, err)
+		}
+		for _, key := range resp.Keys {
+			entries = append(entries, &keyEntry{KeyInfo: key, open: open})
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
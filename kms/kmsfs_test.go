@@ -6,13 +6,16 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"errors"
+	"io"
 	"io/fs"
 	"os"
 	"reflect"
 	"testing"
+	"testing/fstest"
 
 	"go.step.sm/crypto/kms/apiv1"
 	"go.step.sm/crypto/kms/softkms"
+	"go.step.sm/crypto/pemutil"
 )
 
 type fakeCM struct {
@@ -42,10 +45,18 @@ func (f *fakeCM) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
 	return nil
 }
 
+// fakeKM is a KeyManager that does not implement any other interface.
+type fakeKM struct {
+	apiv1.KeyManager
+}
+
 func TestMain(m *testing.M) {
 	apiv1.Register(apiv1.Type("fake"), func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
 		return &fakeCM{}, nil
 	})
+	apiv1.Register(apiv1.Type("fakekm"), func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
+		return &fakeKM{}, nil
+	})
 	os.Exit(m.Run())
 }
 
@@ -186,8 +197,9 @@ func TestCertFS(t *testing.T) {
 		wantErr bool
 	}{
 		{"ok", args{ctx, "fake:"}, &certFS{kmsfs: &kmsfs{KeyManager: &fakeCM{}}}, false},
+		{"ok softkms", args{ctx, "softkms:"}, &certFS{kmsfs: &kmsfs{KeyManager: &softkms.SoftKMS{}}}, false},
 		{"fail", args{ctx, "fail:"}, nil, true},
-		{"fail not implemented", args{ctx, "softkms:"}, nil, true},
+		{"fail not implemented", args{ctx, "fakekm:"}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -205,6 +217,10 @@ func TestCertFS(t *testing.T) {
 
 func Test_certFS_Open(t *testing.T) {
 	fake := &kmsfs{KeyManager: &fakeCM{}}
+	cert, err := pemutil.ReadCertificate("softkms/testdata/cert.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
 	type fields struct {
 		kmsfs *kmsfs
 	}
@@ -226,8 +242,13 @@ func Test_certFS_Open(t *testing.T) {
 			Path:   "fake:foo",
 			Object: &x509.Certificate{Subject: pkix.Name{CommonName: "fake:foo"}},
 		}, false},
+		{"ok softkms", fields{&kmsfs{KeyManager: &softkms.SoftKMS{}}}, args{"softkms/testdata/cert.crt"}, &object{
+			Path:   "softkms/testdata/cert.crt",
+			Object: cert,
+		}, false},
 		{"fail fake", fields{fake}, args{"fail"}, nil, true},
 		{"fail unregistered", fields{&kmsfs{}}, args{"fail:"}, nil, true},
+		{"fail softkms", fields{&kmsfs{KeyManager: &softkms.SoftKMS{}}}, args{"softkms/testdata/missing.crt"}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -317,3 +338,359 @@ func Test_keyFS_Open(t *testing.T) {
 		})
 	}
 }
+
+// fakeKL is a KeyLister that returns one key per page.
+type fakeKL struct {
+	apiv1.KeyManager
+	keys []string
+	err  error
+}
+
+func (f *fakeKL) ListKeys(req *apiv1.ListKeysRequest) (*apiv1.ListKeysResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	keys := make([]*apiv1.KeyInfo, len(f.keys))
+	for i, name := range f.keys {
+		keys[i] = &apiv1.KeyInfo{Name: name}
+	}
+	return (&apiv1.ListKeysRequest{PageSize: 1, PageToken: req.PageToken}).Paginate(keys)
+}
+
+func (f *fakeKL) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	for _, name := range f.keys {
+		if name == req.Name {
+			return pemutil.Read("softkms/testdata/pub.pem")
+		}
+	}
+	return nil, apiv1.NotFoundError{}
+}
+
+func mustCopyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	b, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestObjectFS(t *testing.T) {
+	ctx := context.TODO()
+	type args struct {
+		ctx    context.Context
+		kmsuri string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    WritableFS
+		wantErr bool
+	}{
+		{"ok", args{ctx, "fake:"}, &objectFS{kmsfs: &kmsfs{KeyManager: &fakeCM{}}}, false},
+		{"ok fakekm", args{ctx, "fakekm:"}, &objectFS{kmsfs: &kmsfs{KeyManager: &fakeKM{}}}, false},
+		{"fail", args{ctx, "fail:"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ObjectFS(tt.args.ctx, tt.args.kmsuri)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ObjectFS() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ObjectFS() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_objectFS_Open(t *testing.T) {
+	cert, err := pemutil.ReadCertificate("softkms/testdata/cert.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := pemutil.Read("softkms/testdata/pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	soft := &kmsfs{KeyManager: &softkms.SoftKMS{}}
+
+	tests := []struct {
+		name    string
+		kmsfs   *kmsfs
+		fsName  string
+		want    fs.File
+		wantErr bool
+	}{
+		{"ok certificate", &kmsfs{KeyManager: &fakeCM{}}, "foo", &object{
+			Path:   "foo",
+			Object: &x509.Certificate{Subject: pkix.Name{CommonName: "foo"}},
+		}, false},
+		{"ok softkms certificate", soft, "softkms/testdata/cert.crt", &object{
+			Path:   "softkms/testdata/cert.crt",
+			Object: cert,
+		}, false},
+		{"ok softkms public key", soft, "softkms/testdata/pub.pem", &object{
+			Path:   "softkms/testdata/pub.pem",
+			Object: pub,
+		}, false},
+		{"fail fake", &kmsfs{KeyManager: &fakeCM{}}, "fail", nil, true},
+		{"fail unregistered", &kmsfs{}, "fail:", nil, true},
+		{"fail softkms", soft, "softkms/testdata/missing.pem", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &objectFS{kmsfs: tt.kmsfs}
+			got, err := f.Open(tt.fsName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("objectFS.Open() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("objectFS.Open() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_objectFS_ReadDir(t *testing.T) {
+	lister := &kmsfs{KeyManager: &fakeKL{keys: []string{"c", "a", "b"}}}
+
+	tests := []struct {
+		name    string
+		kmsfs   *kmsfs
+		dir     string
+		want    []string
+		wantErr bool
+	}{
+		{"ok pages", lister, ".", []string{"a", "b", "c"}, false},
+		{"fail not root", lister, "a", nil, true},
+		{"fail softkms", &kmsfs{KeyManager: &softkms.SoftKMS{}}, "softkms/testdata", nil, true},
+		{"fail list", &kmsfs{KeyManager: &fakeKL{err: errors.New("an error")}}, ".", nil, true},
+		{"fail not implemented", &kmsfs{KeyManager: &fakeKM{}}, ".", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &objectFS{kmsfs: tt.kmsfs}
+			got, err := f.ReadDir(tt.dir)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("objectFS.ReadDir() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			var names []string
+			for _, e := range got {
+				info, err := e.Info()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if e.IsDir() || info.Mode() != 0400 || info.Size() == 0 || info.Sys() == nil {
+					t.Errorf("objectFS.ReadDir() entry %s is not valid", e.Name())
+				}
+				names = append(names, e.Name())
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("objectFS.ReadDir() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func Test_keyFS_ReadDir(t *testing.T) {
+	f := &keyFS{kmsfs: &kmsfs{KeyManager: &fakeKL{keys: []string{"b", "a"}}}}
+	got, err := fs.ReadDir(f, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Name() != "a" || got[1].Name() != "b" {
+		t.Errorf("fs.ReadDir() = %v, want [a b]", got)
+	}
+}
+
+func Test_objectFS_WalkDir(t *testing.T) {
+	keys := []string{"pkcs11:id=02;object=b", "yubikey:slot-id=9a", "pkcs11:id=01;object=a"}
+	f := &objectFS{kmsfs: &kmsfs{KeyManager: &fakeKL{keys: keys}}}
+
+	var paths []string
+	if err := fs.WalkDir(f, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != "." && path != d.Name() {
+			t.Errorf("fs.WalkDir() path = %s, want %s", path, d.Name())
+		}
+		paths = append(paths, path)
+		return nil
+	}); err != nil {
+		t.Fatalf("fs.WalkDir() error = %v", err)
+	}
+	want := []string{".", "pkcs11:id=01;object=a", "pkcs11:id=02;object=b", "yubikey:slot-id=9a"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("fs.WalkDir() = %v, want %v", paths, want)
+	}
+
+	matches, err := fs.Glob(f, "pkcs11:*")
+	if err != nil {
+		t.Fatalf("fs.Glob() error = %v", err)
+	}
+	if want := []string{"pkcs11:id=01;object=a", "pkcs11:id=02;object=b"}; !reflect.DeepEqual(matches, want) {
+		t.Errorf("fs.Glob() = %v, want %v", matches, want)
+	}
+
+	// The error listing the keys is passed to the walk function.
+	f = &objectFS{kmsfs: &kmsfs{KeyManager: &fakeKM{}}}
+	if err := fs.WalkDir(f, ".", func(path string, d fs.DirEntry, err error) error {
+		return err
+	}); !errors.Is(err, apiv1.ErrNotImplemented) {
+		t.Errorf("fs.WalkDir() error = %v, want %v", err, apiv1.ErrNotImplemented)
+	}
+}
+
+func Test_fstest(t *testing.T) {
+	km := &kmsfs{KeyManager: &fakeKL{keys: []string{"pkcs11:id=01;object=a", "yubikey:slot-id=9a"}}}
+	if err := fstest.TestFS(&keyFS{kmsfs: km}, "pkcs11:id=01;object=a", "yubikey:slot-id=9a"); err != nil {
+		t.Errorf("fstest.TestFS() keyFS error = %v", err)
+	}
+	if err := fstest.TestFS(&objectFS{kmsfs: km}, "pkcs11:id=01;object=a", "yubikey:slot-id=9a"); err != nil {
+		t.Errorf("fstest.TestFS() objectFS error = %v", err)
+	}
+}
+
+func Test_dir(t *testing.T) {
+	f := &keyFS{kmsfs: &kmsfs{KeyManager: &fakeKL{keys: []string{"c", "b", "a"}}}}
+	file, err := f.Open(".")
+	if err != nil {
+		t.Fatalf("keyFS.Open() error = %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatalf("dir.Stat() error = %v", err)
+	}
+	if !info.IsDir() || info.Name() != "." || info.Mode() != fs.ModeDir|0500 {
+		t.Errorf("dir.Stat() = %v, want a directory", info)
+	}
+	if _, err := file.Read(make([]byte, 1)); err == nil {
+		t.Error("dir.Read() error = nil, want an error")
+	}
+
+	d := file.(fs.ReadDirFile)
+	var names []string
+	for {
+		entries, err := d.ReadDir(2)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("dir.ReadDir() error = %v", err)
+		}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("dir.ReadDir() = %v, want %v", names, want)
+	}
+	if entries, err := d.ReadDir(-1); err != nil || len(entries) != 0 {
+		t.Errorf("dir.ReadDir() = %v, %v, want no entries", entries, err)
+	}
+}
+
+func Test_objectFS_WriteFile(t *testing.T) {
+	dir := t.TempDir()
+	cert, err := os.ReadFile("softkms/testdata/cert.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := append(append([]byte{}, cert...), cert...)
+	soft := &kmsfs{KeyManager: &softkms.SoftKMS{}}
+
+	tests := []struct {
+		name    string
+		kmsfs   *kmsfs
+		fsName  string
+		data    []byte
+		want    int
+		wantErr bool
+	}{
+		{"ok", soft, dir + "/cert.crt", cert, 1, false},
+		{"ok replace", soft, dir + "/cert.crt", chain, 2, false},
+		{"ok chain", soft, dir + "/chain.crt", chain, 2, false},
+		{"ok fake", &kmsfs{KeyManager: &fakeCM{}}, "foo", cert, 0, false},
+		{"fail store", &kmsfs{KeyManager: &fakeCM{}}, "fail", cert, 0, true},
+		{"fail parse", soft, dir + "/bad.crt", []byte("not a certificate"), 0, true},
+		{"fail not implemented", &kmsfs{KeyManager: &fakeKM{}}, "foo", cert, 0, true},
+		{"fail chain not implemented", &kmsfs{KeyManager: &fakeKM{}}, "foo", chain, 0, true},
+		{"fail unregistered", &kmsfs{}, "fail:", cert, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &objectFS{kmsfs: tt.kmsfs}
+			if err := f.WriteFile(tt.fsName, tt.data, 0600); (err != nil) != tt.wantErr {
+				t.Errorf("objectFS.WriteFile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.want > 0 {
+				certs, err := pemutil.ReadCertificateBundle(tt.fsName)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(certs) != tt.want {
+					t.Errorf("objectFS.WriteFile() stored %d certificates, want %d", len(certs), tt.want)
+				}
+			}
+		})
+	}
+}
+
+func Test_objectFS_GenerateKey(t *testing.T) {
+	dir := t.TempDir()
+	soft := &kmsfs{KeyManager: &softkms.SoftKMS{}}
+
+	tests := []struct {
+		name    string
+		kmsfs   *kmsfs
+		req     *apiv1.CreateKeyRequest
+		wantErr bool
+	}{
+		{"ok", soft, &apiv1.CreateKeyRequest{
+			Name:               "softkms:path=" + dir + "/key.pem",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+		}, false},
+		{"fail exists", soft, &apiv1.CreateKeyRequest{
+			Name:               "softkms:path=" + dir + "/key.pem",
+			SignatureAlgorithm: apiv1.ECDSAWithSHA256,
+		}, true},
+		{"fail algorithm", soft, &apiv1.CreateKeyRequest{
+			Name:               "softkms:path=" + dir + "/other.pem",
+			SignatureAlgorithm: apiv1.SignatureAlgorithm(100),
+		}, true},
+		{"fail unregistered", &kmsfs{}, &apiv1.CreateKeyRequest{Name: "fail:"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &objectFS{kmsfs: tt.kmsfs}
+			got, err := f.GenerateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("objectFS.GenerateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got == nil {
+				return
+			}
+			info, err := got.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Name() != tt.req.Name {
+				t.Errorf("objectFS.GenerateKey() name = %s, want %s", info.Name(), tt.req.Name)
+			}
+			if _, ok := info.Sys().(crypto.PublicKey); !ok || info.Sys() == nil {
+				t.Errorf("objectFS.GenerateKey() does not contain a public key")
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package multikms
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+// MultiKMS implements a KMS that keeps the same keys in multiple replicas, for
+// example, two HSMs with the same CA key. Operations are routed to the first
+// healthy replica, and signers fail over to the next replica if a signature
+// fails.
+//
+// It is configured using the URIs option, with the URI of each replica in
+// order of preference:
+//
+//	{
+//	  "type": "multikms",
+//	  "uris": [
+//	    "pkcs11:module-path=/usr/lib/primary.so;token=ca?pin-source=/etc/pin",
+//	    "pkcs11:module-path=/usr/lib/secondary.so;token=ca?pin-source=/etc/pin"
+//	  ]
+//	}
+//
+// The rest of the options are shared by all the replicas. The names of the keys
+// in the requests are passed to all the replicas, so they must be the same in
+// all of them.
+type MultiKMS struct {
+	replicas []*replica
+}
+
+// replica is one of the KMSes in a MultiKMS.
+type replica struct {
+	index int
+	typ   apiv1.Type
+	km    apiv1.KeyManager
+}
+
+// String returns a name for the replica that does not include the URI, as it
+// might contain a pin.
+func (r *replica) String() string {
+	return fmt.Sprintf("replica #%d (%s)", r.index, r.typ)
+}
+
+// healthCheck checks the connection with the replica if the KMS implements
+// the apiv1.HealthChecker interface.
+func (r *replica) healthCheck() error {
+	if hc, ok := r.km.(apiv1.HealthChecker); ok {
+		return errors.Wrapf(hc.HealthCheck(), "%s is not healthy", r)
+	}
+	return nil
+}
+
+// New creates a new MultiKMS initializing all the replicas in opts.URIs. A
+// replica that cannot be initialized is ignored, but New fails if none of them
+// can be initialized.
+func New(ctx context.Context, opts apiv1.Options) (*MultiKMS, error) {
+	if len(opts.URIs) == 0 {
+		return nil, errors.New("multikms uris are required")
+	}
+
+	var lastErr error
+	k := new(MultiKMS)
+	for i, u := range opts.URIs {
+		o := opts
+		o.Type = apiv1.DefaultKMS
+		o.URI = u
+		o.URIs = nil
+
+		typ, err := o.GetType()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing uri #%d", i)
+		}
+		if typ == apiv1.MultiKMS {
+			return nil, errors.New("multikms cannot be used as a replica")
+		}
+		fn, ok := apiv1.LoadKeyManagerNewFunc(typ)
+		if !ok {
+			return nil, errors.Errorf("unsupported kms type '%s'", typ)
+		}
+
+		r := &replica{index: i, typ: typ}
+		if r.km, err = fn(ctx, o); err != nil {
+			lastErr = errors.Wrapf(err, "error initializing %s", r)
+			continue
+		}
+		k.replicas = append(k.replicas, r)
+	}
+	if len(k.replicas) == 0 {
+		return nil, lastErr
+	}
+
+	return k, nil
+}
+
+func init() {
+	apiv1.Register(apiv1.MultiKMS, func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
+		return New(ctx, opts)
+	})
+}
+
+// GetPublicKey returns the public key from the first healthy replica.
+func (k *MultiKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	var lastErr error
+	for _, r := range k.replicas {
+		if err := r.healthCheck(); err != nil {
+			lastErr = err
+			continue
+		}
+		pub, err := r.km.GetPublicKey(req)
+		if err != nil {
+			lastErr = errors.Wrapf(err, "error getting public key from %s", r)
+			continue
+		}
+		return pub, nil
+	}
+	return nil, lastErr
+}
+
+// CreateKey is not supported, the same key must be created or imported in all
+// the replicas using the tools of each KMS.
+func (k *MultiKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	return nil, apiv1.NotImplementedError{
+		Message: "multikms does not support creating keys",
+	}
+}
+
+// CreateSigner creates a signer that uses the key in all the replicas. The
+// signer uses the first replica available and fails over to the next one if a
+// signature fails.
+//
+// Replicas that are not healthy are skipped, but CreateSigner fails if the
+// signer cannot be created in a healthy replica or if the public keys in the
+// replicas do not match.
+func (k *MultiKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	var lastErr error
+	var signers []*replicaSigner
+	for _, r := range k.replicas {
+		s, err := r.km.CreateSigner(req)
+		if err != nil {
+			if hcErr := r.healthCheck(); hcErr != nil {
+				lastErr = hcErr
+				continue
+			}
+			return nil, errors.Wrapf(err, "error creating signer in %s", r)
+		}
+		if len(signers) > 0 && !equalPublicKeys(signers[0].Public(), s.Public()) {
+			return nil, errors.Errorf("public key in %s does not match the one in the other replicas", r)
+		}
+		signers = append(signers, &replicaSigner{Signer: s, replica: r})
+	}
+	if len(signers) == 0 {
+		return nil, lastErr
+	}
+
+	return &signer{signers: signers}, nil
+}
+
+// HealthCheck returns nil if at least one of the replicas is healthy.
+func (k *MultiKMS) HealthCheck() error {
+	var lastErr error
+	for _, r := range k.replicas {
+		if lastErr = r.healthCheck(); lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+// ValidateName validates the given name in all the replicas that implement the
+// apiv1.NameValidator interface, as the same name is used in all of them.
+func (k *MultiKMS) ValidateName(s string) error {
+	for _, r := range k.replicas {
+		if v, ok := r.km.(apiv1.NameValidator); ok {
+			if err := v.ValidateName(s); err != nil {
+				return errors.Wrapf(err, "error validating name in %s", r)
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes all the replicas, it returns the first error found.
+func (k *MultiKMS) Close() error {
+	var firstErr error
+	for _, r := range k.replicas {
+		if err := r.km.Close(); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "error closing %s", r)
+		}
+	}
+	return firstErr
+}
+
+func equalPublicKeys(a, b crypto.PublicKey) bool {
+	pub, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(b)
+}
@@ -0,0 +1,395 @@
+package multikms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/kms/uri"
+)
+
+const fakeType = apiv1.Type("fakekms")
+
+// fakes are the replicas returned by the fakekms type, by name.
+var fakes map[string]*fakeKMS
+
+func init() {
+	apiv1.Register(fakeType, func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
+		u, err := uri.ParseWithScheme(string(fakeType), opts.URI)
+		if err != nil {
+			return nil, err
+		}
+		f, ok := fakes[u.Get("name")]
+		if !ok {
+			return nil, errors.New("replica not found")
+		}
+		if f.newErr != nil {
+			return nil, f.newErr
+		}
+		return f, nil
+	})
+}
+
+type fakeKMS struct {
+	key       *ecdsa.PrivateKey
+	newErr    error
+	healthErr error
+	getErr    error
+	signerErr error
+	signErr   error
+	validErr  error
+	closeErr  error
+	closed    bool
+}
+
+func (f *fakeKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	return f.key.Public(), nil
+}
+
+func (f *fakeKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if f.signerErr != nil {
+		return nil, f.signerErr
+	}
+	return &fakeSigner{key: f.key, err: f.signErr}, nil
+}
+
+func (f *fakeKMS) HealthCheck() error {
+	return f.healthErr
+}
+
+func (f *fakeKMS) ValidateName(s string) error {
+	return f.validErr
+}
+
+func (f *fakeKMS) Close() error {
+	f.closed = true
+	return f.closeErr
+}
+
+type fakeSigner struct {
+	key *ecdsa.PrivateKey
+	err error
+}
+
+func (s *fakeSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *fakeSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.key.Sign(rand, digest, opts)
+}
+
+func mustKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// mustMultiKMS returns a MultiKMS with the given replicas.
+func mustMultiKMS(t *testing.T, replicas ...*fakeKMS) *MultiKMS {
+	t.Helper()
+	k := new(MultiKMS)
+	for i, f := range replicas {
+		k.replicas = append(k.replicas, &replica{index: i, typ: fakeType, km: f})
+	}
+	return k
+}
+
+func TestNew(t *testing.T) {
+	key := mustKey(t)
+	primary := &fakeKMS{key: key}
+	secondary := &fakeKMS{key: key}
+	failing := &fakeKMS{key: key, newErr: errors.New("an error")}
+	fakes = map[string]*fakeKMS{
+		"primary":   primary,
+		"secondary": secondary,
+		"failing":   failing,
+	}
+	t.Cleanup(func() {
+		fakes = nil
+	})
+
+	type args struct {
+		ctx  context.Context
+		opts apiv1.Options
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *MultiKMS
+		wantErr bool
+	}{
+		{"ok", args{context.Background(), apiv1.Options{
+			Type: apiv1.MultiKMS,
+			URIs: []string{"fakekms:name=primary", "fakekms:name=secondary"},
+		}}, &MultiKMS{replicas: []*replica{
+			{index: 0, typ: fakeType, km: primary},
+			{index: 1, typ: fakeType, km: secondary},
+		}}, false},
+		{"ok with failing replica", args{context.Background(), apiv1.Options{
+			Type: apiv1.MultiKMS,
+			URIs: []string{"fakekms:name=failing", "fakekms:name=secondary"},
+		}}, &MultiKMS{replicas: []*replica{
+			{index: 1, typ: fakeType, km: secondary},
+		}}, false},
+		{"fail no uris", args{context.Background(), apiv1.Options{
+			Type: apiv1.MultiKMS,
+		}}, nil, true},
+		{"fail parse", args{context.Background(), apiv1.Options{
+			Type: apiv1.MultiKMS,
+			URIs: []string{"fakekms:name=primary", "::name=secondary"},
+		}}, nil, true},
+		{"fail nested", args{context.Background(), apiv1.Options{
+			Type: apiv1.MultiKMS,
+			URIs: []string{"fakekms:name=primary", "multikms:name=secondary"},
+		}}, nil, true},
+		{"fail unsupported", args{context.Background(), apiv1.Options{
+			Type: apiv1.MultiKMS,
+			URIs: []string{"fakekms:name=primary", "unsupported:name=secondary"},
+		}}, nil, true},
+		{"fail all replicas", args{context.Background(), apiv1.Options{
+			Type: apiv1.MultiKMS,
+			URIs: []string{"fakekms:name=failing", "fakekms:name=missing"},
+		}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.args.ctx, tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("New() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew_registry(t *testing.T) {
+	fakes = map[string]*fakeKMS{
+		"primary": {key: mustKey(t)},
+	}
+	t.Cleanup(func() {
+		fakes = nil
+	})
+
+	fn, ok := apiv1.LoadKeyManagerNewFunc(apiv1.MultiKMS)
+	if !ok {
+		t.Fatal("apiv1.LoadKeyManagerNewFunc() returned false")
+	}
+	km, err := fn(context.Background(), apiv1.Options{
+		Type: apiv1.MultiKMS,
+		URIs: []string{"fakekms:name=primary"},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, ok := km.(apiv1.HealthChecker); !ok {
+		t.Errorf("MultiKMS does not implement apiv1.HealthChecker")
+	}
+}
+
+func TestMultiKMS_GetPublicKey(t *testing.T) {
+	key := mustKey(t)
+	tests := []struct {
+		name     string
+		replicas []*fakeKMS
+		want     crypto.PublicKey
+		wantErr  bool
+	}{
+		{"ok", []*fakeKMS{{key: key}, {key: mustKey(t)}}, key.Public(), false},
+		{"ok not healthy", []*fakeKMS{{key: mustKey(t), healthErr: errors.New("an error")}, {key: key}}, key.Public(), false},
+		{"ok fail over", []*fakeKMS{{key: mustKey(t), getErr: errors.New("an error")}, {key: key}}, key.Public(), false},
+		{"fail", []*fakeKMS{{key: key, healthErr: errors.New("an error")}, {key: key, getErr: errors.New("an error")}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := mustMultiKMS(t, tt.replicas...)
+			got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: "fakekms:name=key"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MultiKMS.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MultiKMS.GetPublicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiKMS_CreateKey(t *testing.T) {
+	k := mustMultiKMS(t, &fakeKMS{key: mustKey(t)})
+	_, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "fakekms:name=key"})
+	if !errors.As(err, &apiv1.NotImplementedError{}) {
+		t.Errorf("MultiKMS.CreateKey() error = %v, want apiv1.NotImplementedError", err)
+	}
+}
+
+func TestMultiKMS_CreateSigner(t *testing.T) {
+	key := mustKey(t)
+	tests := []struct {
+		name     string
+		replicas []*fakeKMS
+		want     int
+		wantErr  bool
+	}{
+		{"ok", []*fakeKMS{{key: key}, {key: key}}, 2, false},
+		{"ok not healthy", []*fakeKMS{{key: mustKey(t), signerErr: errors.New("an error"), healthErr: errors.New("an error")}, {key: key}}, 1, false},
+		{"fail healthy", []*fakeKMS{{key: key}, {key: key, signerErr: errors.New("an error")}}, 0, true},
+		{"fail public key", []*fakeKMS{{key: key}, {key: mustKey(t)}}, 0, true},
+		{"fail not healthy", []*fakeKMS{
+			{key: key, signerErr: errors.New("an error"), healthErr: errors.New("an error")},
+			{key: key, signerErr: errors.New("an error"), healthErr: errors.New("an error")},
+		}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := mustMultiKMS(t, tt.replicas...)
+			got, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "fakekms:name=key"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MultiKMS.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				if got != nil {
+					t.Errorf("MultiKMS.CreateSigner() = %v, want nil", got)
+				}
+				return
+			}
+			if n := len(got.(*signer).signers); n != tt.want {
+				t.Errorf("MultiKMS.CreateSigner() signers = %d, want %d", n, tt.want)
+			}
+			if !reflect.DeepEqual(got.Public(), key.Public()) {
+				t.Errorf("signer.Public() = %v, want %v", got.Public(), key.Public())
+			}
+		})
+	}
+}
+
+func TestSigner_Sign(t *testing.T) {
+	key := mustKey(t)
+	sum := sha256.Sum256([]byte("the message"))
+	tests := []struct {
+		name     string
+		replicas []*fakeKMS
+		wantErr  bool
+	}{
+		{"ok", []*fakeKMS{{key: key}, {key: key, signErr: errors.New("an error")}}, false},
+		{"ok fail over", []*fakeKMS{{key: key, signErr: errors.New("an error")}, {key: key}}, false},
+		{"fail", []*fakeKMS{{key: key, signErr: errors.New("an error")}, {key: key, signErr: errors.New("an error")}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := mustMultiKMS(t, tt.replicas...)
+			s, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "fakekms:name=key"})
+			if err != nil {
+				t.Fatalf("MultiKMS.CreateSigner() error = %v", err)
+			}
+			got, err := s.Sign(rand.Reader, sum[:], crypto.SHA256)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !ecdsa.VerifyASN1(&key.PublicKey, sum[:], got) {
+				t.Errorf("ecdsa.VerifyASN1() failed")
+			}
+		})
+	}
+}
+
+func TestMultiKMS_HealthCheck(t *testing.T) {
+	key := mustKey(t)
+	tests := []struct {
+		name     string
+		replicas []*fakeKMS
+		wantErr  bool
+	}{
+		{"ok", []*fakeKMS{{key: key}, {key: key}}, false},
+		{"ok one healthy", []*fakeKMS{{key: key, healthErr: errors.New("an error")}, {key: key}}, false},
+		{"fail", []*fakeKMS{{key: key, healthErr: errors.New("an error")}, {key: key, healthErr: errors.New("an error")}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := mustMultiKMS(t, tt.replicas...)
+			if err := k.HealthCheck(); (err != nil) != tt.wantErr {
+				t.Errorf("MultiKMS.HealthCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMultiKMS_ValidateName(t *testing.T) {
+	key := mustKey(t)
+
+	// Replicas that are not a NameValidator are skipped.
+	k := mustMultiKMS(t, &fakeKMS{key: key})
+	k.replicas = append(k.replicas, &replica{index: 1, typ: fakeType, km: struct{ apiv1.KeyManager }{}})
+	if err := k.ValidateName("fake:name=my-key"); err != nil {
+		t.Errorf("MultiKMS.ValidateName() error = %v, want nil", err)
+	}
+
+	tests := []struct {
+		name     string
+		replicas []*fakeKMS
+		wantErr  bool
+	}{
+		{"ok", []*fakeKMS{{key: key}, {key: key}}, false},
+		{"fail first", []*fakeKMS{{key: key, validErr: errors.New("an error")}, {key: key}}, true},
+		{"fail second", []*fakeKMS{{key: key}, {key: key, validErr: errors.New("an error")}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := mustMultiKMS(t, tt.replicas...)
+			if err := k.ValidateName("fake:name=my-key"); (err != nil) != tt.wantErr {
+				t.Errorf("MultiKMS.ValidateName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMultiKMS_Close(t *testing.T) {
+	key := mustKey(t)
+	tests := []struct {
+		name     string
+		replicas []*fakeKMS
+		wantErr  bool
+	}{
+		{"ok", []*fakeKMS{{key: key}, {key: key}}, false},
+		{"fail", []*fakeKMS{{key: key, closeErr: errors.New("an error")}, {key: key}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := mustMultiKMS(t, tt.replicas...)
+			if err := k.Close(); (err != nil) != tt.wantErr {
+				t.Errorf("MultiKMS.Close() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for i, f := range tt.replicas {
+				if !f.closed {
+					t.Errorf("replica #%d was not closed", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package multikms
+
+import (
+	"crypto"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// signer implements a crypto.Signer using the same key in multiple replicas.
+// Signatures are created by the first replica that succeeds.
+type signer struct {
+	signers []*replicaSigner
+}
+
+// replicaSigner is the signer of a replica.
+type replicaSigner struct {
+	crypto.Signer
+	replica *replica
+}
+
+// Public returns the public key of the signer, it is the same in all the
+// replicas.
+func (s *signer) Public() crypto.PublicKey {
+	return s.signers[0].Public()
+}
+
+// Sign signs the digest with the first replica, if it fails, it tries with the
+// next one.
+func (s *signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var err error
+	for _, rs := range s.signers {
+		var signature []byte
+		if signature, err = rs.Sign(rand, digest, opts); err == nil {
+			return signature, nil
+		}
+		err = errors.Wrapf(err, "error signing with %s", rs.replica)
+	}
+	return nil, err
+}
@@ -3,11 +3,13 @@ package kms
 import (
 	"bytes"
 	"encoding/pem"
+	"errors"
 	"io"
 	"io/fs"
 	"sync"
 	"time"
 
+	"go.step.sm/crypto/kms/apiv1"
 	"go.step.sm/crypto/pemutil"
 )
 
@@ -17,12 +19,13 @@ type object struct {
 	Object  interface{}
 	once    sync.Once
 	err     error
+	size    int64
 	pemData *bytes.Buffer
 }
 
 // FileMode implementation
 func (o *object) Name() string       { return o.Path }
-func (o *object) Size() int64        { return int64(o.pemData.Len()) }
+func (o *object) Size() int64        { return o.size }
 func (o *object) Mode() fs.FileMode  { return 0400 }
 func (o *object) ModTime() time.Time { return time.Time{} }
 func (o *object) IsDir() bool        { return false }
@@ -40,6 +43,7 @@ func (o *object) load() error {
 			return
 		}
 		o.pemData = bytes.NewBuffer(pem.EncodeToMemory(b))
+		o.size = int64(o.pemData.Len())
 	})
 	return o.err
 }
@@ -67,3 +71,93 @@ func (o *object) Close() error {
 	}
 	return o.err
 }
+
+// keyEntry implements the fs.DirEntry and fs.FileInfo interfaces for a key
+// returned by ListKeys.
+type keyEntry struct {
+	*apiv1.KeyInfo
+	open func(string) (fs.File, error)
+	size int64
+}
+
+// DirEntry and FileInfo implementation
+func (e *keyEntry) Name() string       { return e.KeyInfo.Name }
+func (e *keyEntry) Size() int64        { return e.size }
+func (e *keyEntry) Mode() fs.FileMode  { return 0400 }
+func (e *keyEntry) ModTime() time.Time { return time.Time{} }
+func (e *keyEntry) IsDir() bool        { return false }
+func (e *keyEntry) Sys() interface{}   { return e.KeyInfo }
+func (e *keyEntry) Type() fs.FileMode  { return 0 }
+
+// Info opens the key to get the size of the file, so it matches the FileInfo
+// returned by Stat.
+func (e *keyEntry) Info() (fs.FileInfo, error) {
+	f, err := e.open(e.KeyInfo.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	e.size = info.Size()
+	return e, nil
+}
+
+// dir implements the fs.ReadDirFile and fs.FileInfo interfaces for the root of
+// a KMS. It allows to use fs.Stat and fs.WalkDir with a KMS.
+type dir struct {
+	Path    string
+	list    func() ([]fs.DirEntry, error)
+	entries []fs.DirEntry
+	loaded  bool
+}
+
+// FileInfo implementation
+func (d *dir) Name() string       { return d.Path }
+func (d *dir) Size() int64        { return 0 }
+func (d *dir) Mode() fs.FileMode  { return fs.ModeDir | 0500 }
+func (d *dir) ModTime() time.Time { return time.Time{} }
+func (d *dir) IsDir() bool        { return true }
+func (d *dir) Sys() interface{}   { return nil }
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d, nil
+}
+
+func (d *dir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "read",
+		Path: d.Path,
+		Err:  errors.New("is a directory"),
+	}
+}
+
+// ReadDir returns the next n entries in the directory, or all the remaining
+// entries if n <= 0.
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.list()
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+	if n <= 0 || n >= len(d.entries) {
+		entries := d.entries
+		d.entries = nil
+		if n > 0 && len(entries) == 0 {
+			return nil, io.EOF
+		}
+		return entries, nil
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *dir) Close() error {
+	d.entries = nil
+	return nil
+}
@@ -35,6 +35,7 @@ func Test_object_FileMode(t *testing.T) {
 	type fields struct {
 		Path    string
 		Object  interface{}
+		size    int64
 		pemData *bytes.Buffer
 	}
 	tests := []struct {
@@ -47,13 +48,14 @@ func Test_object_FileMode(t *testing.T) {
 		wantIsDir   bool
 		wantSys     interface{}
 	}{
-		{"ok", fields{"path", pub, pemData}, "path", int64(pemData.Len()), 0400, time.Time{}, false, pub},
+		{"ok", fields{"path", pub, int64(pemData.Len()), pemData}, "path", int64(pemData.Len()), 0400, time.Time{}, false, pub},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			o := &object{
 				Path:    tt.fields.Path,
 				Object:  tt.fields.Object,
+				size:    tt.fields.size,
 				pemData: tt.fields.pemData,
 			}
 			if got := o.Name(); got != tt.wantName {
@@ -124,6 +126,7 @@ func Test_object_Stat(t *testing.T) {
 		{"ok", fields{"path", pub}, &object{
 			Path:    "path",
 			Object:  pub,
+			size:    int64(pemData.Len()),
 			pemData: pemData,
 		}, false},
 		{"fail", fields{"path", "not a key"}, nil, true},
@@ -193,7 +196,7 @@ func Test_object_Read(t *testing.T) {
 }
 
 func Test_object_Close(t *testing.T) {
-	pub, _ := generateKey(t)
+	pub, pemData := generateKey(t)
 	o := &object{
 		Path:   "path",
 		Object: pub,
@@ -211,12 +214,14 @@ func Test_object_Close(t *testing.T) {
 		{"ok", o, &object{
 			Path:    "path",
 			Object:  nil,
+			size:    int64(pemData.Len()),
 			pemData: nil,
 			err:     io.EOF,
 		}, false},
 		{"eof", o, &object{
 			Path:    "path",
 			Object:  nil,
+			size:    int64(pemData.Len()),
 			pemData: nil,
 			err:     io.EOF,
 		}, true},
@@ -4,9 +4,11 @@
 package pkcs11
 
 import (
+	"context"
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"net/url"
 	"testing"
 
 	"go.step.sm/crypto/kms/apiv1"
@@ -24,6 +26,26 @@ func benchmarkSign(b *testing.B, signer crypto.Signer, opts crypto.SignerOpts) {
 	b.StopTimer()
 }
 
+// benchmarkSignParallel signs from multiple goroutines, the throughput depends
+// on the number of sessions available in the pool, see the max-sessions uri
+// attribute. Use -cpu to change the number of goroutines.
+func benchmarkSignParallel(b *testing.B, signer crypto.Signer, opts crypto.SignerOpts) {
+	hash := opts.HashFunc()
+	h := hash.New()
+	h.Write([]byte("buggy-coheir-RUBRIC-rabbet-liberal-eaglet-khartoum-stagger"))
+	digest := h.Sum(nil)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := signer.Sign(rand.Reader, digest, opts); err != nil {
+				b.Errorf("crypto.Signer.Sign() error = %v", err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+}
+
 func BenchmarkSignRSA(b *testing.B) {
 	k := setupPKCS11(b)
 	signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{
@@ -81,3 +103,70 @@ func BenchmarkSignP521(b *testing.B) {
 	}
 	benchmarkSign(b, signer, crypto.SHA512)
 }
+
+// sessionOptions are the uri attributes used by the parallel benchmarks to
+// compare the default session pool with a small one.
+var sessionOptions = []string{
+	"",
+	"max-sessions=2",
+	"max-sessions=2;pool-wait-timeout=1s",
+}
+
+// newPKCS11WithOptions returns a new KMS created with the uri of the test
+// module and the given attributes. The in-memory module does not have a pool
+// of sessions, so the default KMS is used if no attributes are given and the
+// benchmark is skipped otherwise.
+func newPKCS11WithOptions(b *testing.B, attrs string) *PKCS11 {
+	b.Helper()
+	k := setupPKCS11(b)
+	if attrs == "" {
+		return k
+	}
+	if testModuleURI == "" {
+		b.Skipf("%s does not support the uri attributes %s", testModule, attrs)
+	}
+
+	u, err := url.Parse(testModuleURI)
+	if err != nil {
+		b.Fatalf("url.Parse() error = %v", err)
+	}
+	u.Opaque += ";" + attrs
+	km, err := New(context.Background(), apiv1.Options{
+		Type: apiv1.PKCS11,
+		URI:  u.String(),
+	})
+	if err != nil {
+		b.Fatalf("New() error = %v", err)
+	}
+	b.Cleanup(func() {
+		km.Close()
+	})
+	return km
+}
+
+func benchmarkSignParallelKey(b *testing.B, name string, opts crypto.SignerOpts) {
+	for _, attrs := range sessionOptions {
+		title := attrs
+		if title == "" {
+			title = "default"
+		}
+		b.Run(title, func(b *testing.B) {
+			k := newPKCS11WithOptions(b, attrs)
+			signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{
+				SigningKey: name,
+			})
+			if err != nil {
+				b.Fatalf("PKCS11.CreateSigner() error = %v", err)
+			}
+			benchmarkSignParallel(b, signer, opts)
+		})
+	}
+}
+
+func BenchmarkSignParallelRSA(b *testing.B) {
+	benchmarkSignParallelKey(b, "pkcs11:id=7371;object=rsa-key", crypto.SHA256)
+}
+
+func BenchmarkSignParallelP256(b *testing.B) {
+	benchmarkSignParallelKey(b, "pkcs11:id=7373;object=ecdsa-p256-key", crypto.SHA256)
+}
@@ -0,0 +1,445 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/asn1"
+	"io"
+	"sync"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+// Key type and mechanisms for EdDSA keys defined in PKCS #11 v3.0, they are
+// not available in github.com/miekg/pkcs11.
+const (
+	ckkECEdwards           = 0x00000040
+	ckmECEdwardsKeyPairGen = 0x00001055
+	ckmEdDSA               = 0x00001057
+)
+
+// oidEd25519 is the curve identifier used in the CKA_EC_PARAMS attribute of
+// Ed25519 keys.
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// edwardsSession defines the raw PKCS #11 operations used with Ed25519 keys,
+// they are not available in crypto11. This interface will be used for unit
+// testing.
+type edwardsSession interface {
+	FindObject(id, label []byte, class uint) (pkcs11.ObjectHandle, error)
+	FindObjects(template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error)
+	GetAttributeValue(h pkcs11.ObjectHandle, template []*pkcs11.Attribute) ([]*pkcs11.Attribute, error)
+	GenerateKeyPair(mech []*pkcs11.Mechanism, public, private []*pkcs11.Attribute) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error)
+	Sign(mech []*pkcs11.Mechanism, key pkcs11.ObjectHandle, message []byte) ([]byte, error)
+	DestroyObject(h pkcs11.ObjectHandle) error
+	Close() error
+}
+
+var openEdwardsSession = func(config *crypto11.Config) (edwardsSession, error) {
+	return openSession(config)
+}
+
+// findKeyPairs returns the key pairs with the given attributes using crypto11.
+// It is used for testing purposes.
+var findKeyPairs = func(ctx *crypto11.Context, attributes crypto11.AttributeSet) ([]crypto11.Signer, error) {
+	return ctx.FindKeyPairsWithAttributes(attributes)
+}
+
+// crypto11KeyTypes are the key types supported by crypto11.
+var crypto11KeyTypes = []uint{pkcs11.CKK_RSA, pkcs11.CKK_EC, pkcs11.CKK_DSA}
+
+// p11Context extends crypto11.Context with support for Ed25519 keys. These
+// keys are not supported by crypto11, so they are generated and used with a
+// session opened directly with the PKCS #11 module.
+//
+// Opening a session loads the module and logs in, so the session used to sign
+// is kept open and shared by all the Ed25519 keys. A PKCS #11 session cannot
+// be used concurrently, and Ed25519 signatures are serialized.
+type p11Context struct {
+	*crypto11.Context
+	config    *crypto11.Config
+	mu        sync.RWMutex
+	closed    bool
+	sessionMu sync.Mutex
+	session   edwardsSession
+}
+
+func newP11Context(config *crypto11.Config) (*p11Context, error) {
+	ctx, err := crypto11.Configure(config)
+	if err != nil {
+		return nil, err
+	}
+	return &p11Context{
+		Context: ctx,
+		config:  config,
+	}, nil
+}
+
+// FindKeyPair retrieves a previously created key pair, or nil if it cannot be
+// found. crypto11 fails to load key pairs with an unknown key type, in that
+// case the key pair is searched as an Ed25519 key.
+func (c *p11Context) FindKeyPair(id, label []byte) (crypto11.Signer, error) {
+	signer, err := c.Context.FindKeyPair(id, label)
+	var e pkcs11.Error
+	if err == nil || errors.As(err, &e) {
+		return signer, err
+	}
+	if key, edErr := c.findEdwardsKey(id, label); edErr == nil && key != nil {
+		return key, nil
+	}
+	return nil, err
+}
+
+// FindAllKeyPairs retrieves all the key pairs in the token. crypto11 fails to
+// list the key pairs if the token has a key with a type that it does not
+// support, so the key pairs are searched by the key types supported by
+// crypto11, and the Ed25519 keys are searched directly with the module. Keys
+// of other types are ignored.
+func (c *p11Context) FindAllKeyPairs() ([]crypto11.Signer, error) {
+	var signers []crypto11.Signer
+	for _, keyType := range crypto11KeyTypes {
+		attributes := crypto11.NewAttributeSet()
+		if err := attributes.Set(crypto11.CkaKeyType, keyType); err != nil {
+			return nil, err
+		}
+		keys, err := findKeyPairs(c.Context, attributes)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, keys...)
+	}
+
+	keys, err := c.findEdwardsKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		signers = append(signers, key)
+	}
+	return signers, nil
+}
+
+// GenerateEd25519KeyPairWithAttributes creates an Ed25519 key pair on the token
+// using CKM_EC_EDWARDS_KEY_PAIR_GEN. The given attributes are added to the
+// public and private key templates.
+func (c *p11Context) GenerateEd25519KeyPairWithAttributes(public, private crypto11.AttributeSet) (crypto11.Signer, error) {
+	params, err := asn1.Marshal(oidEd25519)
+	if err != nil {
+		return nil, errors.Wrap(err, "error marshaling ec params")
+	}
+
+	s, err := c.openSession()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	public.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EC_PARAMS, params),
+	})
+	private.AddIfNotPresent([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	})
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(ckmECEdwardsKeyPairGen, nil)}
+	pubHandle, _, err := s.GenerateKeyPair(mech, public.ToSlice(), private.ToSlice())
+	if err != nil {
+		return nil, err
+	}
+	pub, err := edwardsPublicKey(s, pubHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &edwardsKey{
+		ctx:       c,
+		id:        attributeSetValue(private, crypto11.CkaId),
+		label:     attributeSetValue(private, crypto11.CkaLabel),
+		publicKey: pub,
+	}, nil
+}
+
+// GetAttributes gets the values of the attributes of the given key. For
+// Ed25519 keys the attributes are read from the private key.
+func (c *p11Context) GetAttributes(key interface{}, attributes []crypto11.AttributeType) (crypto11.AttributeSet, error) {
+	k, ok := key.(*edwardsKey)
+	if !ok {
+		return c.Context.GetAttributes(key, attributes)
+	}
+
+	s, err := c.openSession()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	h, err := s.FindObject(k.id, k.label, pkcs11.CKO_PRIVATE_KEY)
+	if err != nil {
+		return nil, err
+	}
+	template := make([]*pkcs11.Attribute, len(attributes))
+	for i, typ := range attributes {
+		template[i] = pkcs11.NewAttribute(uint(typ), nil)
+	}
+	values, err := s.GetAttributeValue(h, template)
+	if err != nil {
+		return nil, err
+	}
+	set := crypto11.NewAttributeSet()
+	for _, v := range values {
+		set[crypto11.AttributeType(v.Type)] = v
+	}
+	return set, nil
+}
+
+// Close closes the crypto11 context and the shared session, Ed25519 keys
+// cannot be used after it.
+func (c *p11Context) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	c.sessionMu.Lock()
+	if c.session != nil {
+		_ = c.session.Close()
+		c.session = nil
+	}
+	c.sessionMu.Unlock()
+
+	return c.Context.Close()
+}
+
+// withSession runs fn using the shared session, opening it if necessary. The
+// session is closed and discarded if fn fails with a session error, so the
+// next call will open a new one.
+func (c *p11Context) withSession(fn func(s edwardsSession) error) error {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+
+	if c.session == nil {
+		s, err := c.openSession()
+		if err != nil {
+			return err
+		}
+		c.session = s
+	}
+
+	err := fn(c.session)
+	if err != nil && isSessionError(err) {
+		_ = c.session.Close()
+		c.session = nil
+	}
+	return err
+}
+
+// openSession opens a new session with the PKCS #11 module.
+func (c *p11Context) openSession() (edwardsSession, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return nil, errors.New("pkcs#11 context is closed")
+	}
+	return openEdwardsSession(c.config)
+}
+
+// findEdwardsKey returns the Ed25519 key pair with the given id and label, or
+// nil if it cannot be found.
+func (c *p11Context) findEdwardsKey(id, label []byte) (*edwardsKey, error) {
+	s, err := c.openSession()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	privHandle, err := s.FindObject(id, label, pkcs11.CKO_PRIVATE_KEY)
+	if err != nil {
+		if errors.Is(err, apiv1.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return c.loadEdwardsKey(s, privHandle)
+}
+
+// findEdwardsKeys returns all the Ed25519 key pairs in the token.
+func (c *p11Context) findEdwardsKeys() ([]*edwardsKey, error) {
+	s, err := c.openSession()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	handles, err := s.FindObjects([]*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*edwardsKey
+	for _, h := range handles {
+		key, err := c.loadEdwardsKey(s, h)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// loadEdwardsKey returns the Ed25519 key pair of the given private key, or nil
+// if it is not an Ed25519 key or the public key cannot be found. Like in
+// crypto11, the public key is searched using the CKA_ID of the private key.
+func (c *p11Context) loadEdwardsKey(s edwardsSession, privHandle pkcs11.ObjectHandle) (*edwardsKey, error) {
+	attrs, err := s.GetAttributeValue(privHandle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ID, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(attrs[0].Value) == 0 || !isEdwardsKeyType(attrs[2].Value) {
+		return nil, nil
+	}
+
+	pubHandle, err := s.FindObject(attrs[0].Value, nil, pkcs11.CKO_PUBLIC_KEY)
+	if err != nil {
+		if errors.Is(err, apiv1.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	pub, err := edwardsPublicKey(s, pubHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &edwardsKey{
+		ctx:       c,
+		id:        attrs[0].Value,
+		label:     attrs[1].Value,
+		publicKey: pub,
+	}, nil
+}
+
+// edwardsKey implements crypto11.Signer for an Ed25519 key in the PKCS #11
+// module. Signatures use the shared session of the context, other operations
+// open a new session.
+type edwardsKey struct {
+	ctx       *p11Context
+	id, label []byte
+	publicKey ed25519.PublicKey
+}
+
+// Public returns the public key.
+func (k *edwardsKey) Public() crypto.PublicKey {
+	return k.publicKey
+}
+
+// Sign signs the message using CKM_EDDSA. As with ed25519.PrivateKey, the
+// message must not be hashed, Ed25519ph is not supported.
+func (k *edwardsKey) Sign(rand io.Reader, message []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.Hash(0) {
+		return nil, errors.New("ed25519: cannot sign hashed message")
+	}
+
+	var signature []byte
+	err := k.ctx.withSession(func(s edwardsSession) error {
+		h, err := s.FindObject(k.id, k.label, pkcs11.CKO_PRIVATE_KEY)
+		if err != nil {
+			return err
+		}
+		signature, err = s.Sign([]*pkcs11.Mechanism{pkcs11.NewMechanism(ckmEdDSA, nil)}, h, message)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signature, nil
+}
+
+// Delete removes the private and public keys from the token.
+func (k *edwardsKey) Delete() error {
+	s, err := k.ctx.openSession()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	for _, o := range []struct {
+		label []byte
+		class uint
+	}{{k.label, pkcs11.CKO_PRIVATE_KEY}, {nil, pkcs11.CKO_PUBLIC_KEY}} {
+		h, err := s.FindObject(k.id, o.label, o.class)
+		if err != nil {
+			if errors.Is(err, apiv1.ErrNotFound) {
+				continue
+			}
+			return err
+		}
+		if err := s.DestroyObject(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// edwardsPublicKey returns the Ed25519 public key of the given public key
+// object. CKA_EC_POINT must be a DER-encoded OCTET STRING, but some modules
+// return the raw point.
+func edwardsPublicKey(s edwardsSession, h pkcs11.ObjectHandle) (ed25519.PublicKey, error) {
+	attrs, err := s.GetAttributeValue(h, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	point := attrs[0].Value
+	if len(point) != ed25519.PublicKeySize {
+		var b []byte
+		if rest, err := asn1.Unmarshal(point, &b); err != nil || len(rest) > 0 {
+			return nil, errors.New("error parsing ed25519 public key: invalid ec point")
+		}
+		point = b
+	}
+	if len(point) != ed25519.PublicKeySize {
+		return nil, errors.New("error parsing ed25519 public key: invalid ec point")
+	}
+	return ed25519.PublicKey(point), nil
+}
+
+// isEdwardsKeyType returns true if the given CKA_KEY_TYPE value is
+// CKK_EC_EDWARDS.
+func isEdwardsKeyType(v []byte) bool {
+	return bytes.Equal(v, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards).Value)
+}
+
+// attributeSetValue returns the value of the given attribute or nil if it is
+// not in the set.
+func attributeSetValue(set crypto11.AttributeSet, typ crypto11.AttributeType) []byte {
+	if a, ok := set[typ]; ok {
+		return a.Value
+	}
+	return nil
+}
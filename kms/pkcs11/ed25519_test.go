@@ -0,0 +1,556 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/asn1"
+	"reflect"
+	"testing"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+type stubEdwardsObject struct {
+	attributes []*pkcs11.Attribute
+	key        ed25519.PrivateKey
+}
+
+// stubEdwardsSession is an in-memory implementation of edwardsSession.
+type stubEdwardsSession struct {
+	objects map[pkcs11.ObjectHandle]*stubEdwardsObject
+	next    pkcs11.ObjectHandle
+	err     error
+	mech    []*pkcs11.Mechanism
+	closed  bool
+}
+
+func newStubEdwardsSession(t *testing.T) *stubEdwardsSession {
+	t.Helper()
+	s := &stubEdwardsSession{
+		objects: map[pkcs11.ObjectHandle]*stubEdwardsObject{},
+		next:    1,
+	}
+	// An ECDSA key that must be ignored.
+	s.add(&stubEdwardsObject{attributes: []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, []byte("\x01")),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, []byte("ecdsa")),
+	}})
+	return s
+}
+
+func (s *stubEdwardsSession) add(o *stubEdwardsObject) pkcs11.ObjectHandle {
+	h := s.next
+	s.objects[h] = o
+	s.next++
+	return h
+}
+
+// addKey adds an Ed25519 key pair with the given id and label, the public key
+// is stored with the given CKA_EC_POINT encoding function.
+func (s *stubEdwardsSession) addKey(t *testing.T, id, label string, encode func(ed25519.PublicKey) []byte) ed25519.PrivateKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.add(&stubEdwardsObject{attributes: []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, []byte(id)),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, []byte(label)),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	}, key: priv})
+	if encode != nil {
+		s.add(&stubEdwardsObject{attributes: []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards),
+			pkcs11.NewAttribute(pkcs11.CKA_ID, []byte(id)),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, []byte(label)),
+			pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, encode(pub)),
+		}})
+	}
+	return priv
+}
+
+func derPoint(pub ed25519.PublicKey) []byte {
+	b, _ := asn1.Marshal([]byte(pub))
+	return b
+}
+
+func rawPoint(pub ed25519.PublicKey) []byte {
+	return pub
+}
+
+func (s *stubEdwardsSession) FindObject(id, label []byte, class uint) (pkcs11.ObjectHandle, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	classValue := pkcs11.NewAttribute(pkcs11.CKA_CLASS, class).Value
+	for h := pkcs11.ObjectHandle(1); h < s.next; h++ {
+		o, ok := s.objects[h]
+		if !ok || !bytes.Equal(attributeValue(o.attributes, pkcs11.CKA_CLASS), classValue) {
+			continue
+		}
+		if len(id) > 0 && !bytes.Equal(attributeValue(o.attributes, pkcs11.CKA_ID), id) {
+			continue
+		}
+		if len(label) > 0 && !bytes.Equal(attributeValue(o.attributes, pkcs11.CKA_LABEL), label) {
+			continue
+		}
+		return h, nil
+	}
+	return 0, apiv1.NotFoundError{}
+}
+
+func (s *stubEdwardsSession) FindObjects(template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	var handles []pkcs11.ObjectHandle
+	for h := pkcs11.ObjectHandle(1); h < s.next; h++ {
+		o, ok := s.objects[h]
+		if !ok {
+			continue
+		}
+		match := true
+		for _, a := range template {
+			if !bytes.Equal(attributeValue(o.attributes, a.Type), a.Value) {
+				match = false
+				break
+			}
+		}
+		if match {
+			handles = append(handles, h)
+		}
+	}
+	return handles, nil
+}
+
+func (s *stubEdwardsSession) GetAttributeValue(h pkcs11.ObjectHandle, template []*pkcs11.Attribute) ([]*pkcs11.Attribute, error) {
+	o, ok := s.objects[h]
+	if !ok {
+		return nil, pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	values := make([]*pkcs11.Attribute, len(template))
+	for i, a := range template {
+		v := attributeValue(o.attributes, a.Type)
+		if v == nil {
+			return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_TYPE_INVALID)
+		}
+		values[i] = &pkcs11.Attribute{Type: a.Type, Value: v}
+	}
+	return values, nil
+}
+
+func (s *stubEdwardsSession) GenerateKeyPair(mech []*pkcs11.Mechanism, public, private []*pkcs11.Attribute) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	s.mech = mech
+	if s.err != nil {
+		return 0, 0, s.err
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return 0, 0, err
+	}
+	pubHandle := s.add(&stubEdwardsObject{
+		attributes: append(public, pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, derPoint(pub))),
+	})
+	privHandle := s.add(&stubEdwardsObject{attributes: private, key: priv})
+	return pubHandle, privHandle, nil
+}
+
+func (s *stubEdwardsSession) Sign(mech []*pkcs11.Mechanism, key pkcs11.ObjectHandle, message []byte) ([]byte, error) {
+	s.mech = mech
+	o, ok := s.objects[key]
+	if !ok || o.key == nil {
+		return nil, pkcs11.Error(pkcs11.CKR_KEY_HANDLE_INVALID)
+	}
+	return ed25519.Sign(o.key, message), nil
+}
+
+func (s *stubEdwardsSession) DestroyObject(h pkcs11.ObjectHandle) error {
+	if _, ok := s.objects[h]; !ok {
+		return pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	delete(s.objects, h)
+	return nil
+}
+
+func (s *stubEdwardsSession) Close() error {
+	s.closed = true
+	return nil
+}
+
+func mustEdwardsContext(t *testing.T, s *stubEdwardsSession, openErr error) *p11Context {
+	t.Helper()
+	tmp := openEdwardsSession
+	t.Cleanup(func() {
+		openEdwardsSession = tmp
+	})
+	openEdwardsSession = func(config *crypto11.Config) (edwardsSession, error) {
+		if openErr != nil {
+			return nil, openErr
+		}
+		s.closed = false
+		return s, nil
+	}
+	return &p11Context{config: &crypto11.Config{Path: "module.so", TokenLabel: "token", Pin: "password"}}
+}
+
+func TestP11Context_GenerateEd25519KeyPairWithAttributes(t *testing.T) {
+	tests := []struct {
+		name    string
+		genErr  error
+		openErr error
+		closed  bool
+		wantErr bool
+	}{
+		{"ok", nil, nil, false, false},
+		{"fail generate", pkcs11.Error(pkcs11.CKR_MECHANISM_INVALID), nil, false, true},
+		{"fail open", nil, errors.New("an error"), false, true},
+		{"fail closed", nil, nil, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStubEdwardsSession(t)
+			s.err = tt.genErr
+			c := mustEdwardsContext(t, s, tt.openErr)
+			c.closed = tt.closed
+
+			public, err := crypto11.NewAttributeSetWithIDAndLabel([]byte("\x02"), []byte("ed25519"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			private := public.Copy()
+			got, err := c.GenerateEd25519KeyPairWithAttributes(public, private)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("p11Context.GenerateEd25519KeyPairWithAttributes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(s.mech) != 1 || s.mech[0].Mechanism != ckmECEdwardsKeyPairGen {
+				t.Errorf("p11Context.GenerateEd25519KeyPairWithAttributes() mechanism = %v, want %#x", s.mech, ckmECEdwardsKeyPairGen)
+			}
+			params, _ := asn1.Marshal(oidEd25519)
+			if v := attributeSetValue(public, crypto11.CkaEcParams); !bytes.Equal(v, params) {
+				t.Errorf("p11Context.GenerateEd25519KeyPairWithAttributes() CKA_EC_PARAMS = %x, want %x", v, params)
+			}
+			if v, want := attributeSetValue(private, crypto11.CkaKeyType), pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, ckkECEdwards).Value; !bytes.Equal(v, want) {
+				t.Errorf("p11Context.GenerateEd25519KeyPairWithAttributes() CKA_KEY_TYPE = %x, want %x", v, want)
+			}
+			if !s.closed {
+				t.Error("p11Context.GenerateEd25519KeyPairWithAttributes() session was not closed")
+			}
+
+			// The new key can be found and used.
+			key, err := c.findEdwardsKey([]byte("\x02"), []byte("ed25519"))
+			if err != nil {
+				t.Fatalf("p11Context.findEdwardsKey() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, key) {
+				t.Errorf("p11Context.findEdwardsKey() = %v, want %v", key, got)
+			}
+			sig, err := got.Sign(rand.Reader, []byte("message"), crypto.Hash(0))
+			if err != nil {
+				t.Fatalf("edwardsKey.Sign() error = %v", err)
+			}
+			if !ed25519.Verify(got.Public().(ed25519.PublicKey), []byte("message"), sig) {
+				t.Error("ed25519.Verify() failed")
+			}
+		})
+	}
+}
+
+func TestP11Context_findEdwardsKey(t *testing.T) {
+	s := newStubEdwardsSession(t)
+	der := s.addKey(t, "\x02", "der", derPoint)
+	raw := s.addKey(t, "\x03", "raw", rawPoint)
+	s.addKey(t, "\x04", "no-public", nil)
+	s.addKey(t, "\x05", "bad-point", func(ed25519.PublicKey) []byte { return []byte{1, 2, 3} })
+
+	type args struct {
+		id, label []byte
+	}
+	tests := []struct {
+		name    string
+		openErr error
+		args    args
+		want    crypto.PublicKey
+		wantNil bool
+		wantErr bool
+	}{
+		{"ok der", nil, args{[]byte("\x02"), []byte("der")}, der.Public(), false, false},
+		{"ok raw", nil, args{nil, []byte("raw")}, raw.Public(), false, false},
+		{"ok by id", nil, args{[]byte("\x02"), nil}, der.Public(), false, false},
+		{"ok not found", nil, args{[]byte("\x09"), nil}, nil, true, false},
+		{"ok not ed25519", nil, args{[]byte("\x01"), []byte("ecdsa")}, nil, true, false},
+		{"ok no public key", nil, args{[]byte("\x04"), []byte("no-public")}, nil, true, false},
+		{"fail point", nil, args{[]byte("\x05"), []byte("bad-point")}, nil, true, true},
+		{"fail open", errors.New("an error"), args{[]byte("\x02"), []byte("der")}, nil, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mustEdwardsContext(t, s, tt.openErr)
+			got, err := c.findEdwardsKey(tt.args.id, tt.args.label)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("p11Context.findEdwardsKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("p11Context.findEdwardsKey() = %v, want nil", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got.Public(), tt.want) {
+				t.Errorf("p11Context.findEdwardsKey() public key = %v, want %v", got.Public(), tt.want)
+			}
+		})
+	}
+}
+
+func TestEdwardsKey_Sign(t *testing.T) {
+	s := newStubEdwardsSession(t)
+	priv := s.addKey(t, "\x02", "ed25519", derPoint)
+	message := []byte("message")
+
+	tests := []struct {
+		name    string
+		openErr error
+		closed  bool
+		id      []byte
+		opts    crypto.SignerOpts
+		wantErr bool
+	}{
+		{"ok", nil, false, []byte("\x02"), crypto.Hash(0), false},
+		{"fail hashed", nil, false, []byte("\x02"), crypto.SHA512, true},
+		{"fail missing", nil, false, []byte("\x09"), crypto.Hash(0), true},
+		{"fail open", errors.New("an error"), false, []byte("\x02"), crypto.Hash(0), true},
+		{"fail closed", nil, true, []byte("\x02"), crypto.Hash(0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mustEdwardsContext(t, s, tt.openErr)
+			c.closed = tt.closed
+			k := &edwardsKey{
+				ctx:       c,
+				id:        tt.id,
+				label:     []byte("ed25519"),
+				publicKey: priv.Public().(ed25519.PublicKey),
+			}
+			got, err := k.Sign(rand.Reader, message, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("edwardsKey.Sign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := ed25519.Sign(priv, message); !bytes.Equal(got, want) {
+				t.Errorf("edwardsKey.Sign() = %x, want %x", got, want)
+			}
+			if len(s.mech) != 1 || s.mech[0].Mechanism != ckmEdDSA {
+				t.Errorf("edwardsKey.Sign() mechanism = %v, want %#x", s.mech, ckmEdDSA)
+			}
+		})
+	}
+}
+
+func TestEdwardsKey_Sign_session(t *testing.T) {
+	s := newStubEdwardsSession(t)
+	priv := s.addKey(t, "\x02", "ed25519", derPoint)
+	c := mustEdwardsContext(t, s, nil)
+
+	var opened int
+	openEdwardsSession = func(config *crypto11.Config) (edwardsSession, error) {
+		opened++
+		s.closed = false
+		return s, nil
+	}
+
+	k := &edwardsKey{
+		ctx:       c,
+		id:        []byte("\x02"),
+		label:     []byte("ed25519"),
+		publicKey: priv.Public().(ed25519.PublicKey),
+	}
+	sign := func() error {
+		_, err := k.Sign(rand.Reader, []byte("message"), crypto.Hash(0))
+		return err
+	}
+
+	// The session is shared by all signatures.
+	for i := 0; i < 3; i++ {
+		if err := sign(); err != nil {
+			t.Fatalf("edwardsKey.Sign() error = %v", err)
+		}
+	}
+	if opened != 1 || s.closed {
+		t.Errorf("edwardsKey.Sign() opened %d sessions, closed = %v, want 1 and false", opened, s.closed)
+	}
+
+	// Other errors do not close the session.
+	s.err = errors.New("an error")
+	if err := sign(); err == nil {
+		t.Error("edwardsKey.Sign() error = nil, wantErr true")
+	}
+	if s.closed || c.session == nil {
+		t.Error("edwardsKey.Sign() session was closed")
+	}
+
+	// A session error discards the session, and the next signature opens a
+	// new one.
+	s.err = pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+	if err := sign(); err == nil {
+		t.Error("edwardsKey.Sign() error = nil, wantErr true")
+	}
+	if !s.closed || c.session != nil {
+		t.Error("edwardsKey.Sign() session was not discarded")
+	}
+	s.err = nil
+	if err := sign(); err != nil {
+		t.Fatalf("edwardsKey.Sign() error = %v", err)
+	}
+	if opened != 2 {
+		t.Errorf("edwardsKey.Sign() opened %d sessions, want 2", opened)
+	}
+}
+
+func TestEdwardsKey_Delete(t *testing.T) {
+	s := newStubEdwardsSession(t)
+	s.addKey(t, "\x02", "ed25519", derPoint)
+	c := mustEdwardsContext(t, s, nil)
+
+	key, err := c.findEdwardsKey([]byte("\x02"), []byte("ed25519"))
+	if err != nil || key == nil {
+		t.Fatalf("p11Context.findEdwardsKey() = %v, %v", key, err)
+	}
+	if err := key.Delete(); err != nil {
+		t.Fatalf("edwardsKey.Delete() error = %v", err)
+	}
+	if len(s.objects) != 1 {
+		t.Errorf("edwardsKey.Delete() objects = %d, want 1", len(s.objects))
+	}
+	if key, err := c.findEdwardsKey([]byte("\x02"), []byte("ed25519")); err != nil || key != nil {
+		t.Errorf("p11Context.findEdwardsKey() = %v, %v, want nil", key, err)
+	}
+	// Deleting the key again is not an error.
+	if err := key.Delete(); err != nil {
+		t.Errorf("edwardsKey.Delete() error = %v", err)
+	}
+
+	c.closed = true
+	if err := key.Delete(); err == nil {
+		t.Error("edwardsKey.Delete() error = nil, wantErr true")
+	}
+}
+
+func TestP11Context_GetAttributes(t *testing.T) {
+	s := newStubEdwardsSession(t)
+	s.addKey(t, "\x02", "ed25519", derPoint)
+	c := mustEdwardsContext(t, s, nil)
+
+	key, err := c.findEdwardsKey([]byte("\x02"), []byte("ed25519"))
+	if err != nil || key == nil {
+		t.Fatalf("p11Context.findEdwardsKey() = %v, %v", key, err)
+	}
+	got, err := c.GetAttributes(key, []crypto11.AttributeType{crypto11.CkaId, crypto11.CkaLabel, crypto11.CkaExtractable})
+	if err != nil {
+		t.Fatalf("p11Context.GetAttributes() error = %v", err)
+	}
+	for typ, want := range map[crypto11.AttributeType][]byte{
+		crypto11.CkaId:          []byte("\x02"),
+		crypto11.CkaLabel:       []byte("ed25519"),
+		crypto11.CkaExtractable: {0},
+	} {
+		if v := attributeSetValue(got, typ); !bytes.Equal(v, want) {
+			t.Errorf("p11Context.GetAttributes() %#x = %x, want %x", typ, v, want)
+		}
+	}
+
+	if _, err := c.GetAttributes(key, []crypto11.AttributeType{crypto11.CkaModulus}); err == nil {
+		t.Error("p11Context.GetAttributes() error = nil, wantErr true")
+	}
+}
+
+// stubKeyPair is a crypto11.Signer returned by the stub of findKeyPairs.
+type stubKeyPair struct {
+	crypto.Signer
+}
+
+func (k *stubKeyPair) Delete() error {
+	return nil
+}
+
+func TestP11Context_FindAllKeyPairs(t *testing.T) {
+	s := newStubEdwardsSession(t)
+	ed := s.addKey(t, "\x02", "ed25519", derPoint)
+	s.addKey(t, "\x03", "no-public", nil)
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Like crypto11, findKeyPairs fails if it finds a key type that it does
+	// not support.
+	tmp := findKeyPairs
+	t.Cleanup(func() {
+		findKeyPairs = tmp
+	})
+	findKeyPairs = func(_ *crypto11.Context, attributes crypto11.AttributeSet) ([]crypto11.Signer, error) {
+		if err := attributes.Set(crypto11.CkaClass, pkcs11.CKO_PRIVATE_KEY); err != nil {
+			return nil, err
+		}
+		handles, err := s.FindObjects(attributes.ToSlice())
+		if err != nil {
+			return nil, err
+		}
+		var signers []crypto11.Signer
+		for _, h := range handles {
+			keyType := attributeValue(s.objects[h].attributes, pkcs11.CKA_KEY_TYPE)
+			if !bytes.Equal(keyType, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC).Value) {
+				return nil, errors.Errorf("unsupported key type: %x", keyType)
+			}
+			signers = append(signers, &stubKeyPair{ec})
+		}
+		return signers, nil
+	}
+
+	c := mustEdwardsContext(t, s, nil)
+	if _, err := findKeyPairs(nil, crypto11.NewAttributeSet()); err == nil {
+		t.Fatal("findKeyPairs() error = nil, wantErr true")
+	}
+
+	got, err := c.FindAllKeyPairs()
+	if err != nil {
+		t.Fatalf("p11Context.FindAllKeyPairs() error = %v", err)
+	}
+	want := []crypto.PublicKey{ec.Public(), ed.Public()}
+	if len(got) != len(want) {
+		t.Fatalf("p11Context.FindAllKeyPairs() len = %d, want %d", len(got), len(want))
+	}
+	for i, key := range got {
+		if !reflect.DeepEqual(key.Public(), want[i]) {
+			t.Errorf("p11Context.FindAllKeyPairs()[%d] public key = %v, want %v", i, key.Public(), want[i])
+		}
+	}
+
+	s.err = errors.New("an error")
+	if _, err := c.FindAllKeyPairs(); err == nil {
+		t.Error("p11Context.FindAllKeyPairs() error = nil, wantErr true")
+	}
+}
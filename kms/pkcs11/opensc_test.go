@@ -41,7 +41,8 @@ func mustPKCS11(t TBTesting) *PKCS11 {
 		return nil
 	}
 	var zero int
-	p11, err := crypto11.Configure(&crypto11.Config{
+	testModuleURI = "pkcs11:module-path=" + path + ";slot-id=0?pin-value=123456"
+	p11, err := newP11Context(&crypto11.Config{
 		Path:       path,
 		SlotNumber: &zero,
 		Pin:        "123456",
@@ -4,27 +4,48 @@
 package pkcs11
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"io"
 	"math/big"
+	"sync"
 
 	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
 	"github.com/pkg/errors"
 )
 
+// mustPKCS11 configures a *PKCS11 KMS using an in-memory PKCS #11 module. This
+// module is used when no other module is selected using build tags.
 func mustPKCS11(t TBTesting) *PKCS11 {
 	t.Helper()
 	testModule = "Golang crypto"
+	p11 := newFakePKCS11()
 	k := &PKCS11{
-		p11: &stubPKCS11{
-			signerIndex: make(map[keyType]int),
-			certIndex:   make(map[keyType]int),
-		},
+		p11: p11,
+	}
+	tmp := newGCM
+	t.Cleanup(func() {
+		newGCM = tmp
+	})
+	newGCM = func(key *crypto11.SecretKey) (cipher.AEAD, error) {
+		b, err := p11.secretValue(key)
+		if err != nil {
+			return nil, err
+		}
+		block, err := aes.NewCipher(b)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCMWithNonceSize(block, crypto11.DefaultGCMIVLength)
 	}
 	for i := range testCerts {
 		testCerts[i].Certificates = nil
@@ -34,177 +55,512 @@ func mustPKCS11(t TBTesting) *PKCS11 {
 	return k
 }
 
-type keyType struct {
-	id     string
-	label  string
-	serial string
+// errClosed is the error returned by crypto11 when a closed context is used.
+var errClosed = errors.New("cannot used closed Context")
+
+// errSessionLost is the error returned by the fake module after calling
+// loseSession.
+var errSessionLost = pkcs11.Error(pkcs11.CKR_SESSION_HANDLE_INVALID)
+
+type objectClass int
+
+const (
+	classKeyPair objectClass = iota
+	classSecretKey
+	classCertificate
+)
+
+// fakeObject is an object stored in the token of the fake PKCS #11 module.
+type fakeObject struct {
+	class      objectClass
+	attributes crypto11.AttributeSet
+	key        crypto.Signer
+	secretKey  *crypto11.SecretKey
+	value      []byte
+	cert       *x509.Certificate
 }
 
-func newKey(id, label []byte, serial *big.Int) keyType {
-	var serialString string
-	if serial != nil {
-		serialString = serial.String()
+func (o *fakeObject) matches(class objectClass, id, label []byte, serial *big.Int) bool {
+	if o.class != class {
+		return false
+	}
+	if id != nil && !o.hasAttribute(crypto11.CkaId, id) {
+		return false
 	}
-	return keyType{
-		id:     string(id),
-		label:  string(label),
-		serial: serialString,
+	if label != nil && !o.hasAttribute(crypto11.CkaLabel, label) {
+		return false
 	}
+	if serial != nil && (o.cert == nil || o.cert.SerialNumber.Cmp(serial) != 0) {
+		return false
+	}
+	return true
 }
 
-type stubPKCS11 struct {
-	signers     []crypto11.Signer
-	certs       []*x509.Certificate
-	signerIndex map[keyType]int
-	certIndex   map[keyType]int
+func (o *fakeObject) hasAttribute(typ crypto11.AttributeType, value []byte) bool {
+	a, ok := o.attributes[typ]
+	return ok && bytes.Equal(a.Value, value)
+}
+
+// fakeToken holds the objects of the fake PKCS #11 module. The token is shared
+// by all the contexts opened with fakePKCS11.reopen.
+type fakeToken struct {
+	mu      sync.Mutex
+	objects []*fakeObject
+}
+
+func (t *fakeToken) find(class objectClass, id, label []byte, serial *big.Int) *fakeObject {
+	for _, o := range t.objects {
+		if o.matches(class, id, label, serial) {
+			return o
+		}
+	}
+	return nil
+}
+
+func (t *fakeToken) lookup(fn func(o *fakeObject) bool) (int, *fakeObject) {
+	for i, o := range t.objects {
+		if fn(o) {
+			return i, o
+		}
+	}
+	return -1, nil
+}
+
+func (t *fakeToken) destroy(i int) {
+	t.objects = append(t.objects[:i], t.objects[i+1:]...)
+}
+
+// fakePKCS11 is an in-memory implementation of the P11 interface. Keys are
+// generated with the Go crypto packages and all the objects are stored with
+// their attributes, so they can be found by id, label or serial number like in
+// a real PKCS #11 module.
+type fakePKCS11 struct {
+	*fakeToken
+	closed bool
+	lost   bool
+}
+
+func newFakePKCS11() *fakePKCS11 {
+	return &fakePKCS11{
+		fakeToken: &fakeToken{},
+	}
+}
+
+// reopen returns a new context to the same token.
+func (p *fakePKCS11) reopen() *fakePKCS11 {
+	return &fakePKCS11{
+		fakeToken: p.fakeToken,
+	}
+}
+
+// loseSession makes all the operations in the context fail with a session
+// error.
+func (p *fakePKCS11) loseSession() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lost = true
+}
+
+// check returns an error if the context is closed or the session is lost. It
+// must be called with the lock held.
+func (p *fakePKCS11) check() error {
+	switch {
+	case p.closed:
+		return errClosed
+	case p.lost:
+		return errSessionLost
+	default:
+		return nil
+	}
 }
 
-func (s *stubPKCS11) FindKeyPair(id, label []byte) (crypto11.Signer, error) {
+// newKeyPair returns the key pair for the given key bound to this context.
+func (p *fakePKCS11) newKeyPair(key crypto.Signer) crypto11.Signer {
+	k := &fakeKeyPair{Signer: key, p11: p}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return &fakeRSAKeyPair{fakeKeyPair: k}
+	}
+	return k
+}
+
+func (p *fakePKCS11) FindKeyPair(id, label []byte) (crypto11.Signer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return nil, err
+	}
 	if id == nil && label == nil {
 		return nil, errors.New("id and label cannot both be nil")
 	}
-	if i, ok := s.signerIndex[newKey(id, label, nil)]; ok {
-		return s.signers[i], nil
+	if o := p.find(classKeyPair, id, label, nil); o != nil {
+		return p.newKeyPair(o.key), nil
 	}
 	return nil, nil
 }
 
-func (s *stubPKCS11) FindCertificate(id, label []byte, serial *big.Int) (*x509.Certificate, error) {
-	if id == nil && label == nil && serial == nil {
-		return nil, errors.New("id, label and serial cannot both be nil")
+func (p *fakePKCS11) FindAllKeyPairs() ([]crypto11.Signer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	var signers []crypto11.Signer
+	for _, o := range p.objects {
+		if o.class == classKeyPair {
+			signers = append(signers, p.newKeyPair(o.key))
+		}
+	}
+	return signers, nil
+}
+
+func (p *fakePKCS11) FindKey(id, label []byte) (*crypto11.SecretKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	if id == nil && label == nil {
+		return nil, errors.New("id and label cannot both be nil")
 	}
-	if i, ok := s.certIndex[newKey(id, label, serial)]; ok {
-		return s.certs[i], nil
+	if o := p.find(classSecretKey, id, label, nil); o != nil {
+		return o.secretKey, nil
 	}
 	return nil, nil
+}
+
+// GetAttributes returns the requested attributes of a key pair or a secret
+// key. As in crypto11, the attributes of a key pair are the ones in the
+// private key.
+func (p *fakePKCS11) GetAttributes(key interface{}, attributes []crypto11.AttributeType) (crypto11.AttributeSet, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+
+	var fn func(o *fakeObject) bool
+	switch k := key.(type) {
+	case *fakeKeyPair:
+		fn = func(o *fakeObject) bool { return o.key == k.Signer }
+	case *fakeRSAKeyPair:
+		fn = func(o *fakeObject) bool { return o.key == k.Signer }
+	case *crypto11.SecretKey:
+		fn = func(o *fakeObject) bool { return o.secretKey == k }
+	default:
+		return nil, errors.New("not a PKCS#11 key")
+	}
+
+	_, o := p.lookup(fn)
+	if o == nil {
+		return nil, pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
 
+	set := crypto11.NewAttributeSet()
+	for _, typ := range attributes {
+		a, ok := o.attributes[typ]
+		if !ok {
+			return nil, pkcs11.Error(pkcs11.CKR_ATTRIBUTE_TYPE_INVALID)
+		}
+		set[typ] = crypto11.CopyAttribute(a)
+	}
+	return set, nil
 }
 
-func (s *stubPKCS11) ImportCertificateWithAttributes(template crypto11.AttributeSet, cert *x509.Certificate) error {
-	var id, label []byte
-	if v := template[crypto11.CkaId]; v != nil {
-		id = v.Value
+func (p *fakePKCS11) FindCertificate(id, label []byte, serial *big.Int) (*x509.Certificate, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	if id == nil && label == nil && serial == nil {
+		return nil, errors.New("id, label and serial cannot all be nil")
 	}
-	if v := template[crypto11.CkaLabel]; v != nil {
-		label = v.Value
+	if o := p.find(classCertificate, id, label, serial); o != nil {
+		return o.cert, nil
 	}
-	return s.ImportCertificateWithLabel(id, label, cert)
+	return nil, nil
 }
 
-func (s *stubPKCS11) ImportCertificateWithLabel(id, label []byte, cert *x509.Certificate) error {
-	switch {
-	case id == nil:
-		return errors.New("id cannot both be nil")
-	case label == nil:
-		return errors.New("label cannot both be nil")
-	case cert == nil:
+func (p *fakePKCS11) ImportCertificateWithAttributes(template crypto11.AttributeSet, cert *x509.Certificate) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return err
+	}
+	if cert == nil {
 		return errors.New("certificate cannot be nil")
 	}
 
-	i := len(s.certs)
-	s.certs = append(s.certs, cert)
-	s.certIndex[newKey(id, label, cert.SerialNumber)] = i
-	s.certIndex[newKey(id, nil, nil)] = i
-	s.certIndex[newKey(nil, label, nil)] = i
-	s.certIndex[newKey(nil, nil, cert.SerialNumber)] = i
-	s.certIndex[newKey(id, label, nil)] = i
-	s.certIndex[newKey(id, nil, cert.SerialNumber)] = i
-	s.certIndex[newKey(nil, label, cert.SerialNumber)] = i
+	attributes := template.Copy()
+	if err := setDefaults(attributes, map[crypto11.AttributeType]interface{}{
+		crypto11.CkaToken:       true,
+		crypto11.CkaPrivate:     false,
+		crypto11.CkaExtractable: false,
+		crypto11.CkaValue:       cert.Raw,
+	}); err != nil {
+		return err
+	}
 
+	p.objects = append(p.objects, &fakeObject{
+		class:      classCertificate,
+		attributes: attributes,
+		cert:       cert,
+	})
 	return nil
 }
 
-func (s *stubPKCS11) DeleteCertificate(id, label []byte, serial *big.Int) error {
+// DeleteCertificate deletes the first certificate matching the given id,
+// label and serial. Like crypto11, it does not fail if the certificate does
+// not exist.
+func (p *fakePKCS11) DeleteCertificate(id, label []byte, serial *big.Int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return err
+	}
 	if id == nil && label == nil && serial == nil {
-		return errors.New("id, label and serial cannot both be nil")
+		return errors.New("id, label and serial cannot all be nil")
 	}
-	if i, ok := s.certIndex[newKey(id, label, serial)]; ok {
-		s.certs[i] = nil
+	if i, _ := p.lookup(func(o *fakeObject) bool {
+		return o.matches(classCertificate, id, label, serial)
+	}); i >= 0 {
+		p.destroy(i)
 	}
 	return nil
 }
 
-func (s *stubPKCS11) GenerateRSAKeyPairWithAttributes(public, private crypto11.AttributeSet, bits int) (crypto11.SignerDecrypter, error) {
-	var id, label []byte
-	if v := public[crypto11.CkaId]; v != nil {
-		id = v.Value
+// deleteSecretKey deletes the secret key with the given id and label. The
+// fake module does not return real crypto11 secret keys, so they cannot be
+// deleted with crypto11.SecretKey.Delete.
+func (p *fakePKCS11) deleteSecretKey(id, label []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return err
 	}
-	if v := public[crypto11.CkaLabel]; v != nil {
-		label = v.Value
+	if i, _ := p.lookup(func(o *fakeObject) bool {
+		return o.matches(classSecretKey, id, label, nil)
+	}); i >= 0 {
+		p.destroy(i)
 	}
-	return s.GenerateRSAKeyPairWithLabel(id, label, bits)
+	return nil
 }
 
-func (s *stubPKCS11) GenerateRSAKeyPairWithLabel(id, label []byte, bits int) (crypto11.SignerDecrypter, error) {
-	if id == nil && label == nil {
-		return nil, errors.New("id and label cannot both be nil")
+func (p *fakePKCS11) GenerateRSAKeyPairWithAttributes(public, private crypto11.AttributeSet, bits int) (crypto11.SignerDecrypter, error) {
+	// Like many modules, only the default public exponent is supported.
+	if a, ok := public[crypto11.CkaPublicExponent]; ok && !bytes.Equal(a.Value, []byte{1, 0, 1}) {
+		return nil, pkcs11.Error(pkcs11.CKR_TEMPLATE_INCONSISTENT)
 	}
-	p, err := rsa.GenerateKey(rand.Reader, bits)
+	key, err := rsa.GenerateKey(rand.Reader, bits)
 	if err != nil {
 		return nil, err
 	}
-	k := &privateKey{
-		Signer: p,
-		index:  len(s.signers),
-		stub:   s,
+	if err := p.storeKeyPair(key, public, private); err != nil {
+		return nil, err
 	}
-	s.signers = append(s.signers, k)
-	s.signerIndex[newKey(id, label, nil)] = k.index
-	s.signerIndex[newKey(id, nil, nil)] = k.index
-	s.signerIndex[newKey(nil, label, nil)] = k.index
-	return k, nil
+	return &fakeRSAKeyPair{
+		fakeKeyPair: &fakeKeyPair{Signer: key, p11: p},
+	}, nil
 }
 
-func (s *stubPKCS11) GenerateECDSAKeyPairWithAttributes(public, private crypto11.AttributeSet, curve elliptic.Curve) (crypto11.Signer, error) {
-	var id, label []byte
-	if v := public[crypto11.CkaId]; v != nil {
-		id = v.Value
+func (p *fakePKCS11) GenerateECDSAKeyPairWithAttributes(public, private crypto11.AttributeSet, curve elliptic.Curve) (crypto11.Signer, error) {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
 	}
-	if v := public[crypto11.CkaLabel]; v != nil {
-		label = v.Value
+	if err := p.storeKeyPair(key, public, private); err != nil {
+		return nil, err
 	}
-	return s.GenerateECDSAKeyPairWithLabel(id, label, curve)
+	return &fakeKeyPair{Signer: key, p11: p}, nil
 }
 
-func (s *stubPKCS11) GenerateECDSAKeyPairWithLabel(id, label []byte, curve elliptic.Curve) (crypto11.Signer, error) {
-	if id == nil && label == nil {
+func (p *fakePKCS11) GenerateEd25519KeyPairWithAttributes(public, private crypto11.AttributeSet) (crypto11.Signer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	// ed25519.PrivateKey is a slice, a pointer is used so keys can be compared.
+	key := &fakeEd25519Key{PrivateKey: priv}
+	if err := p.storeKeyPair(key, public, private); err != nil {
+		return nil, err
+	}
+	return &fakeKeyPair{Signer: key, p11: p}, nil
+}
+
+// storeKeyPair stores a new key pair using the attributes of the private key.
+// Private keys are sensitive and not extractable unless the template says
+// otherwise.
+func (p *fakePKCS11) storeKeyPair(key crypto.Signer, public, private crypto11.AttributeSet) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return err
+	}
+	if !hasIDOrLabel(public) || !hasIDOrLabel(private) {
+		return errors.New("id and label cannot both be nil")
+	}
+
+	attributes := private.Copy()
+	if err := setDefaults(attributes, map[crypto11.AttributeType]interface{}{
+		crypto11.CkaToken:       true,
+		crypto11.CkaPrivate:     true,
+		crypto11.CkaSensitive:   true,
+		crypto11.CkaExtractable: false,
+	}); err != nil {
+		return err
+	}
+
+	p.objects = append(p.objects, &fakeObject{
+		class:      classKeyPair,
+		attributes: attributes,
+		key:        key,
+	})
+	return nil
+}
+
+func (p *fakePKCS11) GenerateSecretKeyWithLabel(id, label []byte, bits int, cipher *crypto11.SymmetricCipher) (*crypto11.SecretKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	switch {
+	case id == nil && label == nil:
 		return nil, errors.New("id and label cannot both be nil")
+	case cipher != crypto11.CipherAES:
+		return nil, errors.New("only AES keys are supported")
 	}
-	p, err := ecdsa.GenerateKey(curve, rand.Reader)
+
+	attributes, err := crypto11.NewAttributeSetWithIDAndLabel(id, label)
 	if err != nil {
 		return nil, err
 	}
-	k := &privateKey{
-		Signer: p,
-		index:  len(s.signers),
-		stub:   s,
+	if err := setDefaults(attributes, map[crypto11.AttributeType]interface{}{
+		crypto11.CkaToken:       true,
+		crypto11.CkaSensitive:   true,
+		crypto11.CkaExtractable: false,
+	}); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, bits/8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
 	}
-	s.signers = append(s.signers, k)
-	s.signerIndex[newKey(id, label, nil)] = k.index
-	s.signerIndex[newKey(id, nil, nil)] = k.index
-	s.signerIndex[newKey(nil, label, nil)] = k.index
+	k := &crypto11.SecretKey{Cipher: cipher}
+	p.objects = append(p.objects, &fakeObject{
+		class:      classSecretKey,
+		attributes: attributes,
+		secretKey:  k,
+		value:      b,
+	})
 	return k, nil
 }
 
-func (s *stubPKCS11) Close() error {
+// secretValue returns the value of a secret key. It is used to build the
+// cipher.AEAD in the tests.
+func (p *fakePKCS11) secretValue(key *crypto11.SecretKey) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	_, o := p.lookup(func(o *fakeObject) bool { return o.secretKey == key })
+	if o == nil {
+		return nil, errors.New("secret key not found")
+	}
+	return o.value, nil
+}
+
+func (p *fakePKCS11) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errClosed
+	}
+	p.closed = true
 	return nil
 }
 
-type privateKey struct {
-	crypto.Signer
-	index int
-	stub  *stubPKCS11
+// checkKey returns an error if the context cannot be used or if the key is not
+// in the token.
+func (p *fakePKCS11) checkKey(key crypto.Signer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return err
+	}
+	if i, _ := p.lookup(func(o *fakeObject) bool { return o.key == key }); i < 0 {
+		return pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	return nil
 }
 
-func (s *privateKey) Delete() error {
-	s.stub.signers[s.index] = nil
+func (p *fakePKCS11) deleteKey(key crypto.Signer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.check(); err != nil {
+		return err
+	}
+	i, _ := p.lookup(func(o *fakeObject) bool { return o.key == key })
+	if i < 0 {
+		return pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID)
+	}
+	p.destroy(i)
 	return nil
 }
 
-func (s *privateKey) Decrypt(rnd io.Reader, msg []byte, opts crypto.DecrypterOpts) (plaintext []byte, err error) {
-	k, ok := s.Signer.(*rsa.PrivateKey)
-	if !ok {
-		return nil, errors.New("key is not an rsa key")
+// fakeKeyPair is a crypto11.Signer backed by a key in the fake module.
+type fakeKeyPair struct {
+	crypto.Signer
+	p11 *fakePKCS11
+}
+
+// Delete removes the key pair from the token.
+func (k *fakeKeyPair) Delete() error {
+	return k.p11.deleteKey(k.Signer)
+}
+
+// Sign signs the digest if the key can be used.
+func (k *fakeKeyPair) Sign(rnd io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if err := k.p11.checkKey(k.Signer); err != nil {
+		return nil, err
+	}
+	return k.Signer.Sign(rnd, digest, opts)
+}
+
+// fakeRSAKeyPair is a crypto11.SignerDecrypter backed by an RSA key in the
+// fake module.
+type fakeRSAKeyPair struct {
+	*fakeKeyPair
+}
+
+// Decrypt decrypts the ciphertext if the key can be used.
+func (k *fakeRSAKeyPair) Decrypt(rnd io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if err := k.p11.checkKey(k.Signer); err != nil {
+		return nil, err
+	}
+	return k.Signer.(*rsa.PrivateKey).Decrypt(rnd, msg, opts)
+}
+
+// fakeEd25519Key is an Ed25519 key stored in the fake module.
+type fakeEd25519Key struct {
+	ed25519.PrivateKey
+}
+
+func hasIDOrLabel(set crypto11.AttributeSet) bool {
+	return set[crypto11.CkaId] != nil || set[crypto11.CkaLabel] != nil
+}
+
+// setDefaults sets the given attributes if they are not already in the set.
+func setDefaults(set crypto11.AttributeSet, defaults map[crypto11.AttributeType]interface{}) error {
+	for typ, value := range defaults {
+		if _, ok := set[typ]; ok {
+			continue
+		}
+		if err := set.Set(typ, value); err != nil {
+			return err
+		}
 	}
-	return k.Decrypt(rnd, msg, opts)
+	return nil
 }
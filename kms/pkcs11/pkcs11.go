@@ -6,16 +6,21 @@ package pkcs11
 import (
 	"context"
 	"crypto"
+	"crypto/cipher"
 	"crypto/elliptic"
-	"crypto/rsa"
+	"crypto/rand"
 	"crypto/x509"
 	"encoding/hex"
 	"fmt"
 	"math/big"
+	"net/url"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
 	"github.com/pkg/errors"
 	"go.step.sm/crypto/kms/apiv1"
 	"go.step.sm/crypto/kms/uri"
@@ -24,14 +29,35 @@ import (
 // Scheme is the scheme used in uris.
 const Scheme = "pkcs11"
 
+// keySchema defines the attributes allowed in the URIs, the attributes defined
+// in RFC 7512 and the ones used to configure the module, like
+// pkcs11:token=smallstep;id=7331;object=my-key?module-path=/usr/lib/softhsm.so&pin-value=pass.
+var keySchema = &uri.Schema{
+	Scheme: Scheme,
+	Path: []string{
+		"token", "manufacturer", "model", "serial",
+		"library-manufacturer", "library-description", "library-version",
+		"slot-manufacturer", "slot-description", "slot-id",
+		"type", "id", "object",
+	},
+	Query: []string{
+		"module-name", "module-path", "pin-source", "pin-value",
+		"max-sessions", "pool-wait-timeout", "login-not-supported",
+	},
+}
+
 // DefaultRSASize is the number of bits of a new RSA key if no size has been
 // specified.
 const DefaultRSASize = 3072
 
-// P11 defines the methods on crypto11.Context that this package will use. This
-// interface will be used for unit testing.
+// P11 defines the methods on crypto11.Context that this package will use,
+// extended with the support for Ed25519 keys. This interface will be used for
+// unit testing.
 type P11 interface {
 	FindKeyPair(id, label []byte) (crypto11.Signer, error)
+	FindAllKeyPairs() ([]crypto11.Signer, error)
+	FindKey(id, label []byte) (*crypto11.SecretKey, error)
+	GetAttributes(key interface{}, attributes []crypto11.AttributeType) (crypto11.AttributeSet, error)
 	FindCertificate(id, label []byte, serial *big.Int) (*x509.Certificate, error)
 	ImportCertificateWithAttributes(template crypto11.AttributeSet, certificate *x509.Certificate) error
 	DeleteCertificate(id, label []byte, serial *big.Int) error
@@ -40,17 +66,44 @@ type P11 interface {
 	Close() error
 }
 
+// ed25519KeyGenerator is implemented by the P11 contexts that can generate
+// Ed25519 keys, crypto11 does not support them.
+type ed25519KeyGenerator interface {
+	GenerateEd25519KeyPairWithAttributes(public, private crypto11.AttributeSet) (crypto11.Signer, error)
+}
+
 var p11Configure = func(config *crypto11.Config) (P11, error) {
-	return crypto11.Configure(config)
+	return newP11Context(config)
+}
+
+// newGCM returns the AES-GCM cipher.AEAD of a secret key. It is used for
+// testing purposes.
+var newGCM = func(key *crypto11.SecretKey) (cipher.AEAD, error) {
+	return key.NewGCM()
 }
 
 // PKCS11 is the implementation of a KMS using the PKCS #11 standard.
 type PKCS11 struct {
 	p11    P11
 	closed sync.Once
+	mu     sync.RWMutex
+	config *crypto11.Config
+	hooks  *apiv1.ConnectionHooks
 }
 
-// New returns a new PKCS11 KMS.
+// New returns a new PKCS11 KMS. The uri must contain the module-path and the
+// token, serial or slot-id of the token to use, the pin can be set with the
+// pin-value or pin-source attributes, or in the options. See
+// uri.RegisterPinSource for the supported pin sources. The following
+// attributes can be used to tune the pool of sessions used by crypto11:
+//
+//   - max-sessions: the maximum number of concurrent sessions, at least 2. By
+//     default crypto11 uses 1024, limited by the maximum supported by the
+//     token.
+//   - pool-wait-timeout: the time an operation waits for a free session, for
+//     example "500ms", by default it waits until a session is available.
+//   - login-not-supported: set to true for tokens that do not support logging
+//     in, the pin is not required.
 func New(ctx context.Context, opts apiv1.Options) (*PKCS11, error) {
 	var config crypto11.Config
 	if opts.URI != "" {
@@ -59,7 +112,9 @@ func New(ctx context.Context, opts apiv1.Options) (*PKCS11, error) {
 			return nil, err
 		}
 
-		config.Pin = u.Pin()
+		if config.Pin, err = u.ReadPin(); err != nil {
+			return nil, errors.Wrap(err, "error reading kms uri 'pin-source'")
+		}
 		config.Path = u.Get("module-path")
 		config.TokenLabel = u.Get("token")
 		config.TokenSerial = u.Get("serial")
@@ -70,6 +125,21 @@ func New(ctx context.Context, opts apiv1.Options) (*PKCS11, error) {
 			}
 			config.SlotNumber = &n
 		}
+		if v := u.Get("max-sessions"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || (n != 0 && n < 2) {
+				return nil, errors.New("kms uri 'max-sessions' is not valid")
+			}
+			config.MaxSessions = n
+		}
+		if v := u.Get("pool-wait-timeout"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return nil, errors.New("kms uri 'pool-wait-timeout' is not valid")
+			}
+			config.PoolWaitTimeout = d
+		}
+		config.LoginNotSupported = u.GetBool("login-not-supported")
 	}
 	if config.Pin == "" && opts.Pin != "" {
 		config.Pin = opts.Pin
@@ -80,7 +150,7 @@ func New(ctx context.Context, opts apiv1.Options) (*PKCS11, error) {
 		return nil, errors.New("kms uri 'module-path' are required")
 	case config.TokenLabel == "" && config.TokenSerial == "" && config.SlotNumber == nil:
 		return nil, errors.New("kms uri 'token', 'serial' or 'slot-id' are required")
-	case config.Pin == "":
+	case config.Pin == "" && !config.LoginNotSupported:
 		return nil, errors.New("kms 'pin' cannot be empty")
 	case config.TokenLabel != "" && config.TokenSerial != "":
 		return nil, errors.New("kms uri 'token' and 'serial' are mutually exclusive")
@@ -92,15 +162,18 @@ func New(ctx context.Context, opts apiv1.Options) (*PKCS11, error) {
 
 	p11, err := p11Configure(&config)
 	if err != nil {
-		return nil, errors.Wrap(err, "error initializing PKCS#11")
+		return nil, errors.Wrap(convertError(err), "error initializing PKCS#11")
 	}
 
 	return &PKCS11{
-		p11: p11,
+		p11:    p11,
+		config: &config,
+		hooks:  opts.Hooks,
 	}, nil
 }
 
 func init() {
+	uri.RegisterSchema(keySchema)
 	apiv1.Register(apiv1.PKCS11, func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
 		return New(ctx, opts)
 	})
@@ -112,9 +185,9 @@ func (k *PKCS11) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey,
 		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
 	}
 
-	signer, err := findSigner(k.p11, req.Name)
+	signer, err := findSigner(k.module(), req.Name)
 	if err != nil {
-		return nil, errors.Wrap(err, "getPublicKey failed")
+		return nil, errors.Wrap(convertError(err), "getPublicKey failed")
 	}
 
 	return signer.Public(), nil
@@ -129,9 +202,9 @@ func (k *PKCS11) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespons
 		return nil, errors.New("createKeyRequest 'bits' cannot be negative")
 	}
 
-	signer, err := generateKey(k.p11, req)
+	signer, err := generateKey(k.module(), req)
 	if err != nil {
-		return nil, errors.Wrap(err, "createKey failed")
+		return nil, errors.Wrap(convertError(err), "createKey failed")
 	}
 
 	return &apiv1.CreateKeyResponse{
@@ -149,11 +222,16 @@ func (k *PKCS11) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, er
 		return nil, errors.New("createSignerRequest 'signingKey' cannot be empty")
 	}
 
-	signer, err := findSigner(k.p11, req.SigningKey)
+	signer, err := newSigner(k, req.SigningKey)
 	if err != nil {
-		return nil, errors.Wrap(err, "createSigner failed")
+		return nil, errors.Wrap(convertError(err), "createSigner failed")
 	}
 
+	// RSA keys also implement the crypto.Decrypter interface, as the crypto11
+	// keys do.
+	if d, ok := newDecrypter(signer); ok {
+		return d, nil
+	}
 	return signer, nil
 }
 
@@ -164,16 +242,13 @@ func (k *PKCS11) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Decr
 		return nil, errors.New("createDecrypterRequest 'decryptionKey' cannot be empty")
 	}
 
-	signer, err := findSigner(k.p11, req.DecryptionKey)
+	signer, err := newSigner(k, req.DecryptionKey)
 	if err != nil {
-		return nil, errors.Wrap(err, "createDecrypterRequest failed")
+		return nil, errors.Wrap(convertError(err), "createDecrypterRequest failed")
 	}
 
-	// Only RSA keys will implement the Decrypter interface.
-	if _, ok := signer.Public().(*rsa.PublicKey); ok {
-		if dec, ok := signer.(crypto.Decrypter); ok {
-			return dec, nil
-		}
+	if d, ok := newDecrypter(signer); ok {
+		return d, nil
 	}
 	return nil, errors.New("createDecrypterRequest failed: signer does not implement crypto.Decrypter")
 }
@@ -184,9 +259,9 @@ func (k *PKCS11) LoadCertificate(req *apiv1.LoadCertificateRequest) (*x509.Certi
 	if req.Name == "" {
 		return nil, errors.New("loadCertificateRequest 'name' cannot be nil")
 	}
-	cert, err := findCertificate(k.p11, req.Name)
+	cert, err := findCertificate(k.module(), req.Name)
 	if err != nil {
-		return nil, errors.Wrap(err, "loadCertificate failed")
+		return nil, errors.Wrap(convertError(err), "loadCertificate failed")
 	}
 	return cert, nil
 }
@@ -203,7 +278,7 @@ func (k *PKCS11) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
 
 	id, object, err := parseObject(req.Name)
 	if err != nil {
-		return errors.Wrap(err, "storeCertificate failed")
+		return errors.Wrap(convertError(err), "storeCertificate failed")
 	}
 
 	// Enforce the use of both id and labels. This is not strictly necessary in
@@ -212,9 +287,9 @@ func (k *PKCS11) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
 		return errors.Errorf("key with uri %s is not valid, id and object are required", req.Name)
 	}
 
-	cert, err := k.p11.FindCertificate(id, object, nil)
+	cert, err := k.module().FindCertificate(id, object, nil)
 	if err != nil {
-		return errors.Wrap(err, "storeCertificate failed")
+		return errors.Wrap(convertError(err), "storeCertificate failed")
 	}
 	if cert != nil {
 		return errors.Wrap(apiv1.AlreadyExistsError{
@@ -225,57 +300,199 @@ func (k *PKCS11) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
 	// Import certificate with the necessary attributes.
 	template, err := crypto11.NewAttributeSetWithIDAndLabel(id, object)
 	if err != nil {
-		return errors.Wrap(err, "storeCertificate failed")
+		return errors.Wrap(convertError(err), "storeCertificate failed")
 	}
 	if req.Extractable {
 		template.Set(crypto11.CkaExtractable, true)
 	}
-	if err := k.p11.ImportCertificateWithAttributes(template, req.Certificate); err != nil {
-		return errors.Wrap(err, "storeCertificate failed")
+	if err := k.module().ImportCertificateWithAttributes(template, req.Certificate); err != nil {
+		return errors.Wrap(convertError(err), "storeCertificate failed")
 	}
 
 	return nil
 }
 
-// DeleteKey is a utility function to delete a key given an uri.
-func (k *PKCS11) DeleteKey(u string) error {
-	id, object, err := parseObject(u)
+// ListKeys returns the key pairs present in the PKCS#11 module. Keys are
+// identified by their CKA_ID and CKA_LABEL attributes, and the name filter in
+// the request is matched against the label.
+func (k *PKCS11) ListKeys(req *apiv1.ListKeysRequest) (*apiv1.ListKeysResponse, error) {
+	p11 := k.module()
+	signers, err := p11.FindAllKeyPairs()
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "listKeys failed")
+	}
+
+	var keys []*apiv1.KeyInfo
+	for _, signer := range signers {
+		attrs, err := p11.GetAttributes(signer, []crypto11.AttributeType{
+			crypto11.CkaId, crypto11.CkaLabel,
+		})
+		if err != nil {
+			return nil, errors.Wrap(convertError(err), "listKeys failed")
+		}
+		var id, label []byte
+		if v := attrs[crypto11.CkaId]; v != nil {
+			id = v.Value
+		}
+		if v := attrs[crypto11.CkaLabel]; v != nil {
+			label = v.Value
+		}
+		if !req.MatchKey(string(label), nil) {
+			continue
+		}
+		keys = append(keys, &apiv1.KeyInfo{
+			Name:               newObjectURI(id, label),
+			SignatureAlgorithm: apiv1.SignatureAlgorithmForKey(signer.Public()),
+			ProtectionLevel:    apiv1.HSM,
+		})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+
+	return req.Paginate(keys)
+}
+
+// DeleteKey deletes the key pair with the given uri.
+func (k *PKCS11) DeleteKey(req *apiv1.DeleteKeyRequest) error {
+	id, object, err := parseObject(req.Name)
 	if err != nil {
-		return errors.Wrap(err, "deleteKey failed")
+		return errors.Wrap(convertError(err), "deleteKey failed")
 	}
-	signer, err := k.p11.FindKeyPair(id, object)
+	signer, err := k.module().FindKeyPair(id, object)
 	if err != nil {
-		return errors.Wrap(err, "deleteKey failed")
+		return errors.Wrap(convertError(err), "deleteKey failed")
 	}
 	if signer == nil {
 		return nil
 	}
 	if err := signer.Delete(); err != nil {
-		return errors.Wrap(err, "deleteKey failed")
+		return errors.Wrap(convertError(err), "deleteKey failed")
 	}
 	return nil
 }
 
+// Encrypt encrypts the given plaintext using CKM_AES_GCM with the AES key with
+// the given uri. The returned ciphertext is prefixed with the random IV used.
+func (k *PKCS11) Encrypt(req *apiv1.EncryptRequest) ([]byte, error) {
+	aead, err := findSecretKey(k.module(), req.Name)
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "encrypt failed")
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(convertError(err), "encrypt failed")
+	}
+
+	ciphertext, err := seal(aead, nonce, req.Plaintext, req.AdditionalData)
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "encrypt failed")
+	}
+	return ciphertext, nil
+}
+
+// Decrypt decrypts the given ciphertext, as returned by Encrypt, using
+// CKM_AES_GCM with the AES key with the given uri.
+func (k *PKCS11) Decrypt(req *apiv1.DecryptRequest) ([]byte, error) {
+	aead, err := findSecretKey(k.module(), req.Name)
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "decrypt failed")
+	}
+
+	size := aead.NonceSize()
+	if len(req.Ciphertext) < size+aead.Overhead() {
+		return nil, errors.New("decrypt failed: ciphertext is too short")
+	}
+	plaintext, err := aead.Open(nil, req.Ciphertext[:size], req.Ciphertext[size:], req.AdditionalData)
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "decrypt failed")
+	}
+	return plaintext, nil
+}
+
 // DeleteCertificate is a utility function to delete a certificate given an uri.
 func (k *PKCS11) DeleteCertificate(u string) error {
 	id, object, err := parseObject(u)
 	if err != nil {
-		return errors.Wrap(err, "deleteCertificate failed")
+		return errors.Wrap(convertError(err), "deleteCertificate failed")
 	}
-	if err := k.p11.DeleteCertificate(id, object, nil); err != nil {
-		return errors.Wrap(err, "deleteCertificate failed")
+	if err := k.module().DeleteCertificate(id, object, nil); err != nil {
+		return errors.Wrap(convertError(err), "deleteCertificate failed")
 	}
 	return nil
 }
 
+// HealthCheck checks the session with the PKCS#11 module. If the session has
+// been lost, it reconnects to the module and logs in again.
+func (k *PKCS11) HealthCheck() error {
+	p11 := k.module()
+	err := healthCheck(p11)
+	if err == nil || !isSessionError(err) {
+		return errors.Wrap(convertError(err), "healthCheck failed")
+	}
+
+	k.hooks.NotifySessionLost(apiv1.PKCS11, err)
+	if p11, err = k.reconnect(p11); err != nil {
+		return errors.Wrap(convertError(err), "healthCheck failed")
+	}
+	return errors.Wrap(convertError(healthCheck(p11)), "healthCheck failed")
+}
+
 // Close releases the connection to the PKCS#11 module.
 func (k *PKCS11) Close() (err error) {
 	k.closed.Do(func() {
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		// Removing the configuration prevents new reconnections.
+		k.config = nil
 		err = errors.Wrap(k.p11.Close(), "error closing pkcs#11 context")
 	})
 	return
 }
 
+// ValidateName validates that the given string is a valid key URI.
+func (k *PKCS11) ValidateName(s string) error {
+	if err := keySchema.Validate(s); err != nil {
+		return err
+	}
+	_, _, err := parseObject(s)
+	return err
+}
+
+// module returns the current PKCS#11 context.
+func (k *PKCS11) module() P11 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.p11
+}
+
+// reconnect closes the given PKCS#11 context and creates a new one, logging in
+// again with the configured pin. If the given context has already been
+// replaced, it returns the current one.
+func (k *PKCS11) reconnect(old P11) (P11, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.p11 != old {
+		return k.p11, nil
+	}
+	if k.config == nil {
+		return nil, errors.New("error reconnecting PKCS#11: kms is closed or not configured")
+	}
+
+	// The old context is not usable, errors closing it are ignored.
+	_ = old.Close()
+
+	p11, err := p11Configure(k.config)
+	k.hooks.NotifyReconnect(apiv1.PKCS11, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reconnecting PKCS#11")
+	}
+	k.p11 = p11
+	return p11, nil
+}
+
 func toByte(s string) []byte {
 	if s == "" {
 		return nil
@@ -283,6 +500,19 @@ func toByte(s string) []byte {
 	return []byte(s)
 }
 
+// newObjectURI returns the PKCS #11 URI for an object with the given id and
+// label.
+func newObjectURI(id, label []byte) string {
+	values := url.Values{}
+	if len(id) > 0 {
+		values.Set("id", hex.EncodeToString(id))
+	}
+	if len(label) > 0 {
+		values.Set("object", string(label))
+	}
+	return keySchema.String(values)
+}
+
 func parseObject(rawuri string) ([]byte, []byte, error) {
 	u, err := uri.ParseWithScheme(Scheme, rawuri)
 	if err != nil {
@@ -337,9 +567,17 @@ func generateKey(ctx P11, req *apiv1.CreateKeyRequest) (crypto11.Signer, error)
 	switch req.SignatureAlgorithm {
 	case apiv1.UnspecifiedSignAlgorithm:
 		return ctx.GenerateECDSAKeyPairWithAttributes(public, private, elliptic.P256())
-	case apiv1.SHA256WithRSA, apiv1.SHA384WithRSA, apiv1.SHA512WithRSA:
-		return ctx.GenerateRSAKeyPairWithAttributes(public, private, bits)
-	case apiv1.SHA256WithRSAPSS, apiv1.SHA384WithRSAPSS, apiv1.SHA512WithRSAPSS:
+	case apiv1.SHA256WithRSA, apiv1.SHA384WithRSA, apiv1.SHA512WithRSA,
+		apiv1.SHA256WithRSAPSS, apiv1.SHA384WithRSAPSS, apiv1.SHA512WithRSAPSS:
+		// By default crypto11 uses the public exponent 65537.
+		if e := req.PublicExponent; e != 0 {
+			if e < 3 || e%2 == 0 {
+				return nil, errors.Errorf("createKeyRequest 'publicExponent' %d is not valid", e)
+			}
+			if err := public.Set(crypto11.CkaPublicExponent, big.NewInt(int64(e)).Bytes()); err != nil {
+				return nil, err
+			}
+		}
 		return ctx.GenerateRSAKeyPairWithAttributes(public, private, bits)
 	case apiv1.ECDSAWithSHA256:
 		return ctx.GenerateECDSAKeyPairWithAttributes(public, private, elliptic.P256())
@@ -348,12 +586,64 @@ func generateKey(ctx P11, req *apiv1.CreateKeyRequest) (crypto11.Signer, error)
 	case apiv1.ECDSAWithSHA512:
 		return ctx.GenerateECDSAKeyPairWithAttributes(public, private, elliptic.P521())
 	case apiv1.PureEd25519:
-		return nil, fmt.Errorf("signature algorithm %s is not supported", req.SignatureAlgorithm)
+		g, ok := ctx.(ed25519KeyGenerator)
+		if !ok {
+			return nil, apiv1.NotImplementedError{
+				Message: "pkcs11: Ed25519 keys are not supported",
+			}
+		}
+		return g.GenerateEd25519KeyPairWithAttributes(public, private)
 	default:
 		return nil, fmt.Errorf("signature algorithm %s is not supported", req.SignatureAlgorithm)
 	}
 }
 
+// healthCheck runs a search in the PKCS#11 module, an operation that requires
+// a valid session but has no side effects.
+func healthCheck(ctx P11) error {
+	_, err := ctx.FindKeyPair(nil, []byte("health-check"))
+	return err
+}
+
+// isSessionError returns true if the error returned by the PKCS#11 module
+// indicates that the session has been lost and a new login is required.
+func isSessionError(err error) bool {
+	var e pkcs11.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	switch e {
+	case pkcs11.CKR_SESSION_CLOSED, pkcs11.CKR_SESSION_HANDLE_INVALID,
+		pkcs11.CKR_USER_NOT_LOGGED_IN, pkcs11.CKR_DEVICE_ERROR,
+		pkcs11.CKR_DEVICE_REMOVED, pkcs11.CKR_TOKEN_NOT_PRESENT,
+		pkcs11.CKR_CRYPTOKI_NOT_INITIALIZED:
+		return true
+	default:
+		return false
+	}
+}
+
+// convertError converts the errors returned by the PKCS#11 module to the
+// error types defined in apiv1.
+func convertError(err error) error {
+	var e pkcs11.Error
+	if !errors.As(err, &e) {
+		return err
+	}
+	switch e {
+	case pkcs11.CKR_OBJECT_HANDLE_INVALID, pkcs11.CKR_KEY_HANDLE_INVALID:
+		return apiv1.NotFoundError{Err: err}
+	case pkcs11.CKR_PIN_INCORRECT, pkcs11.CKR_PIN_INVALID,
+		pkcs11.CKR_PIN_EXPIRED, pkcs11.CKR_USER_NOT_LOGGED_IN,
+		pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED, pkcs11.CKR_ACTION_PROHIBITED:
+		return apiv1.PermissionDeniedError{Err: err}
+	case pkcs11.CKR_PIN_LOCKED:
+		return apiv1.PINLockedError{Err: err}
+	default:
+		return err
+	}
+}
+
 func findSigner(ctx P11, rawuri string) (crypto11.Signer, error) {
 	id, object, err := parseObject(rawuri)
 	if err != nil {
@@ -364,11 +654,46 @@ func findSigner(ctx P11, rawuri string) (crypto11.Signer, error) {
 		return nil, errors.Wrapf(err, "error finding key with uri %s", rawuri)
 	}
 	if signer == nil {
-		return nil, errors.Errorf("key with uri %s not found", rawuri)
+		return nil, apiv1.NotFoundError{
+			Message: fmt.Sprintf("key with uri %s not found", rawuri),
+		}
 	}
 	return signer, nil
 }
 
+func findSecretKey(ctx P11, rawuri string) (cipher.AEAD, error) {
+	id, object, err := parseObject(rawuri)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ctx.FindKey(id, object)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error finding key with uri %s", rawuri)
+	}
+	if key == nil {
+		return nil, apiv1.NotFoundError{
+			Message: fmt.Sprintf("key with uri %s not found", rawuri),
+		}
+	}
+	return newGCM(key)
+}
+
+// seal calls aead.Seal and returns the ciphertext prefixed with the nonce.
+// Seal in crypto11 panics if the PKCS #11 module fails, the panic is recovered
+// and returned as an error.
+func seal(aead cipher.AEAD, nonce, plaintext, additionalData []byte) (ciphertext []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = errors.Errorf("%v", r)
+			}
+		}
+	}()
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
 func findCertificate(ctx P11, rawuri string) (*x509.Certificate, error) {
 	u, err := uri.ParseWithScheme(Scheme, rawuri)
 	if err != nil {
@@ -393,7 +718,9 @@ func findCertificate(ctx P11, rawuri string) (*x509.Certificate, error) {
 		return nil, errors.Wrapf(err, "error finding certificate with uri %s", rawuri)
 	}
 	if cert == nil {
-		return nil, errors.Errorf("certificate with uri %s not found", rawuri)
+		return nil, apiv1.NotFoundError{
+			Message: fmt.Sprintf("certificate with uri %s not found", rawuri),
+		}
 	}
 	return cert, nil
 }
@@ -16,8 +16,10 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
 	"github.com/pkg/errors"
 	"go.step.sm/crypto/kms/apiv1"
 	"golang.org/x/crypto/cryptobyte"
@@ -122,6 +124,13 @@ func TestNew(t *testing.T) {
 				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			// The configuration is used to reconnect to the module.
+			if got != nil {
+				if got.config == nil {
+					t.Error("New() config = nil, want not nil")
+				}
+				got.config = nil
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("New() = %v, want %v", got, tt.want)
 			}
@@ -129,6 +138,69 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNew_sessionOptions(t *testing.T) {
+	tmp := p11Configure
+	t.Cleanup(func() {
+		p11Configure = tmp
+	})
+
+	var got *crypto11.Config
+	p11Configure = func(config *crypto11.Config) (P11, error) {
+		got = config
+		return nil, nil
+	}
+	t.Setenv("PKCS11_TEST_PIN", "env-password")
+
+	tests := []struct {
+		name    string
+		uri     string
+		want    crypto11.Config
+		wantErr bool
+	}{
+		{"ok", "pkcs11:module-path=module.so;token=token;max-sessions=16;pool-wait-timeout=500ms?pin-value=password", crypto11.Config{
+			Path: "module.so", TokenLabel: "token", Pin: "password", MaxSessions: 16, PoolWaitTimeout: 500 * time.Millisecond,
+		}, false},
+		{"ok defaults", "pkcs11:module-path=module.so;token=token?pin-value=password", crypto11.Config{
+			Path: "module.so", TokenLabel: "token", Pin: "password",
+		}, false},
+		{"ok login not supported", "pkcs11:module-path=module.so;token=token;login-not-supported=true", crypto11.Config{
+			Path: "module.so", TokenLabel: "token", LoginNotSupported: true,
+		}, false},
+		{"ok pin-source env", "pkcs11:module-path=module.so;token=token?pin-source=env:PKCS11_TEST_PIN", crypto11.Config{
+			Path: "module.so", TokenLabel: "token", Pin: "env-password",
+		}, false},
+		{"fail pin-source", "pkcs11:module-path=module.so;token=token?pin-source=env:PKCS11_TEST_MISSING", crypto11.Config{}, true},
+		{"fail max-sessions", "pkcs11:module-path=module.so;token=token;max-sessions=1?pin-value=password", crypto11.Config{}, true},
+		{"fail max-sessions negative", "pkcs11:module-path=module.so;token=token;max-sessions=-1?pin-value=password", crypto11.Config{}, true},
+		{"fail max-sessions number", "pkcs11:module-path=module.so;token=token;max-sessions=many?pin-value=password", crypto11.Config{}, true},
+		{"fail pool-wait-timeout", "pkcs11:module-path=module.so;token=token;pool-wait-timeout=10?pin-value=password", crypto11.Config{}, true},
+		{"fail pool-wait-timeout negative", "pkcs11:module-path=module.so;token=token;pool-wait-timeout=-1s?pin-value=password", crypto11.Config{}, true},
+		{"fail login-not-supported false", "pkcs11:module-path=module.so;token=token;login-not-supported=false", crypto11.Config{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			k, err := New(context.Background(), apiv1.Options{
+				Type: "pkcs11",
+				URI:  tt.uri,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("New() config = %+v, want %+v", *got, tt.want)
+			}
+			if !reflect.DeepEqual(k.config, got) {
+				t.Errorf("New() config = %+v, want %+v", k.config, got)
+			}
+		})
+	}
+}
+
 func TestPKCS11_GetPublicKey(t *testing.T) {
 	k := setupPKCS11(t)
 	type args struct {
@@ -189,7 +261,7 @@ func TestPKCS11_CreateKey(t *testing.T) {
 	k := setupPKCS11(t)
 
 	// Make sure to delete the created key
-	k.DeleteKey(testObject)
+	k.DeleteKey(&apiv1.DeleteKeyRequest{Name: testObject})
 
 	type args struct {
 		req *apiv1.CreateKeyRequest
@@ -331,6 +403,28 @@ func TestPKCS11_CreateKey(t *testing.T) {
 				SigningKey: testObject,
 			},
 		}, false},
+		{"RSA public exponent", args{&apiv1.CreateKeyRequest{
+			Name:               testObject,
+			SignatureAlgorithm: apiv1.SHA256WithRSA,
+			Bits:               2048,
+			PublicExponent:     65537,
+		}}, &apiv1.CreateKeyResponse{
+			Name:      testObject,
+			PublicKey: &rsa.PublicKey{},
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: testObject,
+			},
+		}, false},
+		{"Ed25519", args{&apiv1.CreateKeyRequest{
+			Name:               testObject,
+			SignatureAlgorithm: apiv1.PureEd25519,
+		}}, &apiv1.CreateKeyResponse{
+			Name:      testObject,
+			PublicKey: ed25519.PublicKey{},
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: testObject,
+			},
+		}, false},
 		{"fail name", args{&apiv1.CreateKeyRequest{
 			Name: "",
 		}}, nil, true},
@@ -348,9 +442,10 @@ func TestPKCS11_CreateKey(t *testing.T) {
 			Bits:               -1,
 			SignatureAlgorithm: apiv1.SHA256WithRSAPSS,
 		}}, nil, true},
-		{"fail ed25519", args{&apiv1.CreateKeyRequest{
+		{"fail public exponent", args{&apiv1.CreateKeyRequest{
 			Name:               "pkcs11:id=9999;object=create-key",
-			SignatureAlgorithm: apiv1.PureEd25519,
+			SignatureAlgorithm: apiv1.SHA256WithRSA,
+			PublicExponent:     65536,
 		}}, nil, true},
 		{"fail unknown", args{&apiv1.CreateKeyRequest{
 			Name:               "pkcs11:id=9999;object=create-key",
@@ -379,7 +474,22 @@ func TestPKCS11_CreateKey(t *testing.T) {
 				t.Errorf("PKCS11.CreateKey() = %v, want %v", got, tt.want)
 			}
 			if got != nil {
-				if err := k.DeleteKey(got.Name); err != nil {
+				signer, err := findSigner(k.p11, got.Name)
+				if err != nil {
+					t.Fatalf("findSigner() error = %v", err)
+				}
+				attrs, err := k.p11.GetAttributes(signer, []crypto11.AttributeType{crypto11.CkaExtractable})
+				if err != nil {
+					t.Fatalf("PKCS11.GetAttributes() error = %v", err)
+				}
+				want := []byte{0}
+				if tt.args.req.Extractable {
+					want = []byte{1}
+				}
+				if v := attrs[crypto11.CkaExtractable]; v == nil || !bytes.Equal(v.Value, want) {
+					t.Errorf("PKCS11.GetAttributes() CKA_EXTRACTABLE = %v, want %v", v, want)
+				}
+				if err := k.DeleteKey(&apiv1.DeleteKeyRequest{Name: got.Name}); err != nil {
 					t.Errorf("PKCS11.DeleteKey() error = %v", err)
 				}
 			}
@@ -429,6 +539,18 @@ func TestPKCS11_CreateSigner(t *testing.T) {
 			SaltLength: rsa.PSSSaltLengthEqualsHash,
 			Hash:       crypto.SHA256,
 		}, false},
+		{"RSA PSS auto", args{&apiv1.CreateSignerRequest{
+			SigningKey: "pkcs11:id=7372;object=rsa-pss-key",
+		}}, apiv1.SHA256WithRSAPSS, &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthAuto,
+			Hash:       crypto.SHA256,
+		}, false},
+		{"RSA PSS salt length", args{&apiv1.CreateSignerRequest{
+			SigningKey: "pkcs11:id=7372;object=rsa-pss-key",
+		}}, apiv1.SHA256WithRSAPSS, &rsa.PSSOptions{
+			SaltLength: 20,
+			Hash:       crypto.SHA256,
+		}, false},
 		{"ECDSA P256", args{&apiv1.CreateSignerRequest{
 			SigningKey: "pkcs11:id=7373;object=ecdsa-p256-key",
 		}}, apiv1.ECDSAWithSHA256, crypto.SHA256, false},
@@ -457,6 +579,20 @@ func TestPKCS11_CreateSigner(t *testing.T) {
 			}
 
 			if got != nil {
+				// RSA signers can also decrypt.
+				dec, isDecrypter := got.(crypto.Decrypter)
+				if pub, isRSA := got.Public().(*rsa.PublicKey); isRSA != isDecrypter {
+					t.Errorf("PKCS11.CreateSigner() implements crypto.Decrypter = %v, want %v", isDecrypter, isRSA)
+				} else if isRSA {
+					enc, err := rsa.EncryptPKCS1v15(rand.Reader, pub, data)
+					if err != nil {
+						t.Fatalf("rsa.EncryptPKCS1v15() error = %v", err)
+					}
+					if dec, err := dec.Decrypt(rand.Reader, enc, nil); err != nil || !bytes.Equal(dec, data) {
+						t.Errorf("crypto.Decrypter.Decrypt() = %s, %v, want %s", dec, err, data)
+					}
+				}
+
 				hash := tt.signerOpts.HashFunc()
 				h := hash.New()
 				h.Write(data)
@@ -492,6 +628,56 @@ func TestPKCS11_CreateSigner(t *testing.T) {
 	}
 }
 
+func TestPKCS11_CreateSigner_ed25519(t *testing.T) {
+	k := setupPKCS11(t)
+	if testModule == "OpenSC" {
+		t.Skip("Ed25519 keys are not supported on OpenSC")
+	}
+
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               testObject,
+		SignatureAlgorithm: apiv1.PureEd25519,
+	})
+	if err != nil {
+		t.Fatalf("PKCS11.CreateKey() error = %v", err)
+	}
+	t.Cleanup(func() {
+		if err := k.DeleteKey(&apiv1.DeleteKeyRequest{Name: testObject}); err != nil {
+			t.Errorf("PKCS11.DeleteKey() error = %v", err)
+		}
+	})
+
+	pub, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: testObject})
+	if err != nil {
+		t.Fatalf("PKCS11.GetPublicKey() error = %v", err)
+	}
+	if !reflect.DeepEqual(pub, resp.PublicKey) {
+		t.Errorf("PKCS11.GetPublicKey() = %v, want %v", pub, resp.PublicKey)
+	}
+
+	signer, err := k.CreateSigner(&resp.CreateSignerRequest)
+	if err != nil {
+		t.Fatalf("PKCS11.CreateSigner() error = %v", err)
+	}
+	data := []byte("buggy-coheir-RUBRIC-rabbet-liberal-eaglet-khartoum-stagger")
+	sig, err := signer.Sign(rand.Reader, data, crypto.Hash(0))
+	if err != nil {
+		t.Fatalf("crypto.Signer.Sign() error = %v", err)
+	}
+	if !ed25519.Verify(pub.(ed25519.PublicKey), data, sig) {
+		t.Error("ed25519.Verify() failed")
+	}
+
+	// Ed25519 keys cannot be created with a context that only implements P11.
+	kk := &PKCS11{p11: struct{ P11 }{k.p11}}
+	if _, err := kk.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               "pkcs11:id=7379;object=ed25519-unsupported",
+		SignatureAlgorithm: apiv1.PureEd25519,
+	}); !errors.Is(err, apiv1.ErrNotImplemented) {
+		t.Errorf("PKCS11.CreateKey() error = %v, want %v", err, apiv1.ErrNotImplemented)
+	}
+}
+
 func TestPKCS11_CreateDecrypter(t *testing.T) {
 	k := setupPKCS11(t)
 	data := []byte("buggy-coheir-RUBRIC-rabbet-liberal-eaglet-khartoum-stagger")
@@ -724,6 +910,102 @@ func TestPKCS11_StoreCertificate(t *testing.T) {
 	}
 }
 
+func TestPKCS11_ListKeys(t *testing.T) {
+	k := setupPKCS11(t)
+
+	algorithms := map[string]apiv1.SignatureAlgorithm{
+		"pkcs11:id=7371;object=rsa-key":        apiv1.SHA256WithRSA,
+		"pkcs11:id=7372;object=rsa-pss-key":    apiv1.SHA256WithRSA,
+		"pkcs11:id=7373;object=ecdsa-p256-key": apiv1.ECDSAWithSHA256,
+		"pkcs11:id=7374;object=ecdsa-p384-key": apiv1.ECDSAWithSHA384,
+		"pkcs11:id=7375;object=ecdsa-p521-key": apiv1.ECDSAWithSHA512,
+	}
+
+	type args struct {
+		req *apiv1.ListKeysRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []string
+		wantErr bool
+	}{
+		{"ok", args{&apiv1.ListKeysRequest{}}, []string{
+			"pkcs11:id=7371;object=rsa-key",
+			"pkcs11:id=7372;object=rsa-pss-key",
+			"pkcs11:id=7373;object=ecdsa-p256-key",
+			"pkcs11:id=7374;object=ecdsa-p384-key",
+			"pkcs11:id=7375;object=ecdsa-p521-key",
+		}, false},
+		{"ok filter", args{&apiv1.ListKeysRequest{NameFilter: "ecdsa-p*-key"}}, []string{
+			"pkcs11:id=7373;object=ecdsa-p256-key",
+			"pkcs11:id=7374;object=ecdsa-p384-key",
+			"pkcs11:id=7375;object=ecdsa-p521-key",
+		}, false},
+		{"ok labels", args{&apiv1.ListKeysRequest{Labels: map[string]string{"foo": "bar"}}}, nil, false},
+		{"fail page token", args{&apiv1.ListKeysRequest{PageToken: "foo"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.ListKeys(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PKCS11.ListKeys() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			keys := make(map[string]*apiv1.KeyInfo)
+			for _, ki := range got.Keys {
+				keys[ki.Name] = ki
+			}
+			if len(tt.want) == 0 && len(keys) != 0 {
+				t.Errorf("PKCS11.ListKeys() = %v, want empty", got.Keys)
+			}
+			for _, name := range tt.want {
+				ki, ok := keys[name]
+				if !ok {
+					t.Errorf("PKCS11.ListKeys() key %s not found", name)
+					continue
+				}
+				if ki.SignatureAlgorithm != algorithms[name] {
+					t.Errorf("PKCS11.ListKeys() key %s algorithm = %v, want %v", name, ki.SignatureAlgorithm, algorithms[name])
+				}
+				if ki.ProtectionLevel != apiv1.HSM {
+					t.Errorf("PKCS11.ListKeys() key %s protection level = %v, want %v", name, ki.ProtectionLevel, apiv1.HSM)
+				}
+			}
+		})
+	}
+
+	// Paginate all the keys one by one.
+	var names []string
+	req := &apiv1.ListKeysRequest{PageSize: 1}
+	for {
+		resp, err := k.ListKeys(req)
+		if err != nil {
+			t.Fatalf("PKCS11.ListKeys() error = %v", err)
+		}
+		if len(resp.Keys) > 1 {
+			t.Fatalf("PKCS11.ListKeys() returned %d keys, want 1", len(resp.Keys))
+		}
+		for _, ki := range resp.Keys {
+			names = append(names, ki.Name)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	all, err := k.ListKeys(&apiv1.ListKeysRequest{})
+	if err != nil {
+		t.Fatalf("PKCS11.ListKeys() error = %v", err)
+	}
+	if len(names) != len(all.Keys) {
+		t.Errorf("PKCS11.ListKeys() paginated %d keys, want %d", len(names), len(all.Keys))
+	}
+}
+
 func TestPKCS11_DeleteKey(t *testing.T) {
 	k := setupPKCS11(t)
 
@@ -749,7 +1031,7 @@ func TestPKCS11_DeleteKey(t *testing.T) {
 			}); err != nil {
 				t.Fatalf("PKCS1.CreateKey() error = %v", err)
 			}
-			if err := k.DeleteKey(tt.args.uri); (err != nil) != tt.wantErr {
+			if err := k.DeleteKey(&apiv1.DeleteKeyRequest{Name: tt.args.uri}); (err != nil) != tt.wantErr {
 				t.Errorf("PKCS11.DeleteKey() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if _, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{
@@ -758,13 +1040,120 @@ func TestPKCS11_DeleteKey(t *testing.T) {
 				t.Error("PKCS11.GetPublicKey() public key found and not expected")
 			}
 			// Make sure to delete the created one.
-			if err := k.DeleteKey(testObject); err != nil {
+			if err := k.DeleteKey(&apiv1.DeleteKeyRequest{Name: testObject}); err != nil {
 				t.Errorf("PKCS11.DeleteKey() error = %v", err)
 			}
 		})
 	}
 }
 
+func Test_teardown(t *testing.T) {
+	k := setupPKCS11(t)
+	id, object, err := parseObject(testSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := k.p11.FindKey(id, object); err != nil || key == nil {
+		t.Fatalf("PKCS11.FindKey() = %v, %v, want a key", key, err)
+	}
+
+	teardown(t, k)
+	if key, err := k.p11.FindKey(id, object); err != nil || key != nil {
+		t.Errorf("PKCS11.FindKey() = %v, %v, want nil", key, err)
+	}
+
+	// Restore the objects used by other tests.
+	setup(t, k)
+}
+
+func TestPKCS11_Encrypt(t *testing.T) {
+	k := setupPKCS11(t)
+
+	type args struct {
+		req *apiv1.EncryptRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"ok", args{&apiv1.EncryptRequest{Name: testSecretKey, Plaintext: []byte("plaintext")}}, false},
+		{"ok additional data", args{&apiv1.EncryptRequest{Name: testSecretKey, Plaintext: []byte("plaintext"), AdditionalData: []byte("aad")}}, false},
+		{"ok by id", args{&apiv1.EncryptRequest{Name: "pkcs11:id=7378", Plaintext: []byte("plaintext")}}, false},
+		{"fail name", args{&apiv1.EncryptRequest{Plaintext: []byte("plaintext")}}, true},
+		{"fail uri", args{&apiv1.EncryptRequest{Name: "pkcs11:foo=bar", Plaintext: []byte("plaintext")}}, true},
+		{"fail missing", args{&apiv1.EncryptRequest{Name: "pkcs11:id=9999;object=missing-key", Plaintext: []byte("plaintext")}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.Encrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PKCS11.Encrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			plaintext, err := k.Decrypt(&apiv1.DecryptRequest{
+				Name:           tt.args.req.Name,
+				Ciphertext:     got,
+				AdditionalData: tt.args.req.AdditionalData,
+			})
+			if err != nil {
+				t.Errorf("PKCS11.Decrypt() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(plaintext, tt.args.req.Plaintext) {
+				t.Errorf("PKCS11.Decrypt() = %s, want %s", plaintext, tt.args.req.Plaintext)
+			}
+		})
+	}
+}
+
+func TestPKCS11_Decrypt(t *testing.T) {
+	k := setupPKCS11(t)
+
+	ciphertext, err := k.Encrypt(&apiv1.EncryptRequest{
+		Name:           testSecretKey,
+		Plaintext:      []byte("plaintext"),
+		AdditionalData: []byte("aad"),
+	})
+	if err != nil {
+		t.Fatalf("PKCS11.Encrypt() error = %v", err)
+	}
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1] ^= 0xff
+
+	type args struct {
+		req *apiv1.DecryptRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", args{&apiv1.DecryptRequest{Name: testSecretKey, Ciphertext: ciphertext, AdditionalData: []byte("aad")}}, []byte("plaintext"), false},
+		{"fail name", args{&apiv1.DecryptRequest{Ciphertext: ciphertext, AdditionalData: []byte("aad")}}, nil, true},
+		{"fail missing", args{&apiv1.DecryptRequest{Name: "pkcs11:id=9999;object=missing-key", Ciphertext: ciphertext, AdditionalData: []byte("aad")}}, nil, true},
+		{"fail additional data", args{&apiv1.DecryptRequest{Name: testSecretKey, Ciphertext: ciphertext}}, nil, true},
+		{"fail tampered", args{&apiv1.DecryptRequest{Name: testSecretKey, Ciphertext: tampered, AdditionalData: []byte("aad")}}, nil, true},
+		{"fail short", args{&apiv1.DecryptRequest{Name: testSecretKey, Ciphertext: ciphertext[:16], AdditionalData: []byte("aad")}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.Decrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PKCS11.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PKCS11.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestPKCS11_DeleteCertificate(t *testing.T) {
 	k := setupPKCS11(t)
 
@@ -817,6 +1206,83 @@ func TestPKCS11_DeleteCertificate(t *testing.T) {
 	}
 }
 
+func TestPKCS11_HealthCheck(t *testing.T) {
+	k := setupPKCS11(t)
+
+	closed := mustPKCS11(t)
+	if err := closed.Close(); err != nil {
+		t.Fatalf("PKCS11.Close() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		kms     *PKCS11
+		wantErr bool
+	}{
+		{"ok", k, false},
+		{"fail closed", closed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.kms.HealthCheck(); (err != nil) != tt.wantErr {
+				t.Errorf("PKCS11.HealthCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPKCS11_errors(t *testing.T) {
+	k := setupPKCS11(t)
+	_, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{
+		Name: "pkcs11:id=9999;object=missing-key",
+	})
+	if !errors.Is(err, apiv1.ErrNotFound) {
+		t.Errorf("PKCS11.GetPublicKey() error = %v, want apiv1.NotFoundError", err)
+	}
+	_, err = k.LoadCertificate(&apiv1.LoadCertificateRequest{
+		Name: "pkcs11:id=9999;object=missing-cert",
+	})
+	if !errors.Is(err, apiv1.ErrNotFound) {
+		t.Errorf("PKCS11.LoadCertificate() error = %v, want apiv1.NotFoundError", err)
+	}
+}
+
+func Test_convertError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"not found", pkcs11.Error(pkcs11.CKR_OBJECT_HANDLE_INVALID), apiv1.ErrNotFound},
+		{"not found key", errors.Wrap(pkcs11.Error(pkcs11.CKR_KEY_HANDLE_INVALID), "an error"), apiv1.ErrNotFound},
+		{"permission denied", pkcs11.Error(pkcs11.CKR_PIN_INCORRECT), apiv1.ErrPermissionDenied},
+		{"permission denied not permitted", pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED), apiv1.ErrPermissionDenied},
+		{"pin locked", errors.Wrap(pkcs11.Error(pkcs11.CKR_PIN_LOCKED), "an error"), apiv1.ErrPINLocked},
+		{"other pkcs11", pkcs11.Error(pkcs11.CKR_GENERAL_ERROR), nil},
+		{"other", errors.New("an error"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := convertError(tt.err)
+			if tt.want == nil {
+				if err != tt.err {
+					t.Errorf("convertError() = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("convertError() = %#v, want %T", err, tt.want)
+			}
+			if err.Error() != tt.err.Error() {
+				t.Errorf("convertError().Error() = %s, want %s", err.Error(), tt.err.Error())
+			}
+		})
+	}
+	if err := convertError(nil); err != nil {
+		t.Errorf("convertError() = %v, want nil", err)
+	}
+}
+
 func TestPKCS11_Close(t *testing.T) {
 	k := mustPKCS11(t)
 	tests := []struct {
@@ -834,3 +1300,29 @@ func TestPKCS11_Close(t *testing.T) {
 		})
 	}
 }
+
+func TestPKCS11_ValidateName(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{"ok id", "pkcs11:id=7331", false},
+		{"ok object", "pkcs11:object=my-key", false},
+		{"ok full", "pkcs11:token=smallstep;id=7331;object=my-key?module-path=/usr/lib/softhsm.so&pin-value=password", false},
+		{"ok any order", "pkcs11:module-path=/usr/lib/softhsm.so;object=my-key;token=smallstep;id=7331?pin-source=/etc/pin", false},
+		{"fail unknown attribute", "pkcs11:id=7331;foo=bar", true},
+		{"fail repeated attribute", "pkcs11:id=7331?id=7332", true},
+		{"fail missing id and object", "pkcs11:token=smallstep", true},
+		{"fail scheme", "yubikey:slot-id=9a", true},
+		{"fail empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &PKCS11{}
+			if err := k.ValidateName(tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("PKCS11.ValidateName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -11,12 +11,14 @@ import (
 	"math/big"
 	"time"
 
+	"github.com/ThalesIgnite/crypto11"
 	"github.com/pkg/errors"
 	"go.step.sm/crypto/kms/apiv1"
 )
 
 var (
 	testModule        = ""
+	testModuleURI     = ""
 	testObject        = "pkcs11:id=7370;object=test-name"
 	testObjectAlt     = "pkcs11:id=7377;object=alt-test-name"
 	testObjectByID    = "pkcs11:id=7370"
@@ -33,6 +35,8 @@ var (
 		{"pkcs11:id=7375;object=ecdsa-p521-key", apiv1.ECDSAWithSHA512, 0},
 	}
 
+	testSecretKey = "pkcs11:id=7378;object=aes-key"
+
 	testCerts = []struct {
 		Name         string
 		Key          string
@@ -51,6 +55,12 @@ type TBTesting interface {
 	Skipf(format string, args ...interface{})
 }
 
+// secretKeyGenerator is implemented by crypto11.Context and the fake module,
+// it is not part of the P11 interface.
+type secretKeyGenerator interface {
+	GenerateSecretKeyWithLabel(id, label []byte, bits int, cipher *crypto11.SymmetricCipher) (*crypto11.SecretKey, error)
+}
+
 func generateCertificate(pub crypto.PublicKey, signer crypto.Signer) (*x509.Certificate, error) {
 	now := time.Now()
 	template := &x509.Certificate{
@@ -87,6 +97,19 @@ func setup(t TBTesting, k *PKCS11) {
 		}
 	}
 
+	if id, object, err := parseObject(testSecretKey); err != nil {
+		t.Errorf("parseObject() error = %v", err)
+	} else if key, err := k.p11.FindKey(id, object); err != nil {
+		t.Errorf("PKCS11.FindKey() error = %v", err)
+	} else if key == nil {
+		g, ok := k.p11.(secretKeyGenerator)
+		if !ok {
+			t.Errorf("PKCS11.GenerateSecretKeyWithLabel() is not supported by %T", k.p11)
+		} else if _, err := g.GenerateSecretKeyWithLabel(id, object, 256, crypto11.CipherAES); err != nil {
+			t.Errorf("PKCS11.GenerateSecretKeyWithLabel() error = %v", err)
+		}
+	}
+
 	for i, c := range testCerts {
 		signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{
 			SigningKey: c.Key,
@@ -116,7 +139,7 @@ func setup(t TBTesting, k *PKCS11) {
 func teardown(t TBTesting, k *PKCS11) {
 	testObjects := []string{testObject, testObjectByID, testObjectByLabel}
 	for _, name := range testObjects {
-		if err := k.DeleteKey(name); err != nil {
+		if err := k.DeleteKey(&apiv1.DeleteKeyRequest{Name: name}); err != nil {
 			t.Errorf("PKCS11.DeleteKey() error = %v", err)
 		}
 		if err := k.DeleteCertificate(name); err != nil {
@@ -124,7 +147,7 @@ func teardown(t TBTesting, k *PKCS11) {
 		}
 	}
 	for _, tk := range testKeys {
-		if err := k.DeleteKey(tk.Name); err != nil {
+		if err := k.DeleteKey(&apiv1.DeleteKeyRequest{Name: tk.Name}); err != nil {
 			t.Errorf("PKCS11.DeleteKey() error = %v", err)
 		}
 	}
@@ -133,6 +156,26 @@ func teardown(t TBTesting, k *PKCS11) {
 			t.Errorf("PKCS11.DeleteCertificate() error = %v", err)
 		}
 	}
+	if id, object, err := parseObject(testSecretKey); err != nil {
+		t.Errorf("parseObject() error = %v", err)
+	} else if err := deleteSecretKey(k.p11, id, object); err != nil {
+		t.Errorf("deleteSecretKey() error = %v", err)
+	}
+}
+
+// deleteSecretKey deletes the secret key with the given id and label if it
+// exists.
+func deleteSecretKey(p11 P11, id, label []byte) error {
+	if d, ok := p11.(interface {
+		deleteSecretKey(id, label []byte) error
+	}); ok {
+		return d.deleteSecretKey(id, label)
+	}
+	key, err := p11.FindKey(id, label)
+	if err != nil || key == nil {
+		return err
+	}
+	return key.Delete()
 }
 
 func setupPKCS11(t TBTesting) *PKCS11 {
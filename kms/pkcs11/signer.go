@@ -0,0 +1,141 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"io"
+	"sync"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+// signer implements a crypto.Signer using a key in the PKCS#11 module. If an
+// operation fails because the session with the module has been lost, the
+// signer reconnects to the module, finds the key again and retries the
+// operation once.
+type signer struct {
+	kms       *PKCS11
+	uri       string
+	publicKey crypto.PublicKey
+	mu        sync.Mutex
+	p11       P11
+	signer    crypto11.Signer
+}
+
+func newSigner(k *PKCS11, uri string) (*signer, error) {
+	p11 := k.module()
+	s, err := findSigner(p11, uri)
+	if err != nil {
+		return nil, err
+	}
+	return &signer{
+		kms:       k,
+		uri:       uri,
+		publicKey: s.Public(),
+		p11:       p11,
+		signer:    s,
+	}, nil
+}
+
+// Public returns the public key of the signer.
+func (s *signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs the digest using the key in the PKCS#11 module.
+//
+// RSA-PSS signatures with rsa.PSSSaltLengthAuto, the zero value of
+// rsa.PSSOptions, are not supported by crypto11. They are created using a salt
+// length equal to the hash length, the value used by crypto/x509 and a valid
+// signature for any verifier using rsa.PSSSaltLengthAuto.
+func (s *signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if o, ok := opts.(*rsa.PSSOptions); ok && o.SaltLength == rsa.PSSSaltLengthAuto {
+		opts = &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash,
+			Hash:       o.Hash,
+		}
+	}
+
+	var signature []byte
+	err := s.do(func(key crypto11.Signer) (err error) {
+		signature, err = key.Sign(rand, digest, opts)
+		return
+	})
+	return signature, err
+}
+
+// do runs the given function with the current key, if it fails because the
+// session has been lost, it reconnects and runs the function again. Errors
+// are converted to the types defined in apiv1.
+func (s *signer) do(fn func(key crypto11.Signer) error) error {
+	p11, key, err := s.current()
+	if err != nil {
+		return convertError(err)
+	}
+
+	err = fn(key)
+	if err == nil || !isSessionError(err) {
+		return convertError(err)
+	}
+
+	s.kms.hooks.NotifySessionLost(apiv1.PKCS11, err)
+	if _, err := s.kms.reconnect(p11); err != nil {
+		return convertError(err)
+	}
+	if _, key, err = s.current(); err != nil {
+		return convertError(err)
+	}
+	return convertError(fn(key))
+}
+
+// current returns the current PKCS#11 context and the key in it. If the
+// context has been replaced after a reconnection, the key is searched again.
+func (s *signer) current() (P11, crypto11.Signer, error) {
+	p11 := s.kms.module()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.p11 != p11 {
+		key, err := findSigner(p11, s.uri)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.p11, s.signer = p11, key
+	}
+	return s.p11, s.signer, nil
+}
+
+// decrypter implements a crypto.Decrypter using an RSA key in the PKCS#11
+// module. Like signer, it reconnects to the module if the session is lost.
+type decrypter struct {
+	*signer
+}
+
+// newDecrypter returns a decrypter with the given signer if the key implements
+// the crypto.Decrypter interface, only RSA keys will implement it.
+func newDecrypter(s *signer) (*decrypter, bool) {
+	if _, ok := s.Public().(*rsa.PublicKey); ok {
+		if _, ok := s.signer.(crypto.Decrypter); ok {
+			return &decrypter{signer: s}, true
+		}
+	}
+	return nil, false
+}
+
+// Decrypt decrypts the ciphertext using the key in the PKCS#11 module.
+func (d *decrypter) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	var plaintext []byte
+	err := d.do(func(key crypto11.Signer) (err error) {
+		dec, ok := key.(crypto.Decrypter)
+		if !ok {
+			return errors.New("key is not a crypto.Decrypter")
+		}
+		plaintext, err = dec.Decrypt(rand, ciphertext, opts)
+		return
+	})
+	return plaintext, err
+}
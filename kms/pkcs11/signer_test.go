@@ -0,0 +1,209 @@
+//go:build cgo && !softhsm2 && !yubihsm2 && !opensc
+// +build cgo,!softhsm2,!yubihsm2,!opensc
+
+package pkcs11
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+type hookCalls struct {
+	sessionLost int
+	reconnect   int
+}
+
+// mustReconnectingPKCS11 returns a *PKCS11 using the fake module that can
+// reconnect to it, and the calls to the connection hooks.
+func mustReconnectingPKCS11(t *testing.T, configureErr error) (*PKCS11, *hookCalls) {
+	t.Helper()
+	tmp := p11Configure
+	t.Cleanup(func() {
+		p11Configure = tmp
+	})
+
+	k := setupPKCS11(t)
+	fake := k.p11.(*fakePKCS11)
+	p11Configure = func(config *crypto11.Config) (P11, error) {
+		if configureErr != nil {
+			return nil, configureErr
+		}
+		return fake.reopen(), nil
+	}
+
+	calls := new(hookCalls)
+	k.config = &crypto11.Config{Path: "fake", TokenLabel: "fake", Pin: "password"}
+	k.hooks = &apiv1.ConnectionHooks{
+		SessionLost: func(typ apiv1.Type, err error) {
+			if typ != apiv1.PKCS11 || err == nil {
+				t.Errorf("ConnectionHooks.SessionLost() = (%v, %v), want (%v, error)", typ, err, apiv1.PKCS11)
+			}
+			calls.sessionLost++
+		},
+		Reconnect: func(typ apiv1.Type, err error) {
+			if typ != apiv1.PKCS11 {
+				t.Errorf("ConnectionHooks.Reconnect() type = %v, want %v", typ, apiv1.PKCS11)
+			}
+			calls.reconnect++
+		},
+	}
+	return k, calls
+}
+
+func loseSession(k *PKCS11) {
+	k.module().(*fakePKCS11).loseSession()
+}
+
+func TestSigner_Sign(t *testing.T) {
+	sum := sha256.Sum256([]byte("the message"))
+
+	tests := []struct {
+		name      string
+		configErr error
+		prepare   func(t *testing.T, k *PKCS11)
+		want      hookCalls
+		wantErr   bool
+	}{
+		{"ok", nil, func(t *testing.T, k *PKCS11) {}, hookCalls{}, false},
+		{"ok reconnect", nil, func(t *testing.T, k *PKCS11) {
+			loseSession(k)
+		}, hookCalls{1, 1}, false},
+		{"ok already reconnected", nil, func(t *testing.T, k *PKCS11) {
+			loseSession(k)
+			if err := k.HealthCheck(); err != nil {
+				t.Fatalf("PKCS11.HealthCheck() error = %v", err)
+			}
+		}, hookCalls{1, 1}, false},
+		{"fail reconnect", errors.New("an error"), func(t *testing.T, k *PKCS11) {
+			loseSession(k)
+		}, hookCalls{1, 1}, true},
+		{"fail key deleted", nil, func(t *testing.T, k *PKCS11) {
+			loseSession(k)
+			p11 := k.module().(*fakePKCS11).reopen()
+			signer, err := findSigner(p11, "pkcs11:id=7373;object=ecdsa-p256-key")
+			if err != nil {
+				t.Fatalf("findSigner() error = %v", err)
+			}
+			if err := signer.Delete(); err != nil {
+				t.Fatalf("Signer.Delete() error = %v", err)
+			}
+		}, hookCalls{1, 1}, true},
+		{"fail closed", nil, func(t *testing.T, k *PKCS11) {
+			if err := k.Close(); err != nil {
+				t.Fatalf("PKCS11.Close() error = %v", err)
+			}
+		}, hookCalls{}, true},
+		{"fail not configured", nil, func(t *testing.T, k *PKCS11) {
+			k.config = nil
+			loseSession(k)
+		}, hookCalls{1, 0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, calls := mustReconnectingPKCS11(t, tt.configErr)
+			signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{
+				SigningKey: "pkcs11:id=7373;object=ecdsa-p256-key",
+			})
+			if err != nil {
+				t.Fatalf("PKCS11.CreateSigner() error = %v", err)
+			}
+
+			tt.prepare(t, k)
+			got, err := signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if *calls != tt.want {
+				t.Errorf("ConnectionHooks calls = %+v, want %+v", *calls, tt.want)
+			}
+			if tt.wantErr {
+				if got != nil {
+					t.Errorf("signer.Sign() = %x, want nil", got)
+				}
+				return
+			}
+			if _, err := signer.Sign(rand.Reader, sum[:], crypto.SHA256); err != nil {
+				t.Errorf("signer.Sign() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDecrypter_Decrypt(t *testing.T) {
+	tests := []struct {
+		name      string
+		configErr error
+		prepare   func(t *testing.T, k *PKCS11)
+		want      hookCalls
+		wantErr   bool
+	}{
+		{"ok", nil, func(t *testing.T, k *PKCS11) {}, hookCalls{}, false},
+		{"ok reconnect", nil, func(t *testing.T, k *PKCS11) {
+			loseSession(k)
+		}, hookCalls{1, 1}, false},
+		{"fail reconnect", errors.New("an error"), func(t *testing.T, k *PKCS11) {
+			loseSession(k)
+		}, hookCalls{1, 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, calls := mustReconnectingPKCS11(t, tt.configErr)
+			dec, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{
+				DecryptionKey: "pkcs11:id=7371;object=rsa-key",
+			})
+			if err != nil {
+				t.Fatalf("PKCS11.CreateDecrypter() error = %v", err)
+			}
+			ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, dec.Public().(*rsa.PublicKey), []byte("plaintext"), nil)
+			if err != nil {
+				t.Fatalf("rsa.EncryptOAEP() error = %v", err)
+			}
+
+			tt.prepare(t, k)
+			got, err := dec.Decrypt(rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decrypter.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if *calls != tt.want {
+				t.Errorf("ConnectionHooks calls = %+v, want %+v", *calls, tt.want)
+			}
+			if !tt.wantErr && !bytes.Equal(got, []byte("plaintext")) {
+				t.Errorf("decrypter.Decrypt() = %s, want plaintext", got)
+			}
+		})
+	}
+}
+
+func TestPKCS11_HealthCheck_reconnect(t *testing.T) {
+	tests := []struct {
+		name      string
+		configErr error
+		want      hookCalls
+		wantErr   bool
+	}{
+		{"ok", nil, hookCalls{1, 1}, false},
+		{"fail", errors.New("an error"), hookCalls{1, 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, calls := mustReconnectingPKCS11(t, tt.configErr)
+			loseSession(k)
+			if err := k.HealthCheck(); (err != nil) != tt.wantErr {
+				t.Errorf("PKCS11.HealthCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if *calls != tt.want {
+				t.Errorf("ConnectionHooks calls = %+v, want %+v", *calls, tt.want)
+			}
+		})
+	}
+}
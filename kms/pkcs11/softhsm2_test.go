@@ -39,7 +39,8 @@ func mustPKCS11(t TBTesting) *PKCS11 {
 		t.Skipf("softHSM2 test skipped on %s", runtime.GOOS)
 		return nil
 	}
-	p11, err := crypto11.Configure(&crypto11.Config{
+	testModuleURI = "pkcs11:module-path=" + path + ";token=pkcs11-test?pin-value=password"
+	p11, err := newP11Context(&crypto11.Config{
 		Path:       path,
 		TokenLabel: "pkcs11-test",
 		Pin:        "password",
@@ -0,0 +1,383 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11
+
+import (
+	"fmt"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+// keyWrapper defines the raw PKCS #11 operations used to wrap and unwrap keys,
+// they are not available in crypto11. This interface will be used for unit
+// testing.
+type keyWrapper interface {
+	FindObject(id, label []byte, class uint) (pkcs11.ObjectHandle, error)
+	WrapKey(mech []*pkcs11.Mechanism, wrappingKey, key pkcs11.ObjectHandle) ([]byte, error)
+	UnwrapKey(mech []*pkcs11.Mechanism, unwrappingKey pkcs11.ObjectHandle, wrappedKey []byte, template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error)
+	Close() error
+}
+
+var openKeyWrapper = func(config *crypto11.Config) (keyWrapper, error) {
+	return openSession(config)
+}
+
+// WrapKey exports the key with the given uri encrypted with the wrapping key.
+// The key can be a private key or an AES key, and it must have been created
+// as extractable. The wrapping key must be an AES key for the AES key wrap
+// algorithms, or an RSA public key for RSA-OAEP.
+//
+// Together with UnwrapKey, it can be used to migrate keys between HSMs that
+// support the same wrapping mechanisms, for example, wrapping an AES transport
+// key with the RSA key of the target HSM, and then the CA key with the
+// transport key.
+func (k *PKCS11) WrapKey(req *apiv1.WrapKeyRequest) ([]byte, error) {
+	switch {
+	case req.Name == "":
+		return nil, errors.New("wrapKeyRequest 'name' cannot be empty")
+	case req.WrappingKey == "":
+		return nil, errors.New("wrapKeyRequest 'wrappingKey' cannot be empty")
+	}
+
+	mech, wrappingClass, err := wrapMechanism(req.Algorithm, pkcs11.CKO_PUBLIC_KEY)
+	if err != nil {
+		return nil, errors.Wrap(err, "wrapKey failed")
+	}
+
+	w, err := k.openKeyWrapper()
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "wrapKey failed")
+	}
+	defer w.Close()
+
+	wrappingKey, err := findObject(w, req.WrappingKey, wrappingClass)
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "wrapKey failed")
+	}
+	key, err := findObject(w, req.Name, pkcs11.CKO_PRIVATE_KEY, pkcs11.CKO_SECRET_KEY)
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "wrapKey failed")
+	}
+
+	wrappedKey, err := w.WrapKey(mech, wrappingKey, key)
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "wrapKey failed")
+	}
+	return wrappedKey, nil
+}
+
+// UnwrapKey imports a key wrapped with WrapKey, the new key will be created
+// with the id and label in the request name. The unwrapping key must be an AES
+// key for the AES key wrap algorithms, or an RSA private key for RSA-OAEP.
+//
+// The public key of an unwrapped private key is not imported, to use the key
+// with CreateSigner a certificate with the same id must be stored using
+// StoreCertificate.
+func (k *PKCS11) UnwrapKey(req *apiv1.UnwrapKeyRequest) error {
+	switch {
+	case req.Name == "":
+		return errors.New("unwrapKeyRequest 'name' cannot be empty")
+	case req.WrappingKey == "":
+		return errors.New("unwrapKeyRequest 'wrappingKey' cannot be empty")
+	case len(req.WrappedKey) == 0:
+		return errors.New("unwrapKeyRequest 'wrappedKey' cannot be empty")
+	}
+
+	id, object, err := parseObject(req.Name)
+	if err != nil {
+		return errors.Wrap(err, "unwrapKey failed")
+	}
+	// Enforce the use of both id and labels. This is not strictly necessary in
+	// PKCS #11, but it's a good practice.
+	if len(id) == 0 || len(object) == 0 {
+		return errors.Errorf("key with uri %s is not valid, id and object are required", req.Name)
+	}
+
+	mech, unwrappingClass, err := wrapMechanism(req.Algorithm, pkcs11.CKO_PRIVATE_KEY)
+	if err != nil {
+		return errors.Wrap(err, "unwrapKey failed")
+	}
+	template, err := unwrapTemplate(id, object, req)
+	if err != nil {
+		return errors.Wrap(err, "unwrapKey failed")
+	}
+
+	w, err := k.openKeyWrapper()
+	if err != nil {
+		return errors.Wrap(convertError(err), "unwrapKey failed")
+	}
+	defer w.Close()
+
+	_, err = findObject(w, req.Name, pkcs11.CKO_PRIVATE_KEY, pkcs11.CKO_SECRET_KEY)
+	switch {
+	case err == nil:
+		return errors.Wrap(apiv1.AlreadyExistsError{
+			Message: req.Name + " already exists",
+		}, "unwrapKey failed")
+	case !errors.Is(err, apiv1.ErrNotFound):
+		return errors.Wrap(convertError(err), "unwrapKey failed")
+	}
+
+	unwrappingKey, err := findObject(w, req.WrappingKey, unwrappingClass)
+	if err != nil {
+		return errors.Wrap(convertError(err), "unwrapKey failed")
+	}
+	if _, err := w.UnwrapKey(mech, unwrappingKey, req.WrappedKey, template); err != nil {
+		return errors.Wrap(convertError(err), "unwrapKey failed")
+	}
+	return nil
+}
+
+// openKeyWrapper opens a new session with the PKCS#11 module used to wrap and
+// unwrap keys.
+func (k *PKCS11) openKeyWrapper() (keyWrapper, error) {
+	k.mu.RLock()
+	config := k.config
+	k.mu.RUnlock()
+	if config == nil {
+		return nil, errors.New("kms is closed or not configured")
+	}
+	return openKeyWrapper(config)
+}
+
+// wrapMechanism returns the mechanism for the given algorithm and the class of
+// the wrapping key. The rsaClass is the class of the key used with RSA-OAEP,
+// the public key to wrap and the private key to unwrap.
+func wrapMechanism(alg apiv1.WrapAlgorithm, rsaClass uint) ([]*pkcs11.Mechanism, uint, error) {
+	switch alg {
+	case apiv1.UnspecifiedWrapAlgorithm, apiv1.AESKeyWrapPad:
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_WRAP_PAD, nil)}, pkcs11.CKO_SECRET_KEY, nil
+	case apiv1.AESKeyWrap:
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_KEY_WRAP, nil)}, pkcs11.CKO_SECRET_KEY, nil
+	case apiv1.RSAOAEPWithSHA256:
+		params := pkcs11.NewOAEPParams(pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, pkcs11.CKZ_DATA_SPECIFIED, nil)
+		return []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, params)}, rsaClass, nil
+	default:
+		return nil, 0, errors.Errorf("wrap algorithm %s is not supported", alg)
+	}
+}
+
+// unwrapTemplate returns the attributes of the new key created by UnwrapKey.
+func unwrapTemplate(id, object []byte, req *apiv1.UnwrapKeyRequest) ([]*pkcs11.Attribute, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, object),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, req.Extractable),
+	}
+
+	if req.SecretKey {
+		return append(template,
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+			pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_WRAP, true),
+			pkcs11.NewAttribute(pkcs11.CKA_UNWRAP, true),
+		), nil
+	}
+
+	template = append(template,
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+	)
+	switch req.SignatureAlgorithm {
+	case apiv1.UnspecifiedSignAlgorithm, apiv1.ECDSAWithSHA256, apiv1.ECDSAWithSHA384, apiv1.ECDSAWithSHA512:
+		return append(template, pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC)), nil
+	case apiv1.SHA256WithRSA, apiv1.SHA384WithRSA, apiv1.SHA512WithRSA,
+		apiv1.SHA256WithRSAPSS, apiv1.SHA384WithRSAPSS, apiv1.SHA512WithRSAPSS:
+		return append(template,
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+			pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+		), nil
+	default:
+		return nil, errors.Errorf("signature algorithm %s is not supported", req.SignatureAlgorithm)
+	}
+}
+
+// findObject returns the first object with the id and label in the given uri
+// and one of the given classes.
+func findObject(w keyWrapper, rawuri string, classes ...uint) (pkcs11.ObjectHandle, error) {
+	id, object, err := parseObject(rawuri)
+	if err != nil {
+		return 0, err
+	}
+	for _, class := range classes {
+		h, err := w.FindObject(id, object, class)
+		if err == nil {
+			return h, nil
+		}
+		if !errors.Is(err, apiv1.ErrNotFound) {
+			return 0, errors.Wrapf(err, "error finding key with uri %s", rawuri)
+		}
+	}
+	return 0, apiv1.NotFoundError{
+		Message: fmt.Sprintf("key with uri %s not found", rawuri),
+	}
+}
+
+// session implements the keyWrapper and edwardsSession interfaces using a
+// session opened directly with the PKCS#11 module. The module is already
+// initialized and logged in by crypto11, so the session shares the login state
+// with it.
+type session struct {
+	ctx    *pkcs11.Ctx
+	handle pkcs11.SessionHandle
+}
+
+func openSession(config *crypto11.Config) (*session, error) {
+	ctx := pkcs11.New(config.Path)
+	if ctx == nil {
+		return nil, errors.Errorf("error loading PKCS#11 module %s", config.Path)
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		ctx.Destroy()
+		return nil, errors.Wrap(err, "error initializing PKCS#11 module")
+	}
+
+	slot, err := findSlot(ctx, config)
+	if err != nil {
+		ctx.Destroy()
+		return nil, err
+	}
+	h, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		ctx.Destroy()
+		return nil, errors.Wrap(err, "error opening PKCS#11 session")
+	}
+	if config.LoginNotSupported {
+		return &session{ctx: ctx, handle: h}, nil
+	}
+	if err := ctx.Login(h, pkcs11.CKU_USER, config.Pin); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+		_ = ctx.CloseSession(h)
+		ctx.Destroy()
+		return nil, errors.Wrap(err, "error logging in PKCS#11 session")
+	}
+
+	return &session{ctx: ctx, handle: h}, nil
+}
+
+// findSlot returns the slot with the token in the configuration.
+func findSlot(ctx *pkcs11.Ctx, config *crypto11.Config) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, errors.Wrap(err, "error listing PKCS#11 slots")
+	}
+	for _, slot := range slots {
+		if config.SlotNumber != nil {
+			if int(slot) == *config.SlotNumber {
+				return slot, nil
+			}
+			continue
+		}
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, errors.Wrap(err, "error getting PKCS#11 token info")
+		}
+		if (config.TokenLabel != "" && info.Label == config.TokenLabel) ||
+			(config.TokenSerial != "" && info.SerialNumber == config.TokenSerial) {
+			return slot, nil
+		}
+	}
+	return 0, errors.New("error finding PKCS#11 token")
+}
+
+// FindObject returns the first object with the given id, label and class.
+func (s *session) FindObject(id, label []byte, class uint) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+	}
+	if len(id) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, id))
+	}
+	if len(label) > 0 {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, label))
+	}
+
+	if err := s.ctx.FindObjectsInit(s.handle, template); err != nil {
+		return 0, err
+	}
+	handles, _, err := s.ctx.FindObjects(s.handle, 1)
+	if finalErr := s.ctx.FindObjectsFinal(s.handle); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(handles) == 0 {
+		return 0, apiv1.NotFoundError{}
+	}
+	return handles[0], nil
+}
+
+// FindObjects returns all the objects that match the given template.
+func (s *session) FindObjects(template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
+	if err := s.ctx.FindObjectsInit(s.handle, template); err != nil {
+		return nil, err
+	}
+
+	var handles []pkcs11.ObjectHandle
+	for {
+		hs, _, err := s.ctx.FindObjects(s.handle, 100)
+		if err != nil {
+			_ = s.ctx.FindObjectsFinal(s.handle)
+			return nil, err
+		}
+		if len(hs) == 0 {
+			break
+		}
+		handles = append(handles, hs...)
+	}
+	if err := s.ctx.FindObjectsFinal(s.handle); err != nil {
+		return nil, err
+	}
+	return handles, nil
+}
+
+// WrapKey wraps the key using the wrapping key.
+func (s *session) WrapKey(mech []*pkcs11.Mechanism, wrappingKey, key pkcs11.ObjectHandle) ([]byte, error) {
+	return s.ctx.WrapKey(s.handle, mech, wrappingKey, key)
+}
+
+// UnwrapKey unwraps the wrapped key and creates a new object with the given
+// template.
+func (s *session) UnwrapKey(mech []*pkcs11.Mechanism, unwrappingKey pkcs11.ObjectHandle, wrappedKey []byte, template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	return s.ctx.UnwrapKey(s.handle, mech, unwrappingKey, wrappedKey, template)
+}
+
+// GetAttributeValue returns the values of the attributes in the template.
+func (s *session) GetAttributeValue(h pkcs11.ObjectHandle, template []*pkcs11.Attribute) ([]*pkcs11.Attribute, error) {
+	return s.ctx.GetAttributeValue(s.handle, h, template)
+}
+
+// GenerateKeyPair generates a new key pair with the given templates.
+func (s *session) GenerateKeyPair(mech []*pkcs11.Mechanism, public, private []*pkcs11.Attribute) (pkcs11.ObjectHandle, pkcs11.ObjectHandle, error) {
+	return s.ctx.GenerateKeyPair(s.handle, mech, public, private)
+}
+
+// Sign signs the message with the given key.
+func (s *session) Sign(mech []*pkcs11.Mechanism, key pkcs11.ObjectHandle, message []byte) ([]byte, error) {
+	if err := s.ctx.SignInit(s.handle, mech, key); err != nil {
+		return nil, err
+	}
+	return s.ctx.Sign(s.handle, message)
+}
+
+// DestroyObject removes the object from the token.
+func (s *session) DestroyObject(h pkcs11.ObjectHandle) error {
+	return s.ctx.DestroyObject(s.handle, h)
+}
+
+// Close closes the session. The module is not finalized and the user is not
+// logged out, as they are shared with crypto11.
+func (s *session) Close() error {
+	err := s.ctx.CloseSession(s.handle)
+	s.ctx.Destroy()
+	return err
+}
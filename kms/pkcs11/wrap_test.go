@@ -0,0 +1,293 @@
+//go:build cgo
+// +build cgo
+
+package pkcs11
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/ThalesIgnite/crypto11"
+	"github.com/miekg/pkcs11"
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+type stubObject struct {
+	id, label string
+	class     uint
+}
+
+type stubKeyWrapper struct {
+	objects  map[stubObject]pkcs11.ObjectHandle
+	err      error
+	mech     []*pkcs11.Mechanism
+	template []*pkcs11.Attribute
+	closed   bool
+}
+
+func newStubKeyWrapper() *stubKeyWrapper {
+	return &stubKeyWrapper{
+		objects: map[stubObject]pkcs11.ObjectHandle{
+			{"\x01", "transport", pkcs11.CKO_SECRET_KEY}: 1,
+			{"\x02", "ca", pkcs11.CKO_PRIVATE_KEY}:       2,
+			{"\x03", "target", pkcs11.CKO_PUBLIC_KEY}:    3,
+			{"\x03", "target", pkcs11.CKO_PRIVATE_KEY}:   4,
+		},
+	}
+}
+
+func (s *stubKeyWrapper) FindObject(id, label []byte, class uint) (pkcs11.ObjectHandle, error) {
+	for o, h := range s.objects {
+		if o.class == class && (len(id) == 0 || o.id == string(id)) && (len(label) == 0 || o.label == string(label)) {
+			return h, nil
+		}
+	}
+	return 0, apiv1.NotFoundError{}
+}
+
+func (s *stubKeyWrapper) WrapKey(mech []*pkcs11.Mechanism, wrappingKey, key pkcs11.ObjectHandle) ([]byte, error) {
+	s.mech = mech
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []byte{byte(wrappingKey), byte(key)}, nil
+}
+
+func (s *stubKeyWrapper) UnwrapKey(mech []*pkcs11.Mechanism, unwrappingKey pkcs11.ObjectHandle, wrappedKey []byte, template []*pkcs11.Attribute) (pkcs11.ObjectHandle, error) {
+	s.mech, s.template = mech, template
+	if s.err != nil {
+		return 0, s.err
+	}
+	return 100, nil
+}
+
+func (s *stubKeyWrapper) Close() error {
+	s.closed = true
+	return nil
+}
+
+func mustKeyWrapper(t *testing.T, w *stubKeyWrapper, openErr error) *PKCS11 {
+	t.Helper()
+	tmp := openKeyWrapper
+	t.Cleanup(func() {
+		openKeyWrapper = tmp
+	})
+	openKeyWrapper = func(config *crypto11.Config) (keyWrapper, error) {
+		if openErr != nil {
+			return nil, openErr
+		}
+		return w, nil
+	}
+	return &PKCS11{config: &crypto11.Config{Path: "module.so", TokenLabel: "token", Pin: "password"}}
+}
+
+// attributeValue returns the value of the attribute with the given type.
+func attributeValue(template []*pkcs11.Attribute, typ uint) []byte {
+	for _, a := range template {
+		if a.Type == typ {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+func TestPKCS11_WrapKey(t *testing.T) {
+	type args struct {
+		req *apiv1.WrapKeyRequest
+	}
+	tests := []struct {
+		name        string
+		wrapErr     error
+		openErr     error
+		args        args
+		want        []byte
+		wantMech    uint
+		wantErr     bool
+		wantErrType error
+	}{
+		{"ok", nil, nil, args{&apiv1.WrapKeyRequest{
+			Name: "pkcs11:id=02;object=ca", WrappingKey: "pkcs11:id=01;object=transport",
+		}}, []byte{1, 2}, pkcs11.CKM_AES_KEY_WRAP_PAD, false, nil},
+		{"ok aes key wrap", nil, nil, args{&apiv1.WrapKeyRequest{
+			Name: "pkcs11:object=ca", WrappingKey: "pkcs11:id=01", Algorithm: apiv1.AESKeyWrap,
+		}}, []byte{1, 2}, pkcs11.CKM_AES_KEY_WRAP, false, nil},
+		{"ok rsa-oaep", nil, nil, args{&apiv1.WrapKeyRequest{
+			Name: "pkcs11:id=01;object=transport", WrappingKey: "pkcs11:id=03;object=target", Algorithm: apiv1.RSAOAEPWithSHA256,
+		}}, []byte{3, 1}, pkcs11.CKM_RSA_PKCS_OAEP, false, nil},
+		{"fail name", nil, nil, args{&apiv1.WrapKeyRequest{
+			WrappingKey: "pkcs11:id=01;object=transport",
+		}}, nil, 0, true, nil},
+		{"fail wrappingKey", nil, nil, args{&apiv1.WrapKeyRequest{
+			Name: "pkcs11:id=02;object=ca",
+		}}, nil, 0, true, nil},
+		{"fail algorithm", nil, nil, args{&apiv1.WrapKeyRequest{
+			Name: "pkcs11:id=02;object=ca", WrappingKey: "pkcs11:id=01;object=transport", Algorithm: apiv1.WrapAlgorithm(100),
+		}}, nil, 0, true, nil},
+		{"fail open", nil, errors.New("an error"), args{&apiv1.WrapKeyRequest{
+			Name: "pkcs11:id=02;object=ca", WrappingKey: "pkcs11:id=01;object=transport",
+		}}, nil, 0, true, nil},
+		{"fail wrappingKey missing", nil, nil, args{&apiv1.WrapKeyRequest{
+			Name: "pkcs11:id=02;object=ca", WrappingKey: "pkcs11:id=99;object=missing",
+		}}, nil, 0, true, apiv1.ErrNotFound},
+		{"fail wrappingKey class", nil, nil, args{&apiv1.WrapKeyRequest{
+			Name: "pkcs11:id=02;object=ca", WrappingKey: "pkcs11:id=01;object=transport", Algorithm: apiv1.RSAOAEPWithSHA256,
+		}}, nil, 0, true, apiv1.ErrNotFound},
+		{"fail key missing", nil, nil, args{&apiv1.WrapKeyRequest{
+			Name: "pkcs11:id=99;object=missing", WrappingKey: "pkcs11:id=01;object=transport",
+		}}, nil, 0, true, apiv1.ErrNotFound},
+		{"fail key uri", nil, nil, args{&apiv1.WrapKeyRequest{
+			Name: "pkcs11:foo=bar", WrappingKey: "pkcs11:id=01;object=transport",
+		}}, nil, 0, true, nil},
+		{"fail wrap", pkcs11.Error(pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED), nil, args{&apiv1.WrapKeyRequest{
+			Name: "pkcs11:id=02;object=ca", WrappingKey: "pkcs11:id=01;object=transport",
+		}}, nil, pkcs11.CKM_AES_KEY_WRAP_PAD, true, apiv1.ErrPermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newStubKeyWrapper()
+			w.err = tt.wrapErr
+			k := mustKeyWrapper(t, w, tt.openErr)
+			got, err := k.WrapKey(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PKCS11.WrapKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErrType != nil && !errors.Is(err, tt.wantErrType) {
+				t.Errorf("PKCS11.WrapKey() error = %v, want %T", err, tt.wantErrType)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("PKCS11.WrapKey() = %v, want %v", got, tt.want)
+			}
+			if tt.wantMech != 0 && (len(w.mech) != 1 || w.mech[0].Mechanism != tt.wantMech) {
+				t.Errorf("PKCS11.WrapKey() mechanism = %v, want %#x", w.mech, tt.wantMech)
+			}
+			if tt.wantMech != 0 && !w.closed {
+				t.Error("PKCS11.WrapKey() session was not closed")
+			}
+		})
+	}
+}
+
+func TestPKCS11_UnwrapKey(t *testing.T) {
+	type args struct {
+		req *apiv1.UnwrapKeyRequest
+	}
+	tests := []struct {
+		name         string
+		wrapErr      error
+		openErr      error
+		args         args
+		wantMech     uint
+		wantClass    uint
+		wantKeyType  uint
+		wantErr      bool
+		wantErrType  error
+		wantTemplate bool
+	}{
+		{"ok", nil, nil, args{&apiv1.UnwrapKeyRequest{
+			Name: "pkcs11:id=05;object=new-ca", WrappingKey: "pkcs11:id=01;object=transport", WrappedKey: []byte{1, 2},
+		}}, pkcs11.CKM_AES_KEY_WRAP_PAD, pkcs11.CKO_PRIVATE_KEY, pkcs11.CKK_EC, false, nil, true},
+		{"ok rsa", nil, nil, args{&apiv1.UnwrapKeyRequest{
+			Name: "pkcs11:id=05;object=new-ca", WrappingKey: "pkcs11:id=01;object=transport", WrappedKey: []byte{1, 2},
+			SignatureAlgorithm: apiv1.SHA256WithRSAPSS, Extractable: true,
+		}}, pkcs11.CKM_AES_KEY_WRAP_PAD, pkcs11.CKO_PRIVATE_KEY, pkcs11.CKK_RSA, false, nil, true},
+		{"ok secret key", nil, nil, args{&apiv1.UnwrapKeyRequest{
+			Name: "pkcs11:id=06;object=new-transport", WrappingKey: "pkcs11:id=03;object=target", WrappedKey: []byte{3, 1},
+			Algorithm: apiv1.RSAOAEPWithSHA256, SecretKey: true,
+		}}, pkcs11.CKM_RSA_PKCS_OAEP, pkcs11.CKO_SECRET_KEY, pkcs11.CKK_AES, false, nil, true},
+		{"fail name", nil, nil, args{&apiv1.UnwrapKeyRequest{
+			WrappingKey: "pkcs11:id=01;object=transport", WrappedKey: []byte{1, 2},
+		}}, 0, 0, 0, true, nil, false},
+		{"fail wrappingKey", nil, nil, args{&apiv1.UnwrapKeyRequest{
+			Name: "pkcs11:id=05;object=new-ca", WrappedKey: []byte{1, 2},
+		}}, 0, 0, 0, true, nil, false},
+		{"fail wrappedKey", nil, nil, args{&apiv1.UnwrapKeyRequest{
+			Name: "pkcs11:id=05;object=new-ca", WrappingKey: "pkcs11:id=01;object=transport",
+		}}, 0, 0, 0, true, nil, false},
+		{"fail name uri", nil, nil, args{&apiv1.UnwrapKeyRequest{
+			Name: "pkcs11:object=new-ca", WrappingKey: "pkcs11:id=01;object=transport", WrappedKey: []byte{1, 2},
+		}}, 0, 0, 0, true, nil, false},
+		{"fail algorithm", nil, nil, args{&apiv1.UnwrapKeyRequest{
+			Name: "pkcs11:id=05;object=new-ca", WrappingKey: "pkcs11:id=01;object=transport", WrappedKey: []byte{1, 2},
+			Algorithm: apiv1.WrapAlgorithm(100),
+		}}, 0, 0, 0, true, nil, false},
+		{"fail signature algorithm", nil, nil, args{&apiv1.UnwrapKeyRequest{
+			Name: "pkcs11:id=05;object=new-ca", WrappingKey: "pkcs11:id=01;object=transport", WrappedKey: []byte{1, 2},
+			SignatureAlgorithm: apiv1.PureEd25519,
+		}}, 0, 0, 0, true, nil, false},
+		{"fail open", nil, errors.New("an error"), args{&apiv1.UnwrapKeyRequest{
+			Name: "pkcs11:id=05;object=new-ca", WrappingKey: "pkcs11:id=01;object=transport", WrappedKey: []byte{1, 2},
+		}}, 0, 0, 0, true, nil, false},
+		{"fail already exists", nil, nil, args{&apiv1.UnwrapKeyRequest{
+			Name: "pkcs11:id=02;object=ca", WrappingKey: "pkcs11:id=01;object=transport", WrappedKey: []byte{1, 2},
+		}}, 0, 0, 0, true, apiv1.ErrAlreadyExists, false},
+		{"fail wrappingKey missing", nil, nil, args{&apiv1.UnwrapKeyRequest{
+			Name: "pkcs11:id=05;object=new-ca", WrappingKey: "pkcs11:id=99;object=missing", WrappedKey: []byte{1, 2},
+		}}, 0, 0, 0, true, apiv1.ErrNotFound, false},
+		{"fail unwrap", pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN), nil, args{&apiv1.UnwrapKeyRequest{
+			Name: "pkcs11:id=05;object=new-ca", WrappingKey: "pkcs11:id=01;object=transport", WrappedKey: []byte{1, 2},
+		}}, pkcs11.CKM_AES_KEY_WRAP_PAD, pkcs11.CKO_PRIVATE_KEY, pkcs11.CKK_EC, true, apiv1.ErrPermissionDenied, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newStubKeyWrapper()
+			w.err = tt.wrapErr
+			k := mustKeyWrapper(t, w, tt.openErr)
+			err := k.UnwrapKey(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("PKCS11.UnwrapKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErrType != nil && !errors.Is(err, tt.wantErrType) {
+				t.Errorf("PKCS11.UnwrapKey() error = %v, want %T", err, tt.wantErrType)
+			}
+			if !tt.wantTemplate {
+				if w.template != nil {
+					t.Errorf("PKCS11.UnwrapKey() template = %v, want nil", w.template)
+				}
+				return
+			}
+
+			if len(w.mech) != 1 || w.mech[0].Mechanism != tt.wantMech {
+				t.Errorf("PKCS11.UnwrapKey() mechanism = %v, want %#x", w.mech, tt.wantMech)
+			}
+			id, object, err := parseObject(tt.args.req.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []*pkcs11.Attribute{
+				pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+				pkcs11.NewAttribute(pkcs11.CKA_LABEL, object),
+				pkcs11.NewAttribute(pkcs11.CKA_CLASS, tt.wantClass),
+				pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, tt.wantKeyType),
+				pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+				pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+				pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, tt.args.req.Extractable),
+			} {
+				if got := attributeValue(w.template, want.Type); !reflect.DeepEqual(got, want.Value) {
+					t.Errorf("PKCS11.UnwrapKey() attribute %#x = %v, want %v", want.Type, got, want.Value)
+				}
+			}
+			if !w.closed {
+				t.Error("PKCS11.UnwrapKey() session was not closed")
+			}
+		})
+	}
+}
+
+func TestPKCS11_WrapKey_closed(t *testing.T) {
+	k := mustKeyWrapper(t, newStubKeyWrapper(), nil)
+	k.config = nil
+	if _, err := k.WrapKey(&apiv1.WrapKeyRequest{
+		Name: "pkcs11:id=02;object=ca", WrappingKey: "pkcs11:id=01;object=transport",
+	}); err == nil {
+		t.Error("PKCS11.WrapKey() error = nil, wantErr true")
+	}
+	if err := k.UnwrapKey(&apiv1.UnwrapKeyRequest{
+		Name: "pkcs11:id=05;object=new-ca", WrappingKey: "pkcs11:id=01;object=transport", WrappedKey: []byte{1, 2},
+	}); err == nil {
+		t.Error("PKCS11.UnwrapKey() error = nil, wantErr true")
+	}
+}
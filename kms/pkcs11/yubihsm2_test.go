@@ -33,7 +33,8 @@ func mustPKCS11(t TBTesting) *PKCS11 {
 		t.Skipf("yubiHSM2 test skipped on %s", runtime.GOOS)
 		return nil
 	}
-	p11, err := crypto11.Configure(&crypto11.Config{
+	testModuleURI = "pkcs11:module-path=" + path + ";token=YubiHSM?pin-value=0001password"
+	p11, err := newP11Context(&crypto11.Config{
 		Path:       path,
 		TokenLabel: "YubiHSM",
 		Pin:        "0001password",
@@ -1,19 +1,42 @@
 package softkms
 
 import (
+	"bytes"
 	"context"
 	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
+	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/keyutil"
 	"go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/kms/uri"
 	"go.step.sm/crypto/pemutil"
 )
 
+// Scheme is the scheme used in uris.
+const Scheme = "softkms"
+
+// keySchema defines the attributes allowed in the key and certificate URIs,
+// like softkms:path=/path/to/key.pem;password-file=/path/to/password.txt.
+var keySchema = &uri.Schema{
+	Scheme: Scheme,
+	Path:   []string{"path", "mode", "password-file", "password-source"},
+}
+
 type algorithmAttributes struct {
 	Type  string
 	Curve string
@@ -45,6 +68,24 @@ var generateKey = func(kty, crv string, size int) (interface{}, interface{}, err
 }
 
 // SoftKMS is a key manager that uses keys stored in disk.
+//
+// Keys can be referenced by the filename or by an URI like:
+//
+//	softkms:path=/path/to/key.pem
+//	softkms:path=/path/to/key.pem;password-file=/path/to/password.txt
+//	softkms:path=/path/to/key.pem;password-source=env:KEY_PASSWORD
+//
+// The password-source attribute supports the same sources as the pin-source
+// attribute in other KMSs, see uri.RegisterPinSource.
+//
+// If an URI is used in CreateKey, the private key is stored in the given path
+// using PKCS #8, encrypted if a password is given, and the public key is
+// stored in the same path with the ".pub" extension.
+//
+// Certificates are also referenced by the filename or by an URI with the
+// permissions used to write the file:
+//
+//	softkms:path=/path/to/cert.crt;mode=0644
 type SoftKMS struct{}
 
 // New returns a new SoftKMS.
@@ -53,6 +94,7 @@ func New(ctx context.Context, opts apiv1.Options) (*SoftKMS, error) {
 }
 
 func init() {
+	uri.RegisterSchema(keySchema)
 	apiv1.Register(apiv1.SoftKMS, func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
 		return New(ctx, opts)
 	})
@@ -63,6 +105,21 @@ func (k *SoftKMS) Close() error {
 	return nil
 }
 
+// ValidateName validates that the given string is a valid filename or URI.
+func (k *SoftKMS) ValidateName(s string) error {
+	if s == "" {
+		return errors.New("key name cannot be empty")
+	}
+	if !uri.HasScheme(Scheme, s) {
+		return nil
+	}
+	if err := keySchema.Validate(s); err != nil {
+		return err
+	}
+	_, _, err := parseKeyURI(s)
+	return err
+}
+
 // CreateSigner returns a new signer configured with the given signing key.
 func (k *SoftKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
 	var opts []pemutil.Options
@@ -84,7 +141,7 @@ func (k *SoftKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, e
 		}
 		return sig, nil
 	case req.SigningKey != "":
-		v, err := pemutil.Read(req.SigningKey, opts...)
+		v, err := readKey(req.SigningKey, req.Password)
 		if err != nil {
 			return nil, err
 		}
@@ -100,12 +157,37 @@ func (k *SoftKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, e
 
 // CreateKey generates a new key using Golang crypto and returns both public and
 // private key.
+//
+// If the name is a softkms URI, the keys are stored in disk and only the
+// public key is returned, the private key file is encrypted with the password
+// in the request or in the password-file or password-source attributes of the
+// URI.
 func (k *SoftKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
 	v, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
 	if !ok {
 		return nil, errors.Errorf("softKMS does not support signature algorithm '%s'", req.SignatureAlgorithm)
 	}
 
+	var path string
+	var opts []pemutil.Options
+	if uri.HasScheme(Scheme, req.Name) {
+		var passwordSource string
+		var err error
+		if path, passwordSource, err = parseKeyURI(req.Name); err != nil {
+			return nil, err
+		}
+		switch {
+		case req.Password != nil:
+			opts = append(opts, pemutil.WithPassword(req.Password))
+		case passwordSource != "":
+			password, err := uri.ReadPinSource(passwordSource)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, pemutil.WithPassword(password))
+		}
+	}
+
 	pub, priv, err := generateKey(v.Type, v.Curve, req.Bits)
 	if err != nil {
 		return nil, err
@@ -115,6 +197,40 @@ func (k *SoftKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespon
 		return nil, errors.Errorf("softKMS createKey result is not a crypto.Signer: type %T", priv)
 	}
 
+	if path != "" {
+		opts = append(opts, pemutil.WithPKCS8(true))
+		privBlock, err := pemutil.Serialize(priv, opts...)
+		if err != nil {
+			return nil, err
+		}
+		pubBlock, err := pemutil.Serialize(pub)
+		if err != nil {
+			return nil, err
+		}
+		// The private key file is created exclusively, so a concurrent
+		// CreateKey cannot replace an existing key.
+		if err := createFile(path, pem.EncodeToMemory(privBlock), 0600); err != nil {
+			if errors.Is(err, os.ErrExist) {
+				return nil, apiv1.AlreadyExistsError{
+					Message: fmt.Sprintf("key file %s already exists", path),
+				}
+			}
+			return nil, convertError(err)
+		}
+		if err := writeFile(path+".pub", pem.EncodeToMemory(pubBlock), 0644); err != nil {
+			os.Remove(path)
+			return nil, convertError(err)
+		}
+		return &apiv1.CreateKeyResponse{
+			Name:      req.Name,
+			PublicKey: pub,
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: req.Name,
+				Password:   req.Password,
+			},
+		}, nil
+	}
+
 	return &apiv1.CreateKeyResponse{
 		Name:       req.Name,
 		PublicKey:  pub,
@@ -126,10 +242,34 @@ func (k *SoftKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespon
 }
 
 // GetPublicKey returns the public key from the file passed in the request name.
+// If the name is a softkms URI, the public key is read from the public key
+// file stored by CreateKey or, if it does not exist, from the private key
+// file, using the password-file or password-source attributes if the key is
+// encrypted.
 func (k *SoftKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
-	v, err := pemutil.Read(req.Name)
+	filename := req.Name
+	if uri.HasScheme(Scheme, req.Name) {
+		path, _, err := parseKeyURI(req.Name)
+		if err != nil {
+			return nil, err
+		}
+		filename = path + ".pub"
+		if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+			v, err := readKey(req.Name, nil)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := v.(crypto.Signer)
+			if !ok {
+				return nil, errors.Errorf("unsupported private key type %T", v)
+			}
+			return signer.Public(), nil
+		}
+	}
+
+	v, err := pemutil.Read(filename)
 	if err != nil {
-		return nil, err
+		return nil, convertError(err)
 	}
 
 	switch vv := v.(type) {
@@ -163,7 +303,7 @@ func (k *SoftKMS) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Dec
 		}
 		return decrypter, nil
 	case req.DecryptionKey != "":
-		v, err := pemutil.Read(req.DecryptionKey, opts...)
+		v, err := readKey(req.DecryptionKey, req.Password)
 		if err != nil {
 			return nil, err
 		}
@@ -176,3 +316,377 @@ func (k *SoftKMS) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Dec
 		return nil, errors.New("failed to load softKMS: please define decryptionKeyPEM or decryptionKey")
 	}
 }
+
+// ListKeys returns the keys in the directory passed in the request name. Only
+// files with a PEM-encoded public or private key are returned, the signature
+// algorithm of encrypted private keys is not available without the password.
+//
+// Keys with a public key file, like the ones stored by CreateKey, are returned
+// once, as a softkms URI with the path of the private key, and the signature
+// algorithm is read from the public key file.
+func (k *SoftKMS) ListKeys(req *apiv1.ListKeysRequest) (*apiv1.ListKeysResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("listKeysRequest 'name' cannot be empty")
+	}
+
+	entries, err := os.ReadDir(req.Name)
+	if err != nil {
+		return nil, errors.Wrapf(convertError(err), "error reading %s", req.Name)
+	}
+
+	files := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			files[e.Name()] = true
+		}
+	}
+
+	var keys []*apiv1.KeyInfo
+	for _, e := range entries {
+		if !files[e.Name()] || !req.MatchKey(e.Name(), nil) {
+			continue
+		}
+		// Public key files are listed with the private key.
+		if strings.HasSuffix(e.Name(), ".pub") && files[strings.TrimSuffix(e.Name(), ".pub")] {
+			continue
+		}
+		filename := filepath.Join(req.Name, e.Name())
+		if files[e.Name()+".pub"] {
+			if alg, ok := readKeyFile(filename + ".pub"); ok {
+				u, err := keySchema.Build(url.Values{"path": {filename}})
+				if err != nil {
+					return nil, err
+				}
+				keys = append(keys, &apiv1.KeyInfo{
+					Name:               u.String(),
+					SignatureAlgorithm: alg,
+					ProtectionLevel:    apiv1.Software,
+				})
+				continue
+			}
+		}
+		alg, ok := readKeyFile(filename)
+		if !ok {
+			continue
+		}
+		keys = append(keys, &apiv1.KeyInfo{
+			Name:               filename,
+			SignatureAlgorithm: alg,
+			ProtectionLevel:    apiv1.Software,
+		})
+	}
+
+	return req.Paginate(keys)
+}
+
+// readKeyFile returns the signature algorithm of the key in the given file,
+// and true if the file contains a key.
+func readKeyFile(filename string) (apiv1.SignatureAlgorithm, bool) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return apiv1.UnspecifiedSignAlgorithm, false
+	}
+	block, _ := pem.Decode(b)
+	switch {
+	case block == nil:
+		return apiv1.UnspecifiedSignAlgorithm, false
+	case block.Headers["Proc-Type"] == "4,ENCRYPTED", block.Type == "ENCRYPTED PRIVATE KEY":
+		return apiv1.UnspecifiedSignAlgorithm, true
+	}
+
+	v, err := pemutil.Parse(b, pemutil.WithFirstBlock())
+	if err != nil {
+		return apiv1.UnspecifiedSignAlgorithm, false
+	}
+	switch vv := v.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return apiv1.SignatureAlgorithmForKey(vv), true
+	case crypto.Signer:
+		return apiv1.SignatureAlgorithmForKey(vv.Public()), true
+	default:
+		return apiv1.UnspecifiedSignAlgorithm, false
+	}
+}
+
+// Encrypt encrypts the given plaintext using AES-GCM with the symmetric key in
+// the JWK file passed in the request name. The JWK file can be encrypted using
+// the password in the request. The returned ciphertext is prefixed with the
+// random nonce used.
+func (k *SoftKMS) Encrypt(req *apiv1.EncryptRequest) ([]byte, error) {
+	aead, err := readSymmetricKey(req.Name, req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "error generating nonce")
+	}
+	return aead.Seal(nonce, nonce, req.Plaintext, req.AdditionalData), nil
+}
+
+// Decrypt decrypts the given ciphertext, as returned by Encrypt, using the
+// symmetric key in the JWK file passed in the request name.
+func (k *SoftKMS) Decrypt(req *apiv1.DecryptRequest) ([]byte, error) {
+	aead, err := readSymmetricKey(req.Name, req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	size := aead.NonceSize()
+	if len(req.Ciphertext) < size+aead.Overhead() {
+		return nil, errors.New("error decrypting data: ciphertext is too short")
+	}
+	plaintext, err := aead.Open(nil, req.Ciphertext[:size], req.Ciphertext[size:], req.AdditionalData)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decrypting data")
+	}
+	return plaintext, nil
+}
+
+// LoadCertificate implements kms.CertificateManager and loads a certificate
+// from the file in the request name. If the file contains a bundle, the first
+// certificate is returned.
+func (k *SoftKMS) LoadCertificate(req *apiv1.LoadCertificateRequest) (*x509.Certificate, error) {
+	chain, err := k.LoadCertificateChain(&apiv1.LoadCertificateChainRequest{
+		Name: req.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chain[0], nil
+}
+
+// StoreCertificate implements kms.CertificateManager and stores a certificate
+// in the file in the request name. The file is replaced if it exists.
+func (k *SoftKMS) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
+	if req.Certificate == nil {
+		return errors.New("storeCertificateRequest 'Certificate' cannot be nil")
+	}
+	return storeCertificates(req.Name, []*x509.Certificate{req.Certificate})
+}
+
+// LoadCertificateChain implements kms.CertificateChainManager and loads the
+// bundle of certificates in the file in the request name.
+func (k *SoftKMS) LoadCertificateChain(req *apiv1.LoadCertificateChainRequest) ([]*x509.Certificate, error) {
+	filename, _, err := parseCertificateName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := pemutil.ReadCertificateBundle(filename)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	if len(chain) == 0 {
+		return nil, errors.Errorf("error reading %s: file does not contain any certificate", filename)
+	}
+	return chain, nil
+}
+
+// StoreCertificateChain implements kms.CertificateChainManager and stores the
+// chain of certificates in the file in the request name. The file is replaced
+// if it exists.
+func (k *SoftKMS) StoreCertificateChain(req *apiv1.StoreCertificateChainRequest) error {
+	if len(req.CertificateChain) == 0 {
+		return errors.New("storeCertificateChainRequest 'CertificateChain' cannot be empty")
+	}
+	for _, cert := range req.CertificateChain {
+		if cert == nil {
+			return errors.New("storeCertificateChainRequest 'CertificateChain' cannot contain nil certificates")
+		}
+	}
+	return storeCertificates(req.Name, req.CertificateChain)
+}
+
+// storeCertificates writes the PEM-encoded certificates in the file in the
+// given name.
+func storeCertificates(name string, certs []*x509.Certificate) error {
+	filename, perm, err := parseCertificateName(name)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		if err := pem.Encode(&buf, &pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: cert.Raw,
+		}); err != nil {
+			return errors.Wrap(err, "error encoding certificate")
+		}
+	}
+	return convertError(writeFile(filename, buf.Bytes(), perm))
+}
+
+// parseCertificateName returns the filename and the permissions of a
+// certificate file. The name can be a filename or an URI like
+// "softkms:path=/path/to/cert.crt;mode=0644". Certificate files are written
+// with the 0600 permissions by default.
+func parseCertificateName(name string) (string, os.FileMode, error) {
+	if name == "" {
+		return "", 0, errors.New("certificate name cannot be empty")
+	}
+	if !uri.HasScheme(Scheme, name) {
+		return name, 0600, nil
+	}
+
+	u, err := uri.ParseWithScheme(Scheme, name)
+	if err != nil {
+		return "", 0, err
+	}
+	path := u.Get("path")
+	if path == "" {
+		return "", 0, errors.Errorf("certificate uri %s is not valid: path is missing", name)
+	}
+	perm := os.FileMode(0600)
+	if v := u.Get("mode"); v != "" {
+		m, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || m > 0777 {
+			return "", 0, errors.Errorf("certificate uri %s is not valid: mode %s is not valid", name, v)
+		}
+		perm = os.FileMode(m)
+	}
+	return path, perm, nil
+}
+
+// writeFile atomically writes the data to the given filename. The data is
+// written to a temporary file in the same directory that is renamed to the
+// filename, so the file is never partially written.
+func writeFile(filename string, data []byte, perm os.FileMode) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return errors.Wrapf(err, "error writing %s", filename)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			err = errors.Wrapf(err, "error writing %s", filename)
+		}
+	}()
+
+	if err = f.Chmod(perm); err != nil {
+		return
+	}
+	if _, err = f.Write(data); err != nil {
+		return
+	}
+	if err = f.Sync(); err != nil {
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// createFile writes the data to a new file with the given filename, it fails
+// with an os.ErrExist error if the file already exists. The file is removed if
+// the data cannot be written.
+func createFile(filename string, data []byte, perm os.FileMode) (err error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return errors.Wrapf(err, "error creating %s", filename)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(filename)
+			err = errors.Wrapf(err, "error writing %s", filename)
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return
+	}
+	if err = f.Sync(); err != nil {
+		return
+	}
+	return f.Close()
+}
+
+// readKey reads the key in the given filename or softkms URI. If the password
+// is not given, the password-file or password-source in the URI is used to
+// decrypt the key.
+func readKey(name string, password []byte) (interface{}, error) {
+	var opts []pemutil.Options
+	filename := name
+	if uri.HasScheme(Scheme, name) {
+		var passwordSource string
+		var err error
+		if filename, passwordSource, err = parseKeyURI(name); err != nil {
+			return nil, err
+		}
+		if passwordSource != "" && password == nil {
+			if password, err = uri.ReadPinSource(passwordSource); err != nil {
+				return nil, convertError(err)
+			}
+		}
+	}
+	if password != nil {
+		opts = append(opts, pemutil.WithPassword(password))
+	}
+
+	v, err := pemutil.Read(filename, opts...)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	return v, nil
+}
+
+// parseKeyURI returns the path and the password source in a softkms URI. The
+// password source is the password-source attribute or, if not present, the
+// password-file attribute.
+func parseKeyURI(rawuri string) (string, string, error) {
+	u, err := uri.ParseWithScheme(Scheme, rawuri)
+	if err != nil {
+		return "", "", err
+	}
+	path := u.Get("path")
+	if path == "" {
+		return "", "", errors.Errorf("key uri %s is not valid: path is missing", rawuri)
+	}
+	if source := u.Get("password-source"); source != "" {
+		return path, source, nil
+	}
+	return path, u.Get("password-file"), nil
+}
+
+// convertError converts the errors reading files to the error types defined in
+// apiv1.
+func convertError(err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return apiv1.NotFoundError{Err: err}
+	case errors.Is(err, os.ErrPermission):
+		return apiv1.PermissionDeniedError{Err: err}
+	default:
+		return err
+	}
+}
+
+// readSymmetricKey reads an oct JWK from the given file and returns an AES-GCM
+// cipher.AEAD with it.
+func readSymmetricKey(filename string, password []byte) (cipher.AEAD, error) {
+	if filename == "" {
+		return nil, errors.New("name cannot be empty")
+	}
+
+	var opts []jose.Option
+	if password != nil {
+		opts = append(opts, jose.WithPassword(password))
+	}
+	jwk, err := jose.ReadKey(filename, opts...)
+	if err != nil {
+		return nil, convertError(err)
+	}
+	key, ok := jwk.Key.([]byte)
+	if !ok {
+		return nil, errors.Errorf("%s is not a symmetric key", filename)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading %s", filename)
+	}
+	return cipher.NewGCM(block)
+}
@@ -9,13 +9,20 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
+	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/minica"
 	"go.step.sm/crypto/pemutil"
 )
 
@@ -63,6 +70,32 @@ func TestSoftKMS_Close(t *testing.T) {
 	}
 }
 
+func TestSoftKMS_ValidateName(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{"ok filename", "testdata/priv.pem", false},
+		{"ok uri", "softkms:path=testdata/priv.pem", false},
+		{"ok password file", "softkms:path=testdata/priv.pem;password-file=testdata/password.txt", false},
+		{"ok password source", "softkms:path=testdata/priv.pem?password-source=env:KEY_PASSWORD", false},
+		{"ok mode", "softkms:path=testdata/cert.crt;mode=0644", false},
+		{"fail unknown attribute", "softkms:path=testdata/priv.pem;pin-value=password", true},
+		{"fail repeated attribute", "softkms:path=testdata/priv.pem?path=testdata/other.pem", true},
+		{"fail missing path", "softkms:password-file=testdata/password.txt", true},
+		{"fail empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SoftKMS{}
+			if err := k.ValidateName(tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.ValidateName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestSoftKMS_CreateSigner(t *testing.T) {
 	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -102,6 +135,12 @@ func TestSoftKMS_CreateSigner(t *testing.T) {
 		Bytes: b,
 	})
 
+	passwordFile := filepath.Join(t.TempDir(), "password.txt")
+	if err := os.WriteFile(passwordFile, []byte("pass\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTKMS_PASSWORD", "pass")
+
 	type args struct {
 		req *apiv1.CreateSignerRequest
 	}
@@ -115,12 +154,17 @@ func TestSoftKMS_CreateSigner(t *testing.T) {
 		{"pem", args{&apiv1.CreateSignerRequest{SigningKeyPEM: pem.EncodeToMemory(pemBlock)}}, pk, false},
 		{"pem password", args{&apiv1.CreateSignerRequest{SigningKeyPEM: pem.EncodeToMemory(pemBlockPassword), Password: []byte("pass")}}, pk, false},
 		{"file", args{&apiv1.CreateSignerRequest{SigningKey: "testdata/priv.pem", Password: []byte("pass")}}, pk2, false},
+		{"uri", args{&apiv1.CreateSignerRequest{SigningKey: "softkms:path=testdata/priv.pem", Password: []byte("pass")}}, pk2, false},
+		{"uri password file", args{&apiv1.CreateSignerRequest{SigningKey: "softkms:path=testdata/priv.pem;password-file=" + passwordFile}}, pk2, false},
+		{"uri password source", args{&apiv1.CreateSignerRequest{SigningKey: "softkms:path=testdata/priv.pem;password-source=env:SOFTKMS_PASSWORD"}}, pk2, false},
+		{"fail uri password source", args{&apiv1.CreateSignerRequest{SigningKey: "softkms:path=testdata/priv.pem;password-source=env:SOFTKMS_MISSING"}}, nil, true},
 		{"fail", args{&apiv1.CreateSignerRequest{}}, nil, true},
 		{"fail bad pem", args{&apiv1.CreateSignerRequest{SigningKeyPEM: []byte("bad pem")}}, nil, true},
 		{"fail bad password", args{&apiv1.CreateSignerRequest{SigningKey: "testdata/priv.pem", Password: []byte("bad-pass")}}, nil, true},
 		{"fail not a signer", args{&apiv1.CreateSignerRequest{SigningKeyPEM: pub}}, nil, true},
 		{"fail not a signer from file", args{&apiv1.CreateSignerRequest{SigningKey: "testdata/pub.pem"}}, nil, true},
 		{"fail missing", args{&apiv1.CreateSignerRequest{SigningKey: "testdata/missing"}}, nil, true},
+		{"fail uri path", args{&apiv1.CreateSignerRequest{SigningKey: "softkms:name=testdata/priv.pem"}}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -233,6 +277,152 @@ func TestSoftKMS_CreateKey(t *testing.T) {
 	}
 }
 
+func TestSoftKMS_CreateKey_persistent(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password.txt")
+	if err := os.WriteFile(passwordFile, []byte("file-password\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTKMS_PASSWORD", "source-password")
+	existing := filepath.Join(dir, "existing.pem")
+	if err := os.WriteFile(existing, []byte("existing"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		req            *apiv1.CreateKeyRequest
+		signerPassword []byte
+		wantEncrypted  bool
+		wantErr        bool
+	}{
+		{"ok", &apiv1.CreateKeyRequest{
+			Name: "softkms:path=" + filepath.Join(dir, "p256.pem"),
+		}, nil, false, false},
+		{"ok rsa", &apiv1.CreateKeyRequest{
+			Name: "softkms:path=" + filepath.Join(dir, "rsa.pem"), SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 2048,
+		}, nil, false, false},
+		{"ok ed25519", &apiv1.CreateKeyRequest{
+			Name: "softkms:path=" + filepath.Join(dir, "ed25519.pem"), SignatureAlgorithm: apiv1.PureEd25519,
+		}, nil, false, false},
+		{"ok password", &apiv1.CreateKeyRequest{
+			Name: "softkms:path=" + filepath.Join(dir, "password.pem"), Password: []byte("password"),
+		}, []byte("password"), true, false},
+		{"ok password file", &apiv1.CreateKeyRequest{
+			Name: "softkms:path=" + filepath.Join(dir, "password-file.pem") + ";password-file=" + passwordFile,
+		}, nil, true, false},
+		{"ok password source", &apiv1.CreateKeyRequest{
+			Name: "softkms:path=" + filepath.Join(dir, "password-source.pem") + ";password-source=env:SOFTKMS_PASSWORD",
+		}, nil, true, false},
+		{"fail path", &apiv1.CreateKeyRequest{
+			Name: "softkms:name=missing",
+		}, nil, false, true},
+		{"fail exists", &apiv1.CreateKeyRequest{
+			Name: "softkms:path=" + existing,
+		}, nil, false, true},
+		{"fail password file", &apiv1.CreateKeyRequest{
+			Name: "softkms:path=" + filepath.Join(dir, "fail.pem") + ";password-file=" + filepath.Join(dir, "missing.txt"),
+		}, nil, false, true},
+		{"fail password source", &apiv1.CreateKeyRequest{
+			Name: "softkms:path=" + filepath.Join(dir, "fail.pem") + ";password-source=env:SOFTKMS_MISSING",
+		}, nil, false, true},
+		{"fail write", &apiv1.CreateKeyRequest{
+			Name: "softkms:path=" + filepath.Join(dir, "missing", "key.pem"),
+		}, nil, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SoftKMS{}
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.PrivateKey != nil || got.CreateSignerRequest.Signer != nil {
+				t.Errorf("SoftKMS.CreateKey() returned the private key")
+			}
+
+			path, _, err := parseKeyURI(tt.req.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if block, _ := pem.Decode(b); block == nil {
+				t.Errorf("%s is not a PEM file", path)
+			} else if encrypted := block.Type == "ENCRYPTED PRIVATE KEY"; encrypted != tt.wantEncrypted {
+				t.Errorf("%s block type = %s, want encrypted %v", path, block.Type, tt.wantEncrypted)
+			}
+
+			pub, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: got.Name})
+			if err != nil {
+				t.Fatalf("SoftKMS.GetPublicKey() error = %v", err)
+			}
+			if !reflect.DeepEqual(pub, got.PublicKey) {
+				t.Errorf("SoftKMS.GetPublicKey() = %v, want %v", pub, got.PublicKey)
+			}
+
+			req := got.CreateSignerRequest
+			if !reflect.DeepEqual(req.Password, tt.signerPassword) {
+				t.Errorf("CreateSignerRequest.Password = %s, want %s", req.Password, tt.signerPassword)
+			}
+			signer, err := k.CreateSigner(&req)
+			if err != nil {
+				t.Fatalf("SoftKMS.CreateSigner() error = %v", err)
+			}
+			if !reflect.DeepEqual(signer.Public(), got.PublicKey) {
+				t.Errorf("signer.Public() = %v, want %v", signer.Public(), got.PublicKey)
+			}
+		})
+	}
+}
+
+func TestSoftKMS_CreateKey_concurrent(t *testing.T) {
+	name := "softkms:path=" + filepath.Join(t.TempDir(), "key.pem")
+
+	const n = 8
+	var wg sync.WaitGroup
+	results := make([]*apiv1.CreateKeyResponse, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = (&SoftKMS{}).CreateKey(&apiv1.CreateKeyRequest{Name: name})
+		}(i)
+	}
+	wg.Wait()
+
+	var created *apiv1.CreateKeyResponse
+	for i, err := range errs {
+		switch {
+		case err == nil && created == nil:
+			created = results[i]
+		case err == nil:
+			t.Error("SoftKMS.CreateKey() succeeded more than once")
+		case !errors.Is(err, apiv1.ErrAlreadyExists):
+			t.Errorf("SoftKMS.CreateKey() error = %v, want %v", err, apiv1.ErrAlreadyExists)
+		}
+	}
+	if created == nil {
+		t.Fatal("SoftKMS.CreateKey() never succeeded")
+	}
+
+	// The stored key is the one returned.
+	signer, err := (&SoftKMS{}).CreateSigner(&created.CreateSignerRequest)
+	if err != nil {
+		t.Fatalf("SoftKMS.CreateSigner() error = %v", err)
+	}
+	if !reflect.DeepEqual(signer.Public(), created.PublicKey) {
+		t.Errorf("signer.Public() = %v, want %v", signer.Public(), created.PublicKey)
+	}
+}
+
 func TestSoftKMS_GetPublicKey(t *testing.T) {
 	b, err := os.ReadFile("testdata/pub.pem")
 	if err != nil {
@@ -243,6 +433,18 @@ func TestSoftKMS_GetPublicKey(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	certKey, err := pemutil.Read("testdata/cert.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := pemutil.Read("testdata/priv.pem", pemutil.WithPassword([]byte("pass")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	passwordFile := filepath.Join(t.TempDir(), "password.txt")
+	if err := os.WriteFile(passwordFile, []byte("pass"), 0600); err != nil {
+		t.Fatal(err)
+	}
 
 	type args struct {
 		req *apiv1.GetPublicKeyRequest
@@ -257,6 +459,12 @@ func TestSoftKMS_GetPublicKey(t *testing.T) {
 		{"cert", args{&apiv1.GetPublicKeyRequest{Name: "testdata/cert.crt"}}, pub, false},
 		{"fail not exists", args{&apiv1.GetPublicKeyRequest{Name: "testdata/missing"}}, nil, true},
 		{"fail type", args{&apiv1.GetPublicKeyRequest{Name: "testdata/cert.key"}}, nil, true},
+		{"uri private key", args{&apiv1.GetPublicKeyRequest{Name: "softkms:path=testdata/cert.key"}}, certKey.(crypto.Signer).Public(), false},
+		{"uri encrypted private key", args{&apiv1.GetPublicKeyRequest{Name: "softkms:path=testdata/priv.pem;password-file=" + passwordFile}}, privKey.(crypto.Signer).Public(), false},
+		{"fail uri not exists", args{&apiv1.GetPublicKeyRequest{Name: "softkms:path=testdata/missing"}}, nil, true},
+		{"fail uri not private key", args{&apiv1.GetPublicKeyRequest{Name: "softkms:path=testdata/pub.pem"}}, nil, true},
+		{"fail uri password", args{&apiv1.GetPublicKeyRequest{Name: "softkms:path=testdata/priv.pem"}}, nil, true},
+		{"fail uri path", args{&apiv1.GetPublicKeyRequest{Name: "softkms:name=testdata/pub.pem"}}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -379,3 +587,497 @@ func TestSoftKMS_CreateDecrypter(t *testing.T) {
 		})
 	}
 }
+
+func TestSoftKMS_ListKeys(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "foo.txt"), []byte("foo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile("testdata/pub.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pub.pem"), b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&SoftKMS{}).CreateKey(&apiv1.CreateKeyRequest{
+		Name:               "softkms:path=" + filepath.Join(dir, "key.pem"),
+		SignatureAlgorithm: apiv1.ECDSAWithSHA384,
+		Password:           []byte("pass"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		req *apiv1.ListKeysRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *apiv1.ListKeysResponse
+		wantErr bool
+	}{
+		{"ok", args{&apiv1.ListKeysRequest{Name: "testdata"}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{
+				{Name: filepath.Join("testdata", "cert.key"), SignatureAlgorithm: apiv1.ECDSAWithSHA256, ProtectionLevel: apiv1.Software},
+				{Name: filepath.Join("testdata", "priv.pem"), SignatureAlgorithm: apiv1.UnspecifiedSignAlgorithm, ProtectionLevel: apiv1.Software},
+				{Name: filepath.Join("testdata", "pub.pem"), SignatureAlgorithm: apiv1.ECDSAWithSHA256, ProtectionLevel: apiv1.Software},
+				{Name: filepath.Join("testdata", "rsa.priv.pem"), SignatureAlgorithm: apiv1.UnspecifiedSignAlgorithm, ProtectionLevel: apiv1.Software},
+				{Name: filepath.Join("testdata", "rsa.pub.pem"), SignatureAlgorithm: apiv1.SHA256WithRSA, ProtectionLevel: apiv1.Software},
+			},
+		}, false},
+		{"ok filter", args{&apiv1.ListKeysRequest{Name: "testdata", NameFilter: "rsa.*"}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{
+				{Name: filepath.Join("testdata", "rsa.priv.pem"), SignatureAlgorithm: apiv1.UnspecifiedSignAlgorithm, ProtectionLevel: apiv1.Software},
+				{Name: filepath.Join("testdata", "rsa.pub.pem"), SignatureAlgorithm: apiv1.SHA256WithRSA, ProtectionLevel: apiv1.Software},
+			},
+		}, false},
+		{"ok page", args{&apiv1.ListKeysRequest{Name: "testdata", PageSize: 2, PageToken: "2"}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{
+				{Name: filepath.Join("testdata", "pub.pem"), SignatureAlgorithm: apiv1.ECDSAWithSHA256, ProtectionLevel: apiv1.Software},
+				{Name: filepath.Join("testdata", "rsa.priv.pem"), SignatureAlgorithm: apiv1.UnspecifiedSignAlgorithm, ProtectionLevel: apiv1.Software},
+			},
+			NextPageToken: "4",
+		}, false},
+		{"ok skip other files", args{&apiv1.ListKeysRequest{Name: dir}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{
+				{Name: "softkms:path=" + strings.ReplaceAll(filepath.Join(dir, "key.pem"), "/", "%2F"), SignatureAlgorithm: apiv1.ECDSAWithSHA384, ProtectionLevel: apiv1.Software},
+				{Name: filepath.Join(dir, "pub.pem"), SignatureAlgorithm: apiv1.ECDSAWithSHA256, ProtectionLevel: apiv1.Software},
+			},
+		}, false},
+		{"ok labels", args{&apiv1.ListKeysRequest{Name: dir, Labels: map[string]string{"foo": "bar"}}}, &apiv1.ListKeysResponse{}, false},
+		{"fail name", args{&apiv1.ListKeysRequest{}}, nil, true},
+		{"fail missing", args{&apiv1.ListKeysRequest{Name: "testdata/missing"}}, nil, true},
+		{"fail page token", args{&apiv1.ListKeysRequest{Name: "testdata", PageToken: "foo"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SoftKMS{}
+			got, err := k.ListKeys(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.ListKeys() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SoftKMS.ListKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func writeSymmetricKey(t *testing.T, dir string, password []byte) string {
+	t.Helper()
+	jwk, err := jose.GenerateJWK("oct", "", "", "enc", "", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b []byte
+	if password != nil {
+		jwe, err := jose.EncryptJWK(jwk, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := jwe.CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = []byte(s)
+	} else if b, err = jwk.MarshalJSON(); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, fmt.Sprintf("key-%d.json", len(password)))
+	if err := os.WriteFile(filename, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestSoftKMS_Encrypt(t *testing.T) {
+	dir := t.TempDir()
+	password := []byte("password")
+	keyFile := writeSymmetricKey(t, dir, nil)
+	encryptedKeyFile := writeSymmetricKey(t, dir, password)
+
+	type args struct {
+		req *apiv1.EncryptRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"ok", args{&apiv1.EncryptRequest{Name: keyFile, Plaintext: []byte("plaintext")}}, false},
+		{"ok additional data", args{&apiv1.EncryptRequest{Name: keyFile, Plaintext: []byte("plaintext"), AdditionalData: []byte("aad")}}, false},
+		{"ok encrypted key", args{&apiv1.EncryptRequest{Name: encryptedKeyFile, Plaintext: []byte("plaintext"), Password: password}}, false},
+		{"fail name", args{&apiv1.EncryptRequest{Plaintext: []byte("plaintext")}}, true},
+		{"fail missing", args{&apiv1.EncryptRequest{Name: "testdata/missing", Plaintext: []byte("plaintext")}}, true},
+		{"fail password", args{&apiv1.EncryptRequest{Name: encryptedKeyFile, Plaintext: []byte("plaintext"), Password: []byte("bad-password")}}, true},
+		{"fail not symmetric", args{&apiv1.EncryptRequest{Name: "testdata/pub.pem", Plaintext: []byte("plaintext")}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SoftKMS{}
+			got, err := k.Encrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.Encrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			plaintext, err := k.Decrypt(&apiv1.DecryptRequest{
+				Name:           tt.args.req.Name,
+				Ciphertext:     got,
+				AdditionalData: tt.args.req.AdditionalData,
+				Password:       tt.args.req.Password,
+			})
+			if err != nil {
+				t.Errorf("SoftKMS.Decrypt() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(plaintext, tt.args.req.Plaintext) {
+				t.Errorf("SoftKMS.Decrypt() = %s, want %s", plaintext, tt.args.req.Plaintext)
+			}
+		})
+	}
+}
+
+func TestSoftKMS_Decrypt(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := writeSymmetricKey(t, dir, nil)
+
+	k := &SoftKMS{}
+	ciphertext, err := k.Encrypt(&apiv1.EncryptRequest{
+		Name:           keyFile,
+		Plaintext:      []byte("plaintext"),
+		AdditionalData: []byte("aad"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1] ^= 0xff
+
+	type args struct {
+		req *apiv1.DecryptRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", args{&apiv1.DecryptRequest{Name: keyFile, Ciphertext: ciphertext, AdditionalData: []byte("aad")}}, []byte("plaintext"), false},
+		{"fail name", args{&apiv1.DecryptRequest{Ciphertext: ciphertext, AdditionalData: []byte("aad")}}, nil, true},
+		{"fail additional data", args{&apiv1.DecryptRequest{Name: keyFile, Ciphertext: ciphertext}}, nil, true},
+		{"fail tampered", args{&apiv1.DecryptRequest{Name: keyFile, Ciphertext: tampered, AdditionalData: []byte("aad")}}, nil, true},
+		{"fail short", args{&apiv1.DecryptRequest{Name: keyFile, Ciphertext: ciphertext[:12], AdditionalData: []byte("aad")}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.Decrypt(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SoftKMS.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSoftKMS_errors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	k := &SoftKMS{}
+	tests := []struct {
+		name string
+		fn   func() error
+	}{
+		{"GetPublicKey", func() error {
+			_, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: missing})
+			return err
+		}},
+		{"CreateSigner", func() error {
+			_, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: missing})
+			return err
+		}},
+		{"CreateDecrypter", func() error {
+			_, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{DecryptionKey: missing})
+			return err
+		}},
+		{"ListKeys", func() error {
+			_, err := k.ListKeys(&apiv1.ListKeysRequest{Name: missing})
+			return err
+		}},
+		{"Encrypt", func() error {
+			_, err := k.Encrypt(&apiv1.EncryptRequest{Name: missing})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.fn(); !errors.Is(err, apiv1.ErrNotFound) {
+				t.Errorf("SoftKMS.%s() error = %v, want apiv1.NotFoundError", tt.name, err)
+			}
+		})
+	}
+}
+
+func Test_convertError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"not found", fmt.Errorf("error reading file: %w", os.ErrNotExist), apiv1.ErrNotFound},
+		{"permission denied", &os.PathError{Op: "open", Path: "file", Err: os.ErrPermission}, apiv1.ErrPermissionDenied},
+		{"other", errors.New("an error"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := convertError(tt.err)
+			if tt.want == nil {
+				if err != tt.err {
+					t.Errorf("convertError() = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("convertError() = %#v, want %T", err, tt.want)
+			}
+			if err.Error() != tt.err.Error() {
+				t.Errorf("convertError().Error() = %s, want %s", err.Error(), tt.err.Error())
+			}
+		})
+	}
+	if err := convertError(nil); err != nil {
+		t.Errorf("convertError() = %v, want nil", err)
+	}
+}
+
+func mustCertificateChain(t *testing.T) []*x509.Certificate {
+	t.Helper()
+	ca, err := minica.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := ca.Sign(&x509.Certificate{
+		Subject:   pkix.Name{CommonName: "test.example.org"},
+		DNSNames:  []string{"test.example.org"},
+		PublicKey: signer.Public(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*x509.Certificate{leaf, ca.Intermediate}
+}
+
+func TestSoftKMS_LoadCertificate(t *testing.T) {
+	cert, err := pemutil.ReadCertificate("testdata/cert.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := mustCertificateChain(t)
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "bundle.crt")
+	if err := storeCertificates(bundle, chain); err != nil {
+		t.Fatal(err)
+	}
+	der := filepath.Join(dir, "cert.der")
+	if err := os.WriteFile(der, cert.Raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.crt")
+	if err := os.WriteFile(empty, []byte("-----BEGIN CERTIFICATE-----\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     *apiv1.LoadCertificateRequest
+		want    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok", &apiv1.LoadCertificateRequest{Name: "testdata/cert.crt"}, cert, false},
+		{"ok uri", &apiv1.LoadCertificateRequest{Name: "softkms:path=testdata/cert.crt"}, cert, false},
+		{"ok der", &apiv1.LoadCertificateRequest{Name: der}, cert, false},
+		{"ok bundle", &apiv1.LoadCertificateRequest{Name: bundle}, chain[0], false},
+		{"fail empty name", &apiv1.LoadCertificateRequest{Name: ""}, nil, true},
+		{"fail missing", &apiv1.LoadCertificateRequest{Name: "testdata/missing.crt"}, nil, true},
+		{"fail uri path", &apiv1.LoadCertificateRequest{Name: "softkms:name=testdata/cert.crt"}, nil, true},
+		{"fail not a certificate", &apiv1.LoadCertificateRequest{Name: "testdata/pub.pem"}, nil, true},
+		{"fail empty", &apiv1.LoadCertificateRequest{Name: empty}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SoftKMS{}
+			got, err := k.LoadCertificate(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.LoadCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SoftKMS.LoadCertificate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSoftKMS_StoreCertificate(t *testing.T) {
+	chain := mustCertificateChain(t)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		req      *apiv1.StoreCertificateRequest
+		filename string
+		wantMode os.FileMode
+		wantErr  bool
+	}{
+		{"ok", &apiv1.StoreCertificateRequest{
+			Name: filepath.Join(dir, "cert.crt"), Certificate: chain[0],
+		}, filepath.Join(dir, "cert.crt"), 0600, false},
+		{"ok replace", &apiv1.StoreCertificateRequest{
+			Name: filepath.Join(dir, "cert.crt"), Certificate: chain[1],
+		}, filepath.Join(dir, "cert.crt"), 0600, false},
+		{"ok uri", &apiv1.StoreCertificateRequest{
+			Name: "softkms:path=" + filepath.Join(dir, "uri.crt") + ";mode=0644", Certificate: chain[0],
+		}, filepath.Join(dir, "uri.crt"), 0644, false},
+		{"fail nil", &apiv1.StoreCertificateRequest{
+			Name: filepath.Join(dir, "nil.crt"),
+		}, "", 0, true},
+		{"fail empty name", &apiv1.StoreCertificateRequest{
+			Name: "", Certificate: chain[0],
+		}, "", 0, true},
+		{"fail uri path", &apiv1.StoreCertificateRequest{
+			Name: "softkms:name=" + filepath.Join(dir, "uri.crt"), Certificate: chain[0],
+		}, "", 0, true},
+		{"fail uri mode", &apiv1.StoreCertificateRequest{
+			Name: "softkms:path=" + filepath.Join(dir, "uri.crt") + ";mode=0999", Certificate: chain[0],
+		}, "", 0, true},
+		{"fail write", &apiv1.StoreCertificateRequest{
+			Name: filepath.Join(dir, "missing", "cert.crt"), Certificate: chain[0],
+		}, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SoftKMS{}
+			if err := k.StoreCertificate(tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.StoreCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			st, err := os.Stat(tt.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st.Mode().Perm() != tt.wantMode {
+				t.Errorf("file mode = %o, want %o", st.Mode().Perm(), tt.wantMode)
+			}
+			got, err := k.LoadCertificate(&apiv1.LoadCertificateRequest{Name: tt.req.Name})
+			if err != nil {
+				t.Fatalf("SoftKMS.LoadCertificate() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.req.Certificate) {
+				t.Errorf("SoftKMS.LoadCertificate() = %v, want %v", got, tt.req.Certificate)
+			}
+		})
+	}
+
+	// Temporary files must be removed.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			t.Errorf("temporary file %s was not removed", e.Name())
+		}
+	}
+}
+
+func TestSoftKMS_LoadCertificateChain(t *testing.T) {
+	chain := mustCertificateChain(t)
+	bundle := filepath.Join(t.TempDir(), "bundle.crt")
+	if err := storeCertificates(bundle, chain); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		req     *apiv1.LoadCertificateChainRequest
+		want    []*x509.Certificate
+		wantErr bool
+	}{
+		{"ok", &apiv1.LoadCertificateChainRequest{Name: bundle}, chain, false},
+		{"ok uri", &apiv1.LoadCertificateChainRequest{Name: "softkms:path=" + bundle}, chain, false},
+		{"fail missing", &apiv1.LoadCertificateChainRequest{Name: "testdata/missing.crt"}, nil, true},
+		{"fail not a certificate", &apiv1.LoadCertificateChainRequest{Name: "testdata/pub.pem"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SoftKMS{}
+			got, err := k.LoadCertificateChain(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.LoadCertificateChain() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SoftKMS.LoadCertificateChain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSoftKMS_StoreCertificateChain(t *testing.T) {
+	chain := mustCertificateChain(t)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		req     *apiv1.StoreCertificateChainRequest
+		wantErr bool
+	}{
+		{"ok", &apiv1.StoreCertificateChainRequest{
+			Name: filepath.Join(dir, "bundle.crt"), CertificateChain: chain,
+		}, false},
+		{"ok uri", &apiv1.StoreCertificateChainRequest{
+			Name: "softkms:path=" + filepath.Join(dir, "uri.crt"), CertificateChain: chain,
+		}, false},
+		{"fail empty", &apiv1.StoreCertificateChainRequest{
+			Name: filepath.Join(dir, "empty.crt"),
+		}, true},
+		{"fail nil", &apiv1.StoreCertificateChainRequest{
+			Name: filepath.Join(dir, "nil.crt"), CertificateChain: []*x509.Certificate{chain[0], nil},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SoftKMS{}
+			if err := k.StoreCertificateChain(tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("SoftKMS.StoreCertificateChain() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			got, err := k.LoadCertificateChain(&apiv1.LoadCertificateChainRequest{Name: tt.req.Name})
+			if err != nil {
+				t.Fatalf("SoftKMS.LoadCertificateChain() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.req.CertificateChain) {
+				t.Errorf("SoftKMS.LoadCertificateChain() = %v, want %v", got, tt.req.CertificateChain)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package sshagentkms
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/ecdsa"
@@ -12,18 +13,49 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 
 	"github.com/pkg/errors"
+	"go.step.sm/crypto/keyutil"
 	"go.step.sm/crypto/kms/apiv1"
 	"go.step.sm/crypto/sshutil"
 
 	"go.step.sm/crypto/pemutil"
 )
 
-// SSHAgentKMS is a key manager that uses keys provided by ssh-agent
+// DefaultRSAKeySize is the default size for RSA keys created in the agent.
+const DefaultRSAKeySize = 3072
+
+type algorithmAttributes struct {
+	Type  string
+	Curve string
+}
+
+// signatureAlgorithmMapping maps the signature algorithms to the keys that can
+// be added to an agent. Ed25519 is the default as it's the only key type that
+// can sign X509 certificates using the agent.
+var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]algorithmAttributes{
+	apiv1.UnspecifiedSignAlgorithm: {"OKP", "Ed25519"},
+	apiv1.SHA256WithRSA:            {"RSA", ""},
+	apiv1.SHA384WithRSA:            {"RSA", ""},
+	apiv1.SHA512WithRSA:            {"RSA", ""},
+	apiv1.ECDSAWithSHA256:          {"EC", "P-256"},
+	apiv1.ECDSAWithSHA384:          {"EC", "P-384"},
+	apiv1.ECDSAWithSHA512:          {"EC", "P-521"},
+	apiv1.PureEd25519:              {"OKP", "Ed25519"},
+}
+
+// SSHAgentKMS is a key manager that uses keys provided by ssh-agent.
+//
+// Keys are referenced using the prefix "sshagentkms:" followed by the comment
+// of the key, its SSH fingerprint, or the key id of the certificate loaded
+// with the key, for example:
+//   - sshagentkms:user@example.com
+//   - sshagentkms:SHA256:ZEaFOYrx5fZ6wL7ZGSGnQmOFuV7FPp7mJOYKtNu35SU
+//   - sshagentkms:MD5:7d:33:1a:c0:02:b6:4b:ab:6c:1a:03:e5:b0:f7:a5:9b
 type SSHAgentKMS struct {
 	agentClient agent.Agent
 }
@@ -62,6 +94,21 @@ func (k *SSHAgentKMS) Close() error {
 	return nil
 }
 
+// ValidateName validates that the given string is a valid key name, a filename
+// or the "sshagentkms:" prefix followed by the comment, fingerprint or key id
+// of the key. sshagentkms names are not key-value URIs, so they are not
+// validated with a schema.
+func (k *SSHAgentKMS) ValidateName(s string) error {
+	switch {
+	case s == "":
+		return errors.New("key name cannot be empty")
+	case s == "sshagentkms:":
+		return errors.Errorf("key name %s is not valid: key is missing", s)
+	default:
+		return nil
+	}
+}
+
 // WrappedSSHSigner is a utility type to wrap a ssh.Signer as a crypto.Signer
 type WrappedSSHSigner struct {
 	Signer        ssh.Signer
@@ -116,6 +163,9 @@ func NewWrappedSignerFromSSHSigner(signer ssh.Signer) crypto.Signer {
 	return &WrappedSSHSigner{Signer: signer}
 }
 
+// findKey returns the position in the agent of the key with the given name.
+// The name is first compared with the comments of the keys, then with their
+// fingerprints, and finally with the key id of the certificates in the agent.
 func (k *SSHAgentKMS) findKey(signingKey string) (target int, err error) {
 	if strings.HasPrefix(signingKey, "sshagentkms:") {
 		var key = strings.TrimPrefix(signingKey, "sshagentkms:")
@@ -129,9 +179,54 @@ func (k *SSHAgentKMS) findKey(signingKey string) (target int, err error) {
 				return i, nil
 			}
 		}
+		for i, s := range l {
+			if pub, _, err := parsePublicKey(s); err == nil && matchFingerprint(pub, key) {
+				return i, nil
+			}
+		}
+		for i, s := range l {
+			if _, cert, err := parsePublicKey(s); err == nil && cert != nil && cert.KeyId == key {
+				// Prefer the key without the certificate if it is also loaded.
+				b := cert.Key.Marshal()
+				for j, ss := range l {
+					if bytes.Equal(ss.Blob, b) {
+						return j, nil
+					}
+				}
+				return i, nil
+			}
+		}
+	}
+
+	return -1, apiv1.NotFoundError{
+		Message: "SSHAgentKMS couldn't find " + signingKey,
+	}
+}
+
+// parsePublicKey parses the given agent key. If the key is a certificate, it
+// returns the public key in the certificate and the certificate.
+func parsePublicKey(key ssh.PublicKey) (ssh.PublicKey, *ssh.Certificate, error) {
+	pub, err := ssh.ParsePublicKey(key.Marshal())
+	if err != nil {
+		return nil, nil, err
+	}
+	if cert, ok := pub.(*ssh.Certificate); ok {
+		return cert.Key, cert, nil
 	}
+	return pub, nil, nil
+}
 
-	return -1, errors.Errorf("SSHAgentKMS couldn't find %s", signingKey)
+// matchFingerprint returns true if the fingerprint of the key, in the SHA256 or
+// MD5 formats used by ssh-keygen, is the given one.
+func matchFingerprint(pub ssh.PublicKey, fingerprint string) bool {
+	switch {
+	case strings.HasPrefix(fingerprint, "SHA256:"):
+		return ssh.FingerprintSHA256(pub) == fingerprint
+	case strings.HasPrefix(fingerprint, "MD5:"):
+		return ssh.FingerprintLegacyMD5(pub) == strings.TrimPrefix(fingerprint, "MD5:")
+	default:
+		return false
+	}
 }
 
 // CreateSigner returns a new signer configured with the given signing key. Note
@@ -186,9 +281,53 @@ func (k *SSHAgentKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signe
 	}
 }
 
-// CreateKey generates a new key and returns both public and private key.
+// CreateKey generates a new key and adds it to the agent using the name
+// without the prefix as the comment, for example, "sshagentkms:my-key". The
+// key is added with the lifetime and confirmation constraints in the request.
+// The private key is not returned, it can only be used through the agent.
 func (k *SSHAgentKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
-	return nil, errors.Errorf("SSHAgentKMS doesn't support generating keys")
+	comment := strings.TrimPrefix(req.Name, "sshagentkms:")
+	if !strings.HasPrefix(req.Name, "sshagentkms:") || comment == "" {
+		return nil, errors.New("createKeyRequest 'name' must be a valid sshagentkms name")
+	}
+	if req.Lifetime < 0 || (req.Lifetime > 0 && req.Lifetime < time.Second) {
+		return nil, errors.New("createKeyRequest 'lifetime' must be at least one second")
+	}
+	v, ok := signatureAlgorithmMapping[req.SignatureAlgorithm]
+	if !ok {
+		return nil, errors.Errorf("SSHAgentKMS does not support signature algorithm '%s'", req.SignatureAlgorithm)
+	}
+	if _, err := k.findKey(req.Name); err == nil {
+		return nil, apiv1.AlreadyExistsError{
+			Message: req.Name + " already exists",
+		}
+	}
+
+	size := req.Bits
+	if v.Type == "RSA" && size == 0 {
+		size = DefaultRSAKeySize
+	}
+	signer, err := keyutil.GenerateSigner(v.Type, v.Curve, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := k.agentClient.Add(agent.AddedKey{
+		PrivateKey:       signer,
+		Comment:          comment,
+		LifetimeSecs:     uint32(req.Lifetime / time.Second),
+		ConfirmBeforeUse: req.ConfirmBeforeUse,
+	}); err != nil {
+		return nil, errors.Wrap(err, "error adding key to the agent")
+	}
+
+	return &apiv1.CreateKeyResponse{
+		Name:      req.Name,
+		PublicKey: signer.Public(),
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: req.Name,
+		},
+	}, nil
 }
 
 // GetPublicKey returns the public key from the file passed in the request name.
@@ -206,7 +345,10 @@ func (k *SSHAgentKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.Publi
 			return nil, err
 		}
 
-		sshPub := s[target].PublicKey()
+		sshPub, _, err := parsePublicKey(s[target].PublicKey())
+		if err != nil {
+			return nil, err
+		}
 		pub, err = sshutil.CryptoPublicKey(sshPub)
 		if err != nil {
 			return nil, err
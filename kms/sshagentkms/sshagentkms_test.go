@@ -8,8 +8,10 @@ import (
 	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"net"
 	"os"
 	"os/exec"
@@ -18,6 +20,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"go.step.sm/crypto/kms/apiv1"
 	"go.step.sm/crypto/pemutil"
@@ -274,6 +277,28 @@ func TestSSHAgentKMS_Close(t *testing.T) {
 	}
 }
 
+func TestSSHAgentKMS_ValidateName(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{"ok comment", "sshagentkms:user@example.com", false},
+		{"ok fingerprint", "sshagentkms:SHA256:ZEaFOYrx5fZ6wL7ZGSGnQmOFuV7FPp7mJOYKtNu35SU", false},
+		{"ok filename", "testdata/priv.pem", false},
+		{"fail missing key", "sshagentkms:", true},
+		{"fail empty", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &SSHAgentKMS{}
+			if err := k.ValidateName(tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("SSHAgentKMS.ValidateName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func TestSSHAgentKMS_CreateSigner(t *testing.T) {
 	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -480,29 +505,229 @@ func TestSSHAgentKMS_GetPublicKey(t *testing.T) {
 	}
 }
 
+// recordingAgent is an agent.Agent that keeps the keys added to it.
+type recordingAgent struct {
+	agent.Agent
+	added []agent.AddedKey
+}
+
+func (a *recordingAgent) Add(key agent.AddedKey) error {
+	a.added = append(a.added, key)
+	return a.Agent.Add(key)
+}
+
 func TestSSHAgentKMS_CreateKey(t *testing.T) {
-	starters := []struct {
-		name    string
-		starter startTestAgentFunc
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		req *apiv1.CreateKeyRequest
+	}
+	tests := []struct {
+		name        string
+		args        args
+		wantType    interface{}
+		wantAdded   agent.AddedKey
+		wantErr     bool
+		wantErrType error
 	}{
-		{"startTestOpenSSHAgent", startTestOpenSSHAgent},
-		{"startTestKeyringAgent", startTestKeyringAgent},
+		{"ok", args{&apiv1.CreateKeyRequest{Name: "sshagentkms:ed25519"}}, ed25519.PublicKey{}, agent.AddedKey{Comment: "ed25519"}, false, nil},
+		{"ok ecdsa", args{&apiv1.CreateKeyRequest{Name: "sshagentkms:ecdsa", SignatureAlgorithm: apiv1.ECDSAWithSHA384}}, &ecdsa.PublicKey{}, agent.AddedKey{Comment: "ecdsa"}, false, nil},
+		{"ok rsa", args{&apiv1.CreateKeyRequest{Name: "sshagentkms:rsa", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 2048}}, &rsa.PublicKey{}, agent.AddedKey{Comment: "rsa"}, false, nil},
+		{"ok lifetime", args{&apiv1.CreateKeyRequest{Name: "sshagentkms:lifetime", Lifetime: time.Hour}}, ed25519.PublicKey{}, agent.AddedKey{Comment: "lifetime", LifetimeSecs: 3600}, false, nil},
+		{"ok confirm", args{&apiv1.CreateKeyRequest{Name: "sshagentkms:confirm", ConfirmBeforeUse: true}}, ed25519.PublicKey{}, agent.AddedKey{Comment: "confirm", ConfirmBeforeUse: true}, false, nil},
+		{"fail name", args{&apiv1.CreateKeyRequest{Name: "sshagentkms:"}}, nil, agent.AddedKey{}, true, nil},
+		{"fail prefix", args{&apiv1.CreateKeyRequest{Name: "my-key"}}, nil, agent.AddedKey{}, true, nil},
+		{"fail lifetime", args{&apiv1.CreateKeyRequest{Name: "sshagentkms:lifetime", Lifetime: 500 * time.Millisecond}}, nil, agent.AddedKey{}, true, nil},
+		{"fail signature algorithm", args{&apiv1.CreateKeyRequest{Name: "sshagentkms:pss", SignatureAlgorithm: apiv1.SHA256WithRSAPSS}}, nil, agent.AddedKey{}, true, nil},
+		{"fail already exists", args{&apiv1.CreateKeyRequest{Name: "sshagentkms:existing"}}, nil, agent.AddedKey{}, true, apiv1.ErrAlreadyExists},
 	}
-	for _, starter := range starters {
-		k, err := NewFromAgent(context.Background(), apiv1.Options{}, starter.starter(t))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring := agent.NewKeyring()
+			if err := keyring.Add(agent.AddedKey{PrivateKey: priv, Comment: "existing"}); err != nil {
+				t.Fatal(err)
+			}
+			sshagent := &recordingAgent{Agent: keyring}
+			k, err := NewFromAgent(context.Background(), apiv1.Options{}, sshagent)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := k.CreateKey(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SSHAgentKMS.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErrType != nil && !errors.Is(err, tt.wantErrType) {
+				t.Errorf("SSHAgentKMS.CreateKey() error = %v, want %T", err, tt.wantErrType)
+			}
+			if tt.wantErr {
+				if got != nil || len(sshagent.added) != 0 {
+					t.Errorf("SSHAgentKMS.CreateKey() = %v, added = %v, want nil", got, sshagent.added)
+				}
+				return
+			}
+
+			if reflect.TypeOf(got.PublicKey) != reflect.TypeOf(tt.wantType) {
+				t.Errorf("SSHAgentKMS.CreateKey() PublicKey = %T, want %T", got.PublicKey, tt.wantType)
+			}
+			if got.PrivateKey != nil {
+				t.Errorf("SSHAgentKMS.CreateKey() PrivateKey = %T, want nil", got.PrivateKey)
+			}
+			wantReq := apiv1.CreateSignerRequest{SigningKey: tt.args.req.Name}
+			if !reflect.DeepEqual(got.CreateSignerRequest, wantReq) {
+				t.Errorf("SSHAgentKMS.CreateKey() CreateSignerRequest = %v, want %v", got.CreateSignerRequest, wantReq)
+			}
+
+			if len(sshagent.added) != 1 {
+				t.Fatalf("SSHAgentKMS.CreateKey() added %d keys, want 1", len(sshagent.added))
+			}
+			added := sshagent.added[0]
+			added.PrivateKey = nil
+			if !reflect.DeepEqual(added, tt.wantAdded) {
+				t.Errorf("SSHAgentKMS.CreateKey() added = %+v, want %+v", added, tt.wantAdded)
+			}
+
+			// The new key can be used to sign
+			pub, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.args.req.Name})
+			if err != nil {
+				t.Fatalf("SSHAgentKMS.GetPublicKey() error = %v", err)
+			}
+			if !reflect.DeepEqual(pub, got.PublicKey) {
+				t.Errorf("SSHAgentKMS.GetPublicKey() = %v, want %v", pub, got.PublicKey)
+			}
+			signer, err := k.CreateSigner(&got.CreateSignerRequest)
+			if err != nil {
+				t.Fatalf("SSHAgentKMS.CreateSigner() error = %v", err)
+			}
+			message := []byte("message")
+			if _, err := signer.Sign(rand.Reader, message, crypto.Hash(0)); err != nil {
+				t.Fatalf("WrappedSSHSigner.Sign() error = %v", err)
+			}
+			sshPub, err := ssh.NewPublicKey(got.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := sshPub.Verify(message, signer.(*WrappedSSHSigner).LastSignature()); err != nil {
+				t.Errorf("ssh.PublicKey.Verify() error = %v", err)
+			}
+		})
+	}
+}
+
+func mustCertificate(t *testing.T, pub crypto.PublicKey, keyID string) *ssh.Certificate {
+	t.Helper()
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caSigner, err := ssh.NewSignerFromSigner(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &ssh.Certificate{
+		Key:             key,
+		KeyId:           keyID,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"jane"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestSSHAgentKMS_findKey(t *testing.T) {
+	mustKey := func() (crypto.PublicKey, ed25519.PrivateKey, ssh.PublicKey) {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
 		if err != nil {
 			t.Fatal(err)
 		}
-		t.Run(starter.name+"/CreateKey", func(t *testing.T) {
-			got, err := k.CreateKey(&apiv1.CreateKeyRequest{
-				Name:               "sshagentkms:0",
-				SignatureAlgorithm: apiv1.ECDSAWithSHA256,
-			})
-			if got != nil {
-				t.Error("SSHAgentKMS.CreateKey() shoudn't return a value")
+		sshPub, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pub, priv, sshPub
+	}
+
+	// Keyring stores a key with a certificate as a single entry, but other
+	// agents, like OpenSSH, store the key and the certificate as two entries.
+	pub0, priv0, sshPub0 := mustKey()
+	pub1, priv1, sshPub1 := mustKey()
+	pub2, priv2, _ := mustKey()
+	keyring := agent.NewKeyring()
+	for _, key := range []agent.AddedKey{
+		{PrivateKey: priv0, Comment: "plain"},
+		{PrivateKey: priv1, Comment: "with-certificate", Certificate: mustCertificate(t, pub1, "certificate-key-id")},
+		{PrivateKey: priv2, Comment: "with-certificate-only", Certificate: mustCertificate(t, pub2, "certificate-only-key-id")},
+		{PrivateKey: priv1, Comment: "key-of-certificate"},
+	} {
+		if err := keyring.Add(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	k, err := NewFromAgent(context.Background(), apiv1.Options{}, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		keyName     string
+		want        crypto.PublicKey
+		wantErr     bool
+		wantErrType error
+	}{
+		{"comment", "sshagentkms:plain", pub0, false, nil},
+		{"comment certificate", "sshagentkms:with-certificate", pub1, false, nil},
+		{"fingerprint sha256", "sshagentkms:" + ssh.FingerprintSHA256(sshPub0), pub0, false, nil},
+		{"fingerprint md5", "sshagentkms:MD5:" + ssh.FingerprintLegacyMD5(sshPub0), pub0, false, nil},
+		{"fingerprint certificate", "sshagentkms:" + ssh.FingerprintSHA256(sshPub1), pub1, false, nil},
+		{"certificate", "sshagentkms:certificate-key-id", pub1, false, nil},
+		{"certificate only", "sshagentkms:certificate-only-key-id", pub2, false, nil},
+		{"fail fingerprint", "sshagentkms:SHA256:ZEaFOYrx5fZ6wL7ZGSGnQmOFuV7FPp7mJOYKtNu35SU", nil, true, apiv1.ErrNotFound},
+		{"fail missing", "sshagentkms:missing", nil, true, apiv1.ErrNotFound},
+		{"fail prefix", "plain", nil, true, apiv1.ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.keyName})
+			if tt.wantErr {
+				// The name without prefix is read as a file
+				if err == nil {
+					t.Errorf("SSHAgentKMS.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if _, err := k.findKey(tt.keyName); !errors.Is(err, tt.wantErrType) {
+					t.Errorf("SSHAgentKMS.findKey() error = %v, want %T", err, tt.wantErrType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SSHAgentKMS.GetPublicKey() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SSHAgentKMS.GetPublicKey() = %v, want %v", got, tt.want)
+			}
+
+			signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: tt.keyName})
+			if err != nil {
+				t.Fatalf("SSHAgentKMS.CreateSigner() error = %v", err)
+			}
+			message := []byte("message")
+			sig, err := signer.Sign(rand.Reader, message, crypto.Hash(0))
+			if err != nil {
+				t.Fatalf("WrappedSSHSigner.Sign() error = %v", err)
 			}
-			if err == nil {
-				t.Error("SSHAgentKMS.CreateKey() didn't return a value")
+			if !ed25519.Verify(tt.want.(ed25519.PublicKey), message, sig) {
+				t.Error("ed25519.Verify() = false, want true")
 			}
 		})
 	}
@@ -0,0 +1,225 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/kms/uri"
+	"go.step.sm/crypto/pemutil"
+)
+
+// TLSCertificate returns a tls.Certificate that signs using the key in the
+// given KMS URI, and uses the certificate chain in certURI.
+//
+// If keyURI is not the URI of a registered KMS, it is considered a file and
+// loaded with the default KMS. If certURI is empty, the chain is loaded from
+// the KMS of the key using the keyURI, if it is the URI of a registered KMS the
+// chain is loaded from that KMS, and otherwise it is read from a PEM file.
+//
+// It also returns the KeyManager used to load the key. The signer in the
+// certificate might require it to keep working, so the caller must close it
+// once the certificate is no longer used, for example, after reloading it.
+func TLSCertificate(ctx context.Context, keyURI, certURI string) (*tls.Certificate, KeyManager, error) {
+	if keyURI == "" {
+		return nil, nil, errors.New("key uri cannot be empty")
+	}
+
+	var err error
+	km := KeyManager(Default)
+	if hasKMSScheme(keyURI) {
+		if km, err = loadKMS(ctx, keyURI); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	signer, err := km.CreateSigner(&apiv1.CreateSignerRequest{
+		SigningKey: keyURI,
+	})
+	if err != nil {
+		km.Close()
+		return nil, nil, errors.Wrapf(err, "error loading key %s", keyURI)
+	}
+
+	chain, err := loadCertificateChain(ctx, km, keyURI, certURI)
+	if err != nil {
+		km.Close()
+		return nil, nil, err
+	}
+
+	cert, err := NewTLSCertificate(signer, chain)
+	if err != nil {
+		km.Close()
+		return nil, nil, err
+	}
+	return cert, km, nil
+}
+
+// NewTLSCertificate returns a tls.Certificate with the given signer and
+// certificate chain, the leaf certificate must be the first one and it must
+// match the public key of the signer. The supported signature algorithms of
+// the certificate are restricted to the ones that a KMS can use with the key:
+// if the signer has a SignatureAlgorithm method, like the cloudkms signer, only
+// that algorithm is used, otherwise ECDSA keys only sign using the hash that
+// matches the curve, and RSA keys do not use RSA-PSS if the signer has a
+// SupportsPSS method that returns false, like the tpmkms signer.
+func NewTLSCertificate(signer crypto.Signer, chain []*x509.Certificate) (*tls.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("certificate chain cannot be empty")
+	}
+
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(chain[0].PublicKey) {
+		return nil, errors.New("certificate does not match the private key")
+	}
+
+	schemes, err := signatureSchemes(signer)
+	if err != nil {
+		return nil, err
+	}
+
+	certificates := make([][]byte, len(chain))
+	for i, crt := range chain {
+		certificates[i] = crt.Raw
+	}
+
+	return &tls.Certificate{
+		Certificate:                  certificates,
+		PrivateKey:                   signer,
+		SupportedSignatureAlgorithms: schemes,
+		Leaf:                         chain[0],
+	}, nil
+}
+
+// hasKMSScheme returns true if the given name is the URI of a registered KMS.
+func hasKMSScheme(name string) bool {
+	u, err := uri.Parse(name)
+	if err != nil {
+		return false
+	}
+	_, ok := apiv1.LoadKeyManagerNewFunc(apiv1.Type(strings.ToLower(u.Scheme)))
+	return ok
+}
+
+// loadCertificateChain loads the certificate chain in certURI, or the one
+// stored with the key in keyURI if certURI is empty.
+func loadCertificateChain(ctx context.Context, km KeyManager, keyURI, certURI string) ([]*x509.Certificate, error) {
+	switch {
+	case certURI == "":
+		return loadCertificateChainFromKMS(km, keyURI)
+	case !hasKMSScheme(certURI):
+		return pemutil.ReadCertificateBundle(certURI)
+	}
+
+	if u, err := uri.Parse(keyURI); err != nil || !uri.HasScheme(u.Scheme, certURI) {
+		ckm, err := loadKMS(ctx, certURI)
+		if err != nil {
+			return nil, err
+		}
+		defer ckm.Close()
+		km = ckm
+	}
+	return loadCertificateChainFromKMS(km, certURI)
+}
+
+func loadCertificateChainFromKMS(km KeyManager, name string) ([]*x509.Certificate, error) {
+	switch cm := km.(type) {
+	case CertificateChainManager:
+		chain, err := cm.LoadCertificateChain(&apiv1.LoadCertificateChainRequest{
+			Name: name,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading certificate chain %s", name)
+		}
+		return chain, nil
+	case CertificateManager:
+		cert, err := cm.LoadCertificate(&apiv1.LoadCertificateRequest{
+			Name: name,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading certificate %s", name)
+		}
+		return []*x509.Certificate{cert}, nil
+	default:
+		return nil, apiv1.NotImplementedError{
+			Message: "kms does not implement a CertificateManager",
+		}
+	}
+}
+
+// algorithmSigner is implemented by signers bound to a single signature
+// algorithm, like the cloudkms signer.
+type algorithmSigner interface {
+	SignatureAlgorithm() x509.SignatureAlgorithm
+}
+
+// pssSigner is implemented by RSA signers that might not be able to create
+// RSA-PSS signatures with the salt length required by TLS, like the tpmkms
+// signer.
+type pssSigner interface {
+	SupportsPSS() bool
+}
+
+// signatureSchemeMapping maps the signature algorithm of a signer bound to a
+// single algorithm with the TLS signature scheme.
+var signatureSchemeMapping = map[x509.SignatureAlgorithm]tls.SignatureScheme{
+	x509.SHA256WithRSA:    tls.PKCS1WithSHA256,
+	x509.SHA384WithRSA:    tls.PKCS1WithSHA384,
+	x509.SHA512WithRSA:    tls.PKCS1WithSHA512,
+	x509.SHA256WithRSAPSS: tls.PSSWithSHA256,
+	x509.SHA384WithRSAPSS: tls.PSSWithSHA384,
+	x509.SHA512WithRSAPSS: tls.PSSWithSHA512,
+	x509.ECDSAWithSHA256:  tls.ECDSAWithP256AndSHA256,
+	x509.ECDSAWithSHA384:  tls.ECDSAWithP384AndSHA384,
+	x509.ECDSAWithSHA512:  tls.ECDSAWithP521AndSHA512,
+	x509.PureEd25519:      tls.Ed25519,
+}
+
+// signatureSchemes returns the TLS signature schemes supported by the given
+// signer.
+func signatureSchemes(signer crypto.Signer) ([]tls.SignatureScheme, error) {
+	if s, ok := signer.(algorithmSigner); ok {
+		alg := s.SignatureAlgorithm()
+		scheme, ok := signatureSchemeMapping[alg]
+		if !ok {
+			return nil, errors.Errorf("unsupported signature algorithm %s", alg)
+		}
+		return []tls.SignatureScheme{scheme}, nil
+	}
+
+	switch k := signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256}, nil
+		case elliptic.P384():
+			return []tls.SignatureScheme{tls.ECDSAWithP384AndSHA384}, nil
+		case elliptic.P521():
+			return []tls.SignatureScheme{tls.ECDSAWithP521AndSHA512}, nil
+		default:
+			return nil, errors.Errorf("unsupported elliptic curve %s", k.Curve.Params().Name)
+		}
+	case *rsa.PublicKey:
+		pkcs1 := []tls.SignatureScheme{
+			tls.PKCS1WithSHA256, tls.PKCS1WithSHA384, tls.PKCS1WithSHA512,
+		}
+		if s, ok := signer.(pssSigner); ok && !s.SupportsPSS() {
+			return pkcs1, nil
+		}
+		return append([]tls.SignatureScheme{
+			tls.PSSWithSHA256, tls.PSSWithSHA384, tls.PSSWithSHA512,
+		}, pkcs1...), nil
+	case ed25519.PublicKey:
+		return []tls.SignatureScheme{tls.Ed25519}, nil
+	default:
+		return nil, errors.Errorf("unsupported public key type %T", k)
+	}
+}
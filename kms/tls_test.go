@@ -0,0 +1,328 @@
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/kms/softkms"
+	"go.step.sm/crypto/pemutil"
+)
+
+// fakeCertManager is a KeyManager that only implements the CertificateManager
+// interface.
+type fakeCertManager struct {
+	apiv1.KeyManager
+	apiv1.CertificateManager
+}
+
+// fakeAlgorithmSigner is a signer bound to a single signature algorithm.
+type fakeAlgorithmSigner struct {
+	crypto.Signer
+	algorithm x509.SignatureAlgorithm
+}
+
+func (s *fakeAlgorithmSigner) SignatureAlgorithm() x509.SignatureAlgorithm {
+	return s.algorithm
+}
+
+// fakePSSSigner is a signer that reports if it supports RSA-PSS.
+type fakePSSSigner struct {
+	crypto.Signer
+	pss bool
+}
+
+func (s *fakePSSSigner) SupportsPSS() bool {
+	return s.pss
+}
+
+// bytesSigner is a signer with an unsupported public key.
+type bytesSigner []byte
+
+func (b bytesSigner) Public() crypto.PublicKey { return []byte(b) }
+func (b bytesSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func mustSelfSigned(t *testing.T, signer crypto.Signer) *x509.Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestTLSCertificate(t *testing.T) {
+	ctx := context.TODO()
+	chain, err := pemutil.ReadCertificateBundle("softkms/testdata/cert.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := (&softkms.SoftKMS{}).CreateSigner(&apiv1.CreateSignerRequest{
+		SigningKey: "softkms/testdata/cert.key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(t.TempDir(), "other.crt")
+	if err := os.WriteFile(other, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: mustSelfSigned(t, key).Raw,
+	}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &tls.Certificate{
+		Certificate:                  [][]byte{chain[0].Raw},
+		PrivateKey:                   signer,
+		SupportedSignatureAlgorithms: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		Leaf:                         chain[0],
+	}
+
+	type args struct {
+		ctx     context.Context
+		keyURI  string
+		certURI string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    *tls.Certificate
+		wantErr bool
+	}{
+		{"ok files", args{ctx, "softkms/testdata/cert.key", "softkms/testdata/cert.crt"}, want, false},
+		{"ok uris", args{ctx, "softkms:path=softkms/testdata/cert.key", "softkms:path=softkms/testdata/cert.crt"}, want, false},
+		{"ok cert uri", args{ctx, "softkms/testdata/cert.key", "softkms:path=softkms/testdata/cert.crt"}, want, false},
+		{"fail empty", args{ctx, "", "softkms/testdata/cert.crt"}, nil, true},
+		{"fail missing key", args{ctx, "softkms/testdata/missing.key", "softkms/testdata/cert.crt"}, nil, true},
+		{"fail missing cert", args{ctx, "softkms/testdata/cert.key", "softkms/testdata/missing.crt"}, nil, true},
+		{"fail missing cert uri", args{ctx, "softkms/testdata/cert.key", "softkms:path=softkms/testdata/missing.crt"}, nil, true},
+		{"fail cert from key", args{ctx, "softkms/testdata/cert.key", ""}, nil, true},
+		{"fail unregistered", args{ctx, "fail:softkms/testdata/cert.key", "softkms/testdata/cert.crt"}, nil, true},
+		{"fail mismatch", args{ctx, "softkms/testdata/cert.key", other}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, km, err := TLSCertificate(tt.args.ctx, tt.args.keyURI, tt.args.certURI)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TLSCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TLSCertificate() = %v, want %v", got, tt.want)
+			}
+			if (km == nil) != tt.wantErr {
+				t.Errorf("TLSCertificate() km = %v, wantErr %v", km, tt.wantErr)
+			}
+			if km != nil {
+				if err := km.Close(); err != nil {
+					t.Errorf("KeyManager.Close() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestTLSCertificate_handshake(t *testing.T) {
+	cert, km, err := TLSCertificate(context.TODO(), "softkms/testdata/cert.key", "softkms/testdata/cert.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer km.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+
+	srv, cli := net.Pipe()
+	defer srv.Close()
+	defer cli.Close()
+	server := tls.Server(srv, &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	client := tls.Client(cli, &tls.Config{
+		RootCAs:            pool,
+		InsecureSkipVerify: true, //nolint:gosec // the test certificate does not have SANs
+		MinVersion:         tls.VersionTLS12,
+	})
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.Handshake()
+	}()
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if peer := client.ConnectionState().PeerCertificates; len(peer) != 1 || !peer[0].Equal(cert.Leaf) {
+		t.Errorf("tls.Conn.PeerCertificates() = %v, want %v", peer, cert.Leaf)
+	}
+}
+
+func TestNewTLSCertificate(t *testing.T) {
+	mustECDSA := func(c elliptic.Curve) crypto.Signer {
+		key, err := ecdsa.GenerateKey(c, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, p384, p521 := mustECDSA(elliptic.P256()), mustECDSA(elliptic.P384()), mustECDSA(elliptic.P521())
+
+	tests := []struct {
+		name    string
+		signer  crypto.Signer
+		chain   []*x509.Certificate
+		want    []tls.SignatureScheme
+		wantErr bool
+	}{
+		{"ok P256", p256, []*x509.Certificate{mustSelfSigned(t, p256)}, []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256}, false},
+		{"ok P384", p384, []*x509.Certificate{mustSelfSigned(t, p384)}, []tls.SignatureScheme{tls.ECDSAWithP384AndSHA384}, false},
+		{"ok P521", p521, []*x509.Certificate{mustSelfSigned(t, p521)}, []tls.SignatureScheme{tls.ECDSAWithP521AndSHA512}, false},
+		{"ok RSA", rsaKey, []*x509.Certificate{mustSelfSigned(t, rsaKey), mustSelfSigned(t, p256)}, []tls.SignatureScheme{
+			tls.PSSWithSHA256, tls.PSSWithSHA384, tls.PSSWithSHA512,
+			tls.PKCS1WithSHA256, tls.PKCS1WithSHA384, tls.PKCS1WithSHA512,
+		}, false},
+		{"ok Ed25519", edKey, []*x509.Certificate{mustSelfSigned(t, edKey)}, []tls.SignatureScheme{tls.Ed25519}, false},
+		{"ok RSA PKCS1 only", &fakeAlgorithmSigner{rsaKey, x509.SHA256WithRSA}, []*x509.Certificate{mustSelfSigned(t, rsaKey)}, []tls.SignatureScheme{tls.PKCS1WithSHA256}, false},
+		{"ok RSA PSS only", &fakeAlgorithmSigner{rsaKey, x509.SHA512WithRSAPSS}, []*x509.Certificate{mustSelfSigned(t, rsaKey)}, []tls.SignatureScheme{tls.PSSWithSHA512}, false},
+		{"ok RSA without PSS", &fakePSSSigner{rsaKey, false}, []*x509.Certificate{mustSelfSigned(t, rsaKey)}, []tls.SignatureScheme{
+			tls.PKCS1WithSHA256, tls.PKCS1WithSHA384, tls.PKCS1WithSHA512,
+		}, false},
+		{"ok RSA with PSS", &fakePSSSigner{rsaKey, true}, []*x509.Certificate{mustSelfSigned(t, rsaKey)}, []tls.SignatureScheme{
+			tls.PSSWithSHA256, tls.PSSWithSHA384, tls.PSSWithSHA512,
+			tls.PKCS1WithSHA256, tls.PKCS1WithSHA384, tls.PKCS1WithSHA512,
+		}, false},
+		{"ok ECDSA only", &fakeAlgorithmSigner{p384, x509.ECDSAWithSHA384}, []*x509.Certificate{mustSelfSigned(t, p384)}, []tls.SignatureScheme{tls.ECDSAWithP384AndSHA384}, false},
+		{"fail unsupported algorithm", &fakeAlgorithmSigner{rsaKey, x509.SHA1WithRSA}, []*x509.Certificate{mustSelfSigned(t, rsaKey)}, nil, true},
+		{"fail empty", p256, nil, nil, true},
+		{"fail mismatch", p256, []*x509.Certificate{mustSelfSigned(t, p384)}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewTLSCertificate(tt.signer, tt.chain)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewTLSCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got.SupportedSignatureAlgorithms, tt.want) {
+				t.Errorf("NewTLSCertificate() SupportedSignatureAlgorithms = %v, want %v", got.SupportedSignatureAlgorithms, tt.want)
+			}
+			if !reflect.DeepEqual(got.PrivateKey, tt.signer) || got.Leaf != tt.chain[0] || len(got.Certificate) != len(tt.chain) {
+				t.Errorf("NewTLSCertificate() = %v, want signer and chain", got)
+			}
+		})
+	}
+}
+
+func Test_loadCertificateChain(t *testing.T) {
+	ctx := context.TODO()
+	chain, err := pemutil.ReadCertificateBundle("softkms/testdata/cert.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	bundle := filepath.Join(dir, "bundle.crt")
+	b, err := os.ReadFile("softkms/testdata/cert.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bundle, append(b, b...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		km      KeyManager
+		keyURI  string
+		certURI string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []*x509.Certificate
+		wantErr bool
+	}{
+		{"ok file", args{Default, "key.pem", "softkms/testdata/cert.crt"}, chain, false},
+		{"ok bundle", args{Default, "key.pem", bundle}, append(chain, chain...), false},
+		{"ok from key", args{Default, "softkms/testdata/cert.crt", ""}, chain, false},
+		{"ok other kms", args{&fakeKM{}, "fakekm:key", "softkms:path=softkms/testdata/cert.crt"}, chain, false},
+		{"ok certificate manager", args{&fakeCertManager{CertificateManager: &fakeCM{}}, "fake:key", "fake:foo"}, []*x509.Certificate{
+			{Subject: pkix.Name{CommonName: "fake:foo"}},
+		}, false},
+		{"fail not implemented", args{&fakeKM{}, "fakekm:key", ""}, nil, true},
+		{"fail certificate manager", args{&fakeCertManager{CertificateManager: &fakeCM{}}, "fail", ""}, nil, true},
+		{"fail missing", args{Default, "key.pem", "softkms/testdata/missing.crt"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadCertificateChain(ctx, tt.args.km, tt.args.keyURI, tt.args.certURI)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadCertificateChain() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadCertificateChain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_signatureSchemes(t *testing.T) {
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signatureSchemes(p224); err == nil {
+		t.Error("signatureSchemes() error = nil, wantErr true")
+	}
+	if _, err := signatureSchemes(&fakeAlgorithmSigner{Signer: p224}); err == nil {
+		t.Error("signatureSchemes() error = nil, wantErr true")
+	}
+	if _, err := signatureSchemes(bytesSigner("foo")); err == nil {
+		t.Error("signatureSchemes() error = nil, wantErr true")
+	}
+}
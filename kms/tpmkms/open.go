@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package tpmkms
+
+import (
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// openDevice opens the TPM in the given path. If the path is empty the default
+// Linux devices, /dev/tpmrm0 and /dev/tpm0, will be tried.
+func openDevice(path string) (io.ReadWriteCloser, error) {
+	if path == "" {
+		return tpm2.OpenTPM()
+	}
+	return tpm2.OpenTPM(path)
+}
@@ -0,0 +1,20 @@
+//go:build windows
+// +build windows
+
+package tpmkms
+
+import (
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/pkg/errors"
+)
+
+// openDevice opens the TPM using the TPM Base Services. A device path is not
+// supported on Windows.
+func openDevice(path string) (io.ReadWriteCloser, error) {
+	if path != "" {
+		return nil, errors.New("tpmKMS does not support a device on windows")
+	}
+	return tpm2.OpenTPM()
+}
@@ -0,0 +1,232 @@
+package tpmkms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/binary"
+	"io"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// Signer implements a crypto.Signer using a persistent key in the TPM.
+type Signer struct {
+	tpm       *TPMKMS
+	handle    tpmutil.Handle
+	publicKey crypto.PublicKey
+	rawRSA    bool
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// SupportsPSS returns true if the signer can create RSA-PSS signatures. The
+// salt length used by TPM2_Sign is chosen by the TPM, so RSA-PSS signatures are
+// created with a raw RSA operation, and only RSA keys that can be used to
+// decrypt without a scheme, like the ones created by CreateKey, support them.
+func (s *Signer) SupportsPSS() bool {
+	return s.rawRSA
+}
+
+// Sign signs the given digest with the TPM key. RSA-PSS signatures use the salt
+// length in the rsa.PSSOptions, rsa.PSSSaltLengthAuto uses a salt of the size
+// of the hash, see SupportsPSS.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, err := getHashAlgorithm(opts.HashFunc())
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := &tpm2.SigScheme{Hash: hash}
+	switch pub := s.publicKey.(type) {
+	case *ecdsa.PublicKey:
+		scheme.Alg = tpm2.AlgECDSA
+	case *rsa.PublicKey:
+		if o, ok := opts.(*rsa.PSSOptions); ok {
+			return s.signPSS(rand, pub, digest, o)
+		}
+		scheme.Alg = tpm2.AlgRSASSA
+	default:
+		return nil, errors.Errorf("unsupported key type %T", s.publicKey)
+	}
+
+	s.tpm.mu.Lock()
+	sig, err := tpm2.Sign(s.tpm.rw, s.handle, "", digest, nil, scheme)
+	s.tpm.mu.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "tpmKMS sign failed")
+	}
+
+	switch {
+	case sig.RSA != nil:
+		return sig.RSA.Signature, nil
+	case sig.ECC != nil:
+		var b cryptobyte.Builder
+		b.AddASN1(asn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1BigInt(sig.ECC.R)
+			b.AddASN1BigInt(sig.ECC.S)
+		})
+		return b.Bytes()
+	default:
+		return nil, errors.Errorf("tpmKMS sign returned an unsupported signature 0x%x", uint16(sig.Alg))
+	}
+}
+
+// signPSS encodes the digest with EMSA-PSS and signs it using TPM2_RSA_Decrypt
+// with TPM_ALG_NULL, a raw RSA operation.
+func (s *Signer) signPSS(rand io.Reader, pub *rsa.PublicKey, digest []byte, opts *rsa.PSSOptions) ([]byte, error) {
+	if !s.rawRSA {
+		return nil, errors.Errorf("tpmKMS key 0x%x does not support rsa-pss signatures", uint32(s.handle))
+	}
+
+	hash := opts.HashFunc()
+	saltLength := opts.SaltLength
+	switch saltLength {
+	case rsa.PSSSaltLengthAuto, rsa.PSSSaltLengthEqualsHash:
+		saltLength = hash.Size()
+	}
+	em, err := emsaPSSEncode(rand, hash, digest, pub.N.BitLen()-1, saltLength)
+	if err != nil {
+		return nil, err
+	}
+
+	s.tpm.mu.Lock()
+	sig, err := tpm2.RSADecrypt(s.tpm.rw, s.handle, "", em, &tpm2.AsymScheme{Alg: tpm2.AlgNull}, "")
+	s.tpm.mu.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "tpmKMS sign failed")
+	}
+
+	// The signature must have the size of the modulus.
+	size := pub.Size()
+	if len(sig) > size {
+		return nil, errors.New("tpmKMS sign returned an invalid signature")
+	}
+	b := make([]byte, size)
+	copy(b[size-len(sig):], sig)
+	return b, nil
+}
+
+// emsaPSSEncode implements the EMSA-PSS encoding operation defined in RFC 8017,
+// section 9.1.1, with MGF1 using the same hash.
+func emsaPSSEncode(rand io.Reader, hash crypto.Hash, digest []byte, emBits, saltLength int) ([]byte, error) {
+	hLen := hash.Size()
+	emLen := (emBits + 7) / 8
+	switch {
+	case len(digest) != hLen:
+		return nil, errors.Errorf("digest length %d does not match hash function %v", len(digest), hash)
+	case saltLength < 0:
+		return nil, errors.Errorf("invalid rsa-pss salt length %d", saltLength)
+	case emLen < hLen+saltLength+2:
+		return nil, errors.New("rsa-pss salt length is too large for the key")
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := io.ReadFull(rand, salt); err != nil {
+		return nil, errors.Wrap(err, "error generating salt")
+	}
+
+	h := hash.New()
+	h.Write(make([]byte, 8))
+	h.Write(digest)
+	h.Write(salt)
+	mHash := h.Sum(nil)
+
+	// EM = maskedDB || H || 0xbc, where DB = PS || 0x01 || salt
+	em := make([]byte, emLen)
+	db := em[:emLen-hLen-1]
+	db[emLen-saltLength-hLen-2] = 0x01
+	copy(db[emLen-saltLength-hLen-1:], salt)
+	copy(em[emLen-hLen-1:], mHash)
+	em[emLen-1] = 0xbc
+
+	mgf1XOR(db, hash, mHash)
+	db[0] &= 0xff >> (8*emLen - emBits)
+	return em, nil
+}
+
+// mgf1XOR XORs out with the mask generated by MGF1 using the given seed.
+func mgf1XOR(out []byte, hash crypto.Hash, seed []byte) {
+	var counter [4]byte
+	for done := 0; done < len(out); {
+		h := hash.New()
+		h.Write(seed)
+		h.Write(counter[:])
+		for _, b := range h.Sum(nil) {
+			if done == len(out) {
+				break
+			}
+			out[done] ^= b
+			done++
+		}
+		binary.BigEndian.PutUint32(counter[:], binary.BigEndian.Uint32(counter[:])+1)
+	}
+}
+
+// Decrypter implements a crypto.Decrypter using a persistent RSA key in the
+// TPM.
+type Decrypter struct {
+	*Signer
+}
+
+// NewDecrypter creates a Decrypter with the given signer. The signer must use
+// an RSA key.
+func NewDecrypter(s *Signer) (*Decrypter, error) {
+	if _, ok := s.publicKey.(*rsa.PublicKey); !ok {
+		return nil, errors.Errorf("tpmKMS key 0x%x is not an RSA key", uint32(s.handle))
+	}
+	return &Decrypter{Signer: s}, nil
+}
+
+// Decrypt decrypts a message encrypted with RSA-OAEP or RSA PKCS #1 v1.5. The
+// TPM appends a null byte to the OAEP labels, so a label must end with a null
+// byte to be used.
+func (d *Decrypter) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	scheme := &tpm2.AsymScheme{Alg: tpm2.AlgRSAES}
+	var label string
+	switch o := opts.(type) {
+	case nil, *rsa.PKCS1v15DecryptOptions:
+	case *rsa.OAEPOptions:
+		hash, err := getHashAlgorithm(o.Hash)
+		if err != nil {
+			return nil, err
+		}
+		scheme = &tpm2.AsymScheme{Alg: tpm2.AlgOAEP, Hash: hash}
+		if n := len(o.Label); n > 0 {
+			if n == 1 || o.Label[n-1] != 0 {
+				return nil, errors.New("rsa-oaep labels must end with a null byte")
+			}
+			label = string(o.Label[:n-1])
+		}
+	default:
+		return nil, errors.Errorf("unsupported decrypter options %T", opts)
+	}
+
+	d.tpm.mu.Lock()
+	defer d.tpm.mu.Unlock()
+	b, err := tpm2.RSADecrypt(d.tpm.rw, d.handle, "", ciphertext, scheme, label)
+	if err != nil {
+		return nil, errors.Wrap(err, "tpmKMS decrypt failed")
+	}
+	return b, nil
+}
+
+func getHashAlgorithm(h crypto.Hash) (tpm2.Algorithm, error) {
+	switch h {
+	case crypto.SHA256:
+		return tpm2.AlgSHA256, nil
+	case crypto.SHA384:
+		return tpm2.AlgSHA384, nil
+	case crypto.SHA512:
+		return tpm2.AlgSHA512, nil
+	default:
+		return 0, errors.Errorf("unsupported hash function %v", h)
+	}
+}
@@ -0,0 +1,250 @@
+package tpmkms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// rsaTPM is a fake TPM that only implements TPM2_RSA_Decrypt with TPM_ALG_NULL,
+// a raw RSA operation, using an RSA key.
+type rsaTPM struct {
+	key  *rsa.PrivateKey
+	resp bytes.Buffer
+}
+
+func (r *rsaTPM) Write(p []byte) (int, error) {
+	// Header, key handle and authorization area size.
+	if len(p) < 18 || binary.BigEndian.Uint32(p[6:10]) != uint32(tpm2.CmdRSADecrypt) {
+		return 0, errors.New("unsupported command")
+	}
+	b := p[18+binary.BigEndian.Uint32(p[14:18]):]
+	n := binary.BigEndian.Uint16(b)
+	m := new(big.Int).SetBytes(b[2 : 2+n])
+	out := new(big.Int).Exp(m, r.key.D, r.key.N).Bytes()
+
+	// Header, parameter size, output and an empty session with the nonce,
+	// attributes and hmac.
+	r.resp.Reset()
+	for _, v := range []interface{}{
+		uint16(tpm2.TagSessions), uint32(10 + 4 + 2 + len(out) + 5), uint32(0),
+		uint32(2 + len(out)), uint16(len(out)), out,
+		uint16(0), uint8(1), uint16(0),
+	} {
+		if err := binary.Write(&r.resp, binary.BigEndian, v); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (r *rsaTPM) Read(p []byte) (int, error) {
+	return r.resp.Read(p)
+}
+
+func (r *rsaTPM) Close() error {
+	return nil
+}
+
+func TestSigner_Sign(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := newBrokenKMS()
+	sum := sha256.Sum256([]byte("the message"))
+
+	type args struct {
+		rand   io.Reader
+		digest []byte
+		opts   crypto.SignerOpts
+	}
+	tests := []struct {
+		name    string
+		signer  *Signer
+		args    args
+		wantErr bool
+	}{
+		{"fail hash", &Signer{tpm: k, handle: 0x81000100, publicKey: ecKey.Public()}, args{rand.Reader, sum[:], crypto.SHA1}, true},
+		{"fail key type", &Signer{tpm: k, handle: 0x81000100, publicKey: edPub}, args{rand.Reader, sum[:], crypto.SHA256}, true},
+		{"fail sign ecdsa", &Signer{tpm: k, handle: 0x81000100, publicKey: ecKey.Public()}, args{rand.Reader, sum[:], crypto.SHA256}, true},
+		{"fail sign rsa", &Signer{tpm: k, handle: 0x81000100, publicKey: rsaKey.Public()}, args{rand.Reader, sum[:], crypto.SHA256}, true},
+		{"fail sign rsa-pss", &Signer{tpm: k, handle: 0x81000100, publicKey: rsaKey.Public()}, args{rand.Reader, sum[:], &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthAuto, Hash: crypto.SHA256,
+		}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.signer.Sign(tt.args.rand, tt.args.digest, tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil {
+				t.Errorf("Signer.Sign() = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestDecrypter_Decrypt(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDecrypter(&Signer{tpm: newBrokenKMS(), handle: 0x81000100, publicKey: rsaKey.Public()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		rand       io.Reader
+		ciphertext []byte
+		opts       crypto.DecrypterOpts
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"fail opts", args{rand.Reader, []byte("ciphertext"), crypto.SHA256}, true},
+		{"fail hash", args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA1}}, true},
+		{"fail label", args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label")}}, true},
+		{"fail null label", args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte{0}}}, true},
+		{"fail decrypt oaep", args{rand.Reader, []byte("ciphertext"), &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label\x00")}}, true},
+		{"fail decrypt pkcs1v15", args{rand.Reader, []byte("ciphertext"), &rsa.PKCS1v15DecryptOptions{}}, true},
+		{"fail decrypt nil", args{rand.Reader, []byte("ciphertext"), nil}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.Decrypt(tt.args.rand, tt.args.ciphertext, tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decrypter.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil {
+				t.Errorf("Decrypter.Decrypt() = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestSigner_Sign_pss(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A modulus with a size that is not a multiple of 8.
+	oddKey, err := rsa.GenerateKey(rand.Reader, 2047)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newSigner := func(key *rsa.PrivateKey, rawRSA bool) *Signer {
+		return &Signer{
+			tpm:       &TPMKMS{rw: &rsaTPM{key: key}},
+			handle:    0x81000100,
+			publicKey: key.Public(),
+			rawRSA:    rawRSA,
+		}
+	}
+	sum256 := sha256.Sum256([]byte("the message"))
+	sum384 := sha512.Sum384([]byte("the message"))
+	sum512 := sha512.Sum512([]byte("the message"))
+
+	tests := []struct {
+		name       string
+		signer     *Signer
+		digest     []byte
+		opts       *rsa.PSSOptions
+		saltLength int
+		wantErr    bool
+	}{
+		{"ok equals hash", newSigner(rsaKey, true), sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, rsa.PSSSaltLengthEqualsHash, false},
+		{"ok auto", newSigner(rsaKey, true), sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto, Hash: crypto.SHA256}, rsa.PSSSaltLengthEqualsHash, false},
+		{"ok sha384", newSigner(rsaKey, true), sum384[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA384}, rsa.PSSSaltLengthEqualsHash, false},
+		{"ok sha512", newSigner(rsaKey, true), sum512[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA512}, rsa.PSSSaltLengthEqualsHash, false},
+		{"ok salt length", newSigner(rsaKey, true), sum256[:], &rsa.PSSOptions{SaltLength: 20, Hash: crypto.SHA256}, 20, false},
+		{"ok no salt", newSigner(rsaKey, true), sum256[:], &rsa.PSSOptions{SaltLength: 0, Hash: crypto.SHA256}, 0, false},
+		{"ok odd modulus", newSigner(oddKey, true), sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, rsa.PSSSaltLengthEqualsHash, false},
+		{"fail not raw rsa", newSigner(rsaKey, false), sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, 0, true},
+		{"fail digest", newSigner(rsaKey, true), sum384[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, 0, true},
+		{"fail salt length", newSigner(rsaKey, true), sum256[:], &rsa.PSSOptions{SaltLength: 256, Hash: crypto.SHA256}, 0, true},
+		{"fail sign", &Signer{tpm: newBrokenKMS(), handle: 0x81000100, publicKey: rsaKey.Public(), rawRSA: true}, sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := tt.signer.Sign(rand.Reader, tt.digest, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			pub := tt.signer.Public().(*rsa.PublicKey)
+			if len(sig) != pub.Size() {
+				t.Errorf("Signer.Sign() len = %d, want %d", len(sig), pub.Size())
+			}
+			if err := rsa.VerifyPSS(pub, tt.opts.Hash, tt.digest, sig, &rsa.PSSOptions{
+				SaltLength: tt.saltLength,
+			}); err != nil {
+				t.Errorf("rsa.VerifyPSS() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestSigner_Sign_pssCertificate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := &Signer{
+		tpm:       &TPMKMS{rw: &rsaTPM{key: rsaKey}},
+		handle:    0x81000100,
+		publicKey: rsaKey.Public(),
+		rawRSA:    true,
+	}
+
+	// crypto/x509 verifies RSA-PSS signatures using rsa.PSSSaltLengthEqualsHash.
+	template := &x509.Certificate{
+		SerialNumber:       big.NewInt(1),
+		Subject:            pkix.Name{CommonName: "test"},
+		SignatureAlgorithm: x509.SHA384WithRSAPSS,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Errorf("x509.Certificate.CheckSignature() error = %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+//go:build tpmsimulator
+// +build tpmsimulator
+
+package tpmkms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+// mustSimulatorKMS creates a TPMKMS connected to a TPM simulator. To run these
+// tests, a simulator implementing the Microsoft simulator protocol, like the
+// IBM software TPM, must be listening in the ports 2321 and 2322:
+//
+//	tpm_server -rm
+//
+// The address of the simulator can be changed with the TPM_SIMULATOR
+// environment variable.
+func mustSimulatorKMS(t *testing.T) *TPMKMS {
+	t.Helper()
+	addr := os.Getenv("TPM_SIMULATOR")
+	if addr == "" {
+		addr = "127.0.0.1:2321"
+	}
+	k, err := New(context.Background(), apiv1.Options{
+		URI: "tpm:simulator=" + addr,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = k.Close()
+	})
+	return k
+}
+
+func mustCreateKey(t *testing.T, k *TPMKMS, name string, alg apiv1.SignatureAlgorithm, bits int) *apiv1.CreateKeyResponse {
+	t.Helper()
+	_ = k.DeleteKey(&apiv1.DeleteKeyRequest{Name: name})
+	resp, err := k.CreateKey(&apiv1.CreateKeyRequest{
+		Name:               name,
+		SignatureAlgorithm: alg,
+		Bits:               bits,
+	})
+	if err != nil {
+		t.Fatalf("TPMKMS.CreateKey() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = k.DeleteKey(&apiv1.DeleteKeyRequest{Name: name})
+	})
+	return resp
+}
+
+func TestSimulator_CreateKey(t *testing.T) {
+	k := mustSimulatorKMS(t)
+	tests := []struct {
+		name string
+		key  string
+		alg  apiv1.SignatureAlgorithm
+		bits int
+	}{
+		{"ok P256", "tpm:handle=0x81000100", apiv1.ECDSAWithSHA256, 0},
+		{"ok P384", "tpm:handle=0x81000101", apiv1.ECDSAWithSHA384, 0},
+		{"ok RSA", "tpm:handle=0x81000102", apiv1.SHA256WithRSA, 2048},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := mustCreateKey(t, k, tt.key, tt.alg, tt.bits)
+			if resp.Name != tt.key {
+				t.Errorf("TPMKMS.CreateKey() name = %s, want %s", resp.Name, tt.key)
+			}
+			pub, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: tt.key})
+			if err != nil {
+				t.Fatalf("TPMKMS.GetPublicKey() error = %v", err)
+			}
+			if !reflect.DeepEqual(pub, resp.PublicKey) {
+				t.Errorf("TPMKMS.GetPublicKey() = %v, want %v", pub, resp.PublicKey)
+			}
+			if _, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: tt.key}); err == nil {
+				t.Error("TPMKMS.CreateKey() error = nil, wantErr true")
+			}
+		})
+	}
+}
+
+func TestSimulator_Sign(t *testing.T) {
+	k := mustSimulatorKMS(t)
+	mustCreateKey(t, k, "tpm:handle=0x81000100", apiv1.ECDSAWithSHA256, 0)
+	mustCreateKey(t, k, "tpm:handle=0x81000101", apiv1.SHA256WithRSA, 2048)
+
+	ecSigner, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "tpm:handle=0x81000100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSigner, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "tpm:handle=0x81000101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum256 := sha256.Sum256([]byte("the message"))
+	sum384 := sha512.Sum384([]byte("the message"))
+	tests := []struct {
+		name   string
+		signer crypto.Signer
+		digest []byte
+		opts   crypto.SignerOpts
+	}{
+		{"ok ecdsa", ecSigner, sum256[:], crypto.SHA256},
+		{"ok ecdsa sha384", ecSigner, sum384[:], crypto.SHA384},
+		{"ok rsa", rsaSigner, sum256[:], crypto.SHA256},
+		{"ok rsa-pss", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto, Hash: crypto.SHA256}},
+		{"ok rsa-pss equals hash", rsaSigner, sum256[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sig, err := tt.signer.Sign(rand.Reader, tt.digest, tt.opts)
+			if err != nil {
+				t.Fatalf("Signer.Sign() error = %v", err)
+			}
+			switch pub := tt.signer.Public().(type) {
+			case *ecdsa.PublicKey:
+				if !ecdsa.VerifyASN1(pub, tt.digest, sig) {
+					t.Error("ecdsa.VerifyASN1() failed")
+				}
+			case *rsa.PublicKey:
+				if _, ok := tt.opts.(*rsa.PSSOptions); ok {
+					err = rsa.VerifyPSS(pub, crypto.SHA256, tt.digest, sig, &rsa.PSSOptions{
+						SaltLength: rsa.PSSSaltLengthEqualsHash,
+					})
+				} else {
+					err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, tt.digest, sig)
+				}
+				if err != nil {
+					t.Errorf("rsa verify error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSimulator_Decrypt(t *testing.T) {
+	k := mustSimulatorKMS(t)
+	mustCreateKey(t, k, "tpm:handle=0x81000100", apiv1.SHA256WithRSA, 2048)
+	mustCreateKey(t, k, "tpm:handle=0x81000101", apiv1.ECDSAWithSHA256, 0)
+
+	if _, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{DecryptionKey: "tpm:handle=0x81000101"}); err == nil {
+		t.Error("TPMKMS.CreateDecrypter() error = nil, wantErr true")
+	}
+
+	d, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{DecryptionKey: "tpm:handle=0x81000100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := d.Public().(*rsa.PublicKey)
+	plaintext := []byte("the plaintext")
+
+	oaep, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, plaintext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oaepLabel, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, plaintext, []byte("label\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1, err := rsa.EncryptPKCS1v15(rand.Reader, pub, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		opts       crypto.DecrypterOpts
+	}{
+		{"ok oaep", oaep, &rsa.OAEPOptions{Hash: crypto.SHA256}},
+		{"ok oaep label", oaepLabel, &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label\x00")}},
+		{"ok pkcs1v15", pkcs1, &rsa.PKCS1v15DecryptOptions{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := d.Decrypt(rand.Reader, tt.ciphertext, tt.opts)
+			if err != nil {
+				t.Fatalf("Decrypter.Decrypt() error = %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("Decrypter.Decrypt() = %s, want %s", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestSimulator_Certificate(t *testing.T) {
+	k := mustSimulatorKMS(t)
+	cert := mustCertificate(t)
+	if err := k.StoreCertificate(&apiv1.StoreCertificateRequest{
+		Name:        "tpm:nv-index=0x01500000",
+		Certificate: cert,
+	}); err != nil {
+		t.Fatalf("TPMKMS.StoreCertificate() error = %v", err)
+	}
+	t.Cleanup(func() {
+		_ = tpm2.NVUndefineSpace(k.rw, "", tpm2.HandleOwner, 0x01500000)
+	})
+
+	// Store again to replace the existing index.
+	if err := k.StoreCertificate(&apiv1.StoreCertificateRequest{
+		Name:        "tpm:nv-index=0x01500000",
+		Certificate: cert,
+	}); err != nil {
+		t.Fatalf("TPMKMS.StoreCertificate() error = %v", err)
+	}
+
+	got, err := k.LoadCertificate(&apiv1.LoadCertificateRequest{Name: "tpm:nv-index=0x01500000"})
+	if err != nil {
+		t.Fatalf("TPMKMS.LoadCertificate() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, cert) {
+		t.Errorf("TPMKMS.LoadCertificate() = %v, want %v", got, cert)
+	}
+}
+
+func TestSimulator_CreateAttestation(t *testing.T) {
+	k := mustSimulatorKMS(t)
+	resp := mustCreateKey(t, k, "tpm:handle=0x81000100", apiv1.ECDSAWithSHA256, 0)
+	t.Cleanup(func() {
+		_ = tpm2.EvictControl(k.rw, "", tpm2.HandleOwner, k.akHandle, k.akHandle)
+	})
+
+	nonce := []byte("the nonce")
+	got, err := k.CreateAttestation(&apiv1.CreateAttestationRequest{
+		Name:  "tpm:handle=0x81000100",
+		Nonce: nonce,
+	})
+	if err != nil {
+		t.Fatalf("TPMKMS.CreateAttestation() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.PublicKey, resp.PublicKey) {
+		t.Errorf("TPMKMS.CreateAttestation() PublicKey = %v, want %v", got.PublicKey, resp.PublicKey)
+	}
+	if got.CertificateChain != nil {
+		t.Errorf("TPMKMS.CreateAttestation() CertificateChain = %v, want nil", got.CertificateChain)
+	}
+
+	// Verify the signature of the attestation.
+	sig, err := tpm2.DecodeSignature(bytes.NewBuffer(got.AttestationSignature))
+	if err != nil {
+		t.Fatal(err)
+	}
+	akPub, ok := got.AttestationPublicKey.(*ecdsa.PublicKey)
+	if !ok || sig.ECC == nil {
+		t.Fatalf("TPMKMS.CreateAttestation() AttestationPublicKey = %T, want *ecdsa.PublicKey", got.AttestationPublicKey)
+	}
+	sum := sha256.Sum256(got.AttestationData)
+	if !ecdsa.Verify(akPub, sum[:], sig.ECC.R, sig.ECC.S) {
+		t.Error("ecdsa.Verify() failed")
+	}
+
+	// Verify the certified key and the nonce.
+	data, err := tpm2.DecodeAttestationData(got.AttestationData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data.ExtraData, nonce) {
+		t.Errorf("AttestationData.ExtraData = %s, want %s", data.ExtraData, nonce)
+	}
+	public, name, _, err := tpm2.ReadPublic(k.rw, 0x81000100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.AttestedCertifyInfo == nil || data.AttestedCertifyInfo.Name.Digest == nil {
+		t.Fatal("AttestationData.AttestedCertifyInfo is missing")
+	}
+	if ok, err := data.AttestedCertifyInfo.Name.MatchesPublic(public); err != nil || !ok {
+		t.Errorf("AttestationData.AttestedCertifyInfo.Name does not match %x", name)
+	}
+}
@@ -0,0 +1,695 @@
+// Package tpmkms implements a KMS using a TPM 2.0.
+//
+// Most of the operations require a TPM, and their tests run against a TPM
+// simulator implementing the Microsoft simulator protocol, like the IBM
+// software TPM. These tests are in simulator_test.go and only run with the
+// tpmsimulator build tag:
+//
+//	tpm_server -rm &
+//	go test -tags tpmsimulator ./kms/tpmkms
+//
+// A plain go test only covers the parsing of names and options, and the error
+// paths.
+package tpmkms
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"github.com/google/go-tpm/tpmutil/mssim"
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/kms/uri"
+)
+
+// Scheme is the scheme used in uris.
+const Scheme = "tpm"
+
+// keySchema defines the attributes allowed in the key and certificate URIs,
+// like tpm:handle=0x81000100 or tpm:nv-index=0x01500000.
+var keySchema = &uri.Schema{
+	Scheme: Scheme,
+	Path:   []string{"handle", "nv-index", "ek"},
+}
+
+// DefaultRSASize is the number of bits of a new RSA key if no size has been
+// specified.
+const DefaultRSASize = 2048
+
+// DefaultSRKHandle is the persistent handle where the storage root key is
+// usually found. If there's no key in this handle, a transient storage root key
+// will be created using the default ECC template.
+const DefaultSRKHandle tpmutil.Handle = 0x81000001
+
+// DefaultAKHandle is the persistent handle of the attestation key used by
+// CreateAttestation. If there's no key in this handle, a new one will be
+// created in the endorsement hierarchy and persisted.
+const DefaultAKHandle tpmutil.Handle = 0x81010001
+
+// EKCertRSAIndex and EKCertECCIndex are the NV indices where the TPM
+// manufacturer stores the RSA and ECC endorsement key certificates.
+const (
+	EKCertRSAIndex tpmutil.Handle = 0x01c00002
+	EKCertECCIndex tpmutil.Handle = 0x01c0000a
+)
+
+// Persistent handles that can be evicted by the owner, and NV indices.
+const (
+	minPersistentHandle tpmutil.Handle = 0x81000000
+	maxPersistentHandle tpmutil.Handle = 0x817fffff
+	minNVIndex          tpmutil.Handle = 0x01000000
+	maxNVIndex          tpmutil.Handle = 0x01ffffff
+)
+
+// maxNVBufferSize is the size of the chunks used to write in an NV index. It's
+// the minimum value of TPM_PT_NV_BUFFER_MAX required to TPMs.
+const maxNVBufferSize = 512
+
+// keyAttributes are the attributes of the keys created by CreateKey. RSA keys
+// can also be used to decrypt.
+const keyAttributes = tpm2.FlagSign | tpm2.FlagFixedTPM | tpm2.FlagFixedParent |
+	tpm2.FlagSensitiveDataOrigin | tpm2.FlagUserWithAuth | tpm2.FlagNoDA
+
+// srkTemplate is the ECC storage root key template defined in the TCG TPM v2.0
+// Provisioning Guidance.
+var srkTemplate = tpm2.Public{
+	Type:       tpm2.AlgECC,
+	NameAlg:    tpm2.AlgSHA256,
+	Attributes: tpm2.FlagStorageDefault | tpm2.FlagNoDA,
+	ECCParameters: &tpm2.ECCParams{
+		Symmetric: &tpm2.SymScheme{
+			Alg:     tpm2.AlgAES,
+			KeyBits: 128,
+			Mode:    tpm2.AlgCFB,
+		},
+		CurveID: tpm2.CurveNISTP256,
+	},
+}
+
+// akTemplate is the template of the restricted signing key created to certify
+// other keys.
+var akTemplate = tpm2.Public{
+	Type:       tpm2.AlgECC,
+	NameAlg:    tpm2.AlgSHA256,
+	Attributes: tpm2.FlagSignerDefault | tpm2.FlagNoDA,
+	ECCParameters: &tpm2.ECCParams{
+		Sign: &tpm2.SigScheme{
+			Alg:  tpm2.AlgECDSA,
+			Hash: tpm2.AlgSHA256,
+		},
+		CurveID: tpm2.CurveNISTP256,
+	},
+}
+
+// TPMKMS implements a KMS using a TPM 2.0.
+//
+// The URI used to configure the KMS has the following format:
+//
+//	tpm:device=/dev/tpmrm0
+//	tpm:simulator=127.0.0.1:2321;ak-handle=0x81010001
+//
+// If the device is not given, /dev/tpmrm0 or /dev/tpm0 will be used, on
+// Windows the TPM Base Services are always used. The simulator attribute
+// connects to a TPM simulator implementing the Microsoft simulator protocol,
+// the platform port is the next one to the command port.
+//
+// Keys are created under the storage root key and persisted in the owner
+// hierarchy, they are identified by their persistent handle:
+//
+//	tpm:handle=0x81000100
+//
+// Certificates are stored in NV indices, the endorsement key certificates can
+// also be loaded using the "ek" attribute:
+//
+//	tpm:nv-index=0x01500000
+//	tpm:ek=rsa
+//	tpm:ek=ecc
+type TPMKMS struct {
+	mu       sync.Mutex
+	rw       io.ReadWriteCloser
+	akHandle tpmutil.Handle
+}
+
+// openSimulator connects to a TPM simulator and starts it up.
+var openSimulator = func(addr string) (io.ReadWriteCloser, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing simulator address %s", addr)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing simulator address %s", addr)
+	}
+	rw, err := mssim.Open(mssim.Config{
+		CommandAddress:  addr,
+		PlatformAddress: net.JoinHostPort(host, strconv.Itoa(p+1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tpm2.Startup(rw, tpm2.StartupClear); err != nil {
+		rw.Close()
+		return nil, err
+	}
+	return rw, nil
+}
+
+// New creates a new TPMKMS.
+func New(ctx context.Context, opts apiv1.Options) (*TPMKMS, error) {
+	var device, simulator string
+	akHandle := DefaultAKHandle
+	if opts.URI != "" {
+		u, err := uri.ParseWithScheme(Scheme, opts.URI)
+		if err != nil {
+			return nil, err
+		}
+		device = u.Get("device")
+		simulator = u.Get("simulator")
+		if v := u.Get("ak-handle"); v != "" {
+			if akHandle, err = parsePersistentHandle(v); err != nil {
+				return nil, errors.Wrapf(err, "kms uri %s is not valid", opts.URI)
+			}
+		}
+	}
+
+	var rw io.ReadWriteCloser
+	var err error
+	if simulator != "" {
+		rw, err = openSimulator(simulator)
+	} else {
+		rw, err = openDevice(device)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening TPM")
+	}
+
+	return &TPMKMS{
+		rw:       rw,
+		akHandle: akHandle,
+	}, nil
+}
+
+func init() {
+	uri.RegisterSchema(keySchema)
+	apiv1.Register(apiv1.TPMKMS, func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
+		return New(ctx, opts)
+	})
+}
+
+// GetPublicKey returns the public key of a persistent key.
+func (k *TPMKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+
+	handle, err := parseKeyHandle(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.readPublic(handle)
+}
+
+// CreateKey creates a new key under the storage root key and persists it in
+// the handle given in the name.
+func (k *TPMKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+
+	handle, err := parseKeyHandle(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newKeyTemplate(req.SignatureAlgorithm, req.Bits)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, err := k.readPublic(handle); err == nil {
+		return nil, errors.Errorf("tpmKMS key %s already exists", req.Name)
+	}
+
+	srk, closer, err := k.loadSRK()
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	private, public, _, _, _, err := tpm2.CreateKey(k.rw, srk, tpm2.PCRSelection{}, "", "", template)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating key")
+	}
+	h, _, err := tpm2.Load(k.rw, srk, "", public, private)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading key")
+	}
+	defer k.flush(h)
+
+	if err := tpm2.EvictControl(k.rw, "", tpm2.HandleOwner, h, handle); err != nil {
+		return nil, errors.Wrap(err, "error persisting key")
+	}
+
+	pub, err := decodePublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+
+	name := keyName(handle)
+	return &apiv1.CreateKeyResponse{
+		Name:      name,
+		PublicKey: pub,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: name,
+		},
+	}, nil
+}
+
+// CreateSigner creates a signer using a persistent key.
+func (k *TPMKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("createSignerRequest 'signingKey' cannot be empty")
+	}
+	s, err := k.newSigner(req.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// CreateDecrypter creates a decrypter using a persistent RSA key.
+func (k *TPMKMS) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Decrypter, error) {
+	if req.DecryptionKey == "" {
+		return nil, errors.New("createDecrypterRequest 'decryptionKey' cannot be empty")
+	}
+	s, err := k.newSigner(req.DecryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewDecrypter(s)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// DeleteKey evicts the persistent key in the request name.
+func (k *TPMKMS) DeleteKey(req *apiv1.DeleteKeyRequest) error {
+	if req.Name == "" {
+		return errors.New("deleteKeyRequest 'name' cannot be empty")
+	}
+
+	handle, err := parseKeyHandle(req.Name)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := tpm2.EvictControl(k.rw, "", tpm2.HandleOwner, handle, handle); err != nil {
+		return errors.Wrap(err, "error deleting key")
+	}
+	return nil
+}
+
+// LoadCertificate reads a certificate from an NV index. The endorsement key
+// certificates can be loaded using the names tpm:ek=rsa and tpm:ek=ecc.
+func (k *TPMKMS) LoadCertificate(req *apiv1.LoadCertificateRequest) (*x509.Certificate, error) {
+	if req.Name == "" {
+		return nil, errors.New("loadCertificateRequest 'name' cannot be empty")
+	}
+
+	index, err := parseNVIndex(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.readCertificate(index)
+}
+
+// StoreCertificate writes a certificate in an NV index owned by the storage
+// hierarchy. If the index is already defined it will be replaced.
+func (k *TPMKMS) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
+	switch {
+	case req.Name == "":
+		return errors.New("storeCertificateRequest 'name' cannot be empty")
+	case req.Certificate == nil:
+		return errors.New("storeCertificateRequest 'Certificate' cannot be empty")
+	}
+
+	index, err := parseNVIndex(req.Name)
+	if err != nil {
+		return err
+	}
+	if index == EKCertRSAIndex || index == EKCertECCIndex {
+		return errors.New("tpmKMS cannot replace an endorsement key certificate")
+	}
+	data := req.Certificate.Raw
+	if len(data) > math.MaxUint16 {
+		return errors.New("tpmKMS certificate is too large")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, err := tpm2.NVReadPublic(k.rw, index); err == nil {
+		if err := tpm2.NVUndefineSpace(k.rw, "", tpm2.HandleOwner, index); err != nil {
+			return errors.Wrap(err, "error deleting NV index")
+		}
+	}
+	attrs := tpm2.AttrOwnerWrite | tpm2.AttrOwnerRead | tpm2.AttrAuthRead | tpm2.AttrNoDA
+	if err := tpm2.NVDefineSpace(k.rw, tpm2.HandleOwner, index, "", "", nil, attrs, uint16(len(data))); err != nil {
+		return errors.Wrap(err, "error defining NV index")
+	}
+	for offset := 0; offset < len(data); offset += maxNVBufferSize {
+		end := offset + maxNVBufferSize
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := tpm2.NVWrite(k.rw, tpm2.HandleOwner, index, "", data[offset:end], uint16(offset)); err != nil {
+			return errors.Wrap(err, "error writing NV index")
+		}
+	}
+	return nil
+}
+
+// CreateAttestation certifies a persistent key using the attestation key. The
+// response contains the TPMS_ATTEST structure with the nonce in the request,
+// its signature, the public key of the attestation key, and the endorsement
+// key certificates if the TPM has them.
+//
+// The attestation key is a primary key that is not certified by the
+// endorsement key, so the endorsement key certificates are not a chain for the
+// attestation. Before trusting it, a verifier must bind the attestation key to
+// the endorsement key, for example using TPM2_MakeCredential and
+// TPM2_ActivateCredential.
+//
+// # Experimental
+//
+// Notice: This API is EXPERIMENTAL and may be changed or removed in a later
+// release.
+func (k *TPMKMS) CreateAttestation(req *apiv1.CreateAttestationRequest) (*apiv1.CreateAttestationResponse, error) {
+	handle, err := parseKeyHandle(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	pub, err := k.readPublic(handle)
+	if err != nil {
+		return nil, err
+	}
+	akPub, err := k.loadAK()
+	if err != nil {
+		return nil, err
+	}
+
+	// A null scheme uses the scheme of the attestation key.
+	attestation, signature, err := tpm2.CertifyEx(k.rw, "", "", handle, k.akHandle, req.Nonce, tpm2.SigScheme{Alg: tpm2.AlgNull})
+	if err != nil {
+		return nil, errors.Wrap(err, "error certifying key")
+	}
+
+	var ekCerts []*x509.Certificate
+	for _, index := range []tpmutil.Handle{EKCertRSAIndex, EKCertECCIndex} {
+		if cert, err := k.readCertificate(index); err == nil {
+			ekCerts = append(ekCerts, cert)
+		}
+	}
+
+	return &apiv1.CreateAttestationResponse{
+		PublicKey:                  pub,
+		AttestationData:            attestation,
+		AttestationSignature:       signature,
+		AttestationPublicKey:       akPub,
+		EndorsementKeyCertificates: ekCerts,
+	}, nil
+}
+
+// Close closes the connection with the TPM.
+func (k *TPMKMS) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return errors.Wrap(k.rw.Close(), "error closing TPM")
+}
+
+// ValidateName validates that the given string is a valid key URI.
+func (k *TPMKMS) ValidateName(s string) error {
+	if err := keySchema.Validate(s); err != nil {
+		return err
+	}
+	_, err := parseKeyHandle(s)
+	return err
+}
+
+func (k *TPMKMS) newSigner(name string) (*Signer, error) {
+	handle, err := parseKeyHandle(name)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	public, err := k.readPublicArea(handle)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := public.Key()
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing public key")
+	}
+	return &Signer{
+		tpm:       k,
+		handle:    handle,
+		publicKey: pub,
+		rawRSA:    isRawRSAKey(public),
+	}, nil
+}
+
+func (k *TPMKMS) readPublicArea(handle tpmutil.Handle) (tpm2.Public, error) {
+	public, _, _, err := tpm2.ReadPublic(k.rw, handle)
+	if err != nil {
+		return tpm2.Public{}, errors.Wrapf(err, "error reading key 0x%x", uint32(handle))
+	}
+	return public, nil
+}
+
+func (k *TPMKMS) readPublic(handle tpmutil.Handle) (crypto.PublicKey, error) {
+	public, err := k.readPublicArea(handle)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := public.Key()
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing public key")
+	}
+	return pub, nil
+}
+
+// loadSRK returns the persistent storage root key if it exists, or creates a
+// transient one. The returned function flushes the transient key.
+func (k *TPMKMS) loadSRK() (tpmutil.Handle, func(), error) {
+	if _, _, _, err := tpm2.ReadPublic(k.rw, DefaultSRKHandle); err == nil {
+		return DefaultSRKHandle, func() {}, nil
+	}
+	h, _, err := tpm2.CreatePrimary(k.rw, tpm2.HandleOwner, tpm2.PCRSelection{}, "", "", srkTemplate)
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "error creating storage root key")
+	}
+	return h, func() { k.flush(h) }, nil
+}
+
+// loadAK returns the public key of the attestation key, creating and
+// persisting the key if it does not exist.
+func (k *TPMKMS) loadAK() (crypto.PublicKey, error) {
+	if pub, err := k.readPublic(k.akHandle); err == nil {
+		return pub, nil
+	}
+	h, pub, err := tpm2.CreatePrimary(k.rw, tpm2.HandleEndorsement, tpm2.PCRSelection{}, "", "", akTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating attestation key")
+	}
+	defer k.flush(h)
+	if err := tpm2.EvictControl(k.rw, "", tpm2.HandleOwner, h, k.akHandle); err != nil {
+		return nil, errors.Wrap(err, "error persisting attestation key")
+	}
+	return pub, nil
+}
+
+func (k *TPMKMS) readCertificate(index tpmutil.Handle) (*x509.Certificate, error) {
+	b, err := tpm2.NVReadEx(k.rw, index, tpm2.HandleOwner, "", 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading NV index 0x%x", uint32(index))
+	}
+	return parseCertificate(b)
+}
+
+func (k *TPMKMS) flush(h tpmutil.Handle) {
+	_ = tpm2.FlushContext(k.rw, h)
+}
+
+func newKeyTemplate(alg apiv1.SignatureAlgorithm, bits int) (tpm2.Public, error) {
+	switch alg {
+	case apiv1.UnspecifiedSignAlgorithm, apiv1.ECDSAWithSHA256:
+		return newECCTemplate(tpm2.CurveNISTP256), nil
+	case apiv1.ECDSAWithSHA384:
+		return newECCTemplate(tpm2.CurveNISTP384), nil
+	case apiv1.SHA256WithRSA, apiv1.SHA384WithRSA, apiv1.SHA512WithRSA,
+		apiv1.SHA256WithRSAPSS, apiv1.SHA384WithRSAPSS, apiv1.SHA512WithRSAPSS:
+		switch bits {
+		case 0:
+			bits = DefaultRSASize
+		case 2048, 3072, 4096:
+		default:
+			return tpm2.Public{}, errors.Errorf("tpmKMS does not support signature algorithm '%s' with '%d' bits", alg, bits)
+		}
+		return tpm2.Public{
+			Type:       tpm2.AlgRSA,
+			NameAlg:    tpm2.AlgSHA256,
+			Attributes: keyAttributes | tpm2.FlagDecrypt,
+			RSAParameters: &tpm2.RSAParams{
+				KeyBits: uint16(bits),
+			},
+		}, nil
+	default:
+		return tpm2.Public{}, errors.Errorf("tpmKMS does not support signature algorithm '%s'", alg)
+	}
+}
+
+// isRawRSAKey returns true if the key is an unrestricted RSA decryption key
+// without a scheme, these keys can be used with TPM2_RSA_Decrypt and
+// TPM_ALG_NULL to perform raw RSA operations.
+func isRawRSAKey(public tpm2.Public) bool {
+	if public.Type != tpm2.AlgRSA || public.RSAParameters == nil {
+		return false
+	}
+	if public.Attributes&tpm2.FlagDecrypt == 0 || public.Attributes&tpm2.FlagRestricted != 0 {
+		return false
+	}
+	sch := public.RSAParameters.Sign
+	return sch == nil || sch.Alg.IsNull()
+}
+
+func newECCTemplate(curve tpm2.EllipticCurve) tpm2.Public {
+	return tpm2.Public{
+		Type:       tpm2.AlgECC,
+		NameAlg:    tpm2.AlgSHA256,
+		Attributes: keyAttributes,
+		ECCParameters: &tpm2.ECCParams{
+			CurveID: curve,
+		},
+	}
+}
+
+func decodePublicKey(b []byte) (crypto.PublicKey, error) {
+	public, err := tpm2.DecodePublic(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding public key")
+	}
+	pub, err := public.Key()
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing public key")
+	}
+	return pub, nil
+}
+
+// parseCertificate parses a DER certificate ignoring any trailing data, NV
+// indices provisioned by manufacturers might be padded.
+func parseCertificate(b []byte) (*x509.Certificate, error) {
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(b, &raw); err != nil {
+		return nil, errors.Wrap(err, "error parsing certificate")
+	}
+	cert, err := x509.ParseCertificate(raw.FullBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing certificate")
+	}
+	return cert, nil
+}
+
+// keyName returns the URI of a persistent key.
+func keyName(handle tpmutil.Handle) string {
+	return keySchema.String(url.Values{
+		"handle": []string{fmt.Sprintf("0x%x", uint32(handle))},
+	})
+}
+
+// parseKeyHandle parses a key URI like tpm:handle=0x81000100.
+func parseKeyHandle(rawuri string) (tpmutil.Handle, error) {
+	u, err := uri.ParseWithScheme(Scheme, rawuri)
+	if err != nil {
+		return 0, err
+	}
+	v := u.Get("handle")
+	if v == "" {
+		return 0, errors.Errorf("key uri %s is not valid: handle is missing", rawuri)
+	}
+	handle, err := parsePersistentHandle(v)
+	if err != nil {
+		return 0, errors.Wrapf(err, "key uri %s is not valid", rawuri)
+	}
+	return handle, nil
+}
+
+// parseNVIndex parses a certificate URI like tpm:nv-index=0x01500000 or
+// tpm:ek=rsa.
+func parseNVIndex(rawuri string) (tpmutil.Handle, error) {
+	u, err := uri.ParseWithScheme(Scheme, rawuri)
+	if err != nil {
+		return 0, err
+	}
+	if v := u.Get("ek"); v != "" {
+		switch v {
+		case "rsa":
+			return EKCertRSAIndex, nil
+		case "ecc":
+			return EKCertECCIndex, nil
+		default:
+			return 0, errors.Errorf("certificate uri %s is not valid: ek must be rsa or ecc", rawuri)
+		}
+	}
+	v := u.Get("nv-index")
+	if v == "" {
+		return 0, errors.Errorf("certificate uri %s is not valid: nv-index is missing", rawuri)
+	}
+	index, err := parseHandle(v, minNVIndex, maxNVIndex)
+	if err != nil {
+		return 0, errors.Wrapf(err, "certificate uri %s is not valid", rawuri)
+	}
+	return index, nil
+}
+
+func parsePersistentHandle(s string) (tpmutil.Handle, error) {
+	return parseHandle(s, minPersistentHandle, maxPersistentHandle)
+}
+
+func parseHandle(s string, min, max tpmutil.Handle) (tpmutil.Handle, error) {
+	v, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, errors.Errorf("handle %q is not a number", s)
+	}
+	if h := tpmutil.Handle(v); h >= min && h <= max {
+		return h, nil
+	}
+	return 0, errors.Errorf("handle %q is not between 0x%x and 0x%x", s, uint32(min), uint32(max))
+}
@@ -0,0 +1,643 @@
+package tpmkms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+// brokenTPM is an io.ReadWriteCloser that fails all the TPM commands.
+type brokenTPM struct {
+	closed   bool
+	closeErr error
+}
+
+func (b *brokenTPM) Read(p []byte) (int, error) {
+	return 0, errors.New("read error")
+}
+
+func (b *brokenTPM) Write(p []byte) (int, error) {
+	return 0, errors.New("write error")
+}
+
+func (b *brokenTPM) Close() error {
+	b.closed = true
+	return b.closeErr
+}
+
+func newBrokenKMS() *TPMKMS {
+	return &TPMKMS{
+		rw:       &brokenTPM{},
+		akHandle: DefaultAKHandle,
+	}
+}
+
+func mustCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestNew(t *testing.T) {
+	tmp := openSimulator
+	t.Cleanup(func() {
+		openSimulator = tmp
+	})
+
+	var simulatorAddr string
+	fakeRW := &brokenTPM{}
+	openSimulator = func(addr string) (io.ReadWriteCloser, error) {
+		simulatorAddr = addr
+		if addr == "127.0.0.1:2321" {
+			return fakeRW, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+
+	type args struct {
+		ctx  context.Context
+		opts apiv1.Options
+	}
+	tests := []struct {
+		name     string
+		args     args
+		want     *TPMKMS
+		wantAddr string
+		wantErr  bool
+	}{
+		{"ok simulator", args{context.Background(), apiv1.Options{URI: "tpm:simulator=127.0.0.1:2321"}}, &TPMKMS{
+			rw: fakeRW, akHandle: DefaultAKHandle,
+		}, "127.0.0.1:2321", false},
+		{"ok ak-handle", args{context.Background(), apiv1.Options{URI: "tpm:simulator=127.0.0.1:2321;ak-handle=0x81010002"}}, &TPMKMS{
+			rw: fakeRW, akHandle: 0x81010002,
+		}, "127.0.0.1:2321", false},
+		{"fail scheme", args{context.Background(), apiv1.Options{URI: "pkcs11:simulator=127.0.0.1:2321"}}, nil, "", true},
+		{"fail ak-handle", args{context.Background(), apiv1.Options{URI: "tpm:simulator=127.0.0.1:2321;ak-handle=0x1234"}}, nil, "", true},
+		{"fail simulator", args{context.Background(), apiv1.Options{URI: "tpm:simulator=127.0.0.1:1"}}, nil, "127.0.0.1:1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			simulatorAddr = ""
+			got, err := New(tt.args.ctx, tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("New() = %v, want %v", got, tt.want)
+			}
+			if simulatorAddr != tt.wantAddr {
+				t.Errorf("New() simulator = %s, want %s", simulatorAddr, tt.wantAddr)
+			}
+		})
+	}
+}
+
+func TestNew_device(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("devices are not supported on windows")
+	}
+	if _, err := New(context.Background(), apiv1.Options{URI: "tpm:device=testdata/missing"}); err == nil {
+		t.Error("New() error = nil, wantErr true")
+	}
+}
+
+func Test_openSimulator(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		wantErr bool
+	}{
+		{"fail address", "127.0.0.1", true},
+		{"fail port", "127.0.0.1:port", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := openSimulator(tt.addr); (err != nil) != tt.wantErr {
+				t.Errorf("openSimulator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	fn, ok := apiv1.LoadKeyManagerNewFunc(apiv1.TPMKMS)
+	if !ok {
+		t.Fatal("apiv1.LoadKeyManagerNewFunc() tpm is not registered")
+	}
+	if _, err := fn(context.Background(), apiv1.Options{URI: "tpm:ak-handle=foo"}); err == nil {
+		t.Error("KeyManagerNewFunc() error = nil, wantErr true")
+	}
+}
+
+func TestTPMKMS_GetPublicKey(t *testing.T) {
+	k := newBrokenKMS()
+	tests := []struct {
+		name    string
+		req     *apiv1.GetPublicKeyRequest
+		wantErr bool
+	}{
+		{"fail empty", &apiv1.GetPublicKeyRequest{}, true},
+		{"fail name", &apiv1.GetPublicKeyRequest{Name: "tpm:handle=0x1234"}, true},
+		{"fail read", &apiv1.GetPublicKeyRequest{Name: "tpm:handle=0x81000100"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.GetPublicKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TPMKMS.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil {
+				t.Errorf("TPMKMS.GetPublicKey() = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestTPMKMS_CreateKey(t *testing.T) {
+	k := newBrokenKMS()
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateKeyRequest
+		wantErr bool
+	}{
+		{"fail empty", &apiv1.CreateKeyRequest{}, true},
+		{"fail name", &apiv1.CreateKeyRequest{Name: "tpm:handle=foo"}, true},
+		{"fail algorithm", &apiv1.CreateKeyRequest{Name: "tpm:handle=0x81000100", SignatureAlgorithm: apiv1.PureEd25519}, true},
+		{"fail bits", &apiv1.CreateKeyRequest{Name: "tpm:handle=0x81000100", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 1024}, true},
+		{"fail create", &apiv1.CreateKeyRequest{Name: "tpm:handle=0x81000100"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateKey(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TPMKMS.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil {
+				t.Errorf("TPMKMS.CreateKey() = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestTPMKMS_CreateSigner(t *testing.T) {
+	k := newBrokenKMS()
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateSignerRequest
+		wantErr bool
+	}{
+		{"fail empty", &apiv1.CreateSignerRequest{}, true},
+		{"fail name", &apiv1.CreateSignerRequest{SigningKey: "tpm:nv-index=0x01500000"}, true},
+		{"fail read", &apiv1.CreateSignerRequest{SigningKey: "tpm:handle=0x81000100"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateSigner(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TPMKMS.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil {
+				t.Errorf("TPMKMS.CreateSigner() = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestTPMKMS_CreateDecrypter(t *testing.T) {
+	k := newBrokenKMS()
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateDecrypterRequest
+		wantErr bool
+	}{
+		{"fail empty", &apiv1.CreateDecrypterRequest{}, true},
+		{"fail name", &apiv1.CreateDecrypterRequest{DecryptionKey: "tpm:handle=0x81800000"}, true},
+		{"fail read", &apiv1.CreateDecrypterRequest{DecryptionKey: "tpm:handle=0x81000100"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateDecrypter(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TPMKMS.CreateDecrypter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil {
+				t.Errorf("TPMKMS.CreateDecrypter() = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestTPMKMS_DeleteKey(t *testing.T) {
+	k := newBrokenKMS()
+	tests := []struct {
+		name    string
+		req     *apiv1.DeleteKeyRequest
+		wantErr bool
+	}{
+		{"fail empty", &apiv1.DeleteKeyRequest{}, true},
+		{"fail name", &apiv1.DeleteKeyRequest{Name: "tpm:ek=rsa"}, true},
+		{"fail evict", &apiv1.DeleteKeyRequest{Name: "tpm:handle=0x81000100"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := k.DeleteKey(tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("TPMKMS.DeleteKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTPMKMS_LoadCertificate(t *testing.T) {
+	k := newBrokenKMS()
+	tests := []struct {
+		name    string
+		req     *apiv1.LoadCertificateRequest
+		wantErr bool
+	}{
+		{"fail empty", &apiv1.LoadCertificateRequest{}, true},
+		{"fail name", &apiv1.LoadCertificateRequest{Name: "tpm:handle=0x81000100"}, true},
+		{"fail read", &apiv1.LoadCertificateRequest{Name: "tpm:ek=rsa"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.LoadCertificate(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TPMKMS.LoadCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil {
+				t.Errorf("TPMKMS.LoadCertificate() = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestTPMKMS_StoreCertificate(t *testing.T) {
+	k := newBrokenKMS()
+	cert := mustCertificate(t)
+	tests := []struct {
+		name    string
+		req     *apiv1.StoreCertificateRequest
+		wantErr bool
+	}{
+		{"fail empty", &apiv1.StoreCertificateRequest{Certificate: cert}, true},
+		{"fail certificate", &apiv1.StoreCertificateRequest{Name: "tpm:nv-index=0x01500000"}, true},
+		{"fail name", &apiv1.StoreCertificateRequest{Name: "tpm:nv-index=0x81000100", Certificate: cert}, true},
+		{"fail ek", &apiv1.StoreCertificateRequest{Name: "tpm:ek=ecc", Certificate: cert}, true},
+		{"fail define", &apiv1.StoreCertificateRequest{Name: "tpm:nv-index=0x01500000", Certificate: cert}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := k.StoreCertificate(tt.req); (err != nil) != tt.wantErr {
+				t.Errorf("TPMKMS.StoreCertificate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTPMKMS_CreateAttestation(t *testing.T) {
+	k := newBrokenKMS()
+	tests := []struct {
+		name    string
+		req     *apiv1.CreateAttestationRequest
+		wantErr bool
+	}{
+		{"fail empty", &apiv1.CreateAttestationRequest{}, true},
+		{"fail name", &apiv1.CreateAttestationRequest{Name: "tpm:handle=0x01500000"}, true},
+		{"fail read", &apiv1.CreateAttestationRequest{Name: "tpm:handle=0x81000100", Nonce: []byte("nonce")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateAttestation(tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TPMKMS.CreateAttestation() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != nil {
+				t.Errorf("TPMKMS.CreateAttestation() = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestTPMKMS_Close(t *testing.T) {
+	tests := []struct {
+		name    string
+		rw      *brokenTPM
+		wantErr bool
+	}{
+		{"ok", &brokenTPM{}, false},
+		{"fail", &brokenTPM{closeErr: errors.New("close error")}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &TPMKMS{rw: tt.rw}
+			if err := k.Close(); (err != nil) != tt.wantErr {
+				t.Errorf("TPMKMS.Close() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.rw.closed {
+				t.Error("TPMKMS.Close() did not close the TPM")
+			}
+		})
+	}
+}
+
+func TestTPMKMS_ValidateName(t *testing.T) {
+	k := newBrokenKMS()
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{"ok", "tpm:handle=0x81000100", false},
+		{"ok decimal", "tpm:handle=2164260864", false},
+		{"ok max", "tpm:handle=0x817fffff", false},
+		{"fail unknown attribute", "tpm:handle=0x81000100;device=/dev/tpmrm0", true},
+		{"fail repeated attribute", "tpm:handle=0x81000100?handle=0x81000101", true},
+		{"fail empty", "", true},
+		{"fail scheme", "yubikey:slot-id=9a", true},
+		{"fail missing", "tpm:nv-index=0x01500000", true},
+		{"fail number", "tpm:handle=foo", true},
+		{"fail transient", "tpm:handle=0x80000000", true},
+		{"fail platform", "tpm:handle=0x81800000", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := k.ValidateName(tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("TPMKMS.ValidateName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_newKeyTemplate(t *testing.T) {
+	eccTemplate := func(curve tpm2.EllipticCurve) tpm2.Public {
+		return tpm2.Public{
+			Type:          tpm2.AlgECC,
+			NameAlg:       tpm2.AlgSHA256,
+			Attributes:    keyAttributes,
+			ECCParameters: &tpm2.ECCParams{CurveID: curve},
+		}
+	}
+	rsaTemplate := func(bits uint16) tpm2.Public {
+		return tpm2.Public{
+			Type:          tpm2.AlgRSA,
+			NameAlg:       tpm2.AlgSHA256,
+			Attributes:    keyAttributes | tpm2.FlagDecrypt,
+			RSAParameters: &tpm2.RSAParams{KeyBits: bits},
+		}
+	}
+	type args struct {
+		alg  apiv1.SignatureAlgorithm
+		bits int
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    tpm2.Public
+		wantErr bool
+	}{
+		{"ok default", args{apiv1.UnspecifiedSignAlgorithm, 0}, eccTemplate(tpm2.CurveNISTP256), false},
+		{"ok P256", args{apiv1.ECDSAWithSHA256, 0}, eccTemplate(tpm2.CurveNISTP256), false},
+		{"ok P384", args{apiv1.ECDSAWithSHA384, 0}, eccTemplate(tpm2.CurveNISTP384), false},
+		{"ok RSA", args{apiv1.SHA256WithRSA, 0}, rsaTemplate(DefaultRSASize), false},
+		{"ok RSA 3072", args{apiv1.SHA384WithRSA, 3072}, rsaTemplate(3072), false},
+		{"ok RSA-PSS 4096", args{apiv1.SHA512WithRSAPSS, 4096}, rsaTemplate(4096), false},
+		{"fail P521", args{apiv1.ECDSAWithSHA512, 0}, tpm2.Public{}, true},
+		{"fail Ed25519", args{apiv1.PureEd25519, 0}, tpm2.Public{}, true},
+		{"fail RSA bits", args{apiv1.SHA256WithRSAPSS, 1024}, tpm2.Public{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newKeyTemplate(tt.args.alg, tt.args.bits)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newKeyTemplate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newKeyTemplate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isRawRSAKey(t *testing.T) {
+	rsaTemplate, err := newKeyTemplate(apiv1.SHA256WithRSA, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withScheme := rsaTemplate
+	withScheme.RSAParameters = &tpm2.RSAParams{
+		Sign:    &tpm2.SigScheme{Alg: tpm2.AlgRSASSA, Hash: tpm2.AlgSHA256},
+		KeyBits: 2048,
+	}
+	withNullScheme := rsaTemplate
+	withNullScheme.RSAParameters = &tpm2.RSAParams{
+		Sign:    &tpm2.SigScheme{Alg: tpm2.AlgNull},
+		KeyBits: 2048,
+	}
+	signOnly := rsaTemplate
+	signOnly.Attributes = keyAttributes
+	restricted := rsaTemplate
+	restricted.Attributes |= tpm2.FlagRestricted
+
+	tests := []struct {
+		name   string
+		public tpm2.Public
+		want   bool
+	}{
+		{"ok", rsaTemplate, true},
+		{"ok null scheme", withNullScheme, true},
+		{"false scheme", withScheme, false},
+		{"false sign only", signOnly, false},
+		{"false restricted", restricted, false},
+		{"false ecc", newECCTemplate(tpm2.CurveNISTP256), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRawRSAKey(tt.public); got != tt.want {
+				t.Errorf("isRawRSAKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_keyName(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle tpmutil.Handle
+		want   string
+	}{
+		{"ok", 0x81000100, "tpm:handle=0x81000100"},
+		{"ok srk", DefaultSRKHandle, "tpm:handle=0x81000001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keyName(tt.handle); got != tt.want {
+				t.Errorf("keyName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseNVIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawuri  string
+		want    tpmutil.Handle
+		wantErr bool
+	}{
+		{"ok", "tpm:nv-index=0x01500000", 0x01500000, false},
+		{"ok ek rsa", "tpm:ek=rsa", EKCertRSAIndex, false},
+		{"ok ek ecc", "tpm:ek=ecc", EKCertECCIndex, false},
+		{"fail scheme", "tpmkms:nv-index=0x01500000", 0, true},
+		{"fail ek", "tpm:ek=ed25519", 0, true},
+		{"fail missing", "tpm:handle=0x81000100", 0, true},
+		{"fail range", "tpm:nv-index=0x02000000", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNVIndex(tt.rawuri)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseNVIndex() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseNVIndex() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseCertificate(t *testing.T) {
+	cert := mustCertificate(t)
+	padded := append(append([]byte{}, cert.Raw...), make([]byte, 64)...)
+	tests := []struct {
+		name    string
+		b       []byte
+		want    *x509.Certificate
+		wantErr bool
+	}{
+		{"ok", cert.Raw, cert, false},
+		{"ok padded", padded, cert, false},
+		{"fail asn1", []byte("foo"), nil, true},
+		{"fail certificate", []byte{0x30, 0x03, 0x02, 0x01, 0x01}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCertificate(tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseCertificate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCertificate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_decodePublicKey(t *testing.T) {
+	template := newECCTemplate(tpm2.CurveNISTP256)
+	template.ECCParameters.Point = tpm2.ECPoint{
+		XRaw: elliptic.P256().Params().Gx.Bytes(),
+		YRaw: elliptic.P256().Params().Gy.Bytes(),
+	}
+	b, err := template.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     elliptic.P256().Params().Gx,
+		Y:     elliptic.P256().Params().Gy,
+	}
+
+	tests := []struct {
+		name    string
+		b       []byte
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{"ok", b, want, false},
+		{"fail", []byte("foo"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodePublicKey(tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodePublicKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodePublicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewDecrypter(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := newBrokenKMS()
+	tests := []struct {
+		name    string
+		s       *Signer
+		wantErr bool
+	}{
+		{"ok", &Signer{tpm: k, handle: 0x81000100, publicKey: rsaKey.Public()}, false},
+		{"fail", &Signer{tpm: k, handle: 0x81000100, publicKey: ecKey.Public()}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDecrypter(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewDecrypter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
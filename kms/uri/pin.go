@@ -0,0 +1,135 @@
+package uri
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// PinSourceFunc is the type that represents the method used to read a PIN or
+// passphrase. The value is the part of the source after the scheme, for
+// example the variable name in "env:PKCS11_PIN".
+type PinSourceFunc func(value string) ([]byte, error)
+
+// PinPrompter defines the function signature for the PromptPin callback.
+type PinPrompter func(prompt string) ([]byte, error)
+
+// PromptPin is the method used to ask for a PIN or passphrase when a source
+// like "prompt:Please enter the PIN" is used. It is not defined by default.
+var PromptPin PinPrompter
+
+// CommandTimeout is the maximum time a "cmd" source can run.
+var CommandTimeout = 30 * time.Second
+
+var pinSources = new(sync.Map)
+
+func init() {
+	RegisterPinSource("file", readFile)
+	RegisterPinSource("env", readEnv)
+	RegisterPinSource("systemd", readCredential)
+	RegisterPinSource("prompt", readPrompt)
+}
+
+// RegisterPinSource adds to the registry a method to read PINs or passphrases
+// from sources with the given scheme. The following sources are registered by
+// default:
+//
+//   - file:/path/to/pin.txt, or just /path/to/pin.txt, reads a file.
+//   - env:NAME reads the environment variable NAME.
+//   - systemd:name reads the systemd credential name in $CREDENTIALS_DIRECTORY.
+//   - prompt:message asks for the value using PromptPin.
+//
+// Sources like cmd:/path/to/command args..., that run a command and read its
+// output, are not registered by default, as key names might come from
+// untrusted input. Applications can enable them with:
+//
+//	uri.RegisterPinSource("cmd", uri.CommandPinSource)
+func RegisterPinSource(scheme string, fn PinSourceFunc) {
+	pinSources.Store(strings.ToLower(scheme), fn)
+}
+
+// LoadPinSourceFunc returns the method registered for the given scheme.
+func LoadPinSourceFunc(scheme string) (PinSourceFunc, bool) {
+	v, ok := pinSources.Load(strings.ToLower(scheme))
+	if !ok {
+		return nil, false
+	}
+	fn, ok := v.(PinSourceFunc)
+	return fn, ok
+}
+
+// ReadPinSource reads the PIN or passphrase from the given source, trailing
+// whitespace is removed from the result. Sources without a registered scheme
+// are considered files.
+func ReadPinSource(source string) ([]byte, error) {
+	fn, value := PinSourceFunc(readFile), source
+	if scheme, v, ok := strings.Cut(source, ":"); ok {
+		if f, ok := LoadPinSourceFunc(scheme); ok {
+			fn, value = f, v
+		} else if strings.EqualFold(scheme, "cmd") {
+			return nil, errors.New("pin source cmd is not enabled: it must be registered using uri.RegisterPinSource")
+		}
+	}
+	b, err := fn(value)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRightFunc(b, unicode.IsSpace), nil
+}
+
+func readEnv(name string) ([]byte, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, errors.Errorf("environment variable %s is not set", name)
+	}
+	return []byte(v), nil
+}
+
+func readCredential(name string) ([]byte, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return nil, errors.New("environment variable CREDENTIALS_DIRECTORY is not set")
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return nil, errors.Errorf("systemd credential name %q is not valid", name)
+	}
+	return readFile(filepath.Join(dir, name))
+}
+
+// CommandPinSource runs the given command, with the arguments separated by
+// spaces, and returns its output. The command is killed if it runs longer than
+// CommandTimeout.
+func CommandPinSource(command string) ([]byte, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("pin source command cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CommandTimeout)
+	defer cancel()
+
+	//nolint:gosec // the command is defined by the configuration
+	b, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error running %s", args[0])
+	}
+	return b, nil
+}
+
+func readPrompt(prompt string) ([]byte, error) {
+	if PromptPin == nil {
+		return nil, errors.New("pin prompter is not defined")
+	}
+	if prompt == "" {
+		prompt = "Please enter the PIN"
+	}
+	return PromptPin(prompt)
+}
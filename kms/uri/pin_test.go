@@ -0,0 +1,192 @@
+package uri
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestRegisterPinSource(t *testing.T) {
+	fn := func(value string) ([]byte, error) {
+		return []byte("custom-" + value), nil
+	}
+	RegisterPinSource("Custom", fn)
+	t.Cleanup(func() {
+		pinSources.Delete("custom")
+	})
+
+	got, ok := LoadPinSourceFunc("custom")
+	if !ok {
+		t.Fatal("LoadPinSourceFunc() ok = false, want true")
+	}
+	if b, err := got("pin"); err != nil || string(b) != "custom-pin" {
+		t.Errorf("PinSourceFunc() = %s, %v, want custom-pin, nil", b, err)
+	}
+	if b, err := ReadPinSource("CUSTOM:value"); err != nil || string(b) != "custom-value" {
+		t.Errorf("ReadPinSource() = %s, %v, want custom-value, nil", b, err)
+	}
+}
+
+func TestLoadPinSourceFunc(t *testing.T) {
+	pinSources.Store("bad-type", "not a function")
+	t.Cleanup(func() {
+		pinSources.Delete("bad-type")
+	})
+
+	tests := []struct {
+		name   string
+		scheme string
+		wantOk bool
+	}{
+		{"file", "file", true},
+		{"env", "env", true},
+		{"systemd", "systemd", true},
+		{"cmd not registered", "cmd", false},
+		{"prompt", "prompt", true},
+		{"upper case", "ENV", true},
+		{"missing", "missing", false},
+		{"bad type", "bad-type", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := LoadPinSourceFunc(tt.scheme)
+			if ok != tt.wantOk {
+				t.Errorf("LoadPinSourceFunc() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if (got != nil) != tt.wantOk {
+				t.Errorf("LoadPinSourceFunc() = %p, want nil %v", got, !tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestReadPinSource(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pkcs11-pin"), []byte("credential-pin\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+	t.Setenv("URI_TEST_PIN", "env-pin \n")
+
+	tests := []struct {
+		name    string
+		source  string
+		want    []byte
+		wantErr bool
+	}{
+		{"file", "testdata/pin.txt", []byte("trim-this-pin"), false},
+		{"file scheme", "file:testdata/pin.txt", []byte("trim-this-pin"), false},
+		{"env", "env:URI_TEST_PIN", []byte("env-pin"), false},
+		{"systemd", "systemd:pkcs11-pin", []byte("credential-pin"), false},
+		{"fail file", "testdata/missing.txt", nil, true},
+		{"fail env", "env:URI_TEST_MISSING", nil, true},
+		{"fail systemd missing", "systemd:missing", nil, true},
+		{"fail systemd empty", "systemd:", nil, true},
+		{"fail systemd path", "systemd:../pkcs11-pin", nil, true},
+		{"fail cmd not registered", "cmd:echo cmd-pin", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadPinSource(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadPinSource() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadPinSource() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPinSource_systemd(t *testing.T) {
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	if _, err := ReadPinSource("systemd:pkcs11-pin"); err == nil {
+		t.Error("ReadPinSource() error = nil, want error")
+	}
+}
+
+func TestReadPinSource_cmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test requires a posix shell")
+	}
+	RegisterPinSource("cmd", CommandPinSource)
+	t.Cleanup(func() {
+		pinSources.Delete("cmd")
+	})
+
+	tests := []struct {
+		name    string
+		source  string
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", "cmd:echo cmd-pin", []byte("cmd-pin"), false},
+		{"ok args", "cmd:printf %s cmd-pin", []byte("cmd-pin"), false},
+		{"fail exit status", "cmd:false", nil, true},
+		{"fail empty", "cmd:", nil, true},
+		{"fail missing", "cmd:/missing/command", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadPinSource(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadPinSource() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadPinSource() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPinSource_prompt(t *testing.T) {
+	t.Cleanup(func() {
+		PromptPin = nil
+	})
+
+	var gotPrompt string
+	tests := []struct {
+		name       string
+		prompter   PinPrompter
+		source     string
+		want       []byte
+		wantPrompt string
+		wantErr    bool
+	}{
+		{"ok", func(prompt string) ([]byte, error) {
+			gotPrompt = prompt
+			return []byte("prompt-pin\n"), nil
+		}, "prompt:Enter the token PIN", []byte("prompt-pin"), "Enter the token PIN", false},
+		{"ok default", func(prompt string) ([]byte, error) {
+			gotPrompt = prompt
+			return []byte("prompt-pin"), nil
+		}, "prompt:", []byte("prompt-pin"), "Please enter the PIN", false},
+		{"fail prompter", func(prompt string) ([]byte, error) {
+			gotPrompt = prompt
+			return nil, errors.New("an error")
+		}, "prompt:Enter the token PIN", nil, "Enter the token PIN", true},
+		{"fail no prompter", nil, "prompt:Enter the token PIN", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPrompt = ""
+			PromptPin = tt.prompter
+			got, err := ReadPinSource(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadPinSource() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadPinSource() = %s, want %s", got, tt.want)
+			}
+			if gotPrompt != tt.wantPrompt {
+				t.Errorf("PromptPin() prompt = %q, want %q", gotPrompt, tt.wantPrompt)
+			}
+		})
+	}
+}
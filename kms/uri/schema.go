@@ -0,0 +1,194 @@
+package uri
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Schema defines the attributes allowed in the URIs of a KMS. It is used to
+// validate URIs and to serialize them in a canonical form, with the attributes
+// in the order they are defined, path attributes separated by ";", query
+// attributes separated by "&", and the values percent-encoded following RFC
+// 7512.
+type Schema struct {
+	// Scheme is the scheme of the URIs, e.g. "pkcs11".
+	Scheme string
+	// Path are the attributes serialized in the path of the URI.
+	Path []string
+	// Query are the attributes serialized in the query of the URI.
+	Query []string
+}
+
+var schemas = new(sync.Map)
+
+// RegisterSchema adds to the registry the schema of the URIs of a KMS.
+func RegisterSchema(s *Schema) {
+	schemas.Store(strings.ToLower(s.Scheme), s)
+}
+
+// LoadSchema returns the schema registered for the given scheme.
+func LoadSchema(scheme string) (*Schema, bool) {
+	v, ok := schemas.Load(strings.ToLower(scheme))
+	if !ok {
+		return nil, false
+	}
+	s, ok := v.(*Schema)
+	return s, ok
+}
+
+// Parse parses the given URI and returns it in its canonical form. It fails if
+// the scheme does not match or if the URI contains unknown or repeated
+// attributes, no matter if they are in the path or in the query.
+func (s *Schema) Parse(rawuri string) (*URI, error) {
+	u, err := ParseWithScheme(s.Scheme, rawuri)
+	if err != nil {
+		return nil, err
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", rawuri)
+	}
+
+	values := make(url.Values, len(u.Values)+len(query))
+	for _, v := range []url.Values{u.Values, query} {
+		for key, vals := range v {
+			if _, ok := values[key]; ok || len(vals) > 1 {
+				return nil, errors.Errorf("error parsing %s: attribute %s is repeated", rawuri, key)
+			}
+			values[key] = vals
+		}
+	}
+
+	u, err = s.Build(values)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", rawuri)
+	}
+	return u, nil
+}
+
+// Validate returns an error if the given URI is not valid for the schema.
+func (s *Schema) Validate(rawuri string) error {
+	_, err := s.Parse(rawuri)
+	return err
+}
+
+// Normalize returns the canonical form of the given URI.
+func (s *Schema) Normalize(rawuri string) (string, error) {
+	u, err := s.Parse(rawuri)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// Build creates a new URI in its canonical form with the given attributes. It
+// fails if an attribute is not in the schema or has more than one value.
+func (s *Schema) Build(values url.Values) (*URI, error) {
+	for key, vals := range values {
+		if !s.has(key) {
+			return nil, errors.Errorf("attribute %s is not supported", key)
+		}
+		if len(vals) > 1 {
+			return nil, errors.Errorf("attribute %s is repeated", key)
+		}
+	}
+
+	path := make(url.Values)
+	for _, key := range s.Path {
+		if vals, ok := values[key]; ok {
+			path[key] = vals
+		}
+	}
+
+	return &URI{
+		URL:    s.url(values),
+		Values: path,
+	}, nil
+}
+
+// String returns the canonical form of a URI with the given attributes. It is
+// used by the KMSs to create the names of their keys, and unlike Build, it does
+// not validate the attributes: attributes not defined in the schema are
+// ignored and only the first value of each attribute is used.
+func (s *Schema) String(values url.Values) string {
+	return s.url(values).String()
+}
+
+func (s *Schema) url(values url.Values) *url.URL {
+	return &url.URL{
+		Scheme:   s.Scheme,
+		Opaque:   encodeValues(s.Path, values, false),
+		RawQuery: encodeValues(s.Query, values, true),
+	}
+}
+
+func (s *Schema) has(key string) bool {
+	for _, k := range s.Path {
+		if k == key {
+			return true
+		}
+	}
+	for _, k := range s.Query {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeValues encodes the values of the given keys, in order, as path
+// attributes separated by ";", or as query attributes separated by "&".
+func encodeValues(keys []string, values url.Values, query bool) string {
+	sep := ";"
+	if query {
+		sep = "&"
+	}
+	var parts []string
+	for _, key := range keys {
+		if vals, ok := values[key]; ok {
+			var v string
+			if len(vals) > 0 {
+				v = vals[0]
+			}
+			parts = append(parts, key+"="+escape(v, query))
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// escape percent-encodes a value. Unreserved characters and the reserved
+// characters that RFC 7512 allows in path attributes are not encoded, with the
+// exception of "+", that url.ParseQuery decodes as a space, and "=". The "/"
+// is only allowed in query attributes.
+func escape(s string, query bool) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if shouldEscape(c, query) {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func shouldEscape(c byte, query bool) bool {
+	if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+		return false
+	}
+	switch c {
+	case '-', '.', '_', '~', ':', '[', ']', '@', '!', '$', '\'', '(', ')', '*', ',':
+		return false
+	case '/':
+		return !query
+	default:
+		return true
+	}
+}
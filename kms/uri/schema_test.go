@@ -0,0 +1,237 @@
+package uri
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+var testSchema = &Schema{
+	Scheme: "pkcs11",
+	Path:   []string{"token", "id", "object"},
+	Query:  []string{"module-path", "pin-value"},
+}
+
+func TestRegisterSchema(t *testing.T) {
+	s := &Schema{Scheme: "Test-KMS", Path: []string{"name"}}
+	RegisterSchema(s)
+	t.Cleanup(func() {
+		schemas.Delete("test-kms")
+	})
+	schemas.Store("bad-type", "not a schema")
+	t.Cleanup(func() {
+		schemas.Delete("bad-type")
+	})
+
+	tests := []struct {
+		name   string
+		scheme string
+		want   *Schema
+		wantOk bool
+	}{
+		{"ok", "test-kms", s, true},
+		{"ok upper case", "TEST-KMS", s, true},
+		{"missing", "missing", nil, false},
+		{"bad type", "bad-type", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := LoadSchema(tt.scheme)
+			if ok != tt.wantOk {
+				t.Errorf("LoadSchema() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if got != tt.want {
+				t.Errorf("LoadSchema() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchema_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawuri  string
+		want    *URI
+		wantErr bool
+	}{
+		{"ok", "pkcs11:token=ca;id=7331;object=root?module-path=/usr/lib/softhsm.so&pin-value=pass", &URI{
+			URL: &url.URL{
+				Scheme:   "pkcs11",
+				Opaque:   "token=ca;id=7331;object=root",
+				RawQuery: "module-path=/usr/lib/softhsm.so&pin-value=pass",
+			},
+			Values: url.Values{"token": []string{"ca"}, "id": []string{"7331"}, "object": []string{"root"}},
+		}, false},
+		{"ok order", "PKCS11:object=root;id=7331?pin-value=pass&module-path=/usr/lib/softhsm.so", &URI{
+			URL: &url.URL{
+				Scheme:   "pkcs11",
+				Opaque:   "id=7331;object=root",
+				RawQuery: "module-path=/usr/lib/softhsm.so&pin-value=pass",
+			},
+			Values: url.Values{"id": []string{"7331"}, "object": []string{"root"}},
+		}, false},
+		{"ok location", "pkcs11:module-path=/usr/lib/softhsm.so;token=ca?object=root", &URI{
+			URL: &url.URL{
+				Scheme:   "pkcs11",
+				Opaque:   "token=ca;object=root",
+				RawQuery: "module-path=/usr/lib/softhsm.so",
+			},
+			Values: url.Values{"token": []string{"ca"}, "object": []string{"root"}},
+		}, false},
+		{"ok encoding", "pkcs11:id=%72%73;object=my%20key%3Bwith%2Bchars%26more", &URI{
+			URL: &url.URL{
+				Scheme: "pkcs11",
+				Opaque: "id=rs;object=my%20key%3Bwith%2Bchars%26more",
+			},
+			Values: url.Values{"id": []string{"rs"}, "object": []string{"my key;with+chars&more"}},
+		}, false},
+		{"ok empty", "pkcs11:", &URI{
+			URL:    &url.URL{Scheme: "pkcs11"},
+			Values: url.Values{},
+		}, false},
+		{"fail scheme", "yubikey:slot-id=9a", nil, true},
+		{"fail parse", "pkcs11:id=%ZZ", nil, true},
+		{"fail parse query", "pkcs11:id=7331?object=%ZZ", nil, true},
+		{"fail query separator", "pkcs11:id=7331?object=root;pin-value=pass", nil, true},
+		{"fail unknown", "pkcs11:id=7331;slot-id=1", nil, true},
+		{"fail unknown query", "pkcs11:id=7331?pin-source=/etc/pin", nil, true},
+		{"fail repeated", "pkcs11:id=7331;id=7332", nil, true},
+		{"fail repeated query", "pkcs11:id=7331?id=7332", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testSchema.Parse(tt.rawuri)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Schema.Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Schema.Parse() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchema_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawuri  string
+		wantErr bool
+	}{
+		{"ok", "pkcs11:id=7331;object=root?pin-value=pass", false},
+		{"fail unknown", "pkcs11:id=7331;foo=bar", true},
+		{"fail scheme", "yubikey:id=7331", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := testSchema.Validate(tt.rawuri); (err != nil) != tt.wantErr {
+				t.Errorf("Schema.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchema_Normalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		rawuri  string
+		want    string
+		wantErr bool
+	}{
+		{"ok", "pkcs11:object=root;id=7331", "pkcs11:id=7331;object=root", false},
+		{"ok query", "pkcs11:pin-value=pass;object=root?module-path=/usr/lib/softhsm.so", "pkcs11:object=root?module-path=/usr/lib/softhsm.so&pin-value=pass", false},
+		{"ok encoding", "pkcs11:object=%72oot%2c%20key", "pkcs11:object=root,%20key", false},
+		{"ok slash", "pkcs11:object=a/b?module-path=%2Fusr%2Flib%2Fsofthsm.so", "pkcs11:object=a%2Fb?module-path=/usr/lib/softhsm.so", false},
+		{"ok only query", "pkcs11:?pin-value=pass", "pkcs11:?pin-value=pass", false},
+		{"ok canonical", "pkcs11:token=ca;id=7331?pin-value=pass", "pkcs11:token=ca;id=7331?pin-value=pass", false},
+		{"fail", "pkcs11:foo=bar", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testSchema.Normalize(tt.rawuri)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Schema.Normalize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Schema.Normalize() = %v, want %v", got, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			// Normalized URIs must round-trip.
+			if again, err := testSchema.Normalize(got); err != nil || again != got {
+				t.Errorf("Schema.Normalize() = %v, %v, want %v", again, err, got)
+			}
+		})
+	}
+}
+
+func TestSchema_Build(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  url.Values
+		want    string
+		wantErr bool
+	}{
+		{"ok", url.Values{"object": []string{"root"}, "id": []string{"7331"}, "pin-value": []string{"pass"}}, "pkcs11:id=7331;object=root?pin-value=pass", false},
+		{"ok escape", url.Values{"object": []string{"a=b?c#d%e f"}}, "pkcs11:object=a%3Db%3Fc%23d%25e%20f", false},
+		{"ok binary", url.Values{"id": []string{"\x00\xff"}}, "pkcs11:id=%00%FF", false},
+		{"ok slash", url.Values{"object": []string{"a/b"}, "module-path": []string{"/usr/lib/softhsm.so"}}, "pkcs11:object=a%2Fb?module-path=/usr/lib/softhsm.so", false},
+		{"ok empty value", url.Values{"object": nil}, "pkcs11:object=", false},
+		{"fail unknown", url.Values{"foo": []string{"bar"}}, "", true},
+		{"fail repeated", url.Values{"id": []string{"1", "2"}}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testSchema.Build(tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Schema.Build() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				if got != nil {
+					t.Errorf("Schema.Build() = %v, want nil", got)
+				}
+				return
+			}
+			if s := got.String(); s != tt.want {
+				t.Errorf("Schema.Build() = %v, want %v", s, tt.want)
+			}
+			// Built URIs must be parsed to the same values.
+			u, err := testSchema.Parse(got.String())
+			if err != nil {
+				t.Fatalf("Schema.Parse() error = %v", err)
+			}
+			for key, vals := range tt.values {
+				want := ""
+				if len(vals) > 0 {
+					want = vals[0]
+				}
+				if v := u.Get(key); v != want {
+					t.Errorf("URI.Get(%q) = %q, want %q", key, v, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSchema_String(t *testing.T) {
+	tests := []struct {
+		name   string
+		values url.Values
+		want   string
+	}{
+		{"ok", url.Values{"object": []string{"root"}, "id": []string{"7331"}, "pin-value": []string{"pass"}}, "pkcs11:id=7331;object=root?pin-value=pass"},
+		{"ok escape", url.Values{"object": []string{"a/b c"}}, "pkcs11:object=a%2Fb%20c"},
+		{"ok first value", url.Values{"id": []string{"1", "2"}}, "pkcs11:id=1"},
+		{"ok unknown", url.Values{"id": []string{"1"}, "foo": []string{"bar"}}, "pkcs11:id=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testSchema.String(tt.values); got != tt.want {
+				t.Errorf("Schema.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
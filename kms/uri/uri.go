@@ -1,12 +1,10 @@
 package uri
 
 import (
-	"bytes"
 	"encoding/hex"
 	"net/url"
 	"os"
 	"strings"
-	"unicode"
 
 	"github.com/pkg/errors"
 )
@@ -122,17 +120,28 @@ func (u *URI) GetEncoded(key string) []byte {
 }
 
 // Pin returns the pin encoded in the url. It will read the pin from the
-// pin-value or the pin-source attributes.
+// pin-value or the pin-source attributes. It returns an empty string if the
+// pin-source cannot be read.
 func (u *URI) Pin() string {
+	pin, _ := u.ReadPin()
+	return pin
+}
+
+// ReadPin returns the pin encoded in the url. It will read the pin from the
+// pin-value or the pin-source attributes, see RegisterPinSource for the
+// supported sources. It returns an error if the pin-source cannot be read.
+func (u *URI) ReadPin() (string, error) {
 	if value := u.Get("pin-value"); value != "" {
-		return value
+		return value, nil
 	}
-	if path := u.Get("pin-source"); path != "" {
-		if b, err := readFile(path); err == nil {
-			return string(bytes.TrimRightFunc(b, unicode.IsSpace))
+	if source := u.Get("pin-source"); source != "" {
+		b, err := ReadPinSource(source)
+		if err != nil {
+			return "", err
 		}
+		return string(b), nil
 	}
-	return ""
+	return "", nil
 }
 
 func readFile(path string) ([]byte, error) {
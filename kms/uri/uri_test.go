@@ -246,6 +246,7 @@ func TestURI_Pin(t *testing.T) {
 		{"from source", mustParse("pkcs11:id=%72%73?pin-source=testdata/pin.txt"), "trim-this-pin"},
 		{"from missing", mustParse("pkcs11:id=%72%73"), ""},
 		{"from source missing", mustParse("pkcs11:id=%72%73?pin-source=testdata/foo.txt"), ""},
+		{"from env source missing", mustParse("pkcs11:id=%72%73?pin-source=env:URI_TEST_MISSING"), ""},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -256,6 +257,42 @@ func TestURI_Pin(t *testing.T) {
 	}
 }
 
+func TestURI_ReadPin(t *testing.T) {
+	t.Setenv("URI_TEST_PIN", "env-pin")
+	mustParse := func(s string) *URI {
+		u, err := Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	tests := []struct {
+		name    string
+		uri     *URI
+		want    string
+		wantErr bool
+	}{
+		{"from value", mustParse("pkcs11:id=%72%73?pin-value=0123456789"), "0123456789", false},
+		{"from source", mustParse("pkcs11:id=%72%73?pin-source=testdata/pin.txt"), "trim-this-pin", false},
+		{"from env source", mustParse("pkcs11:id=%72%73?pin-source=env:URI_TEST_PIN"), "env-pin", false},
+		{"from missing", mustParse("pkcs11:id=%72%73"), "", false},
+		{"fail source missing", mustParse("pkcs11:id=%72%73?pin-source=testdata/foo.txt"), "", true},
+		{"fail env source missing", mustParse("pkcs11:id=%72%73?pin-source=env:URI_TEST_MISSING"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.uri.ReadPin()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("URI.ReadPin() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("URI.ReadPin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestURI_String(t *testing.T) {
 	mustParse := func(s string) *URI {
 		u, err := Parse(s)
@@ -0,0 +1,223 @@
+package vaultkms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// client is a minimal client of the Vault HTTP API. If the client has AppRole
+// credentials, it logs in again and retries the request when Vault denies the
+// token, for example, once the token has expired.
+type client struct {
+	address    string
+	token      string
+	namespace  string
+	httpClient *http.Client
+	appRole    *appRole
+	mu         sync.RWMutex
+	loginMu    sync.Mutex
+}
+
+// appRole are the credentials of the AppRole auth method.
+type appRole struct {
+	mount    string
+	roleID   string
+	secretID string
+}
+
+// response is the common envelope of the Vault HTTP API responses.
+type response struct {
+	Data   json.RawMessage `json:"data"`
+	Auth   *authResponse   `json:"auth"`
+	Errors []string        `json:"errors"`
+}
+
+type authResponse struct {
+	ClientToken string `json:"client_token"`
+}
+
+func defaultContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 15*time.Second)
+}
+
+// login authenticates using the AppRole credentials of the client and sets the
+// token of the client.
+func (c *client) login() error {
+	resp, err := c.doRequest(http.MethodPost, "auth/"+c.appRole.mount+"/login", map[string]string{
+		"role_id":   c.appRole.roleID,
+		"secret_id": c.appRole.secretID,
+	}, "")
+	if err != nil {
+		return errors.Wrap(err, "vault approle login failed")
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return errors.New("vault approle login failed: response does not contain a token")
+	}
+	c.mu.Lock()
+	c.token = resp.Auth.ClientToken
+	c.mu.Unlock()
+	return nil
+}
+
+// relogin logs in again if the given token is still the token of the client.
+// Concurrent requests failing with the same token will only log in once.
+func (c *client) relogin(token string) error {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+	if c.getToken() != token {
+		return nil
+	}
+	return c.login()
+}
+
+// getToken returns the current token of the client.
+func (c *client) getToken() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// get performs a GET request to the given path and decodes the response data
+// into v.
+func (c *client) get(path string, v interface{}) error {
+	resp, err := c.do(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	return decodeData(resp, v)
+}
+
+// post performs a POST request to the given path with the given body and
+// decodes the response data into v. If v is nil the data is ignored.
+func (c *client) post(path string, body, v interface{}) error {
+	resp, err := c.do(http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+	return decodeData(resp, v)
+}
+
+// do performs a request using the token of the client. If Vault denies the
+// token and the client has AppRole credentials, it logs in again and retries
+// the request once.
+func (c *client) do(method, path string, body interface{}) (*response, error) {
+	token := c.getToken()
+	resp, err := c.doRequest(method, path, body, token)
+	if c.appRole == nil || !isPermissionDenied(err) {
+		return resp, err
+	}
+	if err := c.relogin(token); err != nil {
+		return nil, err
+	}
+	return c.doRequest(method, path, body, c.getToken())
+}
+
+func (c *client) doRequest(method, path string, body interface{}, token string) (*response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, errors.Wrap(err, "error marshaling request")
+		}
+		r = bytes.NewReader(b)
+	}
+
+	ctx, cancel := defaultContext()
+	defer cancel()
+
+	u := strings.TrimSuffix(c.address, "/") + "/v1/" + path
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating request to %s", u)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error doing %s %s", method, path)
+	}
+	defer res.Body.Close()
+
+	resp := new(response)
+	if res.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(res.Body).Decode(resp); err != nil && err != io.EOF {
+			return nil, errors.Wrapf(err, "error decoding response from %s %s", method, path)
+		}
+	}
+	if res.StatusCode >= 400 {
+		return nil, &statusError{
+			method:     method,
+			path:       path,
+			statusCode: res.StatusCode,
+			errors:     resp.Errors,
+		}
+	}
+	return resp, nil
+}
+
+// statusError is the error returned when the Vault API responds with an error
+// status code.
+type statusError struct {
+	method     string
+	path       string
+	statusCode int
+	errors     []string
+}
+
+func (e *statusError) Error() string {
+	if len(e.errors) > 0 {
+		return fmt.Sprintf("%s %s failed with status %d: %s", e.method, e.path, e.statusCode, strings.Join(e.errors, ", "))
+	}
+	return fmt.Sprintf("%s %s failed with status %d", e.method, e.path, e.statusCode)
+}
+
+// isNotFound returns true if the given error is a statusError with the status
+// code 404.
+func isNotFound(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.statusCode == http.StatusNotFound
+}
+
+// isPermissionDenied returns true if the given error is a statusError with the
+// status code 403. Vault uses it for invalid and expired tokens.
+func isPermissionDenied(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.statusCode == http.StatusForbidden
+}
+
+func decodeData(resp *response, v interface{}) error {
+	if len(resp.Data) == 0 {
+		return errors.New("vault response does not contain data")
+	}
+	if err := json.Unmarshal(resp.Data, v); err != nil {
+		return errors.Wrap(err, "error decoding vault response")
+	}
+	return nil
+}
+
+// keyPath returns the escaped path of a transit endpoint.
+func keyPath(mount, endpoint, name string, rest ...string) string {
+	parts := append([]string{mount, endpoint, url.PathEscape(name)}, rest...)
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,308 @@
+package vaultkms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.step.sm/crypto/pemutil"
+)
+
+const (
+	testToken    = "s.test-token"
+	testRoleID   = "test-role-id"
+	testSecretID = "test-secret-id"
+)
+
+// fakeVault is an in-memory stand-in of the Vault transit secrets engine and
+// the AppRole auth method.
+type fakeVault struct {
+	mu        sync.Mutex
+	namespace string
+	token     string
+	logins    int
+	keys      map[string]*fakeKey
+}
+
+type fakeKey struct {
+	keyType  string
+	versions []crypto.Signer
+}
+
+func newFakeVault(t *testing.T) (*fakeVault, *httptest.Server) {
+	t.Helper()
+	fv := &fakeVault{
+		token: testToken,
+		keys:  make(map[string]*fakeKey),
+	}
+	srv := httptest.NewServer(fv)
+	t.Cleanup(srv.Close)
+	return fv, srv
+}
+
+// mustCreateKey creates a new key or a new version of an existing key.
+func (fv *fakeVault) mustCreateKey(t *testing.T, mount, name, keyType string) crypto.Signer {
+	t.Helper()
+	signer, err := generateSigner(keyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	k, ok := fv.keys[mount+"/"+name]
+	if !ok {
+		k = &fakeKey{keyType: keyType}
+		fv.keys[mount+"/"+name] = k
+	}
+	k.versions = append(k.versions, signer)
+	return signer
+}
+
+// expireToken invalidates the current token, the next AppRole login will get a
+// new one.
+func (fv *fakeVault) expireToken() {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	fv.token = "s.new-token-" + strconv.Itoa(fv.logins)
+}
+
+func generateSigner(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "ecdsa-p256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ecdsa-p384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ecdsa-p521":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "ed25519":
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	case "rsa-2048":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "rsa-3072":
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case "rsa-4096":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", keyType)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string][]string{"errors": {msg}})
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (fv *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+
+	if r.Header.Get("X-Vault-Namespace") != fv.namespace {
+		writeError(w, http.StatusForbidden, "namespace not found")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if path == "auth/approle/login" && r.Method == http.MethodPost {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if body["role_id"] != testRoleID || body["secret_id"] != testSecretID {
+			writeError(w, http.StatusBadRequest, "invalid role or secret ID")
+			return
+		}
+		fv.logins++
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]string{"client_token": fv.token},
+		})
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != fv.token {
+		writeError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	// Paths are mount/endpoint/name[/hash]
+	parts := strings.Split(path, "/")
+	i := 1
+	for ; i < len(parts)-1; i++ {
+		if parts[i] == "keys" || parts[i] == "sign" || parts[i] == "decrypt" {
+			break
+		}
+	}
+	if i >= len(parts)-1 {
+		writeError(w, http.StatusNotFound, "unsupported path")
+		return
+	}
+	mount, endpoint, name, rest := strings.Join(parts[:i], "/"), parts[i], parts[i+1], parts[i+2:]
+
+	var body map[string]interface{}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	key, ok := fv.keys[mount+"/"+name]
+	switch {
+	case endpoint == "keys" && r.Method == http.MethodPost:
+		if !ok {
+			signer, err := generateSigner(fmt.Sprint(body["type"]))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			fv.keys[mount+"/"+name] = &fakeKey{
+				keyType:  fmt.Sprint(body["type"]),
+				versions: []crypto.Signer{signer},
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case !ok:
+		writeError(w, http.StatusNotFound, "key not found")
+	case endpoint == "keys" && r.Method == http.MethodGet:
+		fv.readKey(w, key)
+	case endpoint == "sign" && r.Method == http.MethodPost:
+		fv.sign(w, key, body, rest)
+	case endpoint == "decrypt" && r.Method == http.MethodPost:
+		fv.decrypt(w, key, body)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "unsupported method")
+	}
+}
+
+func (fv *fakeVault) readKey(w http.ResponseWriter, key *fakeKey) {
+	keys := make(map[string]interface{})
+	for i, signer := range key.versions {
+		var publicKey string
+		if pub, ok := signer.Public().(ed25519.PublicKey); ok {
+			publicKey = base64.StdEncoding.EncodeToString(pub)
+		} else {
+			block, err := pemutil.Serialize(signer.Public())
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			publicKey = string(pem.EncodeToMemory(block))
+		}
+		keys[strconv.Itoa(i+1)] = map[string]string{"public_key": publicKey}
+	}
+	writeData(w, map[string]interface{}{
+		"type":           key.keyType,
+		"latest_version": len(key.versions),
+		"keys":           keys,
+	})
+}
+
+func (fv *fakeVault) getVersion(key *fakeKey, v interface{}) (int, crypto.Signer, error) {
+	version := len(key.versions)
+	if f, ok := v.(float64); ok && f > 0 {
+		version = int(f)
+	}
+	if version > len(key.versions) {
+		return 0, nil, fmt.Errorf("invalid key version %d", version)
+	}
+	return version, key.versions[version-1], nil
+}
+
+func (fv *fakeVault) sign(w http.ResponseWriter, key *fakeKey, body map[string]interface{}, rest []string) {
+	version, signer, err := fv.getVersion(key, body["key_version"])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	input, err := base64.StdEncoding.DecodeString(fmt.Sprint(body["input"]))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var opts crypto.SignerOpts = crypto.Hash(0)
+	if len(rest) > 0 {
+		switch rest[0] {
+		case "sha2-256":
+			opts = crypto.SHA256
+		case "sha2-384":
+			opts = crypto.SHA384
+		case "sha2-512":
+			opts = crypto.SHA512
+		default:
+			writeError(w, http.StatusBadRequest, "unsupported hash algorithm")
+			return
+		}
+		if body["prehashed"] != true {
+			writeError(w, http.StatusBadRequest, "input is not prehashed")
+			return
+		}
+		if body["signature_algorithm"] == "pss" {
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: opts.HashFunc()}
+		}
+	}
+
+	sig, err := signer.Sign(rand.Reader, input, opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeData(w, map[string]string{
+		"signature": "vault:v" + strconv.Itoa(version) + ":" + base64.StdEncoding.EncodeToString(sig),
+	})
+}
+
+func (fv *fakeVault) decrypt(w http.ResponseWriter, key *fakeKey, body map[string]interface{}) {
+	parts := strings.SplitN(fmt.Sprint(body["ciphertext"]), ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		writeError(w, http.StatusBadRequest, "invalid ciphertext")
+		return
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(parts[1], "v"))
+	if err != nil || version <= 0 || version > len(key.versions) {
+		writeError(w, http.StatusBadRequest, "invalid ciphertext version")
+		return
+	}
+	priv, ok := key.versions[version-1].(*rsa.PrivateKey)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "key does not support decryption")
+		return
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, ciphertext, nil)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeData(w, map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	})
+}
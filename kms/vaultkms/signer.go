@@ -0,0 +1,141 @@
+package vaultkms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Signer implements a crypto.Signer using a key in the transit secrets engine.
+type Signer struct {
+	client    *client
+	key       keyName
+	publicKey crypto.PublicKey
+}
+
+// Public returns the public key of this signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs the given digest with the transit key. Ed25519 keys sign the full
+// message.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	body := map[string]interface{}{
+		"input":       base64.StdEncoding.EncodeToString(digest),
+		"key_version": s.key.version,
+	}
+
+	var path string
+	switch s.publicKey.(type) {
+	case ed25519.PublicKey:
+		if opts.HashFunc() != crypto.Hash(0) {
+			return nil, errors.New("ed25519 keys do not support pre-hashed messages")
+		}
+		path = keyPath(s.key.mount, "sign", s.key.name)
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		alg, err := getHashAlgorithm(opts.HashFunc())
+		if err != nil {
+			return nil, err
+		}
+		body["prehashed"] = true
+		if _, ok := s.publicKey.(*rsa.PublicKey); ok {
+			if pss, ok := opts.(*rsa.PSSOptions); ok {
+				if pss.SaltLength != rsa.PSSSaltLengthEqualsHash && pss.SaltLength != opts.HashFunc().Size() {
+					return nil, errors.New("rsa-pss only supports salt length equals hash")
+				}
+				body["signature_algorithm"] = "pss"
+				body["salt_length"] = "hash"
+			} else {
+				body["signature_algorithm"] = "pkcs1v15"
+			}
+		}
+		path = keyPath(s.key.mount, "sign", s.key.name, alg)
+	default:
+		return nil, errors.Errorf("unsupported key type %T", s.publicKey)
+	}
+
+	var resp struct {
+		Signature string `json:"signature"`
+	}
+	if err := s.client.post(path, body, &resp); err != nil {
+		return nil, errors.Wrap(err, "vaultKMS sign failed")
+	}
+	return decodeValue(resp.Signature)
+}
+
+// Decrypter implements a crypto.Decrypter using an RSA key in the transit
+// secrets engine.
+type Decrypter struct {
+	*Signer
+}
+
+// NewDecrypter creates a Decrypter with the given signer. The signer must use
+// an RSA key.
+func NewDecrypter(s *Signer) (*Decrypter, error) {
+	if _, ok := s.publicKey.(*rsa.PublicKey); !ok {
+		return nil, errors.Errorf("vaultKMS key %s is not an RSA key", s.key.name)
+	}
+	return &Decrypter{Signer: s}, nil
+}
+
+// Decrypt decrypts a message encrypted with RSA-OAEP and SHA-256, the only
+// scheme supported by the transit secrets engine.
+func (d *Decrypter) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	oaep, ok := opts.(*rsa.OAEPOptions)
+	switch {
+	case !ok:
+		return nil, errors.Errorf("unsupported decrypter options %T", opts)
+	case oaep.Hash != crypto.SHA256:
+		return nil, errors.Errorf("unsupported hash function %v", oaep.Hash)
+	case len(oaep.Label) > 0:
+		return nil, errors.New("rsa-oaep labels are not supported")
+	}
+
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := d.client.post(keyPath(d.key.mount, "decrypt", d.key.name), map[string]string{
+		"ciphertext": "vault:v" + strconv.Itoa(d.key.version) + ":" + base64.StdEncoding.EncodeToString(ciphertext),
+	}, &resp); err != nil {
+		return nil, errors.Wrap(err, "vaultKMS decrypt failed")
+	}
+	b, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding vaultKMS plaintext")
+	}
+	return b, nil
+}
+
+func getHashAlgorithm(h crypto.Hash) (string, error) {
+	switch h {
+	case crypto.SHA256:
+		return "sha2-256", nil
+	case crypto.SHA384:
+		return "sha2-384", nil
+	case crypto.SHA512:
+		return "sha2-512", nil
+	default:
+		return "", errors.Errorf("unsupported hash function %v", h)
+	}
+}
+
+// decodeValue decodes a value in the format vault:v1:base64.
+func decodeValue(s string) ([]byte, error) {
+	i := strings.LastIndex(s, ":")
+	if !strings.HasPrefix(s, "vault:v") || i < 0 {
+		return nil, errors.Errorf("error decoding vaultKMS value %q", s)
+	}
+	b, err := base64.StdEncoding.DecodeString(s[i+1:])
+	if err != nil {
+		return nil, errors.Wrap(err, "error decoding vaultKMS value")
+	}
+	return b, nil
+}
@@ -0,0 +1,175 @@
+package vaultkms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"io"
+	"reflect"
+	"testing"
+
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+func TestSigner_Sign(t *testing.T) {
+	fv, k := newTestKMS(t)
+	fv.mustCreateKey(t, "transit", "ec-key", "ecdsa-p384")
+	fv.mustCreateKey(t, "transit", "rsa-key", "rsa-2048")
+	fv.mustCreateKey(t, "transit", "ed-key", "ed25519")
+
+	mustSigner := func(name string) crypto.Signer {
+		s, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	ecSigner := mustSigner("vaultkms:name=ec-key")
+	rsaSigner := mustSigner("vaultkms:name=rsa-key")
+	edSigner := mustSigner("vaultkms:name=ed-key")
+
+	message := []byte("the message")
+	sum256 := sha256.Sum256(message)
+	sum384 := sha512.Sum384(message)
+
+	verify := func(pub crypto.PublicKey, digest, sig []byte, opts crypto.SignerOpts) bool {
+		switch pub := pub.(type) {
+		case *ecdsa.PublicKey:
+			return ecdsa.VerifyASN1(pub, digest, sig)
+		case *rsa.PublicKey:
+			if pss, ok := opts.(*rsa.PSSOptions); ok {
+				return rsa.VerifyPSS(pub, opts.HashFunc(), digest, sig, pss) == nil
+			}
+			return rsa.VerifyPKCS1v15(pub, opts.HashFunc(), digest, sig) == nil
+		case ed25519.PublicKey:
+			return ed25519.Verify(pub, digest, sig)
+		default:
+			return false
+		}
+	}
+
+	type args struct {
+		rand   io.Reader
+		digest []byte
+		opts   crypto.SignerOpts
+	}
+	tests := []struct {
+		name    string
+		signer  crypto.Signer
+		args    args
+		wantErr bool
+	}{
+		{"ok ecdsa", ecSigner, args{rand.Reader, sum384[:], crypto.SHA384}, false},
+		{"ok rsa", rsaSigner, args{rand.Reader, sum256[:], crypto.SHA256}, false},
+		{"ok rsa-pss", rsaSigner, args{rand.Reader, sum256[:], &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256,
+		}}, false},
+		{"ok ed25519", edSigner, args{rand.Reader, message, crypto.Hash(0)}, false},
+		{"fail hash", ecSigner, args{rand.Reader, sum256[:], crypto.SHA1}, true},
+		{"fail ed25519 hash", edSigner, args{rand.Reader, sum256[:], crypto.SHA256}, true},
+		{"fail rsa-pss salt length", rsaSigner, args{rand.Reader, sum256[:], &rsa.PSSOptions{
+			SaltLength: rsa.PSSSaltLengthAuto, Hash: crypto.SHA256,
+		}}, true},
+		{"fail key type", &Signer{client: k.client, key: keyName{mount: "transit", name: "ec-key"}, publicKey: []byte("foo")}, args{rand.Reader, sum256[:], crypto.SHA256}, true},
+		{"fail sign", &Signer{client: k.client, key: keyName{mount: "transit", name: "missing"}, publicKey: ecSigner.Public()}, args{rand.Reader, sum256[:], crypto.SHA256}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.signer.Sign(tt.args.rand, tt.args.digest, tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !verify(tt.signer.Public(), tt.args.digest, got, tt.args.opts) {
+				t.Errorf("Signer.Sign() signature verification failed")
+			}
+		})
+	}
+}
+
+func TestDecrypter_Decrypt(t *testing.T) {
+	fv, k := newTestKMS(t)
+	fv.mustCreateKey(t, "transit", "rsa-key", "rsa-2048")
+	fv.mustCreateKey(t, "transit", "rsa-key", "rsa-2048")
+
+	mustDecrypter := func(name string) crypto.Decrypter {
+		d, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{DecryptionKey: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	d1 := mustDecrypter("vaultkms:name=rsa-key;version=1")
+	d2 := mustDecrypter("vaultkms:name=rsa-key")
+
+	mustEncrypt := func(d crypto.Decrypter, label []byte) []byte {
+		b, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, d.Public().(*rsa.PublicKey), []byte("plaintext"), label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	type args struct {
+		rand       io.Reader
+		ciphertext []byte
+		opts       crypto.DecrypterOpts
+	}
+	tests := []struct {
+		name      string
+		decrypter crypto.Decrypter
+		args      args
+		want      []byte
+		wantErr   bool
+	}{
+		{"ok version", d1, args{rand.Reader, mustEncrypt(d1, nil), &rsa.OAEPOptions{Hash: crypto.SHA256}}, []byte("plaintext"), false},
+		{"ok latest", d2, args{rand.Reader, mustEncrypt(d2, nil), &rsa.OAEPOptions{Hash: crypto.SHA256}}, []byte("plaintext"), false},
+		{"fail version", d2, args{rand.Reader, mustEncrypt(d1, nil), &rsa.OAEPOptions{Hash: crypto.SHA256}}, nil, true},
+		{"fail opts", d1, args{rand.Reader, mustEncrypt(d1, nil), nil}, nil, true},
+		{"fail pkcs1v15", d1, args{rand.Reader, mustEncrypt(d1, nil), &rsa.PKCS1v15DecryptOptions{}}, nil, true},
+		{"fail hash", d1, args{rand.Reader, mustEncrypt(d1, nil), &rsa.OAEPOptions{Hash: crypto.SHA384}}, nil, true},
+		{"fail label", d1, args{rand.Reader, mustEncrypt(d1, []byte("label")), &rsa.OAEPOptions{Hash: crypto.SHA256, Label: []byte("label")}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.decrypter.Decrypt(tt.args.rand, tt.args.ciphertext, tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decrypter.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decrypter.Decrypt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_decodeValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []byte
+		wantErr bool
+	}{
+		{"ok", "vault:v1:Zm9v", []byte("foo"), false},
+		{"ok version", "vault:v12:YmFy", []byte("bar"), false},
+		{"fail prefix", "Zm9v", nil, true},
+		{"fail base64", "vault:v1:%%%", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeValue(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decodeValue() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,475 @@
+package vaultkms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+	"go.step.sm/crypto/kms/uri"
+	"go.step.sm/crypto/pemutil"
+)
+
+// Scheme is the scheme used in uris.
+const Scheme = "vaultkms"
+
+// keySchema defines the attributes allowed in the key URIs, like
+// vaultkms:name=my-key;mount=transit;version=2.
+var keySchema = &uri.Schema{
+	Scheme: Scheme,
+	Path:   []string{"name", "mount", "version"},
+}
+
+// DefaultAddress is the address of the Vault server used if it's not defined
+// in the URI or in the VAULT_ADDR environment variable.
+const DefaultAddress = "https://127.0.0.1:8200"
+
+// DefaultMount is the default path where the transit secrets engine is
+// mounted.
+const DefaultMount = "transit"
+
+// DefaultAppRoleMount is the default path where the AppRole auth method is
+// mounted.
+const DefaultAppRoleMount = "approle"
+
+// DefaultRSASize is the number of bits of a new RSA key if no size has been
+// specified.
+const DefaultRSASize = 3072
+
+// signatureAlgorithmMapping is a mapping between the step signature algorithm,
+// and bits for RSA keys, with the transit key type.
+var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]interface{}{
+	apiv1.UnspecifiedSignAlgorithm: "ecdsa-p256",
+	apiv1.SHA256WithRSA:            rsaKeyTypes,
+	apiv1.SHA384WithRSA:            rsaKeyTypes,
+	apiv1.SHA512WithRSA:            rsaKeyTypes,
+	apiv1.SHA256WithRSAPSS:         rsaKeyTypes,
+	apiv1.SHA384WithRSAPSS:         rsaKeyTypes,
+	apiv1.SHA512WithRSAPSS:         rsaKeyTypes,
+	apiv1.ECDSAWithSHA256:          "ecdsa-p256",
+	apiv1.ECDSAWithSHA384:          "ecdsa-p384",
+	apiv1.ECDSAWithSHA512:          "ecdsa-p521",
+	apiv1.PureEd25519:              "ed25519",
+}
+
+var rsaKeyTypes = map[int]string{
+	0:    "rsa-3072",
+	2048: "rsa-2048",
+	3072: "rsa-3072",
+	4096: "rsa-4096",
+}
+
+// VaultKMS implements a KMS using the transit secrets engine of HashiCorp
+// Vault.
+//
+// The URI used to configure the KMS has the following format:
+//
+//	vaultkms:address=https://vault:8200;mount=transit;namespace=ns;token-file=/path/to/token
+//	vaultkms:address=https://vault:8200;role-id=my-role;secret-id-file=/path/to/secret-id
+//
+// All the attributes are optional. The address defaults to the VAULT_ADDR
+// environment variable, the namespace to VAULT_NAMESPACE, and the mount to
+// "transit". The server certificate is verified using the CA certificate file
+// in "ca-cert" or VAULT_CACERT, or the system roots if none is set; setting
+// VAULT_SKIP_VERIFY=true disables the verification. If "role-id" is set, the
+// AppRole auth method mounted in "approle-mount" will be used to get a token,
+// and to get a new one when Vault denies the current one, for example, when it
+// expires. Otherwise the token will be read from "token-file" or the
+// VAULT_TOKEN environment variable.
+//
+// Keys are identified by URIs like:
+//
+//	vaultkms:name=my-key
+//	vaultkms:name=my-key;mount=transit;version=2
+//
+// If the version is not given, the latest version of the key will be used.
+type VaultKMS struct {
+	client *client
+	mount  string
+}
+
+// New creates a new VaultKMS.
+func New(ctx context.Context, opts apiv1.Options) (*VaultKMS, error) {
+	c := &client{
+		address:   os.Getenv("VAULT_ADDR"),
+		token:     os.Getenv("VAULT_TOKEN"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+	}
+	mount := DefaultMount
+	caCert := os.Getenv("VAULT_CACERT")
+
+	var roleID, secretID, appRoleMount string
+	if opts.URI != "" {
+		u, err := uri.ParseWithScheme(Scheme, opts.URI)
+		if err != nil {
+			return nil, err
+		}
+		if v := u.Get("address"); v != "" {
+			c.address = v
+		}
+		if v := u.Get("namespace"); v != "" {
+			c.namespace = v
+		}
+		if v := u.Get("mount"); v != "" {
+			mount = strings.Trim(v, "/")
+		}
+		if v := u.Get("token-file"); v != "" {
+			if c.token, err = readFile(v); err != nil {
+				return nil, err
+			}
+		}
+		roleID = u.Get("role-id")
+		secretID = u.Get("secret-id")
+		if v := u.Get("secret-id-file"); v != "" {
+			if secretID, err = readFile(v); err != nil {
+				return nil, err
+			}
+		}
+		appRoleMount = u.Get("approle-mount")
+		if v := u.Get("ca-cert"); v != "" {
+			caCert = v
+		}
+	}
+	if c.address == "" {
+		c.address = DefaultAddress
+	}
+
+	var err error
+	if c.httpClient, err = newHTTPClient(caCert, os.Getenv("VAULT_SKIP_VERIFY")); err != nil {
+		return nil, err
+	}
+
+	if roleID != "" {
+		if appRoleMount == "" {
+			appRoleMount = DefaultAppRoleMount
+		}
+		c.appRole = &appRole{
+			mount:    strings.Trim(appRoleMount, "/"),
+			roleID:   roleID,
+			secretID: secretID,
+		}
+		if err := c.login(); err != nil {
+			return nil, err
+		}
+	}
+	if c.token == "" {
+		return nil, errors.New("vaultKMS requires a token, a token-file or a role-id")
+	}
+
+	return &VaultKMS{
+		client: c,
+		mount:  mount,
+	}, nil
+}
+
+func init() {
+	uri.RegisterSchema(keySchema)
+	apiv1.Register(apiv1.VaultKMS, func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
+		return New(ctx, opts)
+	})
+}
+
+// GetPublicKey returns the public key of a transit key.
+func (k *VaultKMS) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey, error) {
+	if req.Name == "" {
+		return nil, errors.New("getPublicKeyRequest 'name' cannot be empty")
+	}
+
+	key, err := k.parseKeyName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	pub, _, err := k.getPublicKey(key)
+	return pub, err
+}
+
+// CreateKey creates a new key in the transit secrets engine. It returns an
+// apiv1.AlreadyExistsError if the key already exists.
+func (k *VaultKMS) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	if req.Name == "" {
+		return nil, errors.New("createKeyRequest 'name' cannot be empty")
+	}
+
+	key, err := k.parseKeyName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	keyType, err := getKeyType(req.SignatureAlgorithm, req.Bits)
+	if err != nil {
+		return nil, err
+	}
+
+	// Creating an existing key in the transit secrets engine is a no-op, so
+	// check first if the key exists.
+	var tk transitKey
+	switch err := k.client.get(keyPath(key.mount, "keys", key.name), &tk); {
+	case err == nil:
+		return nil, apiv1.AlreadyExistsError{
+			Message: req.Name + " already exists",
+		}
+	case !isNotFound(err):
+		return nil, errors.Wrap(err, "vaultKMS read key failed")
+	}
+
+	if err := k.client.post(keyPath(key.mount, "keys", key.name), map[string]string{
+		"type": keyType,
+	}, nil); err != nil {
+		return nil, errors.Wrap(err, "vaultKMS create key failed")
+	}
+
+	key.version = 0
+	pub, version, err := k.getPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	key.version = version
+
+	name := key.String()
+	return &apiv1.CreateKeyResponse{
+		Name:      name,
+		PublicKey: pub,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: name,
+		},
+	}, nil
+}
+
+// CreateSigner creates a signer using a key in the transit secrets engine.
+func (k *VaultKMS) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
+	if req.SigningKey == "" {
+		return nil, errors.New("createSignerRequest 'signingKey' cannot be empty")
+	}
+	return k.newSigner(req.SigningKey)
+}
+
+// CreateDecrypter creates a decrypter using an RSA key in the transit secrets
+// engine. The transit secrets engine only supports RSA-OAEP with SHA-256.
+func (k *VaultKMS) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Decrypter, error) {
+	if req.DecryptionKey == "" {
+		return nil, errors.New("createDecrypterRequest 'decryptionKey' cannot be empty")
+	}
+	s, err := k.newSigner(req.DecryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecrypter(s)
+}
+
+// Close is a noop that just returns nil.
+func (k *VaultKMS) Close() error {
+	return nil
+}
+
+// ValidateName validates that the given string is a valid key name or URI.
+func (k *VaultKMS) ValidateName(s string) error {
+	if uri.HasScheme(Scheme, s) {
+		if err := keySchema.Validate(s); err != nil {
+			return err
+		}
+	}
+	_, err := k.parseKeyName(s)
+	return err
+}
+
+func (k *VaultKMS) newSigner(name string) (*Signer, error) {
+	key, err := k.parseKeyName(name)
+	if err != nil {
+		return nil, err
+	}
+	pub, version, err := k.getPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	key.version = version
+	return &Signer{
+		client:    k.client,
+		key:       key,
+		publicKey: pub,
+	}, nil
+}
+
+// transitKey is the representation of the key returned by the read key
+// endpoint. For asymmetric keys, the keys contain the public key of each
+// version.
+type transitKey struct {
+	Type          string                     `json:"type"`
+	LatestVersion int                        `json:"latest_version"`
+	Keys          map[string]json.RawMessage `json:"keys"`
+}
+
+type transitKeyVersion struct {
+	PublicKey string `json:"public_key"`
+}
+
+// getPublicKey returns the public key of the given key and the version used.
+// If the version in the key is 0, the latest version will be used.
+func (k *VaultKMS) getPublicKey(key keyName) (crypto.PublicKey, int, error) {
+	var tk transitKey
+	if err := k.client.get(keyPath(key.mount, "keys", key.name), &tk); err != nil {
+		return nil, 0, errors.Wrap(err, "vaultKMS read key failed")
+	}
+
+	version := key.version
+	if version == 0 {
+		version = tk.LatestVersion
+	}
+	raw, ok := tk.Keys[strconv.Itoa(version)]
+	if !ok {
+		return nil, 0, errors.Errorf("vaultKMS key %s does not have version %d", key.name, version)
+	}
+	var kv transitKeyVersion
+	if err := json.Unmarshal(raw, &kv); err != nil || kv.PublicKey == "" {
+		return nil, 0, errors.Errorf("vaultKMS key %s of type %s does not have a public key", key.name, tk.Type)
+	}
+
+	pub, err := parsePublicKey(tk.Type, kv.PublicKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	return pub, version, nil
+}
+
+// parsePublicKey parses the public key returned by the transit secrets engine.
+// Ed25519 keys are encoded in base64, the rest of keys are PEM encoded.
+func parsePublicKey(keyType, s string) (crypto.PublicKey, error) {
+	if keyType == "ed25519" {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, errors.New("error parsing ed25519 public key")
+		}
+		return ed25519.PublicKey(b), nil
+	}
+	pub, err := pemutil.ParseKey([]byte(s))
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing public key")
+	}
+	return pub, nil
+}
+
+func getKeyType(alg apiv1.SignatureAlgorithm, bits int) (string, error) {
+	v, ok := signatureAlgorithmMapping[alg]
+	if !ok {
+		return "", errors.Errorf("vaultKMS does not support signature algorithm '%s'", alg)
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case map[int]string:
+		t, ok := v[bits]
+		if !ok {
+			return "", errors.Errorf("vaultKMS does not support signature algorithm '%s' with '%d' bits", alg, bits)
+		}
+		return t, nil
+	default:
+		return "", errors.Errorf("unexpected error: this should not happen")
+	}
+}
+
+// keyName is the parsed representation of a key URI.
+type keyName struct {
+	mount   string
+	name    string
+	version int
+}
+
+// String returns the URI of the key.
+func (k keyName) String() string {
+	values := url.Values{
+		"mount": []string{k.mount},
+		"name":  []string{k.name},
+	}
+	if k.version > 0 {
+		values.Set("version", strconv.Itoa(k.version))
+	}
+	return keySchema.String(values)
+}
+
+// parseKeyName parses a key URI like vaultkms:name=my-key;version=2. A plain
+// key name can also be used.
+func (k *VaultKMS) parseKeyName(rawuri string) (keyName, error) {
+	key := keyName{
+		mount: k.mount,
+		name:  rawuri,
+	}
+	if !strings.HasPrefix(strings.ToLower(rawuri), Scheme+":") {
+		if rawuri == "" || strings.Contains(rawuri, "/") {
+			return keyName{}, errors.Errorf("key name %q is not valid", rawuri)
+		}
+		return key, nil
+	}
+
+	u, err := uri.ParseWithScheme(Scheme, rawuri)
+	if err != nil {
+		return keyName{}, err
+	}
+	if key.name = u.Get("name"); key.name == "" {
+		return keyName{}, errors.Errorf("key uri %s is not valid: name is missing", rawuri)
+	}
+	if v := u.Get("mount"); v != "" {
+		key.mount = strings.Trim(v, "/")
+	}
+	if v := u.Get("version"); v != "" && v != "latest" {
+		if key.version, err = strconv.Atoi(v); err != nil || key.version <= 0 {
+			return keyName{}, errors.Errorf("key uri %s is not valid: version is not valid", rawuri)
+		}
+	}
+	return key, nil
+}
+
+// newHTTPClient returns the http.Client used to connect to Vault. If a CA
+// certificate file is given, it will be used to verify the server certificate.
+// The verification can be disabled setting skipVerify to a true value, as in
+// the VAULT_SKIP_VERIFY environment variable.
+func newHTTPClient(caCert, skipVerify string) (*http.Client, error) {
+	if caCert == "" && skipVerify == "" {
+		return http.DefaultClient, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if caCert != "" {
+		b, err := os.ReadFile(caCert)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", caCert)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("error reading %s: no certificates found", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if skipVerify != "" {
+		insecure, err := strconv.ParseBool(skipVerify)
+		if err != nil {
+			return nil, errors.Errorf("VAULT_SKIP_VERIFY value %q is not valid", skipVerify)
+		}
+		tlsConfig.InsecureSkipVerify = insecure //nolint:gosec // explicitly requested
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Transport: transport,
+	}, nil
+}
+
+func readFile(filename string) (string, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading %s", filename)
+	}
+	return string(bytes.TrimRightFunc(b, unicode.IsSpace)), nil
+}
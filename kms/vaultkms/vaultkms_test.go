@@ -0,0 +1,467 @@
+package vaultkms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range []string{"VAULT_ADDR", "VAULT_TOKEN", "VAULT_NAMESPACE", "VAULT_CACERT", "VAULT_SKIP_VERIFY"} {
+		t.Setenv(key, "")
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func newTestKMS(t *testing.T) (*fakeVault, *VaultKMS) {
+	t.Helper()
+	fv, srv := newFakeVault(t)
+	return fv, &VaultKMS{
+		client: &client{
+			address:    srv.URL,
+			token:      testToken,
+			httpClient: srv.Client(),
+		},
+		mount: DefaultMount,
+	}
+}
+
+func TestNew(t *testing.T) {
+	clearEnv(t)
+	fv, srv := newFakeVault(t)
+	tokenFile := writeFile(t, "token", testToken)
+	secretIDFile := writeFile(t, "secret-id", testSecretID)
+
+	type args struct {
+		ctx  context.Context
+		opts apiv1.Options
+	}
+	tests := []struct {
+		name          string
+		env           map[string]string
+		fakeNamespace string
+		args          args
+		want          *VaultKMS
+		wantErr       bool
+	}{
+		{"ok env", map[string]string{"VAULT_ADDR": srv.URL, "VAULT_TOKEN": testToken}, "", args{context.Background(), apiv1.Options{}}, &VaultKMS{
+			client: &client{address: srv.URL, token: testToken, httpClient: http.DefaultClient},
+			mount:  "transit",
+		}, false},
+		{"ok token file", nil, "", args{context.Background(), apiv1.Options{
+			URI: "vaultkms:address=" + srv.URL + ";token-file=" + tokenFile + ";mount=/my-transit/;namespace=ns1",
+		}}, &VaultKMS{
+			client: &client{address: srv.URL, token: testToken, namespace: "ns1", httpClient: http.DefaultClient},
+			mount:  "my-transit",
+		}, false},
+		{"ok default address", map[string]string{"VAULT_TOKEN": testToken}, "", args{context.Background(), apiv1.Options{}}, &VaultKMS{
+			client: &client{address: DefaultAddress, token: testToken, httpClient: http.DefaultClient},
+			mount:  "transit",
+		}, false},
+		{"ok approle", nil, "", args{context.Background(), apiv1.Options{
+			URI: "vaultkms:address=" + srv.URL + ";role-id=" + testRoleID + ";secret-id-file=" + secretIDFile,
+		}}, &VaultKMS{
+			client: &client{address: srv.URL, token: testToken, httpClient: http.DefaultClient, appRole: &appRole{
+				mount: "approle", roleID: testRoleID, secretID: testSecretID,
+			}},
+			mount: "transit",
+		}, false},
+		{"ok approle namespace", nil, "ns1", args{context.Background(), apiv1.Options{
+			URI: "vaultkms:address=" + srv.URL + ";role-id=" + testRoleID + ";secret-id=" + testSecretID + ";approle-mount=/approle/;namespace=ns1",
+		}}, &VaultKMS{
+			client: &client{address: srv.URL, token: testToken, namespace: "ns1", httpClient: http.DefaultClient, appRole: &appRole{
+				mount: "approle", roleID: testRoleID, secretID: testSecretID,
+			}},
+			mount: "transit",
+		}, false},
+		{"fail uri", nil, "", args{context.Background(), apiv1.Options{URI: "pkcs11:token=foo"}}, nil, true},
+		{"fail token file", nil, "", args{context.Background(), apiv1.Options{
+			URI: "vaultkms:address=" + srv.URL + ";token-file=" + filepath.Join(t.TempDir(), "missing"),
+		}}, nil, true},
+		{"fail secret id file", nil, "", args{context.Background(), apiv1.Options{
+			URI: "vaultkms:address=" + srv.URL + ";role-id=" + testRoleID + ";secret-id-file=" + filepath.Join(t.TempDir(), "missing"),
+		}}, nil, true},
+		{"fail approle", nil, "", args{context.Background(), apiv1.Options{
+			URI: "vaultkms:address=" + srv.URL + ";role-id=" + testRoleID + ";secret-id=bad-secret-id",
+		}}, nil, true},
+		{"fail approle namespace", nil, "ns1", args{context.Background(), apiv1.Options{
+			URI: "vaultkms:address=" + srv.URL + ";role-id=" + testRoleID + ";secret-id=" + testSecretID,
+		}}, nil, true},
+		{"fail no token", nil, "", args{context.Background(), apiv1.Options{
+			URI: "vaultkms:address=" + srv.URL,
+		}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fv.namespace = tt.fakeNamespace
+			got, err := New(tt.args.ctx, tt.args.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("New() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew_tokenExpired(t *testing.T) {
+	clearEnv(t)
+	fv, srv := newFakeVault(t)
+	fv.mustCreateKey(t, "transit", "my-key", "ecdsa-p256")
+
+	tests := []struct {
+		name       string
+		uri        string
+		revoke     bool
+		wantLogins int
+		wantErr    bool
+	}{
+		{"ok approle", "vaultkms:address=" + srv.URL + ";role-id=" + testRoleID + ";secret-id=" + testSecretID, false, 2, false},
+		{"fail token", "vaultkms:address=" + srv.URL + ";token-file=" + writeFile(t, "token", testToken), false, 0, true},
+		{"fail login", "vaultkms:address=" + srv.URL + ";role-id=" + testRoleID + ";secret-id=" + testSecretID, true, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fv.token, fv.logins = testToken, 0
+			k, err := New(context.Background(), apiv1.Options{URI: tt.uri})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if _, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: "my-key"}); err != nil {
+				t.Fatalf("VaultKMS.GetPublicKey() error = %v", err)
+			}
+
+			fv.expireToken()
+			if tt.revoke {
+				k.client.appRole.secretID = "revoked-secret-id"
+			}
+			if _, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: "my-key"}); (err != nil) != tt.wantErr {
+				t.Errorf("VaultKMS.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fv.logins != tt.wantLogins {
+				t.Errorf("fakeVault logins = %d, want %d", fv.logins, tt.wantLogins)
+			}
+		})
+	}
+}
+
+func TestNew_tls(t *testing.T) {
+	clearEnv(t)
+	fv := &fakeVault{token: testToken, keys: make(map[string]*fakeKey)}
+	srv := httptest.NewTLSServer(fv)
+	t.Cleanup(srv.Close)
+
+	caCert := writeFile(t, "ca.crt", string(pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: srv.Certificate().Raw,
+	})))
+	badCACert := writeFile(t, "bad.crt", "not a certificate")
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		uri     string
+		wantErr bool
+	}{
+		{"ok ca-cert", nil, ";ca-cert=" + caCert, false},
+		{"ok VAULT_CACERT", map[string]string{"VAULT_CACERT": caCert}, "", false},
+		{"ok VAULT_SKIP_VERIFY", map[string]string{"VAULT_SKIP_VERIFY": "true"}, "", false},
+		{"fail unknown authority", nil, "", true},
+		{"fail VAULT_SKIP_VERIFY false", map[string]string{"VAULT_SKIP_VERIFY": "false"}, "", true},
+		{"fail VAULT_SKIP_VERIFY", map[string]string{"VAULT_SKIP_VERIFY": "foo"}, "", true},
+		{"fail missing ca-cert", nil, ";ca-cert=" + filepath.Join(t.TempDir(), "missing"), true},
+		{"fail bad ca-cert", nil, ";ca-cert=" + badCACert, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := New(context.Background(), apiv1.Options{
+				URI: "vaultkms:address=" + srv.URL + ";role-id=" + testRoleID + ";secret-id=" + testSecretID + tt.uri,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	clearEnv(t)
+	_, srv := newFakeVault(t)
+	fn, ok := apiv1.LoadKeyManagerNewFunc(apiv1.VaultKMS)
+	if !ok {
+		t.Fatal("apiv1.LoadKeyManagerNewFunc() vaultkms is not registered")
+	}
+	k, err := fn(context.Background(), apiv1.Options{
+		URI: "vaultkms:address=" + srv.URL + ";role-id=" + testRoleID + ";secret-id=" + testSecretID,
+	})
+	if err != nil {
+		t.Fatalf("KeyManagerNewFunc() error = %v", err)
+	}
+	if _, ok := k.(*VaultKMS); !ok {
+		t.Errorf("KeyManagerNewFunc() = %T, want *VaultKMS", k)
+	}
+}
+
+func TestVaultKMS_GetPublicKey(t *testing.T) {
+	fv, k := newTestKMS(t)
+	ecKey := fv.mustCreateKey(t, "transit", "ec-key", "ecdsa-p256")
+	ecKey2 := fv.mustCreateKey(t, "transit", "ec-key", "ecdsa-p256")
+	edKey := fv.mustCreateKey(t, "other/transit", "ed-key", "ed25519")
+	rsaKey := fv.mustCreateKey(t, "transit", "rsa-key", "rsa-2048")
+
+	type args struct {
+		req *apiv1.GetPublicKeyRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    crypto.PublicKey
+		wantErr bool
+	}{
+		{"ok", args{&apiv1.GetPublicKeyRequest{Name: "vaultkms:name=ec-key"}}, ecKey2.Public(), false},
+		{"ok version", args{&apiv1.GetPublicKeyRequest{Name: "vaultkms:name=ec-key;version=1"}}, ecKey.Public(), false},
+		{"ok latest", args{&apiv1.GetPublicKeyRequest{Name: "vaultkms:name=ec-key?version=latest"}}, ecKey2.Public(), false},
+		{"ok name", args{&apiv1.GetPublicKeyRequest{Name: "rsa-key"}}, rsaKey.Public(), false},
+		{"ok mount", args{&apiv1.GetPublicKeyRequest{Name: "vaultkms:name=ed-key;mount=other/transit"}}, edKey.Public(), false},
+		{"fail name", args{&apiv1.GetPublicKeyRequest{}}, nil, true},
+		{"fail uri", args{&apiv1.GetPublicKeyRequest{Name: "vaultkms:mount=transit"}}, nil, true},
+		{"fail version", args{&apiv1.GetPublicKeyRequest{Name: "vaultkms:name=ec-key;version=3"}}, nil, true},
+		{"fail missing", args{&apiv1.GetPublicKeyRequest{Name: "vaultkms:name=missing-key"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.GetPublicKey(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VaultKMS.GetPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VaultKMS.GetPublicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaultKMS_CreateKey(t *testing.T) {
+	fv, k := newTestKMS(t)
+
+	type args struct {
+		req *apiv1.CreateKeyRequest
+	}
+	tests := []struct {
+		name      string
+		args      args
+		wantName  string
+		assertPub func(crypto.PublicKey) bool
+		wantErr   bool
+	}{
+		{"ok default", args{&apiv1.CreateKeyRequest{Name: "vaultkms:name=default-key"}}, "vaultkms:name=default-key;mount=transit;version=1", func(pub crypto.PublicKey) bool {
+			_, ok := pub.(*ecdsa.PublicKey)
+			return ok
+		}, false},
+		{"ok rsa", args{&apiv1.CreateKeyRequest{Name: "rsa-key", SignatureAlgorithm: apiv1.SHA256WithRSAPSS, Bits: 2048}}, "vaultkms:name=rsa-key;mount=transit;version=1", func(pub crypto.PublicKey) bool {
+			k, ok := pub.(*rsa.PublicKey)
+			return ok && k.N.BitLen() == 2048
+		}, false},
+		{"ok ed25519", args{&apiv1.CreateKeyRequest{Name: "vaultkms:name=ed-key;mount=other", SignatureAlgorithm: apiv1.PureEd25519}}, "vaultkms:name=ed-key;mount=other;version=1", func(pub crypto.PublicKey) bool {
+			_, ok := pub.(ed25519.PublicKey)
+			return ok
+		}, false},
+		{"fail name", args{&apiv1.CreateKeyRequest{}}, "", nil, true},
+		{"fail uri", args{&apiv1.CreateKeyRequest{Name: "vaultkms:name=foo;version=bar"}}, "", nil, true},
+		{"fail algorithm", args{&apiv1.CreateKeyRequest{Name: "foo", SignatureAlgorithm: apiv1.SignatureAlgorithm(100)}}, "", nil, true},
+		{"fail bits", args{&apiv1.CreateKeyRequest{Name: "foo", SignatureAlgorithm: apiv1.SHA256WithRSA, Bits: 1024}}, "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateKey(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VaultKMS.CreateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Name != tt.wantName {
+				t.Errorf("VaultKMS.CreateKey() name = %v, want %v", got.Name, tt.wantName)
+			}
+			if got.CreateSignerRequest.SigningKey != tt.wantName {
+				t.Errorf("VaultKMS.CreateKey() signingKey = %v, want %v", got.CreateSignerRequest.SigningKey, tt.wantName)
+			}
+			if !tt.assertPub(got.PublicKey) {
+				t.Errorf("VaultKMS.CreateKey() publicKey = %T, not expected", got.PublicKey)
+			}
+		})
+	}
+
+	// Existing keys are not modified.
+	fv.mustCreateKey(t, "transit", "existing-key", "ecdsa-p256")
+	_, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "vaultkms:name=existing-key", SignatureAlgorithm: apiv1.PureEd25519})
+	if !errors.Is(err, apiv1.ErrAlreadyExists) {
+		t.Errorf("VaultKMS.CreateKey() error = %v, want %v", err, apiv1.ErrAlreadyExists)
+	}
+
+	// The key is created with the given type.
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	if got := fv.keys["transit/rsa-key"].keyType; got != "rsa-2048" {
+		t.Errorf("key type = %s, want rsa-2048", got)
+	}
+	if got := fv.keys["transit/existing-key"]; got.keyType != "ecdsa-p256" || len(got.versions) != 1 {
+		t.Errorf("existing key = %s with %d versions, want ecdsa-p256 with 1 version", got.keyType, len(got.versions))
+	}
+}
+
+func TestVaultKMS_CreateKey_readError(t *testing.T) {
+	_, k := newTestKMS(t)
+	k.client.token = "bad-token"
+	_, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "vaultkms:name=new-key"})
+	if err == nil || errors.Is(err, apiv1.ErrAlreadyExists) {
+		t.Errorf("VaultKMS.CreateKey() error = %v, want a read error", err)
+	}
+}
+
+func TestVaultKMS_CreateSigner(t *testing.T) {
+	fv, k := newTestKMS(t)
+	ecKey := fv.mustCreateKey(t, "transit", "ec-key", "ecdsa-p256")
+	fv.mustCreateKey(t, "transit", "ec-key", "ecdsa-p256")
+
+	type args struct {
+		req *apiv1.CreateSignerRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    crypto.Signer
+		wantErr bool
+	}{
+		{"ok", args{&apiv1.CreateSignerRequest{SigningKey: "vaultkms:name=ec-key;version=1"}}, &Signer{
+			client:    k.client,
+			key:       keyName{mount: "transit", name: "ec-key", version: 1},
+			publicKey: ecKey.Public(),
+		}, false},
+		{"fail signing key", args{&apiv1.CreateSignerRequest{}}, nil, true},
+		{"fail uri", args{&apiv1.CreateSignerRequest{SigningKey: "vaultkms:name="}}, nil, true},
+		{"fail missing", args{&apiv1.CreateSignerRequest{SigningKey: "vaultkms:name=missing"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateSigner(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VaultKMS.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VaultKMS.CreateSigner() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaultKMS_CreateDecrypter(t *testing.T) {
+	fv, k := newTestKMS(t)
+	rsaKey := fv.mustCreateKey(t, "transit", "rsa-key", "rsa-2048")
+	fv.mustCreateKey(t, "transit", "ec-key", "ecdsa-p256")
+
+	type args struct {
+		req *apiv1.CreateDecrypterRequest
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    crypto.Decrypter
+		wantErr bool
+	}{
+		{"ok", args{&apiv1.CreateDecrypterRequest{DecryptionKey: "vaultkms:name=rsa-key"}}, &Decrypter{
+			Signer: &Signer{
+				client:    k.client,
+				key:       keyName{mount: "transit", name: "rsa-key", version: 1},
+				publicKey: rsaKey.Public(),
+			},
+		}, false},
+		{"fail decryption key", args{&apiv1.CreateDecrypterRequest{}}, nil, true},
+		{"fail missing", args{&apiv1.CreateDecrypterRequest{DecryptionKey: "vaultkms:name=missing"}}, nil, true},
+		{"fail not rsa", args{&apiv1.CreateDecrypterRequest{DecryptionKey: "vaultkms:name=ec-key"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := k.CreateDecrypter(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VaultKMS.CreateDecrypter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("VaultKMS.CreateDecrypter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaultKMS_Close(t *testing.T) {
+	_, k := newTestKMS(t)
+	if err := k.Close(); err != nil {
+		t.Errorf("VaultKMS.Close() error = %v", err)
+	}
+}
+
+func TestVaultKMS_ValidateName(t *testing.T) {
+	_, k := newTestKMS(t)
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{"ok", "vaultkms:name=my-key", false},
+		{"ok version", "vaultkms:name=my-key;mount=transit;version=2", false},
+		{"ok latest", "vaultkms:name=my-key?version=latest", false},
+		{"ok name", "my-key", false},
+		{"fail unknown attribute", "vaultkms:name=my-key;address=https://127.0.0.1:8200", true},
+		{"fail repeated attribute", "vaultkms:name=my-key?name=other-key", true},
+		{"fail empty", "", true},
+		{"fail path", "transit/my-key", true},
+		{"fail scheme", "vaultkms:name=my-key%", true},
+		{"fail missing name", "vaultkms:mount=transit", true},
+		{"fail version", "vaultkms:name=my-key;version=0", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := k.ValidateName(tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("VaultKMS.ValidateName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
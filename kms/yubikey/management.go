@@ -0,0 +1,100 @@
+//go:build cgo
+// +build cgo
+
+package yubikey
+
+import (
+	"github.com/go-piv/piv-go/piv"
+	"github.com/pkg/errors"
+)
+
+// The methods in this file allow to manage the PIV applet of the YubiKey, for
+// example, to provision a new device. The methods that update the credentials
+// used by the KMS block other operations while they run. Signers and decrypters
+// keep the PIN they were created with, so they must be created again after
+// changing the PIN.
+
+// Serial returns the serial number of the YubiKey.
+func (k *YubiKey) Serial() (uint32, error) {
+	serial, err := k.device().Serial()
+	if err != nil {
+		return 0, errors.Wrap(convertError(err), "error getting serial number")
+	}
+	return serial, nil
+}
+
+// Version returns the firmware version of the YubiKey as reported by the PIV
+// applet.
+func (k *YubiKey) Version() piv.Version {
+	return k.device().Version()
+}
+
+// Retries returns the number of attempts remaining to enter the correct PIN.
+func (k *YubiKey) Retries() (int, error) {
+	retries, err := k.device().Retries()
+	if err != nil {
+		return 0, errors.Wrap(convertError(err), "error getting pin retries")
+	}
+	return retries, nil
+}
+
+// SetPIN changes the PIN of the YubiKey. If the old PIN is the one configured
+// in the KMS, the KMS will use the new one.
+func (k *YubiKey) SetPIN(oldPIN, newPIN string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.yk.SetPIN(oldPIN, newPIN); err != nil {
+		return errors.Wrap(convertError(err), "error setting pin")
+	}
+	if k.pin == oldPIN {
+		k.pin = newPIN
+	}
+	return nil
+}
+
+// SetPUK changes the PUK of the YubiKey. The PUK is used to unblock the PIN.
+func (k *YubiKey) SetPUK(oldPUK, newPUK string) error {
+	if err := k.device().SetPUK(oldPUK, newPUK); err != nil {
+		return errors.Wrap(convertError(err), "error setting puk")
+	}
+	return nil
+}
+
+// Unblock sets a new PIN using the PUK. It can be used to set a new PIN after
+// the PIN has been blocked. The KMS will use the new PIN.
+func (k *YubiKey) Unblock(puk, newPIN string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.yk.Unblock(puk, newPIN); err != nil {
+		return errors.Wrap(convertError(err), "error unblocking pin")
+	}
+	k.pin = newPIN
+	return nil
+}
+
+// SetManagementKey replaces the management key of the YubiKey with the given
+// one. The KMS will use the new management key.
+func (k *YubiKey) SetManagementKey(newKey [24]byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.yk.SetManagementKey(k.managementKey, newKey); err != nil {
+		return errors.Wrap(convertError(err), "error setting management key")
+	}
+	k.managementKey = newKey
+	return nil
+}
+
+// Reset resets the PIV applet of the YubiKey to its factory settings. All the
+// keys and certificates are removed, and the PIN, PUK and management key are
+// set to their default values. The KMS will use the default PIN and management
+// key.
+func (k *YubiKey) Reset() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.yk.Reset(); err != nil {
+		return errors.Wrap(convertError(err), "error resetting yubikey")
+	}
+	k.pin = piv.DefaultPIN
+	k.managementKey = piv.DefaultManagementKey
+	return nil
+}
@@ -0,0 +1,264 @@
+//go:build cgo
+// +build cgo
+
+package yubikey
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-piv/piv-go/piv"
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+func TestYubiKey_Serial(t *testing.T) {
+	yk := newStubPivKey(t, ECDSA)
+	lost := newStubPivKey(t, ECDSA)
+	lost.lost = true
+
+	tests := []struct {
+		name    string
+		yk      pivKey
+		want    uint32
+		wantErr bool
+	}{
+		{"ok", yk, 112233, false},
+		{"fail", lost, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &YubiKey{yk: tt.yk}
+			got, err := k.Serial()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("YubiKey.Serial() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("YubiKey.Serial() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestYubiKey_Version(t *testing.T) {
+	k := &YubiKey{yk: newStubPivKey(t, ECDSA)}
+	want := piv.Version{Major: 5, Minor: 4, Patch: 3}
+	if got := k.Version(); !reflect.DeepEqual(got, want) {
+		t.Errorf("YubiKey.Version() = %v, want %v", got, want)
+	}
+}
+
+func TestYubiKey_Retries(t *testing.T) {
+	yk := newStubPivKey(t, ECDSA)
+	blocked := newStubPivKey(t, ECDSA)
+	blocked.retries = 0
+	lost := newStubPivKey(t, ECDSA)
+	lost.lost = true
+
+	tests := []struct {
+		name    string
+		yk      pivKey
+		want    int
+		wantErr bool
+	}{
+		{"ok", yk, 3, false},
+		{"ok blocked", blocked, 0, false},
+		{"fail", lost, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &YubiKey{yk: tt.yk}
+			got, err := k.Retries()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("YubiKey.Retries() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("YubiKey.Retries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestYubiKey_SetPIN(t *testing.T) {
+	blocked := newStubPivKey(t, ECDSA)
+	blocked.retries = 0
+
+	type args struct {
+		oldPIN string
+		newPIN string
+	}
+	tests := []struct {
+		name        string
+		yk          *stubPivKey
+		pin         string
+		args        args
+		wantPIN     string
+		wantErrType error
+	}{
+		{"ok", newStubPivKey(t, ECDSA), "123456", args{"123456", "654321"}, "654321", nil},
+		{"ok other pin", newStubPivKey(t, ECDSA), "", args{"123456", "654321"}, "", nil},
+		{"fail pin", newStubPivKey(t, ECDSA), "123456", args{"000000", "654321"}, "123456", apiv1.ErrPermissionDenied},
+		{"fail blocked", blocked, "123456", args{"123456", "654321"}, "123456", apiv1.ErrPINLocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &YubiKey{yk: tt.yk, pin: tt.pin}
+			err := k.SetPIN(tt.args.oldPIN, tt.args.newPIN)
+			if tt.wantErrType != nil {
+				if !errors.Is(err, tt.wantErrType) {
+					t.Errorf("YubiKey.SetPIN() error = %v, want %T", err, tt.wantErrType)
+				}
+			} else if err != nil {
+				t.Errorf("YubiKey.SetPIN() error = %v", err)
+			} else if tt.yk.pin != tt.args.newPIN {
+				t.Errorf("YubiKey.SetPIN() device pin = %s, want %s", tt.yk.pin, tt.args.newPIN)
+			}
+			if k.pin != tt.wantPIN {
+				t.Errorf("YubiKey.SetPIN() pin = %s, want %s", k.pin, tt.wantPIN)
+			}
+		})
+	}
+}
+
+func TestYubiKey_SetPUK(t *testing.T) {
+	type args struct {
+		oldPUK string
+		newPUK string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantPUK string
+		wantErr bool
+	}{
+		{"ok", args{"12345678", "87654321"}, "87654321", false},
+		{"fail", args{"00000000", "87654321"}, "12345678", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yk := newStubPivKey(t, ECDSA)
+			k := &YubiKey{yk: yk}
+			if err := k.SetPUK(tt.args.oldPUK, tt.args.newPUK); (err != nil) != tt.wantErr {
+				t.Errorf("YubiKey.SetPUK() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if yk.puk != tt.wantPUK {
+				t.Errorf("YubiKey.SetPUK() device puk = %s, want %s", yk.puk, tt.wantPUK)
+			}
+		})
+	}
+}
+
+func TestYubiKey_Unblock(t *testing.T) {
+	type args struct {
+		puk    string
+		newPIN string
+	}
+	tests := []struct {
+		name    string
+		pin     string
+		args    args
+		wantPIN string
+		wantErr bool
+	}{
+		{"ok", "123456", args{"12345678", "654321"}, "654321", false},
+		{"ok other pin", "111111", args{"12345678", "654321"}, "654321", false},
+		{"fail puk", "123456", args{"00000000", "654321"}, "123456", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yk := newStubPivKey(t, ECDSA)
+			yk.retries = 0
+			k := &YubiKey{yk: yk, pin: tt.pin}
+			if err := k.Unblock(tt.args.puk, tt.args.newPIN); (err != nil) != tt.wantErr {
+				t.Errorf("YubiKey.Unblock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if k.pin != tt.wantPIN {
+				t.Errorf("YubiKey.Unblock() pin = %s, want %s", k.pin, tt.wantPIN)
+			}
+			if !tt.wantErr && (yk.pin != tt.args.newPIN || yk.retries != 3) {
+				t.Errorf("YubiKey.Unblock() device pin = %s, retries = %d, want %s and 3", yk.pin, yk.retries, tt.args.newPIN)
+			}
+		})
+	}
+}
+
+func TestYubiKey_SetManagementKey(t *testing.T) {
+	newKey := [24]byte{
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	}
+	tests := []struct {
+		name          string
+		managementKey [24]byte
+		want          [24]byte
+		wantErr       bool
+	}{
+		{"ok", piv.DefaultManagementKey, newKey, false},
+		{"fail", [24]byte{}, [24]byte{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yk := newStubPivKey(t, ECDSA)
+			k := &YubiKey{yk: yk, pin: "123456", managementKey: tt.managementKey}
+			if err := k.SetManagementKey(newKey); (err != nil) != tt.wantErr {
+				t.Errorf("YubiKey.SetManagementKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if k.managementKey != tt.want {
+				t.Errorf("YubiKey.SetManagementKey() managementKey = %x, want %x", k.managementKey, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			// The new management key is used to create keys.
+			if _, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9a"}); err != nil {
+				t.Errorf("YubiKey.CreateKey() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestYubiKey_Reset(t *testing.T) {
+	lost := newStubPivKey(t, ECDSA)
+	lost.lost = true
+
+	tests := []struct {
+		name    string
+		yk      *stubPivKey
+		wantErr bool
+	}{
+		{"ok", newStubPivKey(t, ECDSA), false},
+		{"fail", lost, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.yk.pin = "654321"
+			tt.yk.managementKey = [24]byte{1, 2, 3}
+			k := &YubiKey{yk: tt.yk, pin: "654321", managementKey: [24]byte{1, 2, 3}}
+			if err := k.Reset(); (err != nil) != tt.wantErr {
+				t.Errorf("YubiKey.Reset() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if k.pin != piv.DefaultPIN || k.managementKey != piv.DefaultManagementKey {
+				t.Errorf("YubiKey.Reset() pin = %s, managementKey = %x, want defaults", k.pin, k.managementKey)
+			}
+			if _, err := k.GetPublicKey(&apiv1.GetPublicKeyRequest{Name: "yubikey:slot-id=9c"}); err == nil {
+				t.Error("YubiKey.GetPublicKey() error = nil, want key not found")
+			}
+			// The default credentials can be used after a reset.
+			resp, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c"})
+			if err != nil {
+				t.Fatalf("YubiKey.CreateKey() error = %v", err)
+			}
+			if _, err := tt.yk.PrivateKey(piv.SlotSignature, resp.PublicKey, piv.KeyAuth{PIN: k.pin}); err != nil {
+				t.Errorf("pivKey.PrivateKey() error = %v", err)
+			}
+		})
+	}
+}
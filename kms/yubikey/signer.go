@@ -0,0 +1,166 @@
+//go:build cgo
+// +build cgo
+
+package yubikey
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"io"
+	"sync"
+
+	"github.com/go-piv/piv-go/piv"
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+// signer implements a crypto.Signer using a key in a YubiKey slot. If an
+// operation fails and the YubiKey does not respond, the signer reconnects to
+// the YubiKey, gets the private key again using the configured pin and retries
+// the operation once.
+type signer struct {
+	kms       *YubiKey
+	slot      piv.Slot
+	pin       string
+	publicKey crypto.PublicKey
+	mu        sync.Mutex
+	yk        pivKey
+	priv      crypto.PrivateKey
+}
+
+func (k *YubiKey) newSigner(slot piv.Slot, pin string) (*signer, error) {
+	yk := k.device()
+	pub, err := k.getPublicKey(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := yk.PrivateKey(slot, pub, piv.KeyAuth{
+		PIN:       pin,
+		PINPolicy: piv.PINPolicyAlways,
+	})
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "error retrieving private key")
+	}
+
+	return &signer{
+		kms:       k,
+		slot:      slot,
+		pin:       pin,
+		publicKey: pub,
+		yk:        yk,
+		priv:      priv,
+	}, nil
+}
+
+// Public returns the public key of the signer.
+func (s *signer) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs the digest using the key in the YubiKey.
+func (s *signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var signature []byte
+	err := s.do(func(priv crypto.PrivateKey) (err error) {
+		key, ok := priv.(crypto.Signer)
+		if !ok {
+			return errors.New("private key is not a crypto.Signer")
+		}
+		signature, err = key.Sign(rand, digest, opts)
+		return
+	})
+	return signature, err
+}
+
+// do runs the given function with the current private key, if it fails and
+// the connection with the YubiKey has been lost, it reconnects and runs the
+// function again. Errors are converted to the types defined in apiv1.
+func (s *signer) do(fn func(priv crypto.PrivateKey) error) error {
+	yk, priv, err := s.current()
+	if err != nil {
+		return err
+	}
+
+	err = fn(priv)
+	if err == nil || !isSessionLost(yk, err) {
+		return convertError(err)
+	}
+
+	s.kms.hooks.NotifySessionLost(apiv1.YubiKey, err)
+	if _, err := s.kms.reconnect(yk); err != nil {
+		return err
+	}
+	if _, priv, err = s.current(); err != nil {
+		return err
+	}
+	return convertError(fn(priv))
+}
+
+// current returns the current connection to the YubiKey and the private key
+// in it. If the connection has been replaced, the private key is retrieved
+// again, after checking that the slot still has the same public key.
+func (s *signer) current() (pivKey, crypto.PrivateKey, error) {
+	yk := s.kms.device()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.yk != yk {
+		pub, err := getPublicKey(yk, s.slot)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p, ok := pub.(interface{ Equal(crypto.PublicKey) bool }); !ok || !p.Equal(s.publicKey) {
+			return nil, nil, errors.New("error retrieving private key: the key in the yubikey slot has changed")
+		}
+		priv, err := yk.PrivateKey(s.slot, s.publicKey, piv.KeyAuth{
+			PIN:       s.pin,
+			PINPolicy: piv.PINPolicyAlways,
+		})
+		if err != nil {
+			return nil, nil, errors.Wrap(convertError(err), "error retrieving private key")
+		}
+		s.yk, s.priv = yk, priv
+	}
+	return s.yk, s.priv, nil
+}
+
+// decrypter implements a crypto.Decrypter using an RSA key in a YubiKey slot.
+// Like signer, it reconnects to the YubiKey if the connection is lost.
+type decrypter struct {
+	*signer
+}
+
+// newDecrypter returns a decrypter with the given signer if the key implements
+// the crypto.Decrypter interface, only RSA keys will implement it.
+func newDecrypter(s *signer) (*decrypter, bool) {
+	if _, ok := s.Public().(*rsa.PublicKey); ok {
+		if _, ok := s.priv.(crypto.Decrypter); ok {
+			return &decrypter{signer: s}, true
+		}
+	}
+	return nil, false
+}
+
+// Decrypt decrypts the ciphertext using the key in the YubiKey.
+func (d *decrypter) Decrypt(rand io.Reader, ciphertext []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	var plaintext []byte
+	err := d.do(func(priv crypto.PrivateKey) (err error) {
+		key, ok := priv.(crypto.Decrypter)
+		if !ok {
+			return errors.New("private key is not a crypto.Decrypter")
+		}
+		plaintext, err = key.Decrypt(rand, ciphertext, opts)
+		return
+	})
+	return plaintext, err
+}
+
+// isSessionLost returns true if the error is not an authentication error and
+// the YubiKey does not respond.
+func isSessionLost(yk pivKey, err error) bool {
+	var authErr piv.AuthErr
+	if errors.As(err, &authErr) {
+		return false
+	}
+	_, err = yk.Serial()
+	return err != nil
+}
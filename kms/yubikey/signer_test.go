@@ -0,0 +1,230 @@
+//go:build cgo
+// +build cgo
+
+package yubikey
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"testing"
+
+	"github.com/go-piv/piv-go/piv"
+	"github.com/pkg/errors"
+	"go.step.sm/crypto/kms/apiv1"
+)
+
+type hookCalls struct {
+	sessionLost int
+	reconnect   int
+}
+
+func newHooks(t *testing.T, calls *hookCalls) *apiv1.ConnectionHooks {
+	return &apiv1.ConnectionHooks{
+		SessionLost: func(typ apiv1.Type, err error) {
+			if typ != apiv1.YubiKey || err == nil {
+				t.Errorf("ConnectionHooks.SessionLost() = (%v, %v), want (%v, error)", typ, err, apiv1.YubiKey)
+			}
+			calls.sessionLost++
+		},
+		Reconnect: func(typ apiv1.Type, err error) {
+			if typ != apiv1.YubiKey {
+				t.Errorf("ConnectionHooks.Reconnect() type = %v, want %v", typ, apiv1.YubiKey)
+			}
+			calls.reconnect++
+		},
+	}
+}
+
+// stubKey is a private key that fails with the given error.
+type stubKey struct {
+	*rsa.PrivateKey
+	err error
+}
+
+func (k *stubKey) Sign(rnd io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if k.err != nil {
+		return nil, k.err
+	}
+	return k.PrivateKey.Sign(rnd, digest, opts)
+}
+
+func (k *stubKey) Decrypt(rnd io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if k.err != nil {
+		return nil, k.err
+	}
+	return k.PrivateKey.Decrypt(rnd, msg, opts)
+}
+
+type reconnectTest struct {
+	name    string
+	err     error
+	lost    bool
+	closed  bool
+	open    func(t *testing.T, key *rsa.PrivateKey) (pivKey, error)
+	want    hookCalls
+	wantErr bool
+}
+
+// newReconnectTests returns the test cases used in the signer and decrypter
+// tests.
+func newReconnectTests() []reconnectTest {
+	errRemoved := errors.New("the smart card has been removed")
+	// okPivOpen returns a new connection to the YubiKey with the same key in
+	// the signature slot.
+	okPivOpen := func(t *testing.T, key *rsa.PrivateKey) (pivKey, error) {
+		yk := newStubPivKey(t, RSA)
+		cert, err := yk.userCA.Sign(&x509.Certificate{
+			Subject:   pkix.Name{CommonName: "test.example.org"},
+			PublicKey: key.Public(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		yk.signerMap[piv.SlotSignature] = key
+		yk.certMap[piv.SlotSignature] = cert
+		return yk, nil
+	}
+	// otherPivOpen returns a connection to a different YubiKey.
+	otherPivOpen := func(t *testing.T, key *rsa.PrivateKey) (pivKey, error) {
+		yk, err := okPivOpen(t, key)
+		yk.(*stubPivKey).serial = 445566
+		return yk, err
+	}
+	// changedPivOpen returns a new connection to the YubiKey with a different
+	// key in the signature slot.
+	changedPivOpen := func(t *testing.T, key *rsa.PrivateKey) (pivKey, error) {
+		return newStubPivKey(t, RSA), nil
+	}
+	failPivOpen := func(t *testing.T, key *rsa.PrivateKey) (pivKey, error) {
+		return nil, errors.New("error opening card")
+	}
+	failPrivateKey := func(t *testing.T, key *rsa.PrivateKey) (pivKey, error) {
+		yk, err := okPivOpen(t, key)
+		delete(yk.(*stubPivKey).signerMap, piv.SlotSignature)
+		return yk, err
+	}
+
+	return []reconnectTest{
+		{"ok", nil, false, false, failPivOpen, hookCalls{}, false},
+		{"ok reconnect", errRemoved, true, false, okPivOpen, hookCalls{1, 1}, false},
+		{"fail error", errors.New("an error"), false, false, okPivOpen, hookCalls{}, true},
+		{"fail auth error", piv.AuthErr{Retries: 2}, true, false, okPivOpen, hookCalls{}, true},
+		{"fail reconnect", errRemoved, true, false, failPivOpen, hookCalls{1, 1}, true},
+		{"fail private key", errRemoved, true, false, failPrivateKey, hookCalls{1, 1}, true},
+		{"fail other yubikey", errRemoved, true, false, otherPivOpen, hookCalls{1, 1}, true},
+		{"fail key changed", errRemoved, true, false, changedPivOpen, hookCalls{1, 1}, true},
+		{"fail closed", errRemoved, true, true, okPivOpen, hookCalls{1, 0}, true},
+	}
+}
+
+// mustReconnectingYubiKey returns a YubiKey with an RSA key in the signature
+// slot that fails with the test error, and the calls to the connection hooks.
+func mustReconnectingYubiKey(t *testing.T, tt reconnectTest) (*YubiKey, *hookCalls) {
+	t.Helper()
+	pOpen := pivOpen
+	pCards := pivCards
+	t.Cleanup(func() {
+		pivOpen = pOpen
+		pivCards = pCards
+	})
+
+	yk := newStubPivKey(t, RSA)
+	yk.lost = tt.lost
+	key := yk.signerMap[piv.SlotSignature].(*rsa.PrivateKey)
+	yk.signerMap[piv.SlotSignature] = &stubKey{
+		PrivateKey: key,
+		err:        tt.err,
+	}
+
+	pivCards = func() ([]string, error) {
+		return []string{"Yubico YubiKey OTP+FIDO+CCID"}, nil
+	}
+	pivOpen = func(card string) (pivKey, error) {
+		return tt.open(t, key)
+	}
+
+	calls := new(hookCalls)
+	return &YubiKey{
+		yk:            yk,
+		pin:           "123456",
+		managementKey: piv.DefaultManagementKey,
+		serial:        112233,
+		hooks:         newHooks(t, calls),
+	}, calls
+}
+
+func TestSigner_Sign(t *testing.T) {
+	sum := sha256.Sum256([]byte("the message"))
+	for _, tt := range newReconnectTests() {
+		t.Run(tt.name, func(t *testing.T) {
+			k, calls := mustReconnectingYubiKey(t, tt)
+			signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{
+				SigningKey: "yubikey:slot-id=9c",
+			})
+			if err != nil {
+				t.Fatalf("YubiKey.CreateSigner() error = %v", err)
+			}
+			if tt.closed {
+				if err := k.Close(); err != nil {
+					t.Fatalf("YubiKey.Close() error = %v", err)
+				}
+			}
+
+			got, err := signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("signer.Sign() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if *calls != tt.want {
+				t.Errorf("ConnectionHooks calls = %+v, want %+v", *calls, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			if err := rsa.VerifyPKCS1v15(signer.Public().(*rsa.PublicKey), crypto.SHA256, sum[:], got); err != nil {
+				t.Errorf("rsa.VerifyPKCS1v15() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestDecrypter_Decrypt(t *testing.T) {
+	for _, tt := range newReconnectTests() {
+		t.Run(tt.name, func(t *testing.T) {
+			k, calls := mustReconnectingYubiKey(t, tt)
+			dec, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{
+				DecryptionKey: "yubikey:slot-id=9c",
+			})
+			if err != nil {
+				t.Fatalf("YubiKey.CreateDecrypter() error = %v", err)
+			}
+			if tt.closed {
+				if err := k.Close(); err != nil {
+					t.Fatalf("YubiKey.Close() error = %v", err)
+				}
+			}
+
+			ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, dec.Public().(*rsa.PublicKey), []byte("plaintext"))
+			if err != nil {
+				t.Fatalf("rsa.EncryptPKCS1v15() error = %v", err)
+			}
+			got, err := dec.Decrypt(rand.Reader, ciphertext, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("decrypter.Decrypt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if *calls != tt.want {
+				t.Errorf("ConnectionHooks calls = %+v, want %+v", *calls, tt.want)
+			}
+			if !tt.wantErr && !bytes.Equal(got, []byte("plaintext")) {
+				t.Errorf("decrypter.Decrypt() = %s, want plaintext", got)
+			}
+		})
+	}
+}
@@ -9,22 +9,38 @@ import (
 	"crypto/x509"
 	"encoding/hex"
 	"net/url"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/go-piv/piv-go/piv"
 	"github.com/pkg/errors"
 	"go.step.sm/crypto/kms/apiv1"
 	"go.step.sm/crypto/kms/uri"
+	"go.step.sm/crypto/pemutil"
 )
 
 // Scheme is the scheme used in uris.
 const Scheme = "yubikey"
 
+// keySchema defines the attributes allowed in the key URIs, like
+// yubikey:slot-id=9a?pin-value=123456.
+var keySchema = &uri.Schema{
+	Scheme: Scheme,
+	Path:   []string{"slot-id"},
+	Query:  []string{"pin-value", "pin-source"},
+}
+
 // YubiKey implements the KMS interface on a YubiKey.
 type YubiKey struct {
 	yk            pivKey
 	pin           string
 	managementKey [24]byte
+	mu            sync.RWMutex
+	card          string
+	serial        uint32
+	closed        bool
+	hooks         *apiv1.ConnectionHooks
 }
 
 type pivKey interface {
@@ -33,6 +49,15 @@ type pivKey interface {
 	GenerateKey(key [24]byte, slot piv.Slot, opts piv.Key) (crypto.PublicKey, error)
 	PrivateKey(slot piv.Slot, public crypto.PublicKey, auth piv.KeyAuth) (crypto.PrivateKey, error)
 	Attest(slot piv.Slot) (*x509.Certificate, error)
+	Serial() (uint32, error)
+	Version() piv.Version
+	Retries() (int, error)
+	SetPIN(oldPIN, newPIN string) error
+	SetPUK(oldPUK, newPUK string) error
+	Unblock(puk, newPIN string) error
+	SetManagementKey(oldKey, newKey [24]byte) error
+	SetPrivateKeyInsecure(key [24]byte, slot piv.Slot, private crypto.PrivateKey, policy piv.Key) error
+	Reset() error
 	Close() error
 }
 
@@ -53,7 +78,11 @@ func New(ctx context.Context, opts apiv1.Options) (*YubiKey, error) {
 		if err != nil {
 			return nil, err
 		}
-		if v := u.Pin(); v != "" {
+		v, err := u.ReadPin()
+		if err != nil {
+			return nil, errors.Wrap(err, "error reading kms uri 'pin-source'")
+		}
+		if v != "" {
 			opts.Pin = v
 		}
 		if v := u.Get("management-key"); v != "" {
@@ -77,27 +106,78 @@ func New(ctx context.Context, opts apiv1.Options) (*YubiKey, error) {
 		pin = opts.Pin
 	}
 
-	cards, err := pivCards()
+	yk, card, err := openCard("", 0)
 	if err != nil {
 		return nil, err
 	}
-	if len(cards) == 0 {
-		return nil, errors.New("error detecting yubikey: try removing and reconnecting the device")
-	}
 
-	yk, err := pivOpen(cards[0])
+	// The serial number identifies the YubiKey on reconnections. Old devices
+	// might not report it, in that case the card name is used.
+	serial, err := yk.Serial()
 	if err != nil {
-		return nil, errors.Wrap(err, "error opening yubikey")
+		serial = 0
 	}
 
 	return &YubiKey{
 		yk:            yk,
 		pin:           pin,
 		managementKey: managementKey,
+		card:          card,
+		serial:        serial,
+		hooks:         opts.Hooks,
 	}, nil
 }
 
+// openCard opens the YubiKey with the given serial number, or the one with the
+// given card name if the serial number is not known. If both are empty it
+// opens the first YubiKey available.
+func openCard(name string, serial uint32) (pivKey, string, error) {
+	cards, err := pivCards()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(cards) == 0 {
+		return nil, "", errors.New("error detecting yubikey: try removing and reconnecting the device")
+	}
+	if name == "" && serial == 0 {
+		yk, err := pivOpen(cards[0])
+		if err != nil {
+			return nil, "", errors.Wrap(convertError(err), "error opening yubikey")
+		}
+		return yk, cards[0], nil
+	}
+
+	var lastErr error
+	for _, card := range cards {
+		if serial == 0 && card != name {
+			continue
+		}
+		yk, err := pivOpen(card)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if serial == 0 {
+			return yk, card, nil
+		}
+		if s, err := yk.Serial(); err == nil && s == serial {
+			return yk, card, nil
+		}
+		_ = yk.Close()
+	}
+
+	switch {
+	case lastErr != nil:
+		return nil, "", errors.Wrap(convertError(lastErr), "error opening yubikey")
+	case serial != 0:
+		return nil, "", errors.Errorf("error opening yubikey: yubikey with serial number %d is not present", serial)
+	default:
+		return nil, "", errors.Errorf("error opening yubikey: %s is not present", name)
+	}
+}
+
 func init() {
+	uri.RegisterSchema(keySchema)
 	apiv1.Register(apiv1.YubiKey, func(ctx context.Context, opts apiv1.Options) (apiv1.KeyManager, error) {
 		return New(ctx, opts)
 	})
@@ -111,9 +191,9 @@ func (k *YubiKey) LoadCertificate(req *apiv1.LoadCertificateRequest) (*x509.Cert
 		return nil, err
 	}
 
-	cert, err := k.yk.Certificate(slot)
+	cert, err := k.device().Certificate(slot)
 	if err != nil {
-		return nil, errors.Wrap(err, "error retrieving certificate")
+		return nil, errors.Wrap(convertError(err), "error retrieving certificate")
 	}
 
 	return cert, nil
@@ -131,9 +211,9 @@ func (k *YubiKey) StoreCertificate(req *apiv1.StoreCertificateRequest) error {
 		return err
 	}
 
-	err = k.yk.SetCertificate(k.managementKey, slot, req.Certificate)
+	err = k.device().SetCertificate(k.getManagementKey(), slot, req.Certificate)
 	if err != nil {
-		return errors.Wrap(err, "error storing certificate")
+		return errors.Wrap(convertError(err), "error storing certificate")
 	}
 
 	return nil
@@ -154,7 +234,9 @@ func (k *YubiKey) GetPublicKey(req *apiv1.GetPublicKeyRequest) (crypto.PublicKey
 	return pub, nil
 }
 
-// CreateKey generates a new key in the YubiKey and returns the public key.
+// CreateKey generates a new key in the YubiKey and returns the public key. If
+// the slot was reset with DeleteKey, the attestation certificate of the new key
+// is stored in the slot.
 func (k *YubiKey) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyResponse, error) {
 	alg, err := getSignatureAlgorithm(req.SignatureAlgorithm, req.Bits)
 	if err != nil {
@@ -164,16 +246,95 @@ func (k *YubiKey) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespon
 	if err != nil {
 		return nil, err
 	}
-	pinPolicy, touchPolicy := getPolicies(req)
+	pinPolicy, touchPolicy := getPolicies(req.PINPolicy, req.TouchPolicy)
 
-	pub, err := k.yk.GenerateKey(k.managementKey, slot, piv.Key{
+	yk, managementKey := k.device(), k.getManagementKey()
+	deleted, err := isDeletedSlot(yk, slot)
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "error reading certificate")
+	}
+
+	pub, err := yk.GenerateKey(managementKey, slot, piv.Key{
 		Algorithm:   alg,
 		PINPolicy:   pinPolicy,
 		TouchPolicy: touchPolicy,
 	})
 	if err != nil {
-		return nil, errors.Wrap(err, "error generating key")
+		return nil, errors.Wrap(convertError(err), "error generating key")
 	}
+
+	// The empty certificate object written by DeleteKey cannot be removed, so
+	// it is replaced with the attestation certificate of the new key.
+	if deleted {
+		cert, err := yk.Attest(slot)
+		if err != nil {
+			return nil, errors.Wrap(convertError(err), "error attesting key")
+		}
+		if err := yk.SetCertificate(managementKey, slot, cert); err != nil {
+			return nil, errors.Wrap(convertError(err), "error storing certificate")
+		}
+	}
+
+	return &apiv1.CreateKeyResponse{
+		Name:      name,
+		PublicKey: pub,
+		CreateSignerRequest: apiv1.CreateSignerRequest{
+			SigningKey: name,
+		},
+	}, nil
+}
+
+// ImportKey imports an existing private key in the slot in the request name,
+// and stores its certificate in the same slot. It can be used to restore
+// escrowed keys, for example, encryption keys in the slot 9d or in the retired
+// slots. Only RSA 1024, RSA 2048, P-256 and P-384 keys can be imported.
+//
+// The public key of an imported key cannot be attested, and CreateSigner and
+// CreateDecrypter read it from the certificate in the slot, so the certificate
+// is required.
+func (k *YubiKey) ImportKey(req *apiv1.ImportKeyRequest) (*apiv1.CreateKeyResponse, error) {
+	slot, name, err := getSlotAndName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	priv := req.PrivateKey
+	if priv == nil {
+		if len(req.PrivateKeyPEM) == 0 {
+			return nil, errors.New("importKeyRequest 'privateKey' or 'privateKeyPEM' are required")
+		}
+		var opts []pemutil.Options
+		if req.Password != nil {
+			opts = append(opts, pemutil.WithPassword(req.Password))
+		}
+		if priv, err = pemutil.ParseKey(req.PrivateKeyPEM, opts...); err != nil {
+			return nil, errors.Wrap(err, "error parsing private key")
+		}
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unsupported private key type %T", priv)
+	}
+	pub := signer.Public()
+	if req.Certificate == nil {
+		return nil, errors.New("importKeyRequest 'certificate' cannot be empty")
+	}
+	if p, ok := req.Certificate.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !p.Equal(pub) {
+		return nil, errors.New("importKeyRequest 'certificate' does not match the private key")
+	}
+
+	pinPolicy, touchPolicy := getPolicies(req.PINPolicy, req.TouchPolicy)
+	yk, managementKey := k.device(), k.getManagementKey()
+	if err := yk.SetPrivateKeyInsecure(managementKey, slot, priv, piv.Key{
+		PINPolicy:   pinPolicy,
+		TouchPolicy: touchPolicy,
+	}); err != nil {
+		return nil, errors.Wrap(convertError(err), "error importing key")
+	}
+	if err := yk.SetCertificate(managementKey, slot, req.Certificate); err != nil {
+		return nil, errors.Wrap(convertError(err), "error storing certificate")
+	}
+
 	return &apiv1.CreateKeyResponse{
 		Name:      name,
 		PublicKey: pub,
@@ -184,14 +345,14 @@ func (k *YubiKey) CreateKey(req *apiv1.CreateKeyRequest) (*apiv1.CreateKeyRespon
 }
 
 // CreateSigner creates a signer using the key present in the YubiKey signature
-// slot.
+// slot. Signers for RSA keys also implement the crypto.Decrypter interface.
 func (k *YubiKey) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, error) {
 	slot, err := getSlot(req.SigningKey)
 	if err != nil {
 		return nil, err
 	}
 
-	pin := k.pin
+	pin := k.getPIN()
 	if pin == "" {
 		// Attempt to get the pin from the uri
 		if u, err := uri.ParseWithScheme(Scheme, req.SigningKey); err == nil {
@@ -199,24 +360,18 @@ func (k *YubiKey) CreateSigner(req *apiv1.CreateSignerRequest) (crypto.Signer, e
 		}
 	}
 
-	pub, err := k.getPublicKey(slot)
+	s, err := k.newSigner(slot, pin)
 	if err != nil {
 		return nil, err
 	}
-
-	priv, err := k.yk.PrivateKey(slot, pub, piv.KeyAuth{
-		PIN:       pin,
-		PINPolicy: piv.PINPolicyAlways,
-	})
-	if err != nil {
-		return nil, errors.Wrap(err, "error retrieving private key")
-	}
-
-	signer, ok := priv.(crypto.Signer)
-	if !ok {
+	if _, ok := s.priv.(crypto.Signer); !ok {
 		return nil, errors.New("private key is not a crypto.Signer")
 	}
-	return signer, nil
+	// RSA keys can also be used to decrypt.
+	if d, ok := newDecrypter(s); ok {
+		return d, nil
+	}
+	return s, nil
 }
 
 // CreateDecrypter creates a crypto.Decrypter using the key present in the configured
@@ -227,7 +382,7 @@ func (k *YubiKey) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Dec
 		return nil, err
 	}
 
-	pin := k.pin
+	pin := k.getPIN()
 	if pin == "" {
 		// Attempt to get the pin from the uri
 		if u, err := uri.ParseWithScheme(Scheme, req.DecryptionKey); err == nil {
@@ -235,24 +390,15 @@ func (k *YubiKey) CreateDecrypter(req *apiv1.CreateDecrypterRequest) (crypto.Dec
 		}
 	}
 
-	pub, err := k.getPublicKey(slot)
+	s, err := k.newSigner(slot, pin)
 	if err != nil {
 		return nil, err
 	}
-
-	priv, err := k.yk.PrivateKey(slot, pub, piv.KeyAuth{
-		PIN:       pin,
-		PINPolicy: piv.PINPolicyAlways,
-	})
-	if err != nil {
-		return nil, errors.Wrap(err, "error retrieving private key")
-	}
-
-	decrypter, ok := priv.(crypto.Decrypter)
+	d, ok := newDecrypter(s)
 	if !ok {
 		return nil, errors.New("private key is not a crypto.Decrypter")
 	}
-	return decrypter, nil
+	return d, nil
 }
 
 // CreateAttestation creates an attestation certificate from a YubiKey slot.
@@ -267,14 +413,15 @@ func (k *YubiKey) CreateAttestation(req *apiv1.CreateAttestationRequest) (*apiv1
 		return nil, err
 	}
 
-	cert, err := k.yk.Attest(slot)
+	yk := k.device()
+	cert, err := yk.Attest(slot)
 	if err != nil {
-		return nil, errors.Wrap(err, "error attesting slot")
+		return nil, errors.Wrap(convertError(err), "error attesting slot")
 	}
 
-	intermediate, err := k.yk.Certificate(slotAttestation)
+	intermediate, err := yk.Certificate(slotAttestation)
 	if err != nil {
-		return nil, errors.Wrap(err, "error retrieving attestation certificate")
+		return nil, errors.Wrap(convertError(err), "error retrieving attestation certificate")
 	}
 
 	return &apiv1.CreateAttestationResponse{
@@ -284,25 +431,222 @@ func (k *YubiKey) CreateAttestation(req *apiv1.CreateAttestationRequest) (*apiv1
 	}, nil
 }
 
+// DeleteKey resets the slot in the request name. A YubiKey cannot delete a
+// private key, so the key in the slot is replaced with a new random one, making
+// the previous one unrecoverable, and the certificate in the slot is replaced
+// with an empty certificate object.
+//
+// The empty certificate object marks the slot as deleted, and ListKeys will
+// skip it until a new key is created or a new certificate is stored in it.
+func (k *YubiKey) DeleteKey(req *apiv1.DeleteKeyRequest) error {
+	if req.Name == "" {
+		return errors.New("deleteKeyRequest 'name' cannot be empty")
+	}
+
+	slot, err := getSlot(req.Name)
+	if err != nil {
+		return err
+	}
+
+	yk, managementKey := k.device(), k.getManagementKey()
+	if _, err := yk.GenerateKey(managementKey, slot, piv.Key{
+		Algorithm:   piv.AlgorithmEC256,
+		PINPolicy:   piv.PINPolicyAlways,
+		TouchPolicy: piv.TouchPolicyNever,
+	}); err != nil {
+		return errors.Wrap(convertError(err), "error resetting key")
+	}
+
+	// A YubiKey cannot remove a certificate object, an empty one is written
+	// instead.
+	if err := yk.SetCertificate(managementKey, slot, &x509.Certificate{}); err != nil {
+		return errors.Wrap(convertError(err), "error resetting certificate")
+	}
+
+	return nil
+}
+
+// ListKeys returns the slots in the YubiKey that have a key. A slot has a key if
+// it can be attested or if it has a certificate, and it has not been reset with
+// DeleteKey. The name filter is matched against the slot id.
+func (k *YubiKey) ListKeys(req *apiv1.ListKeysRequest) (*apiv1.ListKeysResponse, error) {
+	slotIDs := make([]string, 0, len(slotMapping))
+	for id := range slotMapping {
+		slotIDs = append(slotIDs, id)
+	}
+	sort.Strings(slotIDs)
+
+	yk := k.device()
+	var keys []*apiv1.KeyInfo
+	for _, id := range slotIDs {
+		if !req.MatchKey(id, nil) {
+			continue
+		}
+		slot := slotMapping[id]
+		deleted, err := isDeletedSlot(yk, slot)
+		if err != nil {
+			return nil, errors.Wrapf(convertError(err), "error reading certificate in slot %s", id)
+		}
+		if deleted {
+			continue
+		}
+		pub, err := getPublicKey(yk, slot)
+		switch {
+		case errors.Is(err, piv.ErrNotFound):
+			continue
+		case err != nil:
+			return nil, errors.Wrapf(err, "error reading slot %s", id)
+		}
+		keys = append(keys, &apiv1.KeyInfo{
+			Name:               keySchema.String(url.Values{"slot-id": []string{id}}),
+			SignatureAlgorithm: apiv1.SignatureAlgorithmForKey(pub),
+			ProtectionLevel:    apiv1.HSM,
+		})
+	}
+
+	return req.Paginate(keys)
+}
+
+// HealthCheck checks the connection with the YubiKey. If the connection has
+// been lost, it opens the YubiKey again.
+func (k *YubiKey) HealthCheck() error {
+	yk := k.device()
+	_, err := yk.Serial()
+	if err == nil {
+		return nil
+	}
+
+	k.hooks.NotifySessionLost(apiv1.YubiKey, err)
+	if yk, err = k.reconnect(yk); err != nil {
+		return errors.Wrap(convertError(err), "healthCheck failed")
+	}
+	if _, err := yk.Serial(); err != nil {
+		return errors.Wrap(convertError(err), "healthCheck failed")
+	}
+	return nil
+}
+
 // Close releases the connection to the YubiKey.
 func (k *YubiKey) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.closed = true
 	return errors.Wrap(k.yk.Close(), "error closing yubikey")
 }
 
+// ValidateName validates that the given string is a valid slot or key URI.
+func (k *YubiKey) ValidateName(s string) error {
+	if uri.HasScheme(Scheme, s) {
+		if err := keySchema.Validate(s); err != nil {
+			return err
+		}
+	}
+	_, err := getSlot(s)
+	return err
+}
+
+// device returns the current connection to the YubiKey.
+func (k *YubiKey) device() pivKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.yk
+}
+
+// getPIN returns the PIN used by the KMS.
+func (k *YubiKey) getPIN() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.pin
+}
+
+// getManagementKey returns the management key used by the KMS.
+func (k *YubiKey) getManagementKey() [24]byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.managementKey
+}
+
+// reconnect closes the given connection to the YubiKey and opens a new one to
+// the same device. If the given connection has already been replaced, it
+// returns the current one.
+func (k *YubiKey) reconnect(old pivKey) (pivKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.yk != old {
+		return k.yk, nil
+	}
+	if k.closed {
+		return nil, errors.New("error reconnecting yubikey: kms is closed")
+	}
+
+	// The old connection is not usable, errors closing it are ignored.
+	_ = old.Close()
+
+	yk, card, err := openCard(k.card, k.serial)
+	k.hooks.NotifyReconnect(apiv1.YubiKey, err)
+	if err != nil {
+		return nil, errors.Wrap(convertError(err), "error reconnecting yubikey")
+	}
+	k.yk, k.card = yk, card
+	return yk, nil
+}
+
+// convertError converts the errors returned by the YubiKey to the error types
+// defined in apiv1.
+func convertError(err error) error {
+	var authErr piv.AuthErr
+	switch {
+	case errors.As(err, &authErr) && authErr.Retries == 0:
+		return apiv1.PINLockedError{Err: err}
+	case errors.As(err, &authErr):
+		return apiv1.PermissionDeniedError{Err: err}
+	case errors.Is(err, piv.ErrNotFound):
+		return apiv1.NotFoundError{Err: err}
+	default:
+		return err
+	}
+}
+
 // getPublicKey returns the public key on a slot. First it attempts to do
 // attestation to get a certificate with the public key in it, if this succeeds
 // means that the key was generated in the device. If not we'll try to get the
 // key from a stored certificate in the same slot.
 func (k *YubiKey) getPublicKey(slot piv.Slot) (crypto.PublicKey, error) {
-	cert, err := k.yk.Attest(slot)
+	return getPublicKey(k.device(), slot)
+}
+
+func getPublicKey(yk pivKey, slot piv.Slot) (crypto.PublicKey, error) {
+	cert, err := yk.Attest(slot)
 	if err != nil {
-		if cert, err = k.yk.Certificate(slot); err != nil {
-			return nil, errors.Wrap(err, "error retrieving public key")
+		if cert, err = yk.Certificate(slot); err != nil {
+			return nil, errors.Wrap(convertError(err), "error retrieving public key")
 		}
 	}
 	return cert.PublicKey, nil
 }
 
+// errEmptyCertificate is the error returned parsing the empty certificate
+// object written by DeleteKey.
+var errEmptyCertificate = func() error {
+	_, err := x509.ParseCertificate(nil)
+	return err
+}()
+
+// isDeletedSlot returns true if the certificate object in the slot is the empty
+// one written by DeleteKey. The piv package does not wrap the parsing error, so
+// its message is compared with the one for an empty certificate.
+func isDeletedSlot(yk pivKey, slot piv.Slot) (bool, error) {
+	_, err := yk.Certificate(slot)
+	switch {
+	case err == nil, errors.Is(err, piv.ErrNotFound):
+		return false, nil
+	case err.Error() == "parsing certificate: "+errEmptyCertificate.Error():
+		return true, nil
+	default:
+		return false, err
+	}
+}
+
 // signatureAlgorithmMapping is a mapping between the step signature algorithm,
 // and bits for RSA keys, with yubikey ones.
 var signatureAlgorithmMapping = map[apiv1.SignatureAlgorithm]interface{}{
@@ -388,7 +732,7 @@ func getSlot(name string) (piv.Slot, error) {
 
 func getSlotAndName(name string) (piv.Slot, string, error) {
 	if name == "" {
-		return piv.SlotSignature, "yubikey:slot-id=9c", nil
+		return piv.SlotSignature, keySchema.String(url.Values{"slot-id": []string{"9c"}}), nil
 	}
 
 	var slotID string
@@ -414,15 +758,14 @@ func getSlotAndName(name string) (piv.Slot, string, error) {
 		return piv.Slot{}, "", errors.Errorf("unsupported slot-id '%s'", name)
 	}
 
-	name = "yubikey:slot-id=" + url.QueryEscape(slotID)
-	return s, name, nil
+	return s, keySchema.String(url.Values{"slot-id": []string{slotID}}), nil
 }
 
-// getPolicies returns the pin and touch policies from the request. If they are
+// getPolicies returns the given pin and touch policies as piv ones. If they are
 // not set the defaults are piv.PINPolicyAlways and piv.TouchPolicyNever.
-func getPolicies(req *apiv1.CreateKeyRequest) (piv.PINPolicy, piv.TouchPolicy) {
-	pin := piv.PINPolicy(req.PINPolicy)
-	touch := piv.TouchPolicy(req.TouchPolicy)
+func getPolicies(pinPolicy apiv1.PINPolicy, touchPolicy apiv1.TouchPolicy) (piv.PINPolicy, piv.TouchPolicy) {
+	pin := piv.PINPolicy(pinPolicy)
+	touch := piv.TouchPolicy(touchPolicy)
 	if pin == 0 {
 		pin = piv.PINPolicyAlways
 	}
@@ -12,8 +12,11 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -21,6 +24,7 @@ import (
 	"github.com/pkg/errors"
 	"go.step.sm/crypto/kms/apiv1"
 	"go.step.sm/crypto/minica"
+	"go.step.sm/crypto/pemutil"
 )
 
 type stubPivKey struct {
@@ -30,6 +34,12 @@ type stubPivKey struct {
 	certMap       map[piv.Slot]*x509.Certificate
 	signerMap     map[piv.Slot]interface{}
 	keyOptionsMap map[piv.Slot]piv.Key
+	pin           string
+	puk           string
+	managementKey [24]byte
+	retries       int
+	serial        uint32
+	lost          bool
 }
 
 type symmetricAlgorithm int
@@ -117,27 +127,41 @@ func newStubPivKey(t *testing.T, alg symmetricAlgorithm) *stubPivKey {
 			piv.SlotSignature:      userSigner, // 9c
 		},
 		keyOptionsMap: map[piv.Slot]piv.Key{},
+		pin:           piv.DefaultPIN,
+		puk:           piv.DefaultPUK,
+		managementKey: piv.DefaultManagementKey,
+		retries:       3,
+		serial:        112233,
 	}
 }
 
 func (s *stubPivKey) Certificate(slot piv.Slot) (*x509.Certificate, error) {
 	cert, ok := s.certMap[slot]
-	if !ok {
-		return nil, errors.New("certificate not found")
+	switch {
+	case !ok:
+		return nil, piv.ErrNotFound
+	case cert == nil:
+		// An empty certificate object, as the one written by DeleteKey.
+		_, err := x509.ParseCertificate(nil)
+		return nil, fmt.Errorf("parsing certificate: %v", err)
 	}
 	return cert, nil
 }
 
 func (s *stubPivKey) SetCertificate(key [24]byte, slot piv.Slot, cert *x509.Certificate) error {
-	if !bytes.Equal(piv.DefaultManagementKey[:], key[:]) {
+	if !bytes.Equal(s.managementKey[:], key[:]) {
 		return errors.New("missing or invalid management key")
 	}
+	if len(cert.Raw) == 0 {
+		s.certMap[slot] = nil
+		return nil
+	}
 	s.certMap[slot] = cert
 	return nil
 }
 
 func (s *stubPivKey) GenerateKey(key [24]byte, slot piv.Slot, opts piv.Key) (crypto.PublicKey, error) {
-	if !bytes.Equal(piv.DefaultManagementKey[:], key[:]) {
+	if !bytes.Equal(s.managementKey[:], key[:]) {
 		return nil, errors.New("missing or invalid management key")
 	}
 
@@ -182,8 +206,41 @@ func (s *stubPivKey) GenerateKey(key [24]byte, slot piv.Slot, opts piv.Key) (cry
 	return signer.Public(), nil
 }
 
+func (s *stubPivKey) SetPrivateKeyInsecure(key [24]byte, slot piv.Slot, private crypto.PrivateKey, policy piv.Key) error {
+	if !bytes.Equal(s.managementKey[:], key[:]) {
+		return errors.New("missing or invalid management key")
+	}
+
+	switch priv := private.(type) {
+	case *rsa.PrivateKey:
+		switch priv.N.BitLen() {
+		case 1024:
+			policy.Algorithm = piv.AlgorithmRSA1024
+		case 2048:
+			policy.Algorithm = piv.AlgorithmRSA2048
+		default:
+			return errors.New("unsupported key size")
+		}
+	case *ecdsa.PrivateKey:
+		switch priv.Curve {
+		case elliptic.P256():
+			policy.Algorithm = piv.AlgorithmEC256
+		case elliptic.P384():
+			policy.Algorithm = piv.AlgorithmEC384
+		default:
+			return errors.New("unsupported curve")
+		}
+	default:
+		return errors.New("unsupported private key type")
+	}
+
+	s.signerMap[slot] = private
+	s.keyOptionsMap[slot] = policy
+	return nil
+}
+
 func (s *stubPivKey) PrivateKey(slot piv.Slot, public crypto.PublicKey, auth piv.KeyAuth) (crypto.PrivateKey, error) {
-	if auth.PIN != "123456" {
+	if auth.PIN != s.pin {
 		return nil, errors.New("missing or invalid pin")
 	}
 	key, ok := s.signerMap[slot]
@@ -196,11 +253,88 @@ func (s *stubPivKey) PrivateKey(slot piv.Slot, public crypto.PublicKey, auth piv
 func (s *stubPivKey) Attest(slot piv.Slot) (*x509.Certificate, error) {
 	cert, ok := s.attestMap[slot]
 	if !ok {
-		return nil, errors.New("certificate not found")
+		return nil, piv.ErrNotFound
 	}
 	return cert, nil
 }
 
+func (s *stubPivKey) Serial() (uint32, error) {
+	if s.lost {
+		return 0, errors.New("the smart card has been removed")
+	}
+	return s.serial, nil
+}
+
+func (s *stubPivKey) Version() piv.Version {
+	return piv.Version{Major: 5, Minor: 4, Patch: 3}
+}
+
+func (s *stubPivKey) Retries() (int, error) {
+	if s.lost {
+		return 0, errors.New("the smart card has been removed")
+	}
+	return s.retries, nil
+}
+
+// verify checks a pin or puk like the YubiKey does, decreasing the retries
+// left on failure.
+func (s *stubPivKey) verify(want, got string) error {
+	switch {
+	case s.retries == 0:
+		return piv.AuthErr{Retries: 0}
+	case want != got:
+		s.retries--
+		return piv.AuthErr{Retries: s.retries}
+	default:
+		s.retries = 3
+		return nil
+	}
+}
+
+func (s *stubPivKey) SetPIN(oldPIN, newPIN string) error {
+	if err := s.verify(s.pin, oldPIN); err != nil {
+		return err
+	}
+	s.pin = newPIN
+	return nil
+}
+
+func (s *stubPivKey) SetPUK(oldPUK, newPUK string) error {
+	if oldPUK != s.puk {
+		return piv.AuthErr{Retries: 2}
+	}
+	s.puk = newPUK
+	return nil
+}
+
+func (s *stubPivKey) Unblock(puk, newPIN string) error {
+	if puk != s.puk {
+		return piv.AuthErr{Retries: 2}
+	}
+	s.pin, s.retries = newPIN, 3
+	return nil
+}
+
+func (s *stubPivKey) SetManagementKey(oldKey, newKey [24]byte) error {
+	if !bytes.Equal(s.managementKey[:], oldKey[:]) {
+		return errors.New("missing or invalid management key")
+	}
+	s.managementKey = newKey
+	return nil
+}
+
+func (s *stubPivKey) Reset() error {
+	if s.lost {
+		return errors.New("the smart card has been removed")
+	}
+	s.attestMap = map[piv.Slot]*x509.Certificate{}
+	s.certMap = map[piv.Slot]*x509.Certificate{}
+	s.signerMap = map[piv.Slot]interface{}{}
+	s.keyOptionsMap = map[piv.Slot]piv.Key{}
+	s.pin, s.puk, s.managementKey, s.retries = piv.DefaultPIN, piv.DefaultPUK, piv.DefaultManagementKey, 3
+	return nil
+}
+
 func (s *stubPivKey) Close() error {
 	return nil
 }
@@ -215,9 +349,11 @@ func TestNew(t *testing.T) {
 	})
 
 	yk := newStubPivKey(t, ECDSA)
+	card := "Yubico YubiKey OTP+FIDO+CCID"
+	hooks := &apiv1.ConnectionHooks{}
 
 	okPivCards := func() ([]string, error) {
-		return []string{"Yubico YubiKey OTP+FIDO+CCID"}, nil
+		return []string{card}, nil
 	}
 	failPivCards := func() ([]string, error) {
 		return nil, errors.New("error reading cards")
@@ -229,6 +365,11 @@ func TestNew(t *testing.T) {
 	okPivOpen := func(card string) (pivKey, error) {
 		return yk, nil
 	}
+	noSerialYk := newStubPivKey(t, ECDSA)
+	noSerialYk.lost = true
+	noSerialPivOpen := func(card string) (pivKey, error) {
+		return noSerialYk, nil
+	}
 	failPivOpen := func(card string) (pivKey, error) {
 		return nil, errors.New("error opening card")
 	}
@@ -247,25 +388,37 @@ func TestNew(t *testing.T) {
 		{"ok", args{ctx, apiv1.Options{}}, func() {
 			pivCards = okPivCards
 			pivOpen = okPivOpen
-		}, &YubiKey{yk: yk, pin: "123456", managementKey: piv.DefaultManagementKey}, false},
+		}, &YubiKey{yk: yk, pin: "123456", managementKey: piv.DefaultManagementKey, card: card, serial: 112233}, false},
 		{"ok with uri", args{ctx, apiv1.Options{
 			URI: "yubikey:pin-value=111111;management-key=001122334455667788990011223344556677889900112233",
 		}}, func() {
 			pivCards = okPivCards
 			pivOpen = okPivOpen
-		}, &YubiKey{yk: yk, pin: "111111", managementKey: [24]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x11, 0x22, 0x33}}, false},
+		}, &YubiKey{yk: yk, pin: "111111", managementKey: [24]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x11, 0x22, 0x33}, card: card, serial: 112233}, false},
 		{"ok with Pin", args{ctx, apiv1.Options{Pin: "222222"}}, func() {
 			pivCards = okPivCards
 			pivOpen = okPivOpen
-		}, &YubiKey{yk: yk, pin: "222222", managementKey: piv.DefaultManagementKey}, false},
+		}, &YubiKey{yk: yk, pin: "222222", managementKey: piv.DefaultManagementKey, card: card, serial: 112233}, false},
 		{"ok with ManagementKey", args{ctx, apiv1.Options{ManagementKey: "001122334455667788990011223344556677889900112233"}}, func() {
 			pivCards = okPivCards
 			pivOpen = okPivOpen
-		}, &YubiKey{yk: yk, pin: "123456", managementKey: [24]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x11, 0x22, 0x33}}, false},
+		}, &YubiKey{yk: yk, pin: "123456", managementKey: [24]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0x00, 0x11, 0x22, 0x33}, card: card, serial: 112233}, false},
+		{"ok with Hooks", args{ctx, apiv1.Options{Hooks: hooks}}, func() {
+			pivCards = okPivCards
+			pivOpen = okPivOpen
+		}, &YubiKey{yk: yk, pin: "123456", managementKey: piv.DefaultManagementKey, card: card, serial: 112233, hooks: hooks}, false},
+		{"ok without serial", args{ctx, apiv1.Options{}}, func() {
+			pivCards = okPivCards
+			pivOpen = noSerialPivOpen
+		}, &YubiKey{yk: noSerialYk, pin: "123456", managementKey: piv.DefaultManagementKey, card: card}, false},
 		{"fail uri", args{ctx, apiv1.Options{URI: "badschema:"}}, func() {
 			pivCards = okPivCards
 			pivOpen = okPivOpen
 		}, nil, true},
+		{"fail pin-source", args{ctx, apiv1.Options{URI: "yubikey:pin-source=testdata/missing.txt"}}, func() {
+			pivCards = okPivCards
+			pivOpen = okPivOpen
+		}, nil, true},
 		{"fail management key", args{ctx, apiv1.Options{URI: "yubikey:management-key=xxyyzz"}}, func() {
 			pivCards = okPivCards
 			pivOpen = okPivOpen
@@ -305,6 +458,65 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func Test_openCard(t *testing.T) {
+	pOpen := pivOpen
+	pCards := pivCards
+	t.Cleanup(func() {
+		pivOpen = pOpen
+		pivCards = pCards
+	})
+
+	yk1 := newStubPivKey(t, ECDSA)
+	yk2 := newStubPivKey(t, ECDSA)
+	yk2.serial = 445566
+	devices := map[string]*stubPivKey{
+		"Yubico YubiKey OTP+FIDO+CCID 00 00": yk1,
+		"Yubico YubiKey OTP+FIDO+CCID 01 00": yk2,
+	}
+	pivCards = func() ([]string, error) {
+		return []string{"Yubico YubiKey OTP+FIDO+CCID 00 00", "Yubico YubiKey OTP+FIDO+CCID 01 00"}, nil
+	}
+	pivOpen = func(card string) (pivKey, error) {
+		if yk, ok := devices[card]; ok {
+			return yk, nil
+		}
+		return nil, errors.New("error opening card")
+	}
+
+	type args struct {
+		name   string
+		serial uint32
+	}
+	tests := []struct {
+		name     string
+		args     args
+		want     pivKey
+		wantCard string
+		wantErr  bool
+	}{
+		{"ok first", args{"", 0}, yk1, "Yubico YubiKey OTP+FIDO+CCID 00 00", false},
+		{"ok serial", args{"Yubico YubiKey OTP+FIDO+CCID 00 00", 445566}, yk2, "Yubico YubiKey OTP+FIDO+CCID 01 00", false},
+		{"ok name", args{"Yubico YubiKey OTP+FIDO+CCID 01 00", 0}, yk2, "Yubico YubiKey OTP+FIDO+CCID 01 00", false},
+		{"fail serial", args{"Yubico YubiKey OTP+FIDO+CCID 00 00", 778899}, nil, "", true},
+		{"fail name", args{"Yubico YubiKey OTP+FIDO+CCID 02 00", 0}, nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, card, err := openCard(tt.args.name, tt.args.serial)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("openCard() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("openCard() got = %v, want %v", got, tt.want)
+			}
+			if card != tt.wantCard {
+				t.Errorf("openCard() card = %v, want %v", card, tt.wantCard)
+			}
+		})
+	}
+}
+
 func TestYubiKey_LoadCertificate(t *testing.T) {
 	yk := newStubPivKey(t, ECDSA)
 
@@ -779,6 +991,213 @@ func TestYubiKey_CreateKey_policies(t *testing.T) {
 	}
 }
 
+func TestYubiKey_ImportKey(t *testing.T) {
+	mustCertificate := func(yk *stubPivKey, pub crypto.PublicKey) *x509.Certificate {
+		t.Helper()
+		cert, err := yk.userCA.Sign(&x509.Certificate{
+			Subject:   pkix.Name{CommonName: "escrowed key"},
+			PublicKey: pub,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	mustPEM := func(priv crypto.PrivateKey, password []byte) []byte {
+		t.Helper()
+		var opts []pemutil.Options
+		if password != nil {
+			opts = append(opts, pemutil.WithPassword(password))
+		}
+		block, err := pemutil.Serialize(priv, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(block)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	yk := newStubPivKey(t, ECDSA)
+	rsaCert := mustCertificate(yk, rsaKey.Public())
+	ecCert := mustCertificate(yk, ecKey.Public())
+	edCert := mustCertificate(yk, edKey.Public())
+
+	type args struct {
+		req *apiv1.ImportKeyRequest
+	}
+	tests := []struct {
+		name          string
+		managementKey [24]byte
+		args          args
+		wantSlot      piv.Slot
+		wantKey       piv.Key
+		wantCert      *x509.Certificate
+		want          *apiv1.CreateKeyResponse
+		wantErr       bool
+	}{
+		{"ok key management", piv.DefaultManagementKey, args{&apiv1.ImportKeyRequest{
+			Name:        "yubikey:slot-id=9d",
+			PrivateKey:  rsaKey,
+			Certificate: rsaCert,
+		}}, piv.SlotKeyManagement, piv.Key{Algorithm: piv.AlgorithmRSA2048, PINPolicy: piv.PINPolicyAlways, TouchPolicy: piv.TouchPolicyNever}, rsaCert, &apiv1.CreateKeyResponse{
+			Name:      "yubikey:slot-id=9d",
+			PublicKey: rsaKey.Public(),
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "yubikey:slot-id=9d",
+			},
+		}, false},
+		{"ok retired pem", piv.DefaultManagementKey, args{&apiv1.ImportKeyRequest{
+			Name:          "yubikey:slot-id=82",
+			PrivateKeyPEM: mustPEM(ecKey, nil),
+			Certificate:   ecCert,
+			PINPolicy:     apiv1.PINPolicyOnce,
+			TouchPolicy:   apiv1.TouchPolicyCached,
+		}}, slotMapping["82"], piv.Key{Algorithm: piv.AlgorithmEC384, PINPolicy: piv.PINPolicyOnce, TouchPolicy: piv.TouchPolicyCached}, ecCert, &apiv1.CreateKeyResponse{
+			Name:      "yubikey:slot-id=82",
+			PublicKey: ecKey.Public(),
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "yubikey:slot-id=82",
+			},
+		}, false},
+		{"ok encrypted pem", piv.DefaultManagementKey, args{&apiv1.ImportKeyRequest{
+			Name:          "yubikey:slot-id=95",
+			PrivateKeyPEM: mustPEM(ecKey, []byte("password")),
+			Password:      []byte("password"),
+			Certificate:   ecCert,
+		}}, slotMapping["95"], piv.Key{Algorithm: piv.AlgorithmEC384, PINPolicy: piv.PINPolicyAlways, TouchPolicy: piv.TouchPolicyNever}, ecCert, &apiv1.CreateKeyResponse{
+			Name:      "yubikey:slot-id=95",
+			PublicKey: ecKey.Public(),
+			CreateSignerRequest: apiv1.CreateSignerRequest{
+				SigningKey: "yubikey:slot-id=95",
+			},
+		}, false},
+		{"fail name", piv.DefaultManagementKey, args{&apiv1.ImportKeyRequest{
+			Name:       "yubikey:slot-id=ff",
+			PrivateKey: rsaKey,
+		}}, piv.Slot{}, piv.Key{}, nil, nil, true},
+		{"fail missing key", piv.DefaultManagementKey, args{&apiv1.ImportKeyRequest{
+			Name: "yubikey:slot-id=9d",
+		}}, piv.Slot{}, piv.Key{}, nil, nil, true},
+		{"fail pem", piv.DefaultManagementKey, args{&apiv1.ImportKeyRequest{
+			Name:          "yubikey:slot-id=9d",
+			PrivateKeyPEM: []byte("not a key"),
+		}}, piv.Slot{}, piv.Key{}, nil, nil, true},
+		{"fail password", piv.DefaultManagementKey, args{&apiv1.ImportKeyRequest{
+			Name:          "yubikey:slot-id=9d",
+			PrivateKeyPEM: mustPEM(ecKey, []byte("password")),
+			Password:      []byte("bad-password"),
+		}}, piv.Slot{}, piv.Key{}, nil, nil, true},
+		{"fail not a signer", piv.DefaultManagementKey, args{&apiv1.ImportKeyRequest{
+			Name:       "yubikey:slot-id=9d",
+			PrivateKey: []byte("not a key"),
+		}}, piv.Slot{}, piv.Key{}, nil, nil, true},
+		{"fail missing certificate", piv.DefaultManagementKey, args{&apiv1.ImportKeyRequest{
+			Name:       "yubikey:slot-id=9d",
+			PrivateKey: rsaKey,
+		}}, piv.Slot{}, piv.Key{}, nil, nil, true},
+		{"fail certificate", piv.DefaultManagementKey, args{&apiv1.ImportKeyRequest{
+			Name:        "yubikey:slot-id=9d",
+			PrivateKey:  rsaKey,
+			Certificate: ecCert,
+		}}, piv.Slot{}, piv.Key{}, nil, nil, true},
+		{"fail unsupported key", piv.DefaultManagementKey, args{&apiv1.ImportKeyRequest{
+			Name:        "yubikey:slot-id=9d",
+			PrivateKey:  edKey,
+			Certificate: edCert,
+		}}, piv.Slot{}, piv.Key{}, nil, nil, true},
+		{"fail management key", [24]byte{}, args{&apiv1.ImportKeyRequest{
+			Name:        "yubikey:slot-id=9d",
+			PrivateKey:  rsaKey,
+			Certificate: rsaCert,
+		}}, piv.Slot{}, piv.Key{}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			yk := newStubPivKey(t, ECDSA)
+			k := &YubiKey{
+				yk:            yk,
+				pin:           "123456",
+				managementKey: tt.managementKey,
+			}
+			got, err := k.ImportKey(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("YubiKey.ImportKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("YubiKey.ImportKey() = %v, want %v", got, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(yk.keyOptionsMap[tt.wantSlot], tt.wantKey) {
+				t.Errorf("YubiKey.ImportKey() key options = %v, want %v", yk.keyOptionsMap[tt.wantSlot], tt.wantKey)
+			}
+			if !reflect.DeepEqual(yk.certMap[tt.wantSlot], tt.wantCert) {
+				t.Errorf("YubiKey.ImportKey() certificate = %v, want %v", yk.certMap[tt.wantSlot], tt.wantCert)
+			}
+		})
+	}
+
+	// An imported key with its certificate can be used to decrypt.
+	k := &YubiKey{yk: yk, pin: "123456", managementKey: piv.DefaultManagementKey}
+	if _, err := k.ImportKey(&apiv1.ImportKeyRequest{
+		Name:        "yubikey:slot-id=9d",
+		PrivateKey:  rsaKey,
+		Certificate: rsaCert,
+	}); err != nil {
+		t.Fatalf("YubiKey.ImportKey() error = %v", err)
+	}
+	dec, err := k.CreateDecrypter(&apiv1.CreateDecrypterRequest{DecryptionKey: "yubikey:slot-id=9d"})
+	if err != nil {
+		t.Fatalf("YubiKey.CreateDecrypter() error = %v", err)
+	}
+	ciphertext, err := rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, &rsaKey.PublicKey, []byte("escrowed"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := dec.Decrypt(rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	if err != nil {
+		t.Fatalf("Decrypter.Decrypt() error = %v", err)
+	}
+	if string(plaintext) != "escrowed" {
+		t.Errorf("Decrypter.Decrypt() = %s, want escrowed", plaintext)
+	}
+
+	// An imported key with its certificate can be used to sign.
+	if _, err := k.ImportKey(&apiv1.ImportKeyRequest{
+		Name:          "yubikey:slot-id=82",
+		PrivateKeyPEM: mustPEM(ecKey, nil),
+		Certificate:   ecCert,
+	}); err != nil {
+		t.Fatalf("YubiKey.ImportKey() error = %v", err)
+	}
+	signer, err := k.CreateSigner(&apiv1.CreateSignerRequest{SigningKey: "yubikey:slot-id=82"})
+	if err != nil {
+		t.Fatalf("YubiKey.CreateSigner() error = %v", err)
+	}
+	digest := sha256.Sum256([]byte("escrowed"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Signer.Sign() error = %v", err)
+	}
+	if !ecdsa.VerifyASN1(&ecKey.PublicKey, digest[:], sig) {
+		t.Error("Signer.Sign() signature is not valid")
+	}
+}
+
 func TestYubiKey_CreateSigner(t *testing.T) {
 	yk := newStubPivKey(t, ECDSA)
 
@@ -831,6 +1250,10 @@ func TestYubiKey_CreateSigner(t *testing.T) {
 				t.Errorf("YubiKey.CreateSigner() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			// The private key is wrapped in a signer that can reconnect.
+			if s, ok := got.(*signer); ok {
+				got = s.priv.(crypto.Signer)
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("YubiKey.CreateSigner() = %v, want %v", got, tt.want)
 			}
@@ -838,6 +1261,47 @@ func TestYubiKey_CreateSigner(t *testing.T) {
 	}
 }
 
+func TestYubiKey_CreateSigner_decrypter(t *testing.T) {
+	k := &YubiKey{
+		yk:            newStubPivKey(t, RSA),
+		pin:           "123456",
+		managementKey: piv.DefaultManagementKey,
+	}
+	got, err := k.CreateSigner(&apiv1.CreateSignerRequest{
+		SigningKey: "yubikey:slot-id=9c",
+	})
+	if err != nil {
+		t.Fatalf("YubiKey.CreateSigner() error = %v", err)
+	}
+	d, ok := got.(crypto.Decrypter)
+	if !ok {
+		t.Fatalf("YubiKey.CreateSigner() = %T, want crypto.Decrypter", got)
+	}
+	ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, got.Public().(*rsa.PublicKey), []byte("the message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := d.Decrypt(rand.Reader, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("crypto.Decrypter.Decrypt() error = %v", err)
+	}
+	if string(plaintext) != "the message" {
+		t.Errorf("crypto.Decrypter.Decrypt() = %q, want %q", plaintext, "the message")
+	}
+
+	// Other keys are not decrypters.
+	k.yk = newStubPivKey(t, ECDSA)
+	got, err = k.CreateSigner(&apiv1.CreateSignerRequest{
+		SigningKey: "yubikey:slot-id=9c",
+	})
+	if err != nil {
+		t.Fatalf("YubiKey.CreateSigner() error = %v", err)
+	}
+	if _, ok := got.(crypto.Decrypter); ok {
+		t.Errorf("YubiKey.CreateSigner() = %T, want not crypto.Decrypter", got)
+	}
+}
+
 func TestYubiKey_CreateDecrypter(t *testing.T) {
 	yk := newStubPivKey(t, RSA)
 
@@ -896,6 +1360,10 @@ func TestYubiKey_CreateDecrypter(t *testing.T) {
 				t.Errorf("YubiKey.CreateDecrypter() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			// The private key is wrapped in a decrypter that can reconnect.
+			if d, ok := got.(*decrypter); ok {
+				got = d.priv.(crypto.Decrypter)
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("YubiKey.CreateDecrypter() = %v, want %v", got, tt.want)
 			}
@@ -960,6 +1428,239 @@ func TestYubiKey_CreateAttestation(t *testing.T) {
 	}
 }
 
+func TestYubiKey_DeleteKey(t *testing.T) {
+	yk := newStubPivKey(t, ECDSA)
+	oldSigner := yk.signerMap[piv.SlotSignature]
+
+	type fields struct {
+		yk            pivKey
+		pin           string
+		managementKey [24]byte
+	}
+	type args struct {
+		req *apiv1.DeleteKeyRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{"ok", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.DeleteKeyRequest{
+			Name: "yubikey:slot-id=9c",
+		}}, false},
+		{"fail empty", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.DeleteKeyRequest{}}, true},
+		{"fail getSlot", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.DeleteKeyRequest{
+			Name: "slot-id=9c",
+		}}, true},
+		{"fail generateKey", fields{yk, "123456", [24]byte{}}, args{&apiv1.DeleteKeyRequest{
+			Name: "yubikey:slot-id=9c",
+		}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &YubiKey{
+				yk:            tt.fields.yk,
+				pin:           tt.fields.pin,
+				managementKey: tt.fields.managementKey,
+			}
+			if err := k.DeleteKey(tt.args.req); (err != nil) != tt.wantErr {
+				t.Errorf("YubiKey.DeleteKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if cert := yk.certMap[piv.SlotSignature]; cert != nil {
+		t.Error("YubiKey.DeleteKey() certificate was not cleared")
+	}
+	if reflect.DeepEqual(yk.signerMap[piv.SlotSignature], oldSigner) {
+		t.Error("YubiKey.DeleteKey() key was not replaced")
+	}
+
+	// The deleted key is not listed, even if the new key can be attested as in
+	// a real YubiKey.
+	yk.attestMap[piv.SlotSignature] = yk.attestMap[piv.SlotAuthentication]
+	k := &YubiKey{yk: yk, pin: "123456", managementKey: piv.DefaultManagementKey}
+	got, err := k.ListKeys(&apiv1.ListKeysRequest{})
+	if err != nil {
+		t.Fatalf("YubiKey.ListKeys() error = %v", err)
+	}
+	want := &apiv1.ListKeysResponse{
+		Keys: []*apiv1.KeyInfo{
+			{Name: "yubikey:slot-id=9a", SignatureAlgorithm: apiv1.ECDSAWithSHA256, ProtectionLevel: apiv1.HSM},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("YubiKey.ListKeys() = %v, want %v", got, want)
+	}
+
+	// A new key in the deleted slot replaces the empty certificate object and
+	// is listed again.
+	if _, err := k.CreateKey(&apiv1.CreateKeyRequest{Name: "yubikey:slot-id=9c"}); err != nil {
+		t.Fatalf("YubiKey.CreateKey() error = %v", err)
+	}
+	if cert := yk.certMap[piv.SlotSignature]; cert != yk.attestMap[piv.SlotSignature] {
+		t.Error("YubiKey.CreateKey() attestation certificate was not stored")
+	}
+	got, err = k.ListKeys(&apiv1.ListKeysRequest{})
+	if err != nil {
+		t.Fatalf("YubiKey.ListKeys() error = %v", err)
+	}
+	want.Keys = append(want.Keys, &apiv1.KeyInfo{
+		Name: "yubikey:slot-id=9c", SignatureAlgorithm: apiv1.ECDSAWithSHA256, ProtectionLevel: apiv1.HSM,
+	})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("YubiKey.ListKeys() = %v, want %v", got, want)
+	}
+}
+
+// failCertificatePivKey is a stubPivKey that fails to read the certificates,
+// as a YubiKey with a PC/SC error.
+type failCertificatePivKey struct {
+	*stubPivKey
+}
+
+func (s *failCertificatePivKey) Certificate(slot piv.Slot) (*x509.Certificate, error) {
+	return nil, errors.New("command failed: smart card error")
+}
+
+func TestYubiKey_ListKeys(t *testing.T) {
+	yk := newStubPivKey(t, ECDSA)
+	rsaKey := newStubPivKey(t, RSA)
+	failKey := &failCertificatePivKey{newStubPivKey(t, ECDSA)}
+
+	type fields struct {
+		yk            pivKey
+		pin           string
+		managementKey [24]byte
+	}
+	type args struct {
+		req *apiv1.ListKeysRequest
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    *apiv1.ListKeysResponse
+		wantErr bool
+	}{
+		{"ok", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.ListKeysRequest{}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{
+				{Name: "yubikey:slot-id=9a", SignatureAlgorithm: apiv1.ECDSAWithSHA256, ProtectionLevel: apiv1.HSM},
+				{Name: "yubikey:slot-id=9c", SignatureAlgorithm: apiv1.ECDSAWithSHA256, ProtectionLevel: apiv1.HSM},
+			},
+		}, false},
+		{"ok rsa", fields{rsaKey, "123456", piv.DefaultManagementKey}, args{&apiv1.ListKeysRequest{}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{
+				{Name: "yubikey:slot-id=9a", SignatureAlgorithm: apiv1.SHA256WithRSA, ProtectionLevel: apiv1.HSM},
+				{Name: "yubikey:slot-id=9c", SignatureAlgorithm: apiv1.SHA256WithRSA, ProtectionLevel: apiv1.HSM},
+			},
+		}, false},
+		{"ok filter", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.ListKeysRequest{NameFilter: "9c"}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{
+				{Name: "yubikey:slot-id=9c", SignatureAlgorithm: apiv1.ECDSAWithSHA256, ProtectionLevel: apiv1.HSM},
+			},
+		}, false},
+		{"ok page", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.ListKeysRequest{PageSize: 1}}, &apiv1.ListKeysResponse{
+			Keys: []*apiv1.KeyInfo{
+				{Name: "yubikey:slot-id=9a", SignatureAlgorithm: apiv1.ECDSAWithSHA256, ProtectionLevel: apiv1.HSM},
+			},
+			NextPageToken: "1",
+		}, false},
+		{"ok empty", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.ListKeysRequest{NameFilter: "82"}}, &apiv1.ListKeysResponse{}, false},
+		{"fail page token", fields{yk, "123456", piv.DefaultManagementKey}, args{&apiv1.ListKeysRequest{PageToken: "foo"}}, nil, true},
+		{"fail certificate", fields{failKey, "123456", piv.DefaultManagementKey}, args{&apiv1.ListKeysRequest{}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &YubiKey{
+				yk:            tt.fields.yk,
+				pin:           tt.fields.pin,
+				managementKey: tt.fields.managementKey,
+			}
+			got, err := k.ListKeys(tt.args.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("YubiKey.ListKeys() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("YubiKey.ListKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestYubiKey_HealthCheck(t *testing.T) {
+	pOpen := pivOpen
+	pCards := pivCards
+	t.Cleanup(func() {
+		pivOpen = pOpen
+		pivCards = pCards
+	})
+	pivCards = func() ([]string, error) {
+		return []string{"Yubico YubiKey OTP+FIDO+CCID"}, nil
+	}
+
+	okPivOpen := func(card string) (pivKey, error) {
+		return newStubPivKey(t, ECDSA), nil
+	}
+	lostPivOpen := func(card string) (pivKey, error) {
+		yk := newStubPivKey(t, ECDSA)
+		yk.lost = true
+		return yk, nil
+	}
+	otherPivOpen := func(card string) (pivKey, error) {
+		yk := newStubPivKey(t, ECDSA)
+		yk.serial = 445566
+		return yk, nil
+	}
+	failPivOpen := func(card string) (pivKey, error) {
+		return nil, errors.New("error opening card")
+	}
+
+	tests := []struct {
+		name    string
+		lost    bool
+		closed  bool
+		open    func(card string) (pivKey, error)
+		want    hookCalls
+		wantErr bool
+	}{
+		{"ok", false, false, failPivOpen, hookCalls{}, false},
+		{"ok reconnect", true, false, okPivOpen, hookCalls{1, 1}, false},
+		{"fail reconnect", true, false, failPivOpen, hookCalls{1, 1}, true},
+		{"fail still lost", true, false, lostPivOpen, hookCalls{1, 1}, true},
+		{"fail other yubikey", true, false, otherPivOpen, hookCalls{1, 1}, true},
+		{"fail closed", true, true, okPivOpen, hookCalls{1, 0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pivOpen = tt.open
+			yk := newStubPivKey(t, ECDSA)
+			yk.lost = tt.lost
+			calls := new(hookCalls)
+			k := &YubiKey{
+				yk:            yk,
+				pin:           "123456",
+				managementKey: piv.DefaultManagementKey,
+				serial:        112233,
+				hooks:         newHooks(t, calls),
+			}
+			if tt.closed {
+				if err := k.Close(); err != nil {
+					t.Fatalf("YubiKey.Close() error = %v", err)
+				}
+			}
+			if err := k.HealthCheck(); (err != nil) != tt.wantErr {
+				t.Errorf("YubiKey.HealthCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if *calls != tt.want {
+				t.Errorf("ConnectionHooks calls = %+v, want %+v", *calls, tt.want)
+			}
+		})
+	}
+}
+
 func TestYubiKey_Close(t *testing.T) {
 	yk := newStubPivKey(t, ECDSA)
 
@@ -988,3 +1689,71 @@ func TestYubiKey_Close(t *testing.T) {
 		})
 	}
 }
+
+func TestYubiKey_ValidateName(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		wantErr bool
+	}{
+		{"ok", "yubikey:slot-id=9a", false},
+		{"ok pin", "yubikey:slot-id=9c?pin-value=123456", false},
+		{"ok slot", "9d", false},
+		{"ok default", "", false},
+		{"fail unknown attribute", "yubikey:slot-id=9a;management-key=010203040506070801020304050607080102030405060708", true},
+		{"fail repeated attribute", "yubikey:slot-id=9a?slot-id=9c", true},
+		{"fail missing slot-id", "yubikey:pin-value=123456", true},
+		{"fail slot-id", "yubikey:slot-id=00", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := &YubiKey{}
+			if err := k.ValidateName(tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("YubiKey.ValidateName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestYubiKey_errors(t *testing.T) {
+	k := &YubiKey{yk: newStubPivKey(t, ECDSA)}
+	_, err := k.LoadCertificate(&apiv1.LoadCertificateRequest{
+		Name: "yubikey:slot-id=82",
+	})
+	if !errors.Is(err, apiv1.ErrNotFound) {
+		t.Errorf("YubiKey.LoadCertificate() error = %v, want apiv1.NotFoundError", err)
+	}
+}
+
+func Test_convertError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"not found", piv.ErrNotFound, apiv1.ErrNotFound},
+		{"permission denied", errors.Wrap(piv.AuthErr{Retries: 2}, "an error"), apiv1.ErrPermissionDenied},
+		{"pin locked", piv.AuthErr{Retries: 0}, apiv1.ErrPINLocked},
+		{"other", errors.New("an error"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := convertError(tt.err)
+			if tt.want == nil {
+				if err != tt.err {
+					t.Errorf("convertError() = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("convertError() = %#v, want %T", err, tt.want)
+			}
+			if err.Error() != tt.err.Error() {
+				t.Errorf("convertError().Error() = %s, want %s", err.Error(), tt.err.Error())
+			}
+		})
+	}
+	if err := convertError(nil); err != nil {
+		t.Errorf("convertError() = %v, want nil", err)
+	}
+}